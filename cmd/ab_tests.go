@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	// maxCampaignVariants is the largest number of variants in a campaign's A/B test.
+	maxCampaignVariants = 10
+
+	// defaultABTestMinutes is the default duration of a campaign's A/B test.
+	defaultABTestMinutes = 240
+)
+
+// GetCampaignVariantStats returns the recipients, engagement and attributed revenue
+// of the variants of a campaign's A/B test.
+func (a *App) GetCampaignVariantStats(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	stats, err := a.getCampaignVariantStats([]int{id})
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		models.CampaignABTest
+		EndsAt   *time.Time                    `json:"ends_at"`
+		WinnerID *int                          `json:"winner_id"`
+		Variants []models.CampaignVariantStats `json:"variants"`
+	}{
+		CampaignABTest: camp.ABTest,
+		Variants:       stats[id],
+	}
+	if camp.ABTestEndsAt.Valid {
+		out.EndsAt = &camp.ABTestEndsAt.Time
+	}
+	if camp.ABWinnerID.Valid {
+		out.WinnerID = &camp.ABWinnerID.Int
+	}
+	if out.Variants == nil {
+		out.Variants = []models.CampaignVariantStats{}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// SendCampaignWinner picks the winner of a running campaign's A/B test before the test
+// ends and queues it to the rest of the campaign's audience.
+func (a *App) SendCampaignWinner(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	var req struct {
+		VariantID int `json:"variant_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if camp.Status != models.CampaignStatusRunning || !camp.ABTestEndsAt.Valid || camp.ABWinnerID.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.abTestNotRunning"))
+	}
+
+	found := false
+	for _, v := range camp.Variants {
+		if v.ID == req.VariantID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "variant_id"))
+	}
+
+	// The winner is sent to the rest of the audience like a campaign start.
+	if err := a.checkCampaignStart(c.Request().Context(), camp); err != nil {
+		return err
+	}
+
+	n, err := a.core.SendCampaignWinner(id, req.VariantID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Queued int `json:"queued"`
+	}{n}})
+}
+
+// runABTests periodically picks the winners of the A/B tests of running campaigns
+// that have ended and queues them to the rest of the campaigns' audiences.
+func (a *App) runABTests(interval time.Duration) {
+	fnRun := func() {
+		var ids []int
+		if err := a.queries.GetDueABTests.Select(&ids); err != nil {
+			a.log.Printf("error fetching campaign A/B tests: %v", err)
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+
+		stats, err := a.getCampaignVariantStats(ids)
+		if err != nil {
+			return
+		}
+
+		for _, id := range ids {
+			camp, err := a.core.GetCampaign(id, "", "")
+			if err != nil {
+				continue
+			}
+
+			winner, ok := pickABTestWinner(stats[id], camp.ABTest.Metric)
+			if !ok {
+				a.log.Printf("campaign %d A/B test has no variants", id)
+				continue
+			}
+
+			if err := a.checkCampaignStart(context.Background(), camp); err != nil {
+				a.log.Printf("not sending campaign %d A/B test winner: %v", id, err)
+				continue
+			}
+
+			n, err := a.core.SendCampaignWinner(id, winner.ID)
+			if err != nil {
+				continue
+			}
+
+			a.log.Printf("campaign '%s' (%d) A/B test winner is '%s' by %s, queued %d e-mails",
+				camp.Name, id, winner.Name, camp.ABTest.Metric, n)
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// getCampaignVariantStats returns the variant stats of the given campaigns mapped by campaign ID.
+func (a *App) getCampaignVariantStats(ids []int) (map[int][]models.CampaignVariantStats, error) {
+	var stats []models.CampaignVariantStats
+	if err := a.queries.GetCampaignVariantStats.Select(&stats, pq.Array(ids),
+		a.cfg.ShopifyAttributionModel, a.cfg.ShopifyReportingCurrency); err != nil {
+		a.log.Printf("error fetching campaign variant stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	out := make(map[int][]models.CampaignVariantStats, len(ids))
+	for _, s := range stats {
+		out[s.CampaignID] = append(out[s.CampaignID], s)
+	}
+
+	return out, nil
+}
+
+// pickABTestWinner returns the variant with the highest rate of the given metric per
+// e-mail sent. Ties go to the earliest variant.
+func pickABTestWinner(variants []models.CampaignVariantStats, metric string) (models.CampaignVariantStats, bool) {
+	if len(variants) == 0 {
+		return models.CampaignVariantStats{}, false
+	}
+
+	var (
+		best     = variants[0]
+		bestRate = -1.0
+	)
+	for _, v := range variants {
+		if v.Sent == 0 {
+			continue
+		}
+
+		var val float64
+		switch metric {
+		case models.ABTestMetricOpens:
+			val = float64(v.Views)
+		case models.ABTestMetricRevenue:
+			val = v.Revenue
+		default:
+			val = float64(v.Clicks)
+		}
+
+		if rate := val / float64(v.Sent); rate > bestRate {
+			best, bestRate = v, rate
+		}
+	}
+
+	return best, true
+}
+
+// validateABTest validates the A/B test settings and variants of a campaign.
+func (a *App) validateABTest(c campReq) (campReq, error) {
+	if c.Variants == nil {
+		c.Variants = models.CampaignVariants{}
+	}
+
+	if !c.ABTest.Enabled {
+		return c, nil
+	}
+
+	if c.Messenger != automaticMsgr {
+		return c, errors.New(a.i18n.T("campaigns.abTestNeedsAutomatic"))
+	}
+
+	if len(c.Variants) < 2 || len(c.Variants) > maxCampaignVariants {
+		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidVariants", "max", strconv.Itoa(maxCampaignVariants)))
+	}
+
+	if c.ABTest.TestPercent <= 0 || c.ABTest.TestPercent > 100 {
+		return c, errors.New(a.i18n.T("campaigns.fieldInvalidABTestPercent"))
+	}
+
+	switch c.ABTest.Metric {
+	case models.ABTestMetricOpens, models.ABTestMetricClicks, models.ABTestMetricRevenue:
+	case "":
+		c.ABTest.Metric = models.ABTestMetricClicks
+	default:
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "ab_test.metric"))
+	}
+
+	if c.ABTest.DurationMinutes < 1 {
+		c.ABTest.DurationMinutes = defaultABTestMinutes
+	}
+
+	names := make(map[string]bool, len(c.Variants))
+	for _, v := range c.Variants {
+		if !strHasLen(v.Name, 1, stdInputMaxLen) || names[v.Name] {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidVariantName"))
+		}
+		names[v.Name] = true
+
+		if len(v.Subject) > 5000 {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidSubject"))
+		}
+
+		if v.FromEmail != "" && !reFromAddress.Match([]byte(v.FromEmail)) {
+			if _, err := a.importer.SanitizeEmail(v.FromEmail); err != nil {
+				return c, errors.New(a.i18n.T("campaigns.fieldInvalidFromEmail"))
+			}
+		}
+
+		camp := models.Campaign{Subject: v.Subject, Body: c.Body, TemplateBody: tplTag}
+		if v.Body.Valid {
+			camp.Body = v.Body.String
+		}
+		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
+		}
+	}
+
+	return c, nil
+}
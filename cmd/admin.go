@@ -30,6 +30,8 @@ type serverConfig struct {
 	NeedsRestart  bool            `json:"needs_restart"`
 	HasLegacyUser bool            `json:"has_legacy_user"`
 	Version       string          `json:"version"`
+
+	CampaignApproval bool `json:"campaign_approval"`
 }
 
 // GetServerConfig returns general server config.
@@ -40,6 +42,8 @@ func (a *App) GetServerConfig(c echo.Context) error {
 		Lang:          a.cfg.Lang,
 		Permissions:   a.cfg.PermissionsRaw,
 		HasLegacyUser: a.cfg.HasLegacyUser,
+
+		CampaignApproval: a.cfg.CampaignApproval,
 	}
 	out.PublicSubscription.Enabled = a.cfg.EnablePublicSubPage
 
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// GetCampaignAudience returns the number of subscribers in the audience of a campaign as it'd
+// be sent now, with its exclusion lists and segment query, and its audience snapshot if it
+// has been taken.
+func (a *App) GetCampaignAudience(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	count, err := a.core.CountCampaignAudience(id)
+	if err != nil {
+		return err
+	}
+
+	out := struct {
+		Count      int       `json:"count"`
+		Snapshot   bool      `json:"snapshot"`
+		SnapshotAt null.Time `json:"snapshot_at"`
+		Size       int       `json:"snapshot_size"`
+	}{
+		Count:      count,
+		Snapshot:   camp.NeedsAudienceSnapshot(),
+		SnapshotAt: camp.AudienceSnapshotAt,
+		Size:       camp.AudienceSize,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateCampaignAudience validates the exclusion lists and segment query of a campaign.
+func (a *App) validateCampaignAudience(c campReq) (campReq, error) {
+	if c.ExcludeListIDs == nil {
+		c.ExcludeListIDs = pq.Int64Array{}
+	}
+
+	// A list can't be both targeted and excluded.
+	for _, id := range c.ExcludeListIDs {
+		if slices.Contains(c.ListIDs, int(id)) {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidExcludeLists"))
+		}
+	}
+
+	c.SegmentQuery = formatSQLExp(c.SegmentQuery)
+	if c.SegmentQuery != "" {
+		if err := a.core.ValidateSegmentQuery(c.SegmentQuery); err != nil {
+			return c, errors.New(a.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+		}
+	}
+
+	return c, nil
+}
+
+// checkSegmentQueryPerm checks if the user has the subscribers:sql_query permission
+// to set (or change) the segment query of a campaign.
+func (a *App) checkSegmentQueryPerm(query, current string, c echo.Context) error {
+	if formatSQLExp(query) == "" || formatSQLExp(query) == current {
+		return nil
+	}
+
+	if user := auth.GetUser(c); !user.HasPerm(auth.PermSubscribersSqlQuery) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermSubscribersSqlQuery))
+	}
+
+	return nil
+}
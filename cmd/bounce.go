@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -115,24 +116,27 @@ func (a *App) BlocklistBouncedSubscribers(c echo.Context) error {
 }
 
 // BounceWebhook renders the HTML preview of a template.
-func (a *App) BounceWebhook(c echo.Context) error {
+func (a *App) BounceWebhook(c echo.Context) (err error) {
 	// Read the request body instead of using c.Bind() to read to save the entire raw request as meta.
 	rawReq, err := io.ReadAll(c.Request().Body)
 	if err != nil {
 		a.log.Printf("error reading ses notification body: %v", err)
-		a.logWebhook("unknown", "", c.Request().Header, []byte{}, http.StatusBadRequest, "", false, err.Error())
+		a.logWebhook(c, "unknown", "", []byte{}, http.StatusBadRequest, "", false, err.Error())
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.internalError"))
 	}
 
 	var (
 		service       = c.Param("service")
 		webhookType   = service
+		verified      = isWebhookVerified(c)
 		eventType     = ""
 		processed     = false
 		errorMsg      = ""
+		responseBody  = ""
 		responseStatus = http.StatusOK
 
 		bounces []models.Bounce
+		idx     webhookLogIndex
 	)
 
 	// Default webhook type for native webhooks
@@ -142,8 +146,41 @@ func (a *App) BounceWebhook(c echo.Context) error {
 
 	// Defer logging the webhook after processing
 	defer func() {
-		a.logWebhook(webhookType, eventType, c.Request().Header, rawReq, responseStatus, "", processed, errorMsg)
+		if idx.Email == "" && len(bounces) > 0 {
+			idx.Email = bounces[0].Email
+			idx.CampaignUUID = bounces[0].CampaignUUID
+		}
+		setWebhookLogIndex(c, idx)
+		a.logWebhook(c, webhookType, eventType, rawReq, responseStatus, responseBody, processed, errorMsg)
+
+		// Let the provider's retry of a failed request through.
+		if err != nil {
+			a.releaseWebhookKeys(c)
+		}
 	}()
+
+	// Acknowledge the webhook once it's verified and persisted to the inbox. The inbox
+	// workers process it by running it through this handler again. Subscription handshakes
+	// expect a response from the handler and are always processed right away.
+	if a.inbox != nil && !verified && !isWebhookHandshake(c, service, rawReq) {
+		if err := a.verifyBounceWebhook(c, service, rawReq); err != nil {
+			errorMsg = err.Error()
+			responseStatus = http.StatusBadRequest
+			if e, ok := err.(*echo.HTTPError); ok {
+				responseStatus = e.Code
+			}
+			return err
+		}
+
+		if err := a.pushWebhookInbox(c, webhookType, rawReq); err != nil {
+			errorMsg = err.Error()
+			responseStatus = http.StatusInternalServerError
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+
+		responseBody = webhookQueued
+		return c.JSON(http.StatusOK, okResp{true})
+	}
 	switch true {
 	// Native internal webhook.
 	case service == "":
@@ -174,19 +211,44 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		// SNS webhook registration confirmation. Only after these are processed will the endpoint
 		// start getting bounce notifications.
 		case "SubscriptionConfirmation", "UnsubscribeConfirmation":
+			// Stored subscription handshakes are stale and are never replayed.
+			if verified {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+			}
+
 			if err := a.bounce.SES.ProcessSubscription(rawReq); err != nil {
 				a.log.Printf("error processing SNS (SES) subscription: %v", err)
 				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 			}
 
-		// Bounce notification.
+		// Bounce, complaint, delivery and rendering failure notifications.
 		case "Notification":
-			b, err := a.bounce.SES.ProcessBounce(rawReq)
+			var ev webhooks.SESEvent
+			if verified {
+				ev, err = a.bounce.SES.ParseNotification(rawReq)
+			} else {
+				ev, err = a.bounce.SES.ProcessNotification(rawReq)
+			}
 			if err != nil {
 				a.log.Printf("error processing SES notification: %v", err)
+				errorMsg = err.Error()
+				responseStatus = http.StatusBadRequest
 				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 			}
-			bounces = append(bounces, b)
+			eventType = ev.Type
+			idx = webhookLogIndex{Email: ev.Email, CampaignUUID: ev.CampaignUUID, MessageID: ev.MessageID}
+
+			// SNS retries deliveries that it didn't see succeed.
+			if !a.claimWebhookKey(c, webhookType, snsMessageID(c, rawReq)) {
+				processed = true
+				responseBody = webhookDuplicate
+				return c.JSON(http.StatusOK, okResp{true})
+			}
+
+			a.recordSESEvent(ev)
+			if ev.Bounce != nil {
+				bounces = append(bounces, *ev.Bounce)
+			}
 
 		default:
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
@@ -200,7 +262,12 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		)
 
 		// Sendgrid sends multiple bounces.
-		bs, err := a.bounce.Sendgrid.ProcessBounce(sig, ts, rawReq)
+		var bs []models.Bounce
+		if verified {
+			bs, err = a.bounce.Sendgrid.ParseBounce(rawReq)
+		} else {
+			bs, err = a.bounce.Sendgrid.ProcessBounce(sig, ts, rawReq)
+		}
 		if err != nil {
 			a.log.Printf("error processing sendgrid notification: %v", err)
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
@@ -209,7 +276,12 @@ func (a *App) BounceWebhook(c echo.Context) error {
 
 	// Postmark.
 	case service == "postmark" && a.cfg.BouncePostmarkEnabled:
-		bs, err := a.bounce.Postmark.ProcessBounce(rawReq, c)
+		var bs []models.Bounce
+		if verified {
+			bs, err = a.bounce.Postmark.ParseBounce(rawReq)
+		} else {
+			bs, err = a.bounce.Postmark.ProcessBounce(rawReq, c)
+		}
 		if err != nil {
 			a.log.Printf("error processing postmark notification: %v", err)
 			if _, ok := err.(*echo.HTTPError); ok {
@@ -226,7 +298,12 @@ func (a *App) BounceWebhook(c echo.Context) error {
 			sig = c.Request().Header.Get("X-Webhook-Signature")
 		)
 
-		bs, err := a.bounce.Forwardemail.ProcessBounce(sig, rawReq)
+		var bs []models.Bounce
+		if verified {
+			bs, err = a.bounce.Forwardemail.ParseBounce(rawReq)
+		} else {
+			bs, err = a.bounce.Forwardemail.ProcessBounce(sig, rawReq)
+		}
 		if err != nil {
 			a.log.Printf("error processing forwardemail notification: %v", err)
 			if _, ok := err.(*echo.HTTPError); ok {
@@ -240,6 +317,7 @@ func (a *App) BounceWebhook(c echo.Context) error {
 	// Azure Event Grid.
 	case service == "azure" && a.cfg.BounceAzureEnabled:
 		var events []struct {
+			ID        string                 `json:"id"`
 			EventType string                 `json:"eventType"`
 			Data      map[string]interface{} `json:"data"`
 		}
@@ -251,12 +329,26 @@ func (a *App) BounceWebhook(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 		}
 
+		numDups := 0
 		for _, event := range events {
 			// Set event type for logging (use first event's type)
 			if eventType == "" {
 				eventType = event.EventType
 			}
 
+			// Index the log by the first event's message and recipient.
+			if idx.MessageID == "" {
+				idx.MessageID, _ = event.Data["messageId"].(string)
+				idx.Email, _ = event.Data["recipient"].(string)
+			}
+
+			// Event Grid retries deliveries and a retried batch may have events that
+			// have already been processed.
+			if event.EventType != "Microsoft.EventGrid.SubscriptionValidationEvent" && !a.claimWebhookKey(c, webhookType, event.ID) {
+				numDups++
+				continue
+			}
+
 			switch event.EventType {
 			case "Microsoft.EventGrid.SubscriptionValidationEvent":
 				// Handle subscription validation
@@ -325,6 +417,10 @@ func (a *App) BounceWebhook(c echo.Context) error {
 					}
 				}
 
+				if idx.CampaignID == 0 {
+					idx.CampaignID = campaignID
+				}
+
 				if err == nil && messageID != "" {
 					// Extract status reason and delivery details
 					statusReason := ""
@@ -502,6 +598,10 @@ func (a *App) BounceWebhook(c echo.Context) error {
 					}
 				}
 
+				if idx.CampaignID == 0 {
+					idx.CampaignID = campaignID
+				}
+
 				// Parse timestamp
 				actionTime := time.Now()
 				if t, err := time.Parse(time.RFC3339, engagement.UserActionTimeStamp); err == nil {
@@ -581,6 +681,10 @@ func (a *App) BounceWebhook(c echo.Context) error {
 			}
 		}
 
+		if len(events) > 0 && numDups == len(events) {
+			responseBody = webhookDuplicate
+		}
+
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("bounces.unknownService"))
 	}
@@ -599,12 +703,113 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		}
 	}
 
+	// Fail the request if a bounce couldn't be recorded so that the provider or the inbox
+	// worker retries it. The deferred logger releases its idempotency keys.
+	if errorMsg != "" {
+		responseStatus = http.StatusInternalServerError
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
 	// Mark as successfully processed
 	processed = true
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// recordSESEvent stores an SES event for per-campaign delivery and complaint reporting.
+func (a *App) recordSESEvent(ev webhooks.SESEvent) {
+	if ev.Type == webhooks.SESEventRenderingFailure {
+		a.log.Printf("SES rendering failure for message %s: %s", ev.MessageID, ev.Reason)
+	}
+
+	meta := ev.Meta
+	if len(meta) == 0 {
+		meta = json.RawMessage("{}")
+	}
+
+	ts := ev.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	if _, err := a.queries.InsertSESEvent.Exec(ev.MessageID, ev.CampaignUUID, ev.SubscriberUUID, ev.Email,
+		ev.Type, ev.FeedbackType, ev.Reason, meta, ts); err != nil {
+		a.log.Printf("error storing SES event: %v", err)
+	}
+}
+
+// verifyBounceWebhook verifies the signature or credentials of a bounce webhook request
+// for the given service without processing it.
+func (a *App) verifyBounceWebhook(c echo.Context, service string, b []byte) error {
+	var err error
+	switch true {
+	// Native webhooks are authenticated by the API middleware.
+	case service == "":
+		var v models.Bounce
+		if err := json.Unmarshal(b, &v); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+":"+err.Error())
+		}
+		_, err := a.validateBounceFields(v)
+		return err
+
+	case service == "ses" && a.cfg.BounceSESEnabled:
+		_, err = a.bounce.SES.ProcessNotification(b)
+
+	case service == "sendgrid" && a.cfg.BounceSendgridEnabled:
+		_, err = a.bounce.Sendgrid.ProcessBounce(c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Signature"),
+			c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Timestamp"), b)
+
+	case service == "postmark" && a.cfg.BouncePostmarkEnabled:
+		_, err = a.bounce.Postmark.ProcessBounce(b, c)
+
+	case service == "forwardemail" && a.cfg.BounceForwardemailEnabled:
+		_, err = a.bounce.Forwardemail.ProcessBounce(c.Request().Header.Get("X-Webhook-Signature"), b)
+
+	// Event Grid requests aren't signed.
+	case service == "azure" && a.cfg.BounceAzureEnabled:
+		var events []json.RawMessage
+		err = json.Unmarshal(b, &events)
+
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("bounces.unknownService"))
+	}
+
+	if err != nil {
+		a.log.Printf("error verifying %s webhook: %v", service, err)
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	return nil
+}
+
+// isWebhookHandshake checks if a bounce webhook request is a subscription handshake
+// (SNS subscription confirmation or Event Grid subscription validation).
+func isWebhookHandshake(c echo.Context, service string, b []byte) bool {
+	switch service {
+	case "ses":
+		typ := c.Request().Header.Get("X-Amz-Sns-Message-Type")
+		return typ == "SubscriptionConfirmation" || typ == "UnsubscribeConfirmation"
+
+	case "azure":
+		var events []struct {
+			EventType string `json:"eventType"`
+		}
+		if err := json.Unmarshal(b, &events); err != nil {
+			return false
+		}
+		for _, e := range events {
+			if e.EventType == "Microsoft.EventGrid.SubscriptionValidationEvent" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (a *App) validateBounceFields(b models.Bounce) (models.Bounce, error) {
 	if b.Email == "" && b.SubscriberUUID == "" {
 		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email / subscriber_uuid"))
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/textproto"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/preflight"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// CheckCampaign renders a campaign for a sample subscriber and runs the pre-send checks
+// on it. The sample subscriber is a dummy subscriber unless a subscriber_id is given.
+func (a *App) CheckCampaign(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	req := struct {
+		SubscriberID int `json:"subscriber_id"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	sub := dummySubscriber
+	if req.SubscriberID > 0 {
+		// Check if the user has access to the subscriber.
+		if err := a.hasSubPerm(auth.GetUser(c), []int{req.SubscriberID}); err != nil {
+			return err
+		}
+
+		s, err := a.core.GetSubscriber(req.SubscriberID, "", "")
+		if err != nil {
+			return err
+		}
+		sub = s
+	}
+
+	out, err := a.checkCampaign(c.Request().Context(), id, sub)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// checkCampaign renders a campaign for a subscriber and runs the pre-send checks on it.
+func (a *App) checkCampaign(ctx context.Context, id int, sub models.Subscriber) (preflight.Report, error) {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return preflight.Report{}, err
+	}
+
+	// Links are checked at their destination and not at their tracking URLs, and the
+	// tracking pixel isn't a part of the content.
+	funcs := a.manager.TemplateFuncs(&camp)
+	funcs["TrackLink"] = func(url string, msg *manager.CampaignMessage) string {
+		return url
+	}
+	funcs["TrackView"] = func(msg *manager.CampaignMessage) template.HTML {
+		return ""
+	}
+	if err := camp.CompileTemplate(funcs); err != nil {
+		a.log.Printf("error compiling template: %v", err)
+		return preflight.Report{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	msg, err := a.manager.NewCampaignMessage(&camp, sub)
+	if err != nil {
+		a.log.Printf("error rendering message: %v", err)
+		return preflight.Report{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorRendering", "error", err.Error()))
+	}
+
+	// The headers that the campaign is sent with.
+	unsubURL := fmt.Sprintf(a.urlCfg.UnsubURL, camp.UUID, sub.UUID)
+	hdr := textproto.MIMEHeader{}
+	if a.cfg.Privacy.UnsubHeader {
+		hdr.Set("List-Unsubscribe", `<`+unsubURL+`>`)
+	}
+	for _, set := range camp.Headers {
+		for k, v := range set {
+			hdr.Add(k, v)
+		}
+	}
+
+	servers, err := a.getCampaignSMTPServers(camp.Messenger)
+	if err != nil {
+		return preflight.Report{}, err
+	}
+
+	out := a.preflight.Check(ctx, preflight.Message{
+		Subject:     msg.Subject(),
+		Body:        string(msg.Body()),
+		ContentType: camp.ContentType,
+		Headers:     hdr,
+		UnsubURL:    unsubURL,
+		Source:      camp.Subject + "\n" + camp.Body + "\n" + camp.AltBody.String + "\n" + camp.TemplateBody,
+		Attribs:     sub.Attribs,
+		FromEmail:   camp.FromEmail,
+		Servers:     servers,
+	})
+
+	return out, nil
+}
+
+// getCampaignSMTPServers returns the enabled SMTP servers that a campaign's messenger sends
+// through. The default e-mail messenger and the automatic (queue) messenger send through all
+// of them, and a named SMTP messenger through its server. Other messengers have none.
+func (a *App) getCampaignSMTPServers(messenger string) ([]preflight.Server, error) {
+	set, err := a.core.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []preflight.Server{}
+	for _, s := range set.SMTP {
+		if !s.Enabled {
+			continue
+		}
+		if messenger != email.MessengerName && messenger != "automatic" && messenger != s.Name {
+			continue
+		}
+
+		name := s.Name
+		if name == "" {
+			name = s.Host
+		}
+		out = append(out, preflight.Server{Name: name, Host: s.Host, FromEmail: s.FromEmail})
+	}
+
+	return out, nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// reviewReq is the comment of a reviewer on a campaign in its review.
+type reviewReq struct {
+	Comment string `json:"comment"`
+}
+
+// GetCampaignReviews returns the submissions, approvals, rejections and comments in the
+// review of a campaign.
+func (a *App) GetCampaignReviews(c echo.Context) error {
+	id := getID(c)
+
+	// Reviewers can see the reviews of all campaigns.
+	if user := auth.GetUser(c); !user.HasPerm(auth.PermCampaignsApprove) {
+		if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+			return err
+		}
+	}
+
+	out := []models.CampaignReview{}
+	if err := a.queries.GetCampaignReviews.Select(&out, id); err != nil {
+		a.log.Printf("error fetching campaign reviews: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// SubmitCampaignReview submits a draft campaign for review with a snapshot of its content.
+// Campaigns whose audience is over the approval threshold need approvals from two reviewers
+// other than the submitter.
+func (a *App) SubmitCampaignReview(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	req, err := a.bindReviewReq(c)
+	if err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if err := a.submitCampaignReview(camp, auth.GetUser(c), req.Comment); err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ApproveCampaign records the approval of a campaign in review by a reviewer. The campaign
+// is approved once it has the approvals it needs, after which it can be started or scheduled.
+func (a *App) ApproveCampaign(c echo.Context) error {
+	id := getID(c)
+
+	req, err := a.bindReviewReq(c)
+	if err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.Status != models.CampaignStatusPendingReview {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.notPendingReview"))
+	}
+
+	var approvers []int
+	if err := a.queries.GetCampaignApprovers.Select(&approvers, id); err != nil {
+		a.log.Printf("error fetching campaign approvers: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	// A reviewer only approves once, and campaigns that need two approvals can't be approved
+	// by the user who submitted them.
+	user := auth.GetUser(c)
+	if slices.Contains(approvers, user.ID) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.alreadyApproved"))
+	}
+	if camp.ApprovalsRequired > 1 && camp.SubmittedBy.Valid && camp.SubmittedBy.Int == user.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantApproveOwn"))
+	}
+
+	if err := a.insertCampaignReview(id, user, models.CampaignReviewApproved, req.Comment); err != nil {
+		return err
+	}
+
+	// The campaign is approved once it has the approvals it requires, which are counted
+	// in the query so that concurrent approvals by different reviewers aren't missed.
+	res, err := a.queries.ApproveCampaign.Exec(id)
+	if err != nil {
+		a.log.Printf("error approving campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		a.notifyCampaignSubmitter(camp, models.CampaignReviewApproved, user.Name, req.Comment)
+	}
+
+	out, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RejectCampaign rejects a campaign in review, or withdraws the approval of an approved
+// campaign, and returns it to draft.
+func (a *App) RejectCampaign(c echo.Context) error {
+	id := getID(c)
+
+	req, err := a.bindReviewReq(c)
+	if err != nil {
+		return err
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.Status != models.CampaignStatusPendingReview && camp.Status != models.CampaignStatusApproved {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.notPendingReview"))
+	}
+
+	user := auth.GetUser(c)
+	if err := a.insertCampaignReview(id, user, models.CampaignReviewRejected, req.Comment); err != nil {
+		return err
+	}
+	if _, err := a.queries.ResetCampaignReview.Exec(id, models.CampaignStatusDraft); err != nil {
+		a.log.Printf("error rejecting campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	a.notifyCampaignSubmitter(camp, models.CampaignReviewRejected, user.Name, req.Comment)
+
+	out, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CommentCampaignReview records a comment on the review of a campaign.
+func (a *App) CommentCampaignReview(c echo.Context) error {
+	id := getID(c)
+
+	user := auth.GetUser(c)
+	if !user.HasPerm(auth.PermCampaignsApprove) {
+		if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+			return err
+		}
+	}
+
+	req, err := a.bindReviewReq(c)
+	if err != nil {
+		return err
+	}
+	if req.Comment == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "comment"))
+	}
+
+	if _, err := a.core.GetCampaign(id, "", ""); err != nil {
+		return err
+	}
+
+	if err := a.insertCampaignReview(id, user, models.CampaignReviewCommented, req.Comment); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// isCampaignReviewEdited returns true if an edit to a campaign that's in review or approved
+// changes its reviewed content, which then needs another review. Approved campaigns that
+// have started can't be edited with approval on.
+func (a *App) isCampaignReviewEdited(cm, o models.Campaign, listIDs []int) (bool, error) {
+	if cm.Status != models.CampaignStatusPendingReview && cm.Status != models.CampaignStatusApproved && !cm.ApprovedAt.Valid {
+		return false, nil
+	}
+
+	content, err := makeCampaignContent(o, listIDs)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if content.Equal(cm.ReviewContent) {
+		return false, nil
+	}
+
+	if cm.StartedAt.Valid {
+		if a.cfg.CampaignApproval {
+			return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdateApproved"))
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// submitCampaignReview submits a draft campaign for review and notifies the reviewers other
+// than the submitter. Automated submissions have no user.
+func (a *App) submitCampaignReview(camp models.Campaign, user auth.User, comment string) error {
+	if camp.Status != models.CampaignStatusDraft {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.onlyDraftReview"))
+	}
+
+	content, err := makeCampaignContent(camp, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	approvals := 1
+	if a.cfg.CampaignApprovalThreshold > 0 {
+		n, err := a.core.CountCampaignAudience(camp.ID)
+		if err != nil {
+			return err
+		}
+		if n > a.cfg.CampaignApprovalThreshold {
+			approvals = 2
+		}
+	}
+
+	var reviewID int
+	if err := a.queries.SubmitCampaignReview.Get(&reviewID, camp.ID, user.ID, content, approvals, user.Name, comment); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.onlyDraftReview"))
+		}
+
+		a.log.Printf("error submitting campaign for review: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	// Notify the reviewers.
+	var emails []string
+	if err := a.queries.GetCampaignReviewerEmails.Select(&emails); err != nil {
+		a.log.Printf("error fetching campaign reviewers: %v", err)
+	}
+	emails = slices.DeleteFunc(emails, func(e string) bool {
+		return user.Email.Valid && strings.EqualFold(e, user.Email.String)
+	})
+	a.notifyCampaignReview(emails, camp, models.CampaignReviewSubmitted, user.Name, comment)
+
+	return nil
+}
+
+// copyCampaignApproval records the approval of a campaign (src) on a campaign that's cloned
+// from it to be sent automatically, such as the occurrence of a recurring campaign or a
+// resend, if the clone's content is the approved content. Clones with other content, eg: a
+// resend's subject or the items of a feed digest (hasExtra), are submitted for review when
+// the approval workflow is on, and false is returned as they can't be started until approved.
+func (a *App) copyCampaignApproval(camp *models.Campaign, src models.Campaign, hasExtra bool) (bool, error) {
+	reviewed := false
+	if src.ApprovedAt.Valid && !hasExtra {
+		content, err := makeCampaignContent(*camp, nil)
+		if err != nil {
+			return false, err
+		}
+		reviewed = content.Equal(src.ReviewContent)
+	}
+
+	if reviewed {
+		if _, err := a.queries.CopyCampaignApproval.Exec(camp.ID, src.ID); err != nil {
+			a.log.Printf("error copying campaign approval: %v", err)
+			return false, echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+		}
+		camp.ApprovedAt = src.ApprovedAt
+		return true, nil
+	}
+
+	if !a.cfg.CampaignApproval {
+		return true, nil
+	}
+
+	if err := a.submitCampaignReview(*camp, auth.User{}, ""); err != nil {
+		return false, err
+	}
+	camp.Status = models.CampaignStatusPendingReview
+
+	return false, nil
+}
+
+// resetCampaignReview returns a campaign that's in review or approved to draft when its
+// content is edited, as the edits need another review.
+func (a *App) resetCampaignReview(id int, user auth.User) error {
+	if _, err := a.queries.ResetCampaignReview.Exec(id, models.CampaignStatusDraft); err != nil {
+		a.log.Printf("error resetting campaign review: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return a.insertCampaignReview(id, user, models.CampaignReviewReset, "")
+}
+
+func (a *App) insertCampaignReview(id int, user auth.User, action, comment string) error {
+	var reviewID int
+	if err := a.queries.InsertCampaignReview.Get(&reviewID, id, user.ID, user.Name, action, comment); err != nil {
+		a.log.Printf("error recording campaign review: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return nil
+}
+
+func (a *App) bindReviewReq(c echo.Context) (reviewReq, error) {
+	var req reviewReq
+	if err := c.Bind(&req); err != nil {
+		return req, err
+	}
+
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > 5000 {
+		return req, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "comment"))
+	}
+
+	return req, nil
+}
+
+// notifyCampaignSubmitter notifies the user who submitted a campaign for review of its approval
+// or rejection.
+func (a *App) notifyCampaignSubmitter(camp models.Campaign, action, userName, comment string) {
+	if !camp.SubmittedBy.Valid {
+		return
+	}
+
+	u, err := a.core.GetUser(camp.SubmittedBy.Int, "", "")
+	if err != nil || !u.Email.Valid {
+		return
+	}
+
+	a.notifyCampaignReview([]string{u.Email.String}, camp, action, userName, comment)
+}
+
+// notifyCampaignReview e-mails a review action on a campaign to the given users.
+func (a *App) notifyCampaignReview(to []string, camp models.Campaign, action, userName, comment string) {
+	data := map[string]any{
+		"ID":       camp.ID,
+		"Name":     camp.Name,
+		"Action":   a.i18n.T("campaigns.review." + action),
+		"UserName": userName,
+		"Comment":  comment,
+	}
+
+	subject := a.i18n.Ts("email.review.subject", "name", camp.Name, "action", a.i18n.T("campaigns.review."+action))
+	if err := notifs.Notify(to, subject, notifs.TplCampaignReview, data, nil); err != nil {
+		a.log.Printf("error sending campaign review notification: %v", err)
+	}
+}
+
+// makeCampaignContent returns the reviewed content of a campaign. If listIDs is nil, the
+// lists of the campaign are used.
+func makeCampaignContent(c models.Campaign, listIDs []int) (models.CampaignContent, error) {
+	if listIDs == nil {
+		ids, _, err := campaignListMediaIDs(c)
+		if err != nil {
+			return models.CampaignContent{}, err
+		}
+		listIDs = ids
+	}
+
+	listIDs = slices.Clone(listIDs)
+	slices.Sort(listIDs)
+
+	return models.CampaignContent{
+		Subject:        c.Subject,
+		FromEmail:      c.FromEmail,
+		Body:           c.Body,
+		AltBody:        c.AltBody,
+		ContentType:    c.ContentType,
+		TemplateID:     c.TemplateID,
+		Headers:        c.Headers,
+		ListIDs:        listIDs,
+		ExcludeListIDs: c.ExcludeListIDs,
+		SegmentQuery:   c.SegmentQuery,
+		Variants:       c.Variants,
+	}, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -46,6 +48,10 @@ type campContentReq struct {
 	To   string `json:"to"`
 }
 
+// maxHoldoutPercent is the largest percentage of a campaign's audience that can be
+// held out as a control group.
+const maxHoldoutPercent = 50
+
 var (
 	reFromAddress = regexp.MustCompile(`((.+?)\s)?<(.+?)@(.+?)>`)
 	reSlug        = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]`)
@@ -105,7 +111,7 @@ func (a *App) GetCampaigns(c echo.Context) error {
 
 	// Get purchase stats for all campaigns
 	var purchaseStats []models.CampaignPurchaseStatsListItem
-	if err := a.queries.GetCampaignsPurchaseStats.Select(&purchaseStats, pq.Array(campaignIDs)); err != nil {
+	if err := a.queries.GetCampaignsPurchaseStats.Select(&purchaseStats, pq.Array(campaignIDs), a.cfg.ShopifyReportingCurrency); err != nil {
 		a.log.Printf("error fetching purchase stats for campaigns: %v", err)
 		// Don't fail the request, just log the error and continue without purchase stats
 	}
@@ -121,6 +127,7 @@ func (a *App) GetCampaigns(c echo.Context) error {
 		if stats, ok := purchaseStatsMap[res[i].ID]; ok {
 			res[i].PurchaseOrders = stats.TotalOrders
 			res[i].PurchaseRevenue = stats.TotalRevenue
+			res[i].PurchaseGrossRevenue = stats.GrossRevenue
 			res[i].PurchaseCurrency = stats.Currency
 		}
 	}
@@ -328,6 +335,11 @@ func (a *App) CreateCampaign(c echo.Context) error {
 		o.Messenger = "email"
 	}
 
+	// Does the user have the subscribers:sql_query permission to set a segment query?
+	if err := a.checkSegmentQueryPerm(o.SegmentQuery, "", c); err != nil {
+		return err
+	}
+
 	// Validate.
 	if c, err := a.validateCampaignFields(o); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -339,7 +351,7 @@ func (a *App) CreateCampaign(c echo.Context) error {
 		o.ArchiveTemplateID = o.TemplateID
 	}
 
-	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs, auth.GetUser(c))
 	if err != nil {
 		return err
 	}
@@ -371,22 +383,63 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 	// Read the incoming params into the existing campaign fields from the DB.
 	// This allows updating of values that have been sent whereas fields
 	// that are not in the request retain the old values.
+	// Binding reuses the backing array of the existing variants, so keep a copy to compare.
+	variants := append(models.CampaignVariants{}, cm.Variants...)
+
 	o := campReq{Campaign: cm}
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
 
+	// Does the user have the subscribers:sql_query permission to change the segment query?
+	if err := a.checkSegmentQueryPerm(o.SegmentQuery, cm.SegmentQuery, c); err != nil {
+		return err
+	}
+
 	if c, err := a.validateCampaignFields(o); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	} else {
 		o = c
 	}
 
-	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	// Changing the holdout of a campaign that has started sending would move subscribers
+	// between the recipients and the control group.
+	if cm.StartedAt.Valid && o.HoldoutPercent != cm.HoldoutPercent {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdateHoldout"))
+	}
+
+	// Likewise, the variants of an A/B test that has started are already being sent.
+	if cm.StartedAt.Valid && (o.ABTest != cm.ABTest || !variants.Equal(o.Variants)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdateABTest"))
+	}
+
+	// The audience of a campaign that has started is already being sent or snapshotted.
+	if cm.StartedAt.Valid && (o.SegmentQuery != cm.SegmentQuery || o.AudienceSnapshot != cm.AudienceSnapshot ||
+		!slices.Equal(o.ExcludeListIDs, cm.ExcludeListIDs)) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdateAudience"))
+	}
+
+	// Edits to the reviewed content of a campaign need another review.
+	reviewed, err := a.isCampaignReviewEdited(cm, o.Campaign, o.ListIDs)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs, auth.GetUser(c))
 	if err != nil {
 		return err
 	}
 
+	if reviewed {
+		if err := a.resetCampaignReview(id, auth.GetUser(c)); err != nil {
+			return err
+		}
+
+		if out, err = a.core.GetCampaign(id, "", ""); err != nil {
+			return err
+		}
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -407,6 +460,17 @@ func (a *App) UpdateCampaignStatus(c echo.Context) error {
 		return err
 	}
 
+	if req.Status == models.CampaignStatusRunning || req.Status == models.CampaignStatusScheduled {
+		cm, err := a.core.GetCampaign(id, "", "")
+		if err != nil {
+			return err
+		}
+
+		if err := a.checkCampaignStart(c.Request().Context(), cm); err != nil {
+			return err
+		}
+	}
+
 	// Update the campaign status in the DB.
 	out, err := a.core.UpdateCampaignStatus(id, req.Status)
 	if err != nil {
@@ -429,6 +493,34 @@ func (a *App) UpdateCampaignStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// checkCampaignStart returns an error if a campaign can't be started or scheduled as it
+// hasn't been approved with the approval workflow on, or as it fails the pre-send checks
+// when they're configured to block sends. Paused campaigns can be resumed. It's checked
+// on every path that starts a campaign, including recurring campaigns, resends and A/B
+// test winners.
+func (a *App) checkCampaignStart(ctx context.Context, cm models.Campaign) error {
+	if cm.Status == models.CampaignStatusPaused {
+		return nil
+	}
+
+	if a.cfg.CampaignApproval && !cm.ApprovedAt.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.needsApproval"))
+	}
+
+	if a.cfg.CampaignChecksBlock {
+		rep, err := a.checkCampaign(ctx, cm.ID, dummySubscriber)
+		if err != nil {
+			return err
+		}
+		if rep.Errors > 0 {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("campaigns.checks.failed", "num", strconv.Itoa(rep.Errors)))
+		}
+	}
+
+	return nil
+}
+
 // UpdateCampaignArchive handles campaign status modification.
 func (a *App) UpdateCampaignArchive(c echo.Context) error {
 	id := getID(c)
@@ -518,6 +610,19 @@ func (a *App) GetRunningCampaignStats(c echo.Context) error {
 		}
 	}
 
+	// Attach the variant stats of campaigns with an A/B test.
+	ids := make([]int, 0, len(out))
+	for _, c := range out {
+		ids = append(ids, c.ID)
+	}
+	variants, err := a.getCampaignVariantStats(ids)
+	if err != nil {
+		return err
+	}
+	for i, c := range out {
+		out[i].Variants = variants[c.ID]
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -628,6 +733,16 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 		return c.JSON(http.StatusOK, okResp{out})
 	}
 
+	// SES delivery, bounce and complaint stats.
+	if typ == "ses-events" {
+		out, err := a.core.GetCampaignSESEventCounts(ids, from, to)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
 	// Campaign link stats.
 	if typ == "links" {
 		out, err := a.core.GetCampaignAnalyticsLinks(ids, typ, from, to)
@@ -735,6 +850,26 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
 	}
 
+	if c.HoldoutPercent < 0 || c.HoldoutPercent > maxHoldoutPercent {
+		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidHoldout", "max", strconv.Itoa(maxHoldoutPercent)))
+	}
+
+	c, err := a.validateABTest(c)
+	if err != nil {
+		return c, err
+	}
+
+	c, err = a.validateCampaignAudience(c)
+	if err != nil {
+		return c, err
+	}
+
+	if c.Resend.Enabled {
+		if err := a.validateCampaignResend(c.Resend, 1); err != nil {
+			return c, err
+		}
+	}
+
 	if len(c.Headers) == 0 {
 		c.Headers = make([]map[string]string, 0)
 	}
@@ -743,6 +878,17 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		c.ArchiveMeta = json.RawMessage("{}")
 	}
 
+	// Default UTM parameters.
+	if c.UTM.Source == "" {
+		c.UTM.Source = "listmonk"
+	}
+	if c.UTM.Medium == "" {
+		c.UTM.Medium = "email"
+	}
+	if c.UTM.Campaign != models.CampaignUTMCampaignName {
+		c.UTM.Campaign = models.CampaignUTMCampaignUUID
+	}
+
 	if c.ArchiveSlug.String != "" {
 		// Format the slug to be alpha-numeric-dash.
 		s := strings.ToLower(c.ArchiveSlug.String)
@@ -840,7 +986,9 @@ func (a *App) checkCampaignPerm(types auth.PermType, id int, c echo.Context) err
 func canEditCampaign(status string) bool {
 	return status == models.CampaignStatusDraft ||
 		status == models.CampaignStatusPaused ||
-		status == models.CampaignStatusScheduled
+		status == models.CampaignStatusScheduled ||
+		status == models.CampaignStatusPendingReview ||
+		status == models.CampaignStatusApproved
 }
 
 // GetQueueStats returns statistics about the email queue
@@ -971,3 +1119,36 @@ func (a *App) RemoveSentSubscribersFromLists(c echo.Context) error {
 		"message": fmt.Sprintf("Removed %d subscribers from %d lists", len(subIDs), len(listIDs)),
 	}})
 }
+
+// campaignListMediaIDs returns the IDs of the lists and media of a campaign for cloning
+// it into a new campaign. Lists and media that have been deleted since have no ID.
+func campaignListMediaIDs(c models.Campaign) ([]int, []int, error) {
+	var lists, media []struct {
+		ID null.Int `json:"id"`
+	}
+	if len(c.Lists) > 0 {
+		if err := json.Unmarshal(c.Lists, &lists); err != nil {
+			return nil, nil, fmt.Errorf("error parsing campaign lists: %v", err)
+		}
+	}
+	if len(c.Media) > 0 {
+		if err := json.Unmarshal(c.Media, &media); err != nil {
+			return nil, nil, fmt.Errorf("error parsing campaign media: %v", err)
+		}
+	}
+
+	listIDs := make([]int, 0, len(lists))
+	for _, l := range lists {
+		if l.ID.Valid && l.ID.Int > 0 {
+			listIDs = append(listIDs, l.ID.Int)
+		}
+	}
+	mediaIDs := make([]int, 0, len(media))
+	for _, m := range media {
+		if m.ID.Valid && m.ID.Int > 0 {
+			mediaIDs = append(mediaIDs, m.ID.Int)
+		}
+	}
+
+	return listIDs, mediaIDs, nil
+}
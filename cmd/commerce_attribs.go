@@ -0,0 +1,32 @@
+package main
+
+import "time"
+
+// commerceChurnDays is the expected number of days between orders of customers
+// who have ordered once, used for their churn bucket.
+const commerceChurnDays = 90
+
+// syncCommerceAttribs periodically computes the lifetime value and RFM attributes
+// of subscribers from their purchases into the reserved attribs.commerce namespace.
+func (a *App) syncCommerceAttribs(interval time.Duration) {
+	fnSync := func() {
+		var n int
+		if err := a.queries.SyncCommerceAttribs.Get(&n, a.cfg.ShopifyReportingCurrency, commerceChurnDays); err != nil {
+			a.log.Printf("error syncing subscriber commerce attributes: %v", err)
+			return
+		}
+
+		if n > 0 {
+			a.log.Printf("updated commerce attributes of %d subscribers", n)
+		}
+	}
+
+	fnSync()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnSync()
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetCommerceFlows handles the retrieval of commerce flows.
+func (a *App) GetCommerceFlows(c echo.Context) error {
+	out := []models.CommerceFlow{}
+	if err := a.queries.GetCommerceFlows.Select(&out, 0); err != nil {
+		a.log.Printf("error fetching commerce flows: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "flows", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCommerceFlow handles the retrieval of a commerce flow.
+func (a *App) GetCommerceFlow(c echo.Context) error {
+	out, err := a.getCommerceFlow(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateCommerceFlow handles the creation of a commerce flow.
+func (a *App) CreateCommerceFlow(c echo.Context) error {
+	var o models.CommerceFlow
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateCommerceFlow(&o); err != nil {
+		return err
+	}
+
+	var id int
+	if err := a.queries.CreateCommerceFlow.Get(&id, o.Name, o.Trigger, o.DelayMinutes, o.TemplateID, o.Subject,
+		o.AddListIDs, o.RemoveListIDs, o.Enabled); err != nil {
+		a.log.Printf("error creating commerce flow: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "flow", "error", err.Error()))
+	}
+
+	out, err := a.getCommerceFlow(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateCommerceFlow handles the modification of a commerce flow. Jobs that are
+// already scheduled are run with the updated flow.
+func (a *App) UpdateCommerceFlow(c echo.Context) error {
+	id := getID(c)
+
+	var o models.CommerceFlow
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateCommerceFlow(&o); err != nil {
+		return err
+	}
+
+	res, err := a.queries.UpdateCommerceFlow.Exec(id, o.Name, o.Trigger, o.DelayMinutes, o.TemplateID, o.Subject,
+		o.AddListIDs, o.RemoveListIDs, o.Enabled)
+	if err != nil {
+		a.log.Printf("error updating commerce flow: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "flow", "error", err.Error()))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.notFound", "name", "flow"))
+	}
+
+	out, err := a.getCommerceFlow(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCommerceFlow handles the deletion of a commerce flow and its jobs.
+func (a *App) DeleteCommerceFlow(c echo.Context) error {
+	if _, err := a.queries.DeleteCommerceFlow.Exec(getID(c)); err != nil {
+		a.log.Printf("error deleting commerce flow: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorDeleting", "name", "flow", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getCommerceFlow returns a commerce flow.
+func (a *App) getCommerceFlow(id int) (models.CommerceFlow, error) {
+	var out []models.CommerceFlow
+	if err := a.queries.GetCommerceFlows.Select(&out, id); err != nil {
+		a.log.Printf("error fetching commerce flow: %v", err)
+		return models.CommerceFlow{}, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "flow", "error", err.Error()))
+	}
+	if len(out) == 0 {
+		return models.CommerceFlow{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "flow"))
+	}
+
+	return out[0], nil
+}
+
+// validateCommerceFlow validates the fields of a commerce flow.
+func (a *App) validateCommerceFlow(o *models.CommerceFlow) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	if o.Trigger != models.FlowTriggerCheckoutAbandoned && o.Trigger != models.FlowTriggerOrderPlaced {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "trigger"))
+	}
+
+	if o.DelayMinutes < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "delay_minutes"))
+	}
+
+	if o.TemplateID.Int == 0 {
+		o.TemplateID.Valid = false
+	}
+	if o.TemplateID.Valid {
+		if _, err := a.manager.GetTpl(o.TemplateID.Int); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.notFound", "name", fmt.Sprintf("template %d", o.TemplateID.Int)))
+		}
+	}
+
+	if o.AddListIDs == nil {
+		o.AddListIDs = pq.Int64Array{}
+	}
+	if o.RemoveListIDs == nil {
+		o.RemoveListIDs = pq.Int64Array{}
+	}
+
+	// Lists are only changed for customers who have purchased.
+	if o.Trigger == models.FlowTriggerCheckoutAbandoned && (len(o.AddListIDs) > 0 || len(o.RemoveListIDs) > 0) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "add_list_ids, remove_list_ids"))
+	}
+
+	if !o.TemplateID.Valid && len(o.AddListIDs) == 0 && len(o.RemoveListIDs) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "template_id, add_list_ids, remove_list_ids"))
+	}
+
+	return nil
+}
+
+// scheduleCheckoutFlows schedules the abandoned checkout flows of a checkout after its
+// last activity. Every update of the checkout delays them again, and a completed
+// checkout cancels them.
+func (a *App) scheduleCheckoutFlows(co *webhooks.ShopifyCheckout) error {
+	if co.IsCompleted() {
+		if _, err := a.queries.CancelCheckoutFlowJobs.Exec(co.Token, "", time.Time{}); err != nil {
+			return fmt.Errorf("error cancelling checkout flows: %v", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]json.RawMessage{"checkout": co.RawJSON})
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.queries.ScheduleCommerceFlowJobs.Exec(models.FlowTriggerCheckoutAbandoned,
+		co.Token, co.Email, string(data), co.LastActivity()); err != nil {
+		return fmt.Errorf("error scheduling checkout flows: %v", err)
+	}
+
+	return nil
+}
+
+// startOrderFlows cancels the abandoned checkout flows of the customer of a new order,
+// which recovers the checkouts, and schedules the order placed flows of the order.
+func (a *App) startOrderFlows(order *models.Order) error {
+	orderedAt := time.Now()
+	if order.CreatedAt.Valid {
+		orderedAt = order.CreatedAt.Time
+	}
+
+	if _, err := a.queries.CancelCheckoutFlowJobs.Exec(order.CheckoutToken, order.Email, orderedAt); err != nil {
+		return fmt.Errorf("error cancelling checkout flows: %v", err)
+	}
+
+	if order.CancelledAt.Valid || order.Email == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]any{"order": order})
+	if err != nil {
+		return err
+	}
+
+	if _, err := a.queries.ScheduleCommerceFlowJobs.Exec(models.FlowTriggerOrderPlaced,
+		order.Source+":"+order.OrderID, order.Email, string(data), orderedAt); err != nil {
+		return fmt.Errorf("error scheduling order flows: %v", err)
+	}
+
+	return nil
+}
+
+// runCommerceFlows periodically runs the flow jobs that are due. At most batchSize jobs
+// are run in an interval. Their messages are sent by the e-mail queue.
+func (a *App) runCommerceFlows(interval time.Duration, batchSize int) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	fnRun := func() {
+		var jobs []models.CommerceFlowJob
+		if err := a.queries.NextCommerceFlowJobs.Select(&jobs, batchSize); err != nil {
+			a.log.Printf("error fetching commerce flow jobs: %v", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		var flows []models.CommerceFlow
+		if err := a.queries.GetCommerceFlows.Select(&flows, 0); err != nil {
+			a.log.Printf("error fetching commerce flows: %v", err)
+			return
+		}
+		flowMap := make(map[int]models.CommerceFlow, len(flows))
+		for _, f := range flows {
+			flowMap[f.ID] = f
+		}
+
+		for _, j := range jobs {
+			status, err := a.runCommerceFlowJob(flowMap[j.FlowID], j)
+
+			errMsg := ""
+			if err != nil {
+				errMsg = err.Error()
+				if status == models.FlowJobStatusFailed {
+					a.log.Printf("error running commerce flow %d for %s: %v", j.FlowID, j.Ref, err)
+				}
+			}
+
+			if _, err := a.queries.UpdateCommerceFlowJob.Exec(j.ID, status, errMsg); err != nil {
+				a.log.Printf("error updating commerce flow job %d: %v", j.ID, err)
+			}
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// runCommerceFlowJob runs a flow for the subscriber of a job: the flow's tx template
+// is queued to the subscriber and the subscriber's lists are changed. It returns the
+// resultant status of the job. Customers who are not subscribers are skipped.
+func (a *App) runCommerceFlowJob(flow models.CommerceFlow, j models.CommerceFlowJob) (string, error) {
+	if flow.ID == 0 || !flow.Enabled {
+		return models.FlowJobStatusSkipped, fmt.Errorf("flow %d is disabled", j.FlowID)
+	}
+
+	sub, err := a.core.GetSubscriber(0, "", j.Email)
+	if err != nil {
+		if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
+			return models.FlowJobStatusSkipped, fmt.Errorf("%s is not a subscriber", j.Email)
+		}
+		return models.FlowJobStatusFailed, err
+	}
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return models.FlowJobStatusSkipped, fmt.Errorf("subscriber %d is blocklisted", sub.ID)
+	}
+
+	if len(flow.AddListIDs) > 0 {
+		if err := a.core.AddSubscriptions([]int{sub.ID}, int64sToInts(flow.AddListIDs), ""); err != nil {
+			return models.FlowJobStatusFailed, err
+		}
+	}
+	if len(flow.RemoveListIDs) > 0 {
+		if err := a.core.DeleteSubscriptions([]int{sub.ID}, int64sToInts(flow.RemoveListIDs)); err != nil {
+			return models.FlowJobStatusFailed, err
+		}
+	}
+
+	if !flow.TemplateID.Valid {
+		return models.FlowJobStatusSent, nil
+	}
+
+	// The checkout or order is available to the template in .Tx.Data.
+	var data map[string]any
+	if err := json.Unmarshal(j.Data, &data); err != nil {
+		return models.FlowJobStatusFailed, fmt.Errorf("error parsing job data: %v", err)
+	}
+	if err := a.queueTxTemplate(sub, flow.TemplateID.Int, flow.Subject, data); err != nil {
+		return models.FlowJobStatusFailed, err
+	}
+
+	return models.FlowJobStatusSent, nil
+}
+
+// int64sToInts converts a list of int64 IDs to ints.
+func int64sToInts(ids []int64) []int {
+	out := make([]int, len(ids))
+	for i, id := range ids {
+		out[i] = int(id)
+	}
+
+	return out
+}
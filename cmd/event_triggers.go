@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// maxEventNameLen is the longest name of an event.
+	maxEventNameLen = 200
+
+	// defaultSubscriberEvents is the default number of events of a subscriber that are returned.
+	defaultSubscriberEvents = 100
+)
+
+// TrackEvent handles the tracking of an application event of a subscriber. The event is
+// recorded and the enabled triggers whose filters match it are scheduled.
+func (a *App) TrackEvent(c echo.Context) error {
+	var req struct {
+		SubscriberEmail string      `json:"subscriber_email"`
+		SubscriberUUID  string      `json:"subscriber_uuid"`
+		Name            string      `json:"name"`
+		Properties      models.JSON `json:"properties"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if !strHasLen(req.Name, 1, maxEventNameLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+	if req.SubscriberEmail == "" && req.SubscriberUUID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "subscriber_email, subscriber_uuid"))
+	}
+	if req.Properties == nil {
+		req.Properties = models.JSON{}
+	}
+
+	sub, err := a.core.GetSubscriber(0, req.SubscriberUUID, strings.ToLower(strings.TrimSpace(req.SubscriberEmail)))
+	if err != nil {
+		return err
+	}
+
+	var out struct {
+		ID        int64 `db:"id" json:"id"`
+		Triggered int   `db:"triggered" json:"triggered"`
+	}
+	// The frequency caps of the event's triggers are locked for the subscriber before the
+	// event is inserted, in a separate statement, so that the insert counts the jobs of
+	// concurrent events of the subscriber that were committed while it waited.
+	tx, err := a.db.Beginx()
+	if err != nil {
+		a.log.Printf("error inserting subscriber event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "event", "error", err.Error()))
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Stmtx(a.queries.LockEventTriggerCaps).Exec(sub.ID, req.Name); err != nil {
+		a.log.Printf("error locking event trigger caps: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "event", "error", err.Error()))
+	}
+	if err := tx.Stmtx(a.queries.InsertSubscriberEvent).Get(&out, sub.ID, req.Name, req.Properties); err != nil {
+		a.log.Printf("error inserting subscriber event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "event", "error", err.Error()))
+	}
+	if err := tx.Commit(); err != nil {
+		a.log.Printf("error inserting subscriber event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "event", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSubscriberEvents handles the retrieval of the latest events of a subscriber.
+func (a *App) GetSubscriberEvents(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("per_page"))
+	if limit < 1 || limit > 1000 {
+		limit = defaultSubscriberEvents
+	}
+
+	out := []models.SubscriberEvent{}
+	if err := a.queries.GetSubscriberEvents.Select(&out, id, strings.TrimSpace(c.QueryParam("name")), limit); err != nil {
+		a.log.Printf("error fetching subscriber events: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "events", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetEventTriggers handles the retrieval of event triggers.
+func (a *App) GetEventTriggers(c echo.Context) error {
+	out := []models.EventTrigger{}
+	if err := a.queries.GetEventTriggers.Select(&out, 0); err != nil {
+		a.log.Printf("error fetching event triggers: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "triggers", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetEventTrigger handles the retrieval of an event trigger.
+func (a *App) GetEventTrigger(c echo.Context) error {
+	out, err := a.getEventTrigger(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateEventTrigger handles the creation of an event trigger.
+func (a *App) CreateEventTrigger(c echo.Context) error {
+	var o models.EventTrigger
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateEventTrigger(&o); err != nil {
+		return err
+	}
+
+	var id int
+	if err := a.queries.CreateEventTrigger.Get(&id, o.Name, o.Event, o.Filters, o.DelayMinutes, o.TemplateID,
+		o.Subject, o.SequenceID, o.CapCount, o.CapMinutes, o.Enabled); err != nil {
+		a.log.Printf("error creating event trigger: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "trigger", "error", err.Error()))
+	}
+
+	out, err := a.getEventTrigger(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateEventTrigger handles the modification of an event trigger. Jobs that are
+// already scheduled are run with the updated trigger.
+func (a *App) UpdateEventTrigger(c echo.Context) error {
+	id := getID(c)
+
+	var o models.EventTrigger
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateEventTrigger(&o); err != nil {
+		return err
+	}
+
+	res, err := a.queries.UpdateEventTrigger.Exec(id, o.Name, o.Event, o.Filters, o.DelayMinutes, o.TemplateID,
+		o.Subject, o.SequenceID, o.CapCount, o.CapMinutes, o.Enabled)
+	if err != nil {
+		a.log.Printf("error updating event trigger: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "trigger", "error", err.Error()))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.notFound", "name", "trigger"))
+	}
+
+	out, err := a.getEventTrigger(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteEventTrigger handles the deletion of an event trigger and its jobs.
+func (a *App) DeleteEventTrigger(c echo.Context) error {
+	if _, err := a.queries.DeleteEventTrigger.Exec(getID(c)); err != nil {
+		a.log.Printf("error deleting event trigger: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorDeleting", "name", "trigger", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getEventTrigger returns an event trigger.
+func (a *App) getEventTrigger(id int) (models.EventTrigger, error) {
+	var out []models.EventTrigger
+	if err := a.queries.GetEventTriggers.Select(&out, id); err != nil {
+		a.log.Printf("error fetching event trigger: %v", err)
+		return models.EventTrigger{}, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "trigger", "error", err.Error()))
+	}
+	if len(out) == 0 {
+		return models.EventTrigger{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "trigger"))
+	}
+
+	return out[0], nil
+}
+
+// validateEventTrigger validates the fields of an event trigger.
+func (a *App) validateEventTrigger(o *models.EventTrigger) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	o.Event = strings.TrimSpace(o.Event)
+	if !strHasLen(o.Event, 1, maxEventNameLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "event"))
+	}
+
+	// Filters are a JSON object that's matched against the properties of events.
+	if len(o.Filters) == 0 || string(o.Filters) == "null" {
+		o.Filters = json.RawMessage("{}")
+	}
+	var filters map[string]any
+	if err := json.Unmarshal(o.Filters, &filters); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "filters"))
+	}
+
+	if o.DelayMinutes < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "delay_minutes"))
+	}
+	if o.CapCount < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "cap_count"))
+	}
+	if o.CapMinutes < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "cap_minutes"))
+	}
+
+	if o.TemplateID.Int == 0 {
+		o.TemplateID.Valid = false
+	}
+	if o.TemplateID.Valid {
+		if _, err := a.manager.GetTpl(o.TemplateID.Int); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.notFound", "name", fmt.Sprintf("template %d", o.TemplateID.Int)))
+		}
+	}
+
+	if o.SequenceID.Int == 0 {
+		o.SequenceID.Valid = false
+	}
+	if o.SequenceID.Valid {
+		if _, err := a.getSequence(o.SequenceID.Int); err != nil {
+			return err
+		}
+	}
+
+	if !o.TemplateID.Valid && !o.SequenceID.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.missingFields", "name", "template_id, sequence_id"))
+	}
+
+	return nil
+}
+
+// runEventTriggers periodically runs the trigger jobs that are due. At most batchSize jobs
+// are run in an interval. Their messages are sent by the e-mail queue.
+func (a *App) runEventTriggers(interval time.Duration, batchSize int) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	fnRun := func() {
+		var jobs []models.EventTriggerJob
+		if err := a.queries.NextEventTriggerJobs.Select(&jobs, batchSize); err != nil {
+			a.log.Printf("error fetching event trigger jobs: %v", err)
+			return
+		}
+		if len(jobs) == 0 {
+			return
+		}
+
+		var triggers []models.EventTrigger
+		if err := a.queries.GetEventTriggers.Select(&triggers, 0); err != nil {
+			a.log.Printf("error fetching event triggers: %v", err)
+			return
+		}
+		trigMap := make(map[int]models.EventTrigger, len(triggers))
+		for _, t := range triggers {
+			trigMap[t.ID] = t
+		}
+
+		for _, j := range jobs {
+			status, err := a.runEventTriggerJob(trigMap[j.TriggerID], j)
+
+			errMsg := ""
+			if err != nil {
+				errMsg = err.Error()
+				if status == models.TriggerJobStatusFailed {
+					a.log.Printf("error running event trigger %d for subscriber %d: %v", j.TriggerID, j.SubscriberID, err)
+				}
+			}
+
+			if _, err := a.queries.UpdateEventTriggerJob.Exec(j.ID, status, errMsg); err != nil {
+				a.log.Printf("error updating event trigger job %d: %v", j.ID, err)
+			}
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// runEventTriggerJob runs a trigger for the subscriber of a job: the subscriber is entered
+// into the trigger's sequence and the trigger's tx template is queued to them. It returns the
+// resultant status of the job.
+func (a *App) runEventTriggerJob(t models.EventTrigger, j models.EventTriggerJob) (string, error) {
+	if t.ID == 0 || !t.Enabled {
+		return models.TriggerJobStatusSkipped, fmt.Errorf("trigger %d is disabled", j.TriggerID)
+	}
+
+	sub, err := a.core.GetSubscriber(j.SubscriberID, "", "")
+	if err != nil {
+		return models.TriggerJobStatusFailed, err
+	}
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return models.TriggerJobStatusSkipped, fmt.Errorf("subscriber %d is blocklisted", sub.ID)
+	}
+
+	// Subscribers are added to the sequence's list (if they're not on it already) as
+	// sequences exit subscribers who aren't on their list.
+	if t.SequenceID.Valid {
+		seq, err := a.getSequence(t.SequenceID.Int)
+		if err != nil {
+			return models.TriggerJobStatusFailed, err
+		}
+		if err := a.core.AddSubscriptions([]int{sub.ID}, []int{seq.ListID}, ""); err != nil {
+			return models.TriggerJobStatusFailed, err
+		}
+		if _, err := a.queries.EnterSequence.Exec(seq.ID, sub.ID); err != nil {
+			return models.TriggerJobStatusFailed, fmt.Errorf("error entering sequence %d: %v", seq.ID, err)
+		}
+	}
+
+	if !t.TemplateID.Valid {
+		return models.TriggerJobStatusSent, nil
+	}
+
+	// The event is available to the template in .Tx.Data.event.
+	var props map[string]any
+	if err := json.Unmarshal(j.EventProperties, &props); err != nil {
+		return models.TriggerJobStatusFailed, fmt.Errorf("error parsing event properties: %v", err)
+	}
+	data := map[string]any{
+		"event": map[string]any{
+			"name":       j.EventName,
+			"properties": props,
+			"created_at": j.EventCreatedAt,
+		},
+	}
+	if err := a.queueTxTemplate(sub, t.TemplateID.Int, t.Subject, data); err != nil {
+		return models.TriggerJobStatusFailed, err
+	}
+
+	return models.TriggerJobStatusSent, nil
+}
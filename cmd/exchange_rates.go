@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/internal/currency"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetExchangeRates returns the exchange rates used for normalizing purchase revenue.
+func (a *App) GetExchangeRates(c echo.Context) error {
+	out := []models.ExchangeRate{}
+	if err := a.queries.GetExchangeRates.Select(&out); err != nil {
+		a.log.Printf("error fetching exchange rates: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.errorFetching"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		ReportingCurrency string                `json:"reporting_currency"`
+		Source            string                `json:"source"`
+		Rates             []models.ExchangeRate `json:"rates"`
+	}{a.cfg.ShopifyReportingCurrency, a.cfg.ShopifyExchangeRatesSource, out}})
+}
+
+// UpdateExchangeRates replaces the exchange rates with the posted rates.
+func (a *App) UpdateExchangeRates(c echo.Context) error {
+	var r currency.Rates
+	if err := c.Bind(&r); err != nil {
+		return err
+	}
+	if err := r.Validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
+	if err := a.saveExchangeRates(r); err != nil {
+		a.log.Printf("error saving exchange rates: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	return a.GetExchangeRates(c)
+}
+
+// RefreshExchangeRates loads the exchange rates from the configured source.
+func (a *App) RefreshExchangeRates(c echo.Context) error {
+	if err := a.refreshExchangeRates(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return a.GetExchangeRates(c)
+}
+
+// syncExchangeRates periodically loads the exchange rates from the configured source
+// and converts purchases that don't have a rate to the reporting currency yet.
+func (a *App) syncExchangeRates(interval time.Duration) {
+	fnSync := func() {
+		if a.cfg.ShopifyExchangeRatesSource != "" {
+			if err := a.refreshExchangeRates(); err != nil {
+				a.log.Printf("error refreshing exchange rates: %v", err)
+			}
+			return
+		}
+
+		// Rates are maintained manually. Convert purchases whose reporting currency changed.
+		if err := a.normalizePurchases(); err != nil {
+			a.log.Printf("error normalizing purchases: %v", err)
+		}
+	}
+
+	fnSync()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnSync()
+	}
+}
+
+// refreshExchangeRates loads and saves the rates from the configured source.
+func (a *App) refreshExchangeRates() error {
+	if a.cfg.ShopifyExchangeRatesSource == "" {
+		return errors.New("no exchange rates source configured")
+	}
+
+	r, err := currency.Load(a.cfg.ShopifyExchangeRatesSource, time.Second*30)
+	if err != nil {
+		return err
+	}
+
+	if err := a.saveExchangeRates(r); err != nil {
+		return fmt.Errorf("error saving exchange rates: %v", err)
+	}
+
+	a.log.Printf("loaded %d exchange rates (base %s)", len(r.Rates), r.Base)
+	return nil
+}
+
+// saveExchangeRates replaces the exchange rates and converts purchases that couldn't
+// be converted before.
+func (a *App) saveExchangeRates(r currency.Rates) error {
+	var (
+		codes = make([]string, 0, len(r.Rates))
+		rates = make([]float64, 0, len(r.Rates))
+	)
+	for c, v := range r.Rates {
+		codes = append(codes, c)
+		rates = append(rates, v)
+	}
+
+	if _, err := a.queries.UpdateExchangeRates.Exec(r.Base, pq.Array(codes), pq.Array(rates)); err != nil {
+		return err
+	}
+
+	return a.normalizePurchases()
+}
+
+// normalizePurchases converts purchases without a rate to the current reporting currency.
+func (a *App) normalizePurchases() error {
+	res, err := a.queries.NormalizePurchases.Exec(a.cfg.ShopifyReportingCurrency)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		a.log.Printf("converted %d purchases to %s", n, a.cfg.ShopifyReportingCurrency)
+	}
+	return nil
+}
@@ -117,6 +117,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/subscribers/:id", pm(hasID(a.GetSubscriber), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/export", pm(hasID(a.ExportSubscriberData), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/bounces", pm(hasID(a.GetSubscriberBounces), "bounces:get"))
+		g.GET("/api/subscribers/:id/events", pm(hasID(a.GetSubscriberEvents), "subscribers:get_all", "subscribers:get"))
 		g.DELETE("/api/subscribers/:id/bounces", pm(hasID(a.DeleteSubscriberBounces), "bounces:manage"))
 		g.GET("/api/subscribers/:id/azure-delivery-events", pm(hasID(a.GetSubscriberAzureDeliveryEvents), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/azure-engagement-events", pm(hasID(a.GetSubscriberAzureEngagementEvents), "subscribers:get_all", "subscribers:get"))
@@ -139,6 +140,11 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/webhook-logs", pm(a.GetWebhookLogs, "settings:get"))
 		g.GET("/api/webhook-logs/export", pm(a.ExportWebhookLogs, "settings:get"))
 		g.DELETE("/api/webhook-logs", pm(a.DeleteWebhookLogs, "settings:manage"))
+		g.POST("/api/webhook-logs/replay", pm(a.ReplayWebhookLogs, "settings:manage"))
+		g.POST("/api/webhook-logs/:id/replay", pm(hasID(a.ReplayWebhookLog), "settings:manage"))
+		g.GET("/api/webhook-inbox", pm(a.GetWebhookInbox, "settings:get"))
+		g.GET("/api/webhook-inbox/stats", pm(a.GetWebhookInboxStats, "settings:get"))
+		g.POST("/api/webhook-inbox/:id/retry", pm(hasID(a.RetryWebhookInboxEntry), "settings:manage"))
 
 		// Subscriber operations based on arbitrary SQL queries.
 		// These aren't very REST-like.
@@ -162,12 +168,21 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 		g.GET("/api/campaigns", pm(a.GetCampaigns, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/running/stats", pm(a.GetRunningCampaignStats, "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/recurring", pm(a.GetCampaignRecurrences, "campaigns:get_all"))
+		g.GET("/api/campaigns/recurring/:id", pm(hasID(a.GetCampaignRecurrence), "campaigns:get_all"))
+		g.GET("/api/campaigns/recurring/:id/occurrences", pm(hasID(a.GetCampaignOccurrences), "campaigns:get_all"))
+		g.GET("/api/campaigns/recurring/:id/items", pm(hasID(a.GetCampaignFeedItems), "campaigns:get_all"))
+		g.POST("/api/campaigns/recurring/feed", pm(a.PreviewCampaignFeed, "campaigns:manage_all"))
+		g.POST("/api/campaigns/recurring", pm(a.CreateCampaignRecurrence, "campaigns:manage_all"))
+		g.PUT("/api/campaigns/recurring/:id", pm(hasID(a.UpdateCampaignRecurrence), "campaigns:manage_all"))
+		g.DELETE("/api/campaigns/recurring/:id", pm(hasID(a.DeleteCampaignRecurrence), "campaigns:manage_all"))
 		g.GET("/api/campaigns/:id", pm(hasID(a.GetCampaign), "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/analytics/:type", pm(a.GetCampaignViewAnalytics, "campaigns:get_analytics"))
 		g.GET("/api/campaigns/:id/unsubscribers", pm(hasID(a.GetCampaignUnsubscribers), "campaigns:get_analytics"))
 		g.GET("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview/archive", pm(hasID(a.PreviewCampaignArchive), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/check", pm(hasID(a.CheckCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/content", pm(hasID(a.CampaignContent), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns/:id/text", pm(hasID(a.PreviewCampaign), "campaigns:get"))
 		g.POST("/api/campaigns/:id/test", pm(hasID(a.TestCampaign), "campaigns:manage_all", "campaigns:manage"))
@@ -177,6 +192,20 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/campaigns/:id/archive", pm(hasID(a.UpdateCampaignArchive), "campaigns:manage_all", "campaigns:manage"))
 		g.DELETE("/api/campaigns/:id", pm(hasID(a.DeleteCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns/:id/remove-sent-today", pm(hasID(a.RemoveSentSubscribersFromLists), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/variants/stats", pm(hasID(a.GetCampaignVariantStats), "campaigns:get_analytics"))
+		g.POST("/api/campaigns/:id/variants/winner", pm(hasID(a.SendCampaignWinner), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/resends", pm(hasID(a.GetCampaignResendStats), "campaigns:get_analytics"))
+		g.POST("/api/campaigns/:id/resend", pm(hasID(a.ResendCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/audience", pm(hasID(a.GetCampaignAudience), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/reviews", pm(hasID(a.GetCampaignReviews), "campaigns:get_all", "campaigns:get", "campaigns:approve"))
+		g.POST("/api/campaigns/:id/reviews", pm(hasID(a.CommentCampaignReview), "campaigns:get_all", "campaigns:get", "campaigns:approve"))
+		g.POST("/api/campaigns/:id/submit", pm(hasID(a.SubmitCampaignReview), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/approve", pm(hasID(a.ApproveCampaign), "campaigns:approve"))
+		g.POST("/api/campaigns/:id/reject", pm(hasID(a.RejectCampaign), "campaigns:approve"))
+		g.GET("/api/campaigns/:id/versions", pm(hasID(a.GetCampaignVersions), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/versions/diff", pm(hasID(a.DiffCampaignVersions), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/versions/:version", pm(hasID(a.GetCampaignVersion), "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/:id/versions/:version/restore", pm(hasID(a.RestoreCampaignVersion), "campaigns:manage_all", "campaigns:manage"))
 
 		// Azure Event Grid Analytics API endpoints
 		g.GET("/api/campaigns/:id/azure-analytics", pm(hasID(a.GetCampaignAzureAnalytics), "campaigns:get_analytics"))
@@ -185,7 +214,31 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 		// Shopify Purchase Attribution API endpoints
 		g.GET("/api/campaigns/:id/purchases/stats", pm(hasID(a.GetCampaignPurchaseStats), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/purchases/products", pm(hasID(a.GetCampaignProductStats), "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/purchases/holdout", pm(hasID(a.GetCampaignHoldoutStats), "campaigns:get_analytics"))
 		g.GET("/api/campaigns/performance/summary", pm(a.GetCampaignsPerformanceSummary, "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/orders", pm(a.CreateOrder, "orders:post"))
+		g.GET("/api/commerce/flows", pm(a.GetCommerceFlows, "flows:get"))
+		g.GET("/api/commerce/flows/:id", pm(hasID(a.GetCommerceFlow), "flows:get"))
+		g.POST("/api/commerce/flows", pm(a.CreateCommerceFlow, "flows:manage"))
+		g.PUT("/api/commerce/flows/:id", pm(hasID(a.UpdateCommerceFlow), "flows:manage"))
+		g.DELETE("/api/commerce/flows/:id", pm(hasID(a.DeleteCommerceFlow), "flows:manage"))
+		g.GET("/api/sequences", pm(a.GetSequences, "sequences:get"))
+		g.GET("/api/sequences/:id", pm(hasID(a.GetSequence), "sequences:get"))
+		g.POST("/api/sequences", pm(a.CreateSequence, "sequences:manage"))
+		g.PUT("/api/sequences/:id", pm(hasID(a.UpdateSequence), "sequences:manage"))
+		g.DELETE("/api/sequences/:id", pm(hasID(a.DeleteSequence), "sequences:manage"))
+		g.POST("/api/events/track", pm(a.TrackEvent, "events:track"))
+		g.GET("/api/events/triggers", pm(a.GetEventTriggers, "triggers:get"))
+		g.GET("/api/events/triggers/:id", pm(hasID(a.GetEventTrigger), "triggers:get"))
+		g.POST("/api/events/triggers", pm(a.CreateEventTrigger, "triggers:manage"))
+		g.PUT("/api/events/triggers/:id", pm(hasID(a.UpdateEventTrigger), "triggers:manage"))
+		g.DELETE("/api/events/triggers/:id", pm(hasID(a.DeleteEventTrigger), "triggers:manage"))
+		g.GET("/api/exchange-rates", pm(a.GetExchangeRates, "settings:get"))
+		g.PUT("/api/exchange-rates", pm(a.UpdateExchangeRates, "settings:manage"))
+		g.POST("/api/exchange-rates/refresh", pm(a.RefreshExchangeRates, "settings:manage"))
+		g.GET("/api/shopify/customers/sync", pm(a.GetShopifyCustomerSync, "subscribers:get_all"))
+		g.POST("/api/shopify/customers/sync", pm(a.SyncShopifyCustomers, "subscribers:manage"))
 
 		// Queue system API endpoints
 		g.GET("/api/queue/items", pm(a.GetQueueItems, "campaigns:get_all", "campaigns:get"))
@@ -209,6 +262,10 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.POST("/api/templates", pm(a.CreateTemplate, "templates:manage"))
 		g.PUT("/api/templates/:id", pm(hasID(a.UpdateTemplate), "templates:manage"))
 		g.PUT("/api/templates/:id/default", pm(hasID(a.TemplateSetDefault), "templates:manage"))
+		g.GET("/api/templates/:id/versions", pm(hasID(a.GetTemplateVersions), "templates:get"))
+		g.GET("/api/templates/:id/versions/diff", pm(hasID(a.DiffTemplateVersions), "templates:get"))
+		g.GET("/api/templates/:id/versions/:version", pm(hasID(a.GetTemplateVersion), "templates:get"))
+		g.POST("/api/templates/:id/versions/:version/restore", pm(hasID(a.RestoreTemplateVersion), "templates:manage"))
 		g.DELETE("/api/templates/:id", pm(hasID(a.DeleteTemplate), "templates:manage"))
 
 		g.DELETE("/api/maintenance/subscribers/:type", pm(a.GCSubscribers, "settings:maintain"))
@@ -259,7 +316,15 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		// Shopify webhook endpoint (always enabled if configured)
 		if a.cfg.ShopifyEnabled {
 			g.POST("/webhooks/shopify/orders", a.ShopifyWebhook)
-			a.log.Printf("Registered Shopify webhook route: POST /webhooks/shopify/orders")
+			g.POST("/webhooks/shopify/customers", a.ShopifyWebhook)
+			g.POST("/webhooks/shopify/checkouts", a.ShopifyWebhook)
+			a.log.Printf("Registered Shopify webhook routes: POST /webhooks/shopify/orders, /webhooks/shopify/customers, /webhooks/shopify/checkouts")
+		}
+		if a.cfg.WooCommerceEnabled {
+			g.POST("/webhooks/woocommerce/orders", a.WooCommerceWebhook)
+		}
+		if a.cfg.StripeEnabled {
+			g.POST("/webhooks/stripe", a.StripeWebhook)
 		}
 
 		// Landing page.
@@ -10,6 +10,7 @@ import (
 	"html/template"
 	"log"
 	"maps"
+	"net"
 	"net/http"
 	"os"
 	"path"
@@ -32,6 +33,8 @@ import (
 	"github.com/knadh/koanf/providers/posflag"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/attribution"
+	"github.com/knadh/listmonk/internal/currency"
 	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
 	"github.com/knadh/listmonk/internal/captcha"
@@ -45,6 +48,8 @@ import (
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/messenger/postback"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/preflight"
+	"github.com/knadh/listmonk/internal/inbox"
 	"github.com/knadh/listmonk/internal/queue"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
@@ -85,6 +90,11 @@ type Config struct {
 	EnablePublicArchiveRSSContent bool     `koanf:"enable_public_archive_rss_content"`
 	Lang                          string   `koanf:"lang"`
 	DBBatchSize                   int      `koanf:"batch_size"`
+	CampaignApproval              bool     `koanf:"campaign_approval"`
+	CampaignApprovalThreshold     int      `koanf:"campaign_approval_threshold"`
+	CampaignChecksBlock           bool     `koanf:"campaign_checks_block"`
+	CampaignChecksLinkAllowlist   []string `koanf:"campaign_checks_link_allowlist"`
+	CampaignChecksDKIMSelectors   []string `koanf:"campaign_checks_dkim_selectors"`
 	Privacy                       struct {
 		IndividualTracking bool            `koanf:"individual_tracking"`
 		AllowPreferences   bool            `koanf:"allow_preferences"`
@@ -149,6 +159,27 @@ type Config struct {
 	ShopifyEnabled               bool
 	ShopifyWebhookSecret         string
 	ShopifyAttributionWindowDays int
+	ShopifyAttributionModel      string
+	ShopifySyncCustomers         bool
+	ShopifySyncListIDs           []int
+	ShopifyShopURL               string
+	ShopifyAccessToken           string
+	ShopifyAPIVersion            string
+	ShopifyReportingCurrency     string
+	ShopifyExchangeRatesSource   string
+	WooCommerceEnabled           bool
+	WooCommerceWebhookSecret     string
+	StripeEnabled                bool
+	StripeWebhookSecret          string
+
+	WebhookLogRetention   []models.WebhookLogRetention
+	WebhookIdempotencyTTL time.Duration
+
+	WebhookInbox struct {
+		Enabled     bool
+		Workers     int
+		MaxAttempts int
+	}
 
 	PermissionsRaw json.RawMessage
 	Permissions    map[string]struct{}
@@ -490,6 +521,46 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	if c.ShopifyAttributionWindowDays == 0 {
 		c.ShopifyAttributionWindowDays = 7 // Default to 7 days
 	}
+	c.ShopifyAttributionModel = ko.String("shopify.attribution_model")
+	if !attribution.IsModel(c.ShopifyAttributionModel) {
+		c.ShopifyAttributionModel = attribution.ModelLastClick
+	}
+	c.ShopifySyncCustomers = ko.Bool("shopify.sync_customers")
+	c.ShopifySyncListIDs = ko.Ints("shopify.sync_lists")
+	c.ShopifyShopURL = ko.String("shopify.shop_url")
+	c.ShopifyAccessToken = ko.String("shopify.access_token")
+	c.ShopifyAPIVersion = ko.String("shopify.api_version")
+	c.ShopifyReportingCurrency = strings.ToUpper(ko.String("shopify.reporting_currency"))
+	if !currency.IsCode(c.ShopifyReportingCurrency) {
+		c.ShopifyReportingCurrency = "USD"
+	}
+	c.ShopifyExchangeRatesSource = ko.String("shopify.exchange_rates_source")
+
+	// Load WooCommerce and Stripe order webhook settings.
+	c.WooCommerceEnabled = ko.Bool("woocommerce.enabled")
+	c.WooCommerceWebhookSecret = ko.String("woocommerce.webhook_secret")
+	c.StripeEnabled = ko.Bool("stripe.enabled")
+	c.StripeWebhookSecret = ko.String("stripe.webhook_secret")
+
+	// Load webhook log retention policies.
+	for _, r := range ko.Slices("webhook_logs.retention") {
+		c.WebhookLogRetention = append(c.WebhookLogRetention, models.WebhookLogRetention{
+			WebhookType: r.String("webhook_type"),
+			Days:        r.Int("days"),
+			MaxRows:     r.Int("max_rows"),
+		})
+	}
+
+	// How long processed webhook idempotency keys are remembered for.
+	c.WebhookIdempotencyTTL = ko.Duration("webhook_logs.idempotency_ttl")
+	if c.WebhookIdempotencyTTL <= 0 {
+		c.WebhookIdempotencyTTL = time.Hour * 72
+	}
+
+	// Load webhook inbox settings.
+	c.WebhookInbox.Enabled = ko.Bool("webhook_inbox.enabled")
+	c.WebhookInbox.Workers = ko.Int("webhook_inbox.workers")
+	c.WebhookInbox.MaxAttempts = ko.Int("webhook_inbox.max_attempts")
 
 	c.HasLegacyUser = ko.Exists("app.admin_username") || ko.Exists("app.admin_password")
 
@@ -778,6 +849,30 @@ func initQueueProcessor(db *sqlx.DB, settings models.Settings) *queue.Processor
 	return proc
 }
 
+// initWebhookInbox initializes the durable inbox that incoming webhooks are persisted
+// to before they are processed by its workers.
+func initWebhookInbox(db *sqlx.DB, cfg *Config) *inbox.Inbox {
+	return inbox.New(db, inbox.Config{
+		Workers:         cfg.WebhookInbox.Workers,
+		MaxAttempts:     cfg.WebhookInbox.MaxAttempts,
+		PollInterval:    time.Second * 5,
+		RetryBackoff:    time.Second * 30,
+		MaxRetryBackoff: time.Hour,
+		StaleTimeout:    time.Minute * 10,
+		Retention:       time.Hour * 24 * 7,
+	}, lo)
+}
+
+// initPreflight initializes the pre-send checker of campaigns, which requests the links
+// in campaigns and looks up the DNS records of their from-domains.
+func initPreflight(cfg *Config, u *UrlConfig, i *i18n.I18n) *preflight.Checker {
+	return preflight.New(preflight.Config{
+		RootURL:       u.RootURL,
+		LinkAllowlist: cfg.CampaignChecksLinkAllowlist,
+		DKIMSelectors: cfg.CampaignChecksDKIMSelectors,
+	}, net.DefaultResolver, preflight.NewClient(time.Second*10), i)
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore(ko *koanf.Koanf) media.Store {
 	switch provider := ko.String("upload.provider"); provider {
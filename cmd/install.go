@@ -267,6 +267,7 @@ func installCampaign(campTplID, archiveTplID int, q *models.Queries) {
 		`{"name": "Subscriber"}`,
 		nil,
 		nil,
+		models.CampaignUTM{},
 	); err != nil {
 		lo.Fatalf("error creating sample campaign: %v", err)
 	}
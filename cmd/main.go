@@ -21,9 +21,11 @@ import (
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/inbox"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/preflight"
 	"github.com/knadh/listmonk/internal/queue"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
@@ -53,6 +55,11 @@ type App struct {
 	log          *log.Logger
 	bufLog       *buflog.BufLog
 	queueProc    *queue.Processor
+	inbox        *inbox.Inbox
+	preflight    *preflight.Checker
+
+	// Status of the on-demand Shopify customer backfill.
+	shopifySync shopifyCustomerSync
 
 	about         about
 	fnOptinNotify func(models.Subscriber, []int) (int, error)
@@ -252,8 +259,8 @@ func main() {
 
 	// Wire up the email sending callback for the queue processor
 	// This allows the processor to actually send emails via the campaign manager
-	queueProc.SetPushEmailCallback(func(campaignID int, subID int, serverUUID string) error {
-		return mgr.PushCampaignMessageByID(campaignID, subID, serverUUID)
+	queueProc.SetPushEmailCallback(func(campaignID int, subID int, variantID int, serverUUID string) error {
+		return mgr.PushCampaignMessageByID(campaignID, subID, variantID, serverUUID)
 	})
 
 	// =========================================================================
@@ -278,6 +285,7 @@ func main() {
 		events:     evStream,
 		bufLog:     bufLog,
 		queueProc:  queueProc,
+		preflight:  initPreflight(cfg, urlCfg, i18n),
 
 		pg: paginator.New(paginator.Opt{
 			DefaultPerPage: 20,
@@ -296,11 +304,48 @@ func main() {
 		needsUserSetup: !hasUsers,
 	}
 
+	// Send the tx messages of automations that are queued in the e-mail queue.
+	queueProc.SetPushTxCallback(app.sendQueuedTx)
+
 	// Star the update checker.
 	if ko.Bool("app.check_updates") {
 		go app.checkUpdates(versionString, time.Hour*24)
 	}
 
+	// Start the webhook inbox workers that process webhooks acknowledged by the handlers.
+	if cfg.WebhookInbox.Enabled {
+		app.inbox = initWebhookInbox(db, cfg)
+		app.inbox.SetProcessCallback(app.processInboxEntry)
+		go app.inbox.Start()
+	}
+
+	// Start the webhook log retention and idempotency key expiry job.
+	go app.pruneWebhookLogs(time.Hour)
+
+	// Start the exchange rate refresh and purchase revenue normalization job.
+	go app.syncExchangeRates(time.Hour * 24)
+
+	// Start the subscriber lifetime value and RFM attribute job.
+	go app.syncCommerceAttribs(time.Hour)
+
+	// Start the A/B test runner that sends the winners of campaigns' A/B tests.
+	go app.runABTests(time.Minute)
+
+	// Start the commerce flow runner that queues the messages of due flows in the e-mail queue.
+	go app.runCommerceFlows(time.Minute, ko.Int("app.message_rate")*60)
+
+	// Start the drip sequence runner that queues the due steps of subscribers in sequences.
+	go app.runSequences(time.Minute)
+
+	// Start the event trigger runner that queues the messages of due triggers in the e-mail queue.
+	go app.runEventTriggers(time.Minute, ko.Int("app.message_rate")*60)
+
+	// Start the recurring campaign runner that creates and starts the campaigns of occurrences.
+	go app.runCampaignRecurrences(time.Minute)
+
+	// Start the resend runner that creates the resends of finished campaigns to non-openers.
+	go app.runCampaignResends(time.Minute)
+
 	// Start the app server.
 	srv := initHTTPServer(cfg, urlCfg, i18n, fs, app)
 
@@ -325,6 +370,11 @@ func main() {
 			app.queueProc.Stop()
 		}
 
+		// Stop the webhook inbox workers.
+		if app.inbox != nil {
+			app.inbox.Stop()
+		}
+
 		// Close the DB pool.
 		db.Close()
 
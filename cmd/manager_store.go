@@ -37,6 +37,11 @@ func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
 // campaigns that are also being processed. Additionally, it takes a map of campaignID:sentCount
 // of campaigns that are being processed and updates them in the DB.
 func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error) {
+	// Campaigns that are only sent to a snapshot of their audience are picked once it's taken.
+	if err := s.core.SnapshotDueCampaignAudiences(); err != nil {
+		return nil, err
+	}
+
 	var out []*models.Campaign
 	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts))
 	return out, err
@@ -47,23 +52,32 @@ func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models
 // and every batch takes the last ID of the last batch and fetches the next
 // batch above that.
 func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error) {
-	var camps []runningCamp
-	if err := s.queries.GetRunningCampaign.Select(&camps, campID); err != nil {
-		return nil, err
+	// A batch that only has subscribers in the campaign's holdout moves the checkpoint
+	// without returning any subscribers. Fetch batches until there are subscribers to
+	// send to or the checkpoint doesn't move, which means there are no more subscribers.
+	lastID := -1
+	for {
+		var camps []runningCamp
+		if err := s.queries.GetRunningCampaign.Select(&camps, campID); err != nil {
+			return nil, err
+		}
+
+		var listIDs []int
+		for _, c := range camps {
+			listIDs = append(listIDs, c.ListID)
+		}
+
+		if len(listIDs) == 0 || camps[0].LastSubscriberID == lastID {
+			return nil, nil
+		}
+		lastID = camps[0].LastSubscriberID
+
+		var out []models.Subscriber
+		err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
+		if err != nil || len(out) > 0 {
+			return out, err
+		}
 	}
-
-	var listIDs []int
-	for _, c := range camps {
-		listIDs = append(listIDs, c.ListID)
-	}
-
-	if len(listIDs) == 0 {
-		return nil, nil
-	}
-
-	var out []models.Subscriber
-	err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
-	return out, err
 }
 
 // GetCampaign fetches a campaign from the database.
@@ -73,6 +87,13 @@ func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
 	return out, err
 }
 
+// GetCampaignVariant fetches an A/B test variant of a campaign from the database.
+func (s *store) GetCampaignVariant(campID, variantID int) (models.CampaignVariant, error) {
+	var out models.CampaignVariant
+	err := s.queries.GetCampaignVariant.Get(&out, campID, variantID)
+	return out, err
+}
+
 // GetSubscriber fetches a subscriber from the database.
 func (s *store) GetSubscriber(id int, uuid, email string) (models.Subscriber, error) {
 	return s.core.GetSubscriber(id, uuid, email)
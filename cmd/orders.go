@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/currency"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// CreateOrder handles the ingestion of orders from any store through the API. A new
+// order is attributed to campaigns and an existing order of the same source is updated.
+func (a *App) CreateOrder(c echo.Context) error {
+	var o models.Order
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+
+	o.Source = strings.ToLower(strings.TrimSpace(o.Source))
+	if o.Source == "" {
+		o.Source = models.OrderSourceAPI
+	}
+	o.OrderID = strings.TrimSpace(o.OrderID)
+	o.Currency = strings.ToUpper(strings.TrimSpace(o.Currency))
+
+	if o.OrderID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "order_id"))
+	}
+	em, err := a.importer.SanitizeEmail(o.Email)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email"))
+	}
+	o.Email = strings.ToLower(em)
+	if o.TotalPrice < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "total_price"))
+	}
+	if o.Currency != "" && !currency.IsCode(o.Currency) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "currency"))
+	}
+
+	if err := a.ingestOrder(&o); err != nil {
+		a.log.Printf("error ingesting %s order %s: %v", o.Source, o.OrderID, err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// ingestOrder attributes a new order or updates the purchase of an existing order.
+func (a *App) ingestOrder(o *models.Order) error {
+	ok, err := a.hasPurchase(o.Source, o.OrderID)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return a.updatePurchase(o)
+	}
+
+	// A cancelled order that was never recorded has nothing to void.
+	if o.CancelledAt.Valid {
+		return nil
+	}
+
+	return a.attributePurchase(o)
+}
+
+// WooCommerceWebhook handles WooCommerce order.created and order.updated webhooks.
+// Orders are attributed once they're paid and voided when they're cancelled, failed
+// or refunded.
+func (a *App) WooCommerceWebhook(c echo.Context) error {
+	var (
+		service = models.OrderSourceWooCommerce
+		topic   = c.Request().Header.Get("X-WC-Webhook-Topic")
+	)
+
+	rawReq, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		msg := fmt.Sprintf("error reading request body: %v", err)
+		a.logWebhook(c, service, topic, rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+
+	// WooCommerce pings the URL with a form-encoded webhook_id when a webhook is created.
+	if !bytes.HasPrefix(bytes.TrimSpace(rawReq), []byte("{")) {
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	wc := webhooks.NewWooCommerce(a.cfg.WooCommerceWebhookSecret)
+	if !isWebhookVerified(c) {
+		if err := wc.VerifyWebhook(c.Request().Header.Get("X-WC-Webhook-Signature"), rawReq); err != nil {
+			msg := fmt.Sprintf("signature verification failed: %v", err)
+			a.logWebhook(c, service, topic, rawReq, http.StatusUnauthorized, "", false, msg)
+			return echo.NewHTTPError(http.StatusUnauthorized, msg)
+		}
+	}
+
+	if a.inbox != nil && !isWebhookVerified(c) {
+		if err := a.pushWebhookInbox(c, service, rawReq); err != nil {
+			a.logWebhook(c, service, topic, rawReq, http.StatusInternalServerError, "", false, err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+
+		a.logWebhook(c, service, topic, rawReq, http.StatusOK, webhookQueued, false, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	if topic != webhooks.WooCommerceTopicOrderCreated && topic != webhooks.WooCommerceTopicOrderUpdated {
+		msg := fmt.Sprintf("unsupported topic: %s", topic)
+		a.logWebhook(c, service, topic, rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+
+	wo, err := wc.ProcessOrder(rawReq)
+	if err != nil {
+		msg := fmt.Sprintf("error processing order: %v", err)
+		a.logWebhook(c, service, topic, rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+	o := wo.Order()
+	setWebhookLogIndex(c, webhookLogIndex{Email: o.Email, MessageID: o.OrderID})
+
+	if !a.claimWebhookKey(c, service, c.Request().Header.Get("X-WC-Webhook-Delivery-ID")) {
+		a.logWebhook(c, service, topic, rawReq, http.StatusOK, webhookDuplicate, true, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	// Unpaid orders (pending, on-hold) are attributed when they're updated on payment.
+	if wo.IsPaid() || wo.IsVoid() {
+		if err := a.ingestOrder(o); err != nil {
+			a.log.Printf("error processing woocommerce %s: %v", topic, err)
+			a.logWebhook(c, service, topic, rawReq, http.StatusInternalServerError, "", false, fmt.Sprintf("attribution error: %v", err))
+
+			// Let the provider or the inbox worker retry it.
+			a.releaseWebhookKeys(c)
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+	}
+
+	a.logWebhook(c, service, topic, rawReq, http.StatusOK, "", true, "")
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// StripeWebhook handles Stripe Checkout webhooks. Paid Checkout sessions are attributed
+// as orders.
+func (a *App) StripeWebhook(c echo.Context) error {
+	service := models.OrderSourceStripe
+
+	rawReq, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		msg := fmt.Sprintf("error reading request body: %v", err)
+		a.logWebhook(c, service, "", rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+
+	st := webhooks.NewStripe(a.cfg.StripeWebhookSecret)
+	if !isWebhookVerified(c) {
+		if err := st.VerifyWebhook(c.Request().Header.Get("Stripe-Signature"), rawReq, time.Now()); err != nil {
+			msg := fmt.Sprintf("signature verification failed: %v", err)
+			a.logWebhook(c, service, "", rawReq, http.StatusUnauthorized, "", false, msg)
+			return echo.NewHTTPError(http.StatusUnauthorized, msg)
+		}
+	}
+
+	ev, err := st.ProcessEvent(rawReq)
+	if err != nil {
+		msg := fmt.Sprintf("error processing event: %v", err)
+		a.logWebhook(c, service, "", rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+
+	if a.inbox != nil && !isWebhookVerified(c) {
+		if err := a.pushWebhookInbox(c, service, rawReq); err != nil {
+			a.logWebhook(c, service, ev.Type, rawReq, http.StatusInternalServerError, "", false, err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+
+		a.logWebhook(c, service, ev.Type, rawReq, http.StatusOK, webhookQueued, false, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	// Stripe sends all the event types an endpoint is subscribed to. Acknowledge the
+	// ones that aren't orders.
+	if ev.Type != webhooks.StripeEventCheckoutCompleted && ev.Type != webhooks.StripeEventCheckoutAsyncSuccess {
+		a.logWebhook(c, service, ev.Type, rawReq, http.StatusOK, "", true, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	sess, err := st.ProcessCheckoutSession(ev)
+	if err != nil {
+		msg := fmt.Sprintf("error processing checkout session: %v", err)
+		a.logWebhook(c, service, ev.Type, rawReq, http.StatusBadRequest, "", false, msg)
+		return echo.NewHTTPError(http.StatusBadRequest, msg)
+	}
+	o := sess.Order()
+	setWebhookLogIndex(c, webhookLogIndex{Email: o.Email, MessageID: o.OrderID})
+
+	// Stripe retries deliveries of the same event.
+	if !a.claimWebhookKey(c, service, ev.ID) {
+		a.logWebhook(c, service, ev.Type, rawReq, http.StatusOK, webhookDuplicate, true, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	// Sessions with delayed payment methods are attributed on async_payment_succeeded.
+	if sess.IsPaid() {
+		if err := a.ingestOrder(o); err != nil {
+			a.log.Printf("error processing stripe %s: %v", ev.Type, err)
+			a.logWebhook(c, service, ev.Type, rawReq, http.StatusInternalServerError, "", false, fmt.Sprintf("attribution error: %v", err))
+
+			// Let the provider or the inbox worker retry it.
+			a.releaseWebhookKeys(c)
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+	}
+
+	a.logWebhook(c, service, ev.Type, rawReq, http.StatusOK, "", true, "")
+	return c.JSON(http.StatusOK, okResp{true})
+}
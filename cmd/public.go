@@ -542,8 +542,11 @@ func (a *App) LinkRedirect(c echo.Context) error {
 	var (
 		linkUUID = c.Param("linkUUID")
 		campUUID = c.Param("campUUID")
+
+		// Position of the link in the message for the campaign's UTM parameters.
+		pos, _ = strconv.Atoi(c.QueryParam("pos"))
 	)
-	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID)
+	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID, pos)
 	if err != nil {
 		e := err.(*echo.HTTPError)
 		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
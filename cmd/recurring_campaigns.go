@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gdgvda/cron"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/feed"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// feedDigest is the new items of the feed of a recurring campaign that are sent in
+// the campaign of an occurrence. ids are the pending items that are marked as sent.
+type feedDigest struct {
+	items   models.FeedItems
+	ids     []int
+	pending int
+}
+
+const (
+	// defaultRecurrenceNameFormat is the default name of the campaigns of a recurring campaign.
+	// {name} is the name of the recurring campaign's campaign and {date} the date of the occurrence.
+	defaultRecurrenceNameFormat = "{name} {date}"
+
+	// Feed digest defaults and limits.
+	defaultFeedMaxItems = 20
+	maxFeedItems        = 100
+	feedFetchTimeout    = time.Second * 30
+)
+
+// GetCampaignRecurrences handles the retrieval of recurring campaigns.
+func (a *App) GetCampaignRecurrences(c echo.Context) error {
+	out := []models.CampaignRecurrence{}
+	if err := a.queries.GetCampaignRecurrences.Select(&out, 0); err != nil {
+		a.log.Printf("error fetching recurring campaigns: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignRecurrence handles the retrieval of a recurring campaign.
+func (a *App) GetCampaignRecurrence(c echo.Context) error {
+	out, err := a.getCampaignRecurrence(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignOccurrences handles the retrieval of the campaigns of the occurrences
+// of a recurring campaign, latest first.
+func (a *App) GetCampaignOccurrences(c echo.Context) error {
+	out := []models.CampaignOccurrence{}
+	if err := a.queries.GetCampaignOccurrences.Select(&out, getID(c)); err != nil {
+		a.log.Printf("error fetching campaign occurrences: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateCampaignRecurrence handles the creation of a recurring campaign.
+func (a *App) CreateCampaignRecurrence(c echo.Context) error {
+	var o models.CampaignRecurrence
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateCampaignRecurrence(&o); err != nil {
+		return err
+	}
+
+	var id int
+	if err := a.queries.CreateCampaignRecurrence.Get(&id, o.Name, o.CampaignID, o.Cron, o.Timezone, o.NameFormat,
+		o.SkipPrevious, o.Enabled, o.NextRunAt, o.FeedURL, o.FeedMinItems, o.FeedMaxItems); err != nil {
+		a.log.Printf("error creating recurring campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	out, err := a.getCampaignRecurrence(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateCampaignRecurrence handles the modification of a recurring campaign. The next
+// occurrence is computed again from the updated schedule.
+func (a *App) UpdateCampaignRecurrence(c echo.Context) error {
+	id := getID(c)
+
+	var o models.CampaignRecurrence
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateCampaignRecurrence(&o); err != nil {
+		return err
+	}
+
+	res, err := a.queries.UpdateCampaignRecurrence.Exec(id, o.Name, o.CampaignID, o.Cron, o.Timezone, o.NameFormat,
+		o.SkipPrevious, o.Enabled, o.NextRunAt, o.FeedURL, o.FeedMinItems, o.FeedMaxItems)
+	if err != nil {
+		a.log.Printf("error updating recurring campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+	}
+
+	out, err := a.getCampaignRecurrence(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCampaignRecurrence handles the deletion of a recurring campaign. The campaigns
+// of its occurrences are retained.
+func (a *App) DeleteCampaignRecurrence(c echo.Context) error {
+	if _, err := a.queries.DeleteCampaignRecurrence.Exec(getID(c)); err != nil {
+		a.log.Printf("error deleting recurring campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// GetCampaignFeedItems handles the retrieval of the latest items of the feed of a
+// recurring campaign and the digest campaigns they were sent in.
+func (a *App) GetCampaignFeedItems(c echo.Context) error {
+	out := []models.CampaignFeedItem{}
+	if err := a.queries.GetCampaignFeedItems.Select(&out, getID(c), maxFeedItems); err != nil {
+		a.log.Printf("error fetching campaign feed items: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{recurring.feedItems}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// PreviewCampaignFeed handles the fetching and parsing of an RSS or Atom feed to check
+// it before it's set on a recurring campaign.
+func (a *App) PreviewCampaignFeed(c echo.Context) error {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	req.URL = strings.TrimSpace(req.URL)
+	if !isFeedURL(req.URL) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "url"))
+	}
+
+	out, err := feed.Fetch(req.URL, feedFetchTimeout)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("recurring.feedError", "error", err.Error()))
+	}
+	if len(out) > maxFeedItems {
+		out = out[:maxFeedItems]
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getCampaignRecurrence returns a recurring campaign.
+func (a *App) getCampaignRecurrence(id int) (models.CampaignRecurrence, error) {
+	var out []models.CampaignRecurrence
+	if err := a.queries.GetCampaignRecurrences.Select(&out, id); err != nil {
+		a.log.Printf("error fetching recurring campaign: %v", err)
+		return models.CampaignRecurrence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+	if len(out) == 0 {
+		return models.CampaignRecurrence{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+	}
+
+	return out[0], nil
+}
+
+// validateCampaignRecurrence validates the fields of a recurring campaign and sets its
+// next occurrence.
+func (a *App) validateCampaignRecurrence(o *models.CampaignRecurrence) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	camp, err := a.core.GetCampaign(o.CampaignID, "", "")
+	if err != nil {
+		return err
+	}
+	if o.SkipPrevious && camp.Messenger != automaticMsgr {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("recurring.skipNeedsAutomatic"))
+	}
+
+	o.NameFormat = strings.TrimSpace(o.NameFormat)
+	if o.NameFormat == "" {
+		o.NameFormat = defaultRecurrenceNameFormat
+	}
+	if !strHasLen(o.NameFormat, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name_format"))
+	}
+
+	o.Timezone = strings.TrimSpace(o.Timezone)
+	if o.Timezone == "" {
+		o.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(o.Timezone); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "timezone"))
+	}
+
+	o.FeedURL = strings.TrimSpace(o.FeedURL)
+	if o.FeedURL != "" {
+		if !isFeedURL(o.FeedURL) {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "feed_url"))
+		}
+	}
+	if o.FeedMinItems < 1 {
+		o.FeedMinItems = 1
+	}
+	if o.FeedMaxItems < 1 {
+		o.FeedMaxItems = defaultFeedMaxItems
+	}
+	if o.FeedMaxItems > maxFeedItems || o.FeedMinItems > o.FeedMaxItems {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "feed_max_items"))
+	}
+
+	o.Cron = strings.TrimSpace(o.Cron)
+	next, err := nextCronRun(o.Cron, o.Timezone, time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("recurring.invalidCron", "error", err.Error()))
+	}
+	o.NextRunAt = null.TimeFrom(next)
+
+	return nil
+}
+
+// runCampaignRecurrences periodically creates and starts the campaigns of the recurring
+// campaigns that are due. If the app was stopped through several occurrences, only one
+// campaign is created for them.
+func (a *App) runCampaignRecurrences(interval time.Duration) {
+	fnRun := func() {
+		var ids []int
+		if err := a.queries.GetDueCampaignRecurrences.Select(&ids); err != nil {
+			a.log.Printf("error fetching recurring campaigns: %v", err)
+			return
+		}
+
+		for _, id := range ids {
+			r, err := a.getCampaignRecurrence(id)
+			if err != nil || !r.NextRunAt.Valid {
+				continue
+			}
+
+			next, err := nextCronRun(r.Cron, r.Timezone, time.Now())
+			if err != nil {
+				a.log.Printf("error scheduling recurring campaign %d: %v", id, err)
+				continue
+			}
+
+			// Claim the occurrence so that it's only run once.
+			res, err := a.queries.ClaimCampaignRecurrence.Exec(id, r.NextRunAt.Time, next)
+			if err != nil {
+				a.log.Printf("error claiming recurring campaign %d: %v", id, err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue
+			}
+
+			// The occurrences of feeds are skipped if there aren't enough new items for a digest.
+			var dg feedDigest
+			if r.FeedURL != "" {
+				dg, err = a.pollCampaignFeed(r)
+				if err != nil {
+					a.log.Printf("error polling feed of recurring campaign '%s' (%d): %v", r.Name, id, err)
+					a.setCampaignRecurrenceError(id, err)
+					continue
+				}
+				if len(dg.items) == 0 {
+					a.log.Printf("skipped occurrence of recurring campaign '%s' (%d): %d of %d new feed items",
+						r.Name, id, dg.pending, r.FeedMinItems)
+					continue
+				}
+			}
+
+			camp, err := a.runCampaignRecurrence(r, r.NextRunAt.Time, dg)
+			if err != nil {
+				a.log.Printf("error running recurring campaign '%s' (%d): %v", r.Name, id, err)
+				a.setCampaignRecurrenceError(id, err)
+				continue
+			}
+
+			if camp.Status == models.CampaignStatusPendingReview {
+				a.log.Printf("submitted campaign '%s' (%d) of recurring campaign '%s' (%d) for review", camp.Name, camp.ID, r.Name, id)
+				continue
+			}
+			a.log.Printf("started campaign '%s' (%d) of recurring campaign '%s' (%d)", camp.Name, camp.ID, r.Name, id)
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// runCampaignRecurrence clones the campaign of a recurring campaign into a new campaign
+// for an occurrence, records it and starts it. The items of the feed digest, if any,
+// are set on the campaign and are marked as sent once it has started or been submitted
+// for review. A campaign that can't be started is deleted and its feed items remain
+// pending for the next occurrence.
+func (a *App) runCampaignRecurrence(r models.CampaignRecurrence, runAt time.Time, dg feedDigest) (models.Campaign, error) {
+	src, err := a.core.GetCampaign(r.CampaignID, "", "")
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	listIDs, mediaIDs, err := campaignListMediaIDs(src)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	o := src
+	o.Name = strings.NewReplacer("{name}", src.Name, "{date}", runAt.In(loc).Format("2006-01-02")).Replace(r.NameFormat)
+	o.SendAt = null.Time{}
+	o.ArchiveSlug = null.String{}
+
+	camp, err := a.core.CreateCampaign(o, listIDs, mediaIDs, auth.User{})
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	if err := a.startCampaignOccurrence(r, &camp, src, runAt, dg); err != nil {
+		// Deleting the campaign also deletes its occurrence and any e-mails it queued.
+		if err := a.core.DeleteCampaign(camp.ID); err != nil {
+			a.log.Printf("error deleting campaign %d of recurring campaign %d: %v", camp.ID, r.ID, err)
+		}
+		return camp, err
+	}
+
+	if len(dg.ids) > 0 {
+		if _, err := a.queries.MarkCampaignFeedItemsSent.Exec(r.ID, camp.ID, pq.Array(dg.ids)); err != nil {
+			return camp, fmt.Errorf("error marking feed items as sent: %v", err)
+		}
+	}
+
+	return camp, nil
+}
+
+// startCampaignOccurrence sets the feed items and the approval of the recurring campaign's
+// campaign (src) on the campaign of an occurrence, records the occurrence and starts the
+// campaign. With the approval workflow on, the campaign is started with the approval of the
+// recurring campaign's campaign only if it has the approved content. Otherwise, eg: with the
+// items of a feed digest, it's submitted for review. The campaign is started only if it
+// passes the pre-send checks when they block sends.
+func (a *App) startCampaignOccurrence(r models.CampaignRecurrence, camp *models.Campaign, src models.Campaign, runAt time.Time, dg feedDigest) error {
+	if len(dg.items) > 0 {
+		if _, err := a.queries.SetCampaignFeedItems.Exec(camp.ID, dg.items); err != nil {
+			return fmt.Errorf("error setting feed items: %v", err)
+		}
+	}
+
+	approved, err := a.copyCampaignApproval(camp, src, len(dg.items) > 0)
+	if err != nil {
+		return err
+	}
+	if approved {
+		if err := a.checkCampaignStart(context.Background(), *camp); err != nil {
+			return err
+		}
+	}
+
+	// The occurrence is recorded before the campaign is started as the previous recipients
+	// are skipped when its e-mails are queued.
+	if _, err := a.queries.InsertCampaignOccurrence.Exec(r.ID, camp.ID, runAt, r.SkipPrevious); err != nil {
+		return fmt.Errorf("error recording occurrence: %v", err)
+	}
+
+	// A campaign that's submitted for review is started once it's approved.
+	if !approved {
+		return nil
+	}
+
+	if _, err := a.core.UpdateCampaignStatus(camp.ID, models.CampaignStatusRunning); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// pollCampaignFeed fetches the feed of a recurring campaign and records its new items.
+// If there are at least FeedMinItems items that haven't been sent, the latest FeedMaxItems
+// of them are returned for a digest. Otherwise, the digest is empty and the items remain
+// pending for the next occurrence.
+func (a *App) pollCampaignFeed(r models.CampaignRecurrence) (feedDigest, error) {
+	items, err := feed.Fetch(r.FeedURL, feedFetchTimeout)
+	if err != nil {
+		return feedDigest{}, err
+	}
+
+	if _, err := a.queries.InsertCampaignFeedItems.Exec(r.ID, items); err != nil {
+		return feedDigest{}, fmt.Errorf("error recording feed items: %v", err)
+	}
+
+	var pending []models.CampaignFeedItem
+	if err := a.queries.GetPendingCampaignFeedItems.Select(&pending, r.ID); err != nil {
+		return feedDigest{}, fmt.Errorf("error fetching feed items: %v", err)
+	}
+
+	// All the pending items are marked as sent in the digest, including the older ones
+	// beyond the maximum that aren't in it.
+	out := feedDigest{pending: len(pending)}
+	out.items, out.ids = feed.Digest(pending, r.FeedMinItems, r.FeedMaxItems)
+
+	return out, nil
+}
+
+// setCampaignRecurrenceError records the error of an occurrence of a recurring campaign.
+func (a *App) setCampaignRecurrenceError(id int, e error) {
+	if _, err := a.queries.SetCampaignRecurrenceError.Exec(id, e.Error()); err != nil {
+		a.log.Printf("error updating recurring campaign %d: %v", id, err)
+	}
+}
+
+// isFeedURL checks if a string is an absolute HTTP(s) URL.
+func isFeedURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// nextCronRun returns the next time after t on which a standard cron expression
+// occurs in a timezone.
+func nextCronRun(expr, tz string, t time.Time) (time.Time, error) {
+	if expr == "" || strings.HasPrefix(expr, "TZ=") || strings.HasPrefix(expr, "CRON_TZ=") {
+		return time.Time{}, fmt.Errorf("invalid cron expression '%s'", expr)
+	}
+
+	sch, err := cron.ParseStandard("CRON_TZ=" + tz + " " + expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	next := sch.Next(t)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("cron expression '%s' never occurs", expr)
+	}
+
+	return next, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// maxResendHours is the longest a resend to non-openers can wait after its campaign.
+const maxResendHours = 24 * 30
+
+// ResendCampaign handles the creation of a resend of a finished campaign to its recipients
+// who haven't opened it. The resend is a child campaign that's scheduled after_hours later,
+// or started right away if it's 0.
+func (a *App) ResendCampaign(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	var req models.CampaignResend
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := a.validateCampaignResend(req, 0); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.Status != models.CampaignStatusFinished {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.resendNotFinished"))
+	}
+
+	var resendID int
+	if err := a.queries.InsertCampaignResend.Get(&resendID, id, req.AfterHours, false); err != nil {
+		a.log.Printf("error creating campaign resend: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	out, err := a.createCampaignResend(resendID, camp, req)
+	if err != nil {
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignResendStats returns the recipients, openers and clickers of a campaign and its
+// resends to non-openers, rolled up across all of them. If the campaign is a resend, the
+// stats are of its parent.
+func (a *App) GetCampaignResendStats(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	var parentID int
+	if err := a.queries.GetCampaignResendParent.Get(&parentID, id); err != nil {
+		a.log.Printf("error fetching campaign resends: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	var stats []models.CampaignResendStats
+	if err := a.queries.GetCampaignResendStats.Select(&stats, parentID); err != nil {
+		a.log.Printf("error fetching campaign resend stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+	if len(stats) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+	}
+
+	// The recipients of the resends are a subset of the recipients of the parent, which is first.
+	out := struct {
+		ParentID   int                          `json:"parent_id"`
+		Recipients int                          `json:"recipients"`
+		Sent       int                          `json:"sent"`
+		Openers    int                          `json:"openers"`
+		Clickers   int                          `json:"clickers"`
+		OpenRate   float64                      `json:"open_rate"`
+		ClickRate  float64                      `json:"click_rate"`
+		Campaigns  []models.CampaignResendStats `json:"campaigns"`
+	}{
+		ParentID:   parentID,
+		Recipients: stats[0].Sent,
+		Openers:    stats[0].TotalOpeners,
+		Clickers:   stats[0].TotalClickers,
+		Campaigns:  stats,
+	}
+	for _, s := range stats {
+		out.Sent += s.Sent
+	}
+	if out.Recipients > 0 {
+		out.OpenRate = float64(out.Openers) / float64(out.Recipients) * 100
+		out.ClickRate = float64(out.Clickers) / float64(out.Recipients) * 100
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// runCampaignResends periodically creates the resends to non-openers of the finished
+// campaigns that have automatic resends.
+func (a *App) runCampaignResends(interval time.Duration) {
+	fnRun := func() {
+		var ids []int
+		if err := a.queries.GetDueCampaignResends.Select(&ids); err != nil {
+			a.log.Printf("error fetching campaign resends: %v", err)
+			return
+		}
+
+		for _, id := range ids {
+			camp, err := a.core.GetCampaign(id, "", "")
+			if err != nil {
+				a.log.Printf("error fetching campaign %d for resend: %v", id, err)
+				continue
+			}
+
+			// Claim the resend so that it's only created once.
+			var resendID int
+			if err := a.queries.InsertCampaignResend.Get(&resendID, id, camp.Resend.AfterHours, true); err != nil {
+				if err != sql.ErrNoRows {
+					a.log.Printf("error creating campaign resend: %v", err)
+				}
+				continue
+			}
+
+			out, err := a.createCampaignResend(resendID, camp, camp.Resend)
+			if err != nil {
+				a.log.Printf("error creating resend of campaign '%s' (%d): %v", camp.Name, id, err)
+				continue
+			}
+
+			a.log.Printf("created resend '%s' (%d) of campaign '%s' (%d) to non-openers", out.Name, out.ID, camp.Name, id)
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// createCampaignResend clones a campaign into a resend to its non-openers for a recorded
+// resend and schedules it. Errors are recorded on the resend.
+func (a *App) createCampaignResend(resendID int, parent models.Campaign, r models.CampaignResend) (models.Campaign, error) {
+	out, err := a.cloneCampaignResend(resendID, parent, r)
+	if err != nil {
+		var campID null.Int
+		if out.ID > 0 {
+			campID = null.IntFrom(out.ID)
+		}
+		if _, err := a.queries.SetCampaignResend.Exec(resendID, campID, err.Error()); err != nil {
+			a.log.Printf("error updating campaign resend %d: %v", resendID, err)
+		}
+		return out, err
+	}
+
+	return out, nil
+}
+
+func (a *App) cloneCampaignResend(resendID int, parent models.Campaign, r models.CampaignResend) (models.Campaign, error) {
+	listIDs, mediaIDs, err := campaignListMediaIDs(parent)
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	// The resend doesn't hold out, test, archive or resend again.
+	o := parent
+	o.Name = a.i18n.Ts("campaigns.resendName", "name", parent.Name)
+	if r.Subject != "" {
+		o.Subject = r.Subject
+	}
+	o.SendAt = null.Time{}
+	if r.AfterHours > 0 {
+		o.SendAt = null.TimeFrom(time.Now().Add(time.Duration(r.AfterHours) * time.Hour))
+	}
+	o.Archive = false
+	o.ArchiveSlug = null.String{}
+	o.HoldoutPercent = 0
+	o.ABTest = models.CampaignABTest{}
+	o.Variants = nil
+	o.Resend = models.CampaignResend{}
+
+	camp, err := a.core.CreateCampaign(o, listIDs, mediaIDs, auth.User{})
+	if err != nil {
+		return models.Campaign{}, err
+	}
+
+	// The resend is linked to the campaign before it's started as its audience is
+	// picked by the link.
+	if _, err := a.queries.SetCampaignResend.Exec(resendID, camp.ID, ""); err != nil {
+		return camp, fmt.Errorf("error recording resend: %v", err)
+	}
+
+	// With the approval workflow on, the resend is sent with the approval of its campaign
+	// if it has the approved content. A resend with another subject is left for review.
+	if ok, err := a.copyCampaignApproval(&camp, parent, false); err != nil || !ok {
+		return camp, err
+	}
+	if err := a.checkCampaignStart(context.Background(), camp); err != nil {
+		return camp, err
+	}
+
+	status := models.CampaignStatusRunning
+	if o.SendAt.Valid {
+		status = models.CampaignStatusScheduled
+	}
+
+	return a.core.UpdateCampaignStatus(camp.ID, status)
+}
+
+// validateCampaignResend validates the wait and subject of a resend to non-openers.
+func (a *App) validateCampaignResend(r models.CampaignResend, minHours int) error {
+	if r.AfterHours < minHours || r.AfterHours > maxResendHours {
+		return errors.New(a.i18n.Ts("campaigns.fieldInvalidResendHours",
+			"min", strconv.Itoa(minHours), "max", strconv.Itoa(maxResendHours)))
+	}
+
+	if len(r.Subject) > 5000 {
+		return errors.New(a.i18n.T("campaigns.fieldInvalidSubject"))
+	}
+	if r.Subject != "" {
+		camp := models.Campaign{Subject: r.Subject, TemplateBody: tplTag}
+		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+			return errors.New(a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// maxSequenceSteps is the largest number of steps in a sequence.
+	maxSequenceSteps = 50
+
+	// sequenceBatchSize is the number of subscribers whose due steps are run in an interval.
+	// Sends are queued and are rate limited by the queue.
+	sequenceBatchSize = 1000
+)
+
+// GetSequences handles the retrieval of sequences.
+func (a *App) GetSequences(c echo.Context) error {
+	out := []models.Sequence{}
+	if err := a.queries.GetSequences.Select(&out, 0); err != nil {
+		a.log.Printf("error fetching sequences: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "sequences", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSequence handles the retrieval of a sequence.
+func (a *App) GetSequence(c echo.Context) error {
+	out, err := a.getSequence(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSequence handles the creation of a sequence.
+func (a *App) CreateSequence(c echo.Context) error {
+	var o models.Sequence
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateSequence(&o); err != nil {
+		return err
+	}
+
+	var id int
+	if err := a.queries.CreateSequence.Get(&id, o.Name, o.ListID, o.Steps, o.Enabled); err != nil {
+		a.log.Printf("error creating sequence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorCreating", "name", "sequence", "error", err.Error()))
+	}
+
+	out, err := a.getSequence(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateSequence handles the modification of a sequence. Subscribers in the sequence
+// continue from the index of their next step in the updated steps.
+func (a *App) UpdateSequence(c echo.Context) error {
+	id := getID(c)
+
+	var o models.Sequence
+	if err := c.Bind(&o); err != nil {
+		return err
+	}
+	if err := a.validateSequence(&o); err != nil {
+		return err
+	}
+
+	res, err := a.queries.UpdateSequence.Exec(id, o.Name, o.ListID, o.Steps, o.Enabled)
+	if err != nil {
+		a.log.Printf("error updating sequence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "sequence", "error", err.Error()))
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.notFound", "name", "sequence"))
+	}
+
+	out, err := a.getSequence(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteSequence handles the deletion of a sequence and the progress of its subscribers.
+func (a *App) DeleteSequence(c echo.Context) error {
+	if _, err := a.queries.DeleteSequence.Exec(getID(c)); err != nil {
+		a.log.Printf("error deleting sequence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorDeleting", "name", "sequence", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// getSequence returns a sequence.
+func (a *App) getSequence(id int) (models.Sequence, error) {
+	var out []models.Sequence
+	if err := a.queries.GetSequences.Select(&out, id); err != nil {
+		a.log.Printf("error fetching sequence: %v", err)
+		return models.Sequence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "sequence", "error", err.Error()))
+	}
+	if len(out) == 0 {
+		return models.Sequence{}, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.notFound", "name", "sequence"))
+	}
+
+	return out[0], nil
+}
+
+// validateSequence validates the fields and the steps of a sequence.
+func (a *App) validateSequence(o *models.Sequence) error {
+	o.Name = strings.TrimSpace(o.Name)
+	if !strHasLen(o.Name, 1, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	if _, err := a.core.GetList(o.ListID, ""); err != nil {
+		return err
+	}
+
+	if len(o.Steps) == 0 || len(o.Steps) > maxSequenceSteps {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("sequences.invalidSteps", "max", fmt.Sprintf("%d", maxSequenceSteps)))
+	}
+
+	hasSend := false
+	for i, s := range o.Steps {
+		fnErr := func(field string) error {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("sequences.invalidStep", "num", fmt.Sprintf("%d", i+1), "name", field))
+		}
+
+		switch s.Type {
+		case models.SequenceStepWait:
+			if s.Minutes < 1 {
+				return fnErr("minutes")
+			}
+
+		case models.SequenceStepSend:
+			if s.CampaignID < 1 {
+				return fnErr("campaign_id")
+			}
+			if _, err := a.core.GetCampaign(s.CampaignID, "", ""); err != nil {
+				return fnErr("campaign_id")
+			}
+			hasSend = true
+
+		case models.SequenceStepBranch:
+			// Branches are on a send step before them and only go forward, so sequences always end.
+			if !hasSend {
+				return fnErr("type")
+			}
+			if s.Condition != models.SequenceConditionOpened && s.Condition != models.SequenceConditionClicked {
+				return fnErr("condition")
+			}
+			if s.Then <= i || s.Then > len(o.Steps) {
+				return fnErr("then")
+			}
+			if s.Else <= i || s.Else > len(o.Steps) {
+				return fnErr("else")
+			}
+
+		case models.SequenceStepAddLists, models.SequenceStepRemoveLists:
+			if len(s.ListIDs) == 0 {
+				return fnErr("list_ids")
+			}
+
+		case models.SequenceStepUpdateAttribs:
+			if len(s.Attribs) == 0 {
+				return fnErr("attribs")
+			}
+
+		default:
+			return fnErr("type")
+		}
+	}
+
+	return nil
+}
+
+// runSequences periodically runs the due steps of the subscribers in sequences.
+func (a *App) runSequences(interval time.Duration) {
+	fnRun := func() {
+		var subs []models.SequenceSubscriber
+		if err := a.queries.NextSequenceSubscribers.Select(&subs, sequenceBatchSize); err != nil {
+			a.log.Printf("error fetching sequence subscribers: %v", err)
+			return
+		}
+		if len(subs) == 0 {
+			return
+		}
+
+		var seqs []models.Sequence
+		if err := a.queries.GetSequences.Select(&seqs, 0); err != nil {
+			a.log.Printf("error fetching sequences: %v", err)
+			return
+		}
+		seqMap := make(map[int]models.Sequence, len(seqs))
+		for _, s := range seqs {
+			seqMap[s.ID] = s
+		}
+
+		for _, s := range subs {
+			errMsg := ""
+			if err := a.runSequenceSubscriber(seqMap[s.SequenceID], &s); err != nil {
+				a.log.Printf("error running sequence %d for subscriber %d: %v", s.SequenceID, s.SubscriberID, err)
+				s.Status = models.SequenceSubStatusFailed
+				errMsg = err.Error()
+			}
+
+			if _, err := a.queries.UpdateSequenceSubscriber.Exec(s.SequenceID, s.SubscriberID, s.Step, s.Status,
+				s.NextRunAt, s.LastCampaignID, s.LastSentAt, errMsg); err != nil {
+				a.log.Printf("error updating sequence %d subscriber %d: %v", s.SequenceID, s.SubscriberID, err)
+			}
+		}
+	}
+
+	fnRun()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnRun()
+	}
+}
+
+// runSequenceSubscriber runs the steps of a sequence on a subscriber from their next step
+// until a wait step or the end of the sequence, and sets the resultant progress on s.
+// Subscribers who are blocklisted or have unsubscribed from the sequence's list exit it.
+func (a *App) runSequenceSubscriber(seq models.Sequence, s *models.SequenceSubscriber) error {
+	if seq.ID == 0 {
+		return fmt.Errorf("sequence %d not found", s.SequenceID)
+	}
+
+	if s.SubscriberStatus == models.SubscriberStatusBlockListed ||
+		s.SubscriptionStatus == models.SubscriptionStatusUnsubscribed {
+		s.Status = models.SequenceSubStatusExited
+		return nil
+	}
+
+	for s.Step < len(seq.Steps) {
+		step := seq.Steps[s.Step]
+
+		switch step.Type {
+		case models.SequenceStepWait:
+			s.Step++
+			s.NextRunAt = time.Now().Add(time.Duration(step.Minutes) * time.Minute)
+			s.Status = models.SequenceSubStatusActive
+			return nil
+
+		case models.SequenceStepSend:
+			if _, err := a.queries.QueueSequenceEmail.Exec(step.CampaignID, s.SubscriberID); err != nil {
+				return fmt.Errorf("error queuing campaign %d: %v", step.CampaignID, err)
+			}
+			s.LastCampaignID = null.IntFrom(step.CampaignID)
+			s.LastSentAt = null.TimeFrom(time.Now())
+			s.Step++
+
+		case models.SequenceStepBranch:
+			var e struct {
+				Opened  bool `db:"opened"`
+				Clicked bool `db:"clicked"`
+			}
+			if s.LastCampaignID.Valid {
+				if err := a.queries.GetSequenceEngagement.Get(&e, s.LastCampaignID.Int, s.SubscriberID, s.LastSentAt); err != nil {
+					return fmt.Errorf("error fetching engagement: %v", err)
+				}
+			}
+
+			if (step.Condition == models.SequenceConditionOpened && e.Opened) ||
+				(step.Condition == models.SequenceConditionClicked && e.Clicked) {
+				s.Step = step.Then
+			} else {
+				s.Step = step.Else
+			}
+
+		case models.SequenceStepAddLists:
+			if err := a.core.AddSubscriptions([]int{s.SubscriberID}, step.ListIDs, ""); err != nil {
+				return err
+			}
+			s.Step++
+
+		case models.SequenceStepRemoveLists:
+			if err := a.core.DeleteSubscriptions([]int{s.SubscriberID}, step.ListIDs); err != nil {
+				return err
+			}
+			s.Step++
+
+		case models.SequenceStepUpdateAttribs:
+			if _, err := a.queries.MergeSubscriberAttribs.Exec(s.SubscriberID, step.Attribs); err != nil {
+				return fmt.Errorf("error updating attributes: %v", err)
+			}
+			s.Step++
+
+		default:
+			return fmt.Errorf("unknown step type '%s'", step.Type)
+		}
+	}
+
+	s.Status = models.SequenceSubStatusCompleted
+	return nil
+}
@@ -18,9 +18,12 @@ import (
 	"github.com/knadh/koanf/parsers/json"
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
+	"github.com/knadh/listmonk/internal/attribution"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/currency"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/shopify"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -78,6 +81,9 @@ func (a *App) GetSettings(c echo.Context) error {
 	s.SecurityCaptcha.HCaptcha.Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityCaptcha.HCaptcha.Secret))
 	s.OIDC.ClientSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.OIDC.ClientSecret))
 	s.Shopify.WebhookSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Shopify.WebhookSecret))
+	s.Shopify.AccessToken = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Shopify.AccessToken))
+	s.WooCommerce.WebhookSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.WooCommerce.WebhookSecret))
+	s.Stripe.WebhookSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Stripe.WebhookSecret))
 
 	return c.JSON(http.StatusOK, okResp{s})
 }
@@ -231,6 +237,15 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	if set.Shopify.WebhookSecret == "" {
 		set.Shopify.WebhookSecret = cur.Shopify.WebhookSecret
 	}
+	if set.Shopify.AccessToken == "" {
+		set.Shopify.AccessToken = cur.Shopify.AccessToken
+	}
+	if set.WooCommerce.WebhookSecret == "" {
+		set.WooCommerce.WebhookSecret = cur.WooCommerce.WebhookSecret
+	}
+	if set.Stripe.WebhookSecret == "" {
+		set.Stripe.WebhookSecret = cur.Stripe.WebhookSecret
+	}
 
 	// OIDC user auto-creation is enabled. Validate.
 	if set.OIDC.AutoCreateUsers {
@@ -261,6 +276,28 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.DomainAllowlist = doms
 
+	// Clean the link allowlist and DKIM selectors of the pre-send checks. Allowlisted
+	// hosts can be entered as URLs.
+	hosts := make([]string, 0, len(set.AppCampaignChecksLinkAllowlist))
+	for _, h := range set.AppCampaignChecksLinkAllowlist {
+		h = strings.TrimSpace(strings.ToLower(h))
+		if u, err := url.Parse(h); err == nil && u.Host != "" {
+			h = u.Hostname()
+		}
+		if h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	set.AppCampaignChecksLinkAllowlist = hosts
+
+	sels := make([]string, 0, len(set.AppCampaignChecksDKIMSelectors))
+	for _, s := range set.AppCampaignChecksDKIMSelectors {
+		if s = strings.TrimSpace(s); s != "" {
+			sels = append(sels, s)
+		}
+	}
+	set.AppCampaignChecksDKIMSelectors = sels
+
 	// Validate and clean CORS domains.
 	cors := make([]string, 0, len(set.SecurityCORSOrigins))
 	for _, d := range set.SecurityCORSOrigins {
@@ -282,6 +319,57 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.SecurityCORSOrigins = cors
 
+	// Validate webhook log retention policies. There can be one policy per webhook type.
+	hasPolicy := map[string]bool{}
+	for n, r := range set.WebhookLogRetention {
+		r.WebhookType = strings.ToLower(strings.TrimSpace(r.WebhookType))
+		if r.Days < 0 || r.MaxRows < 0 || hasPolicy[r.WebhookType] {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": webhook log retention: "+r.WebhookType)
+		}
+		hasPolicy[r.WebhookType] = true
+		set.WebhookLogRetention[n] = r
+	}
+
+	if set.WebhookIdempotencyTTL == "" {
+		set.WebhookIdempotencyTTL = "72h"
+	}
+	if d, err := time.ParseDuration(set.WebhookIdempotencyTTL); err != nil || d <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": webhook idempotency TTL")
+	}
+
+	if set.Shopify.AttributionModel == "" {
+		set.Shopify.AttributionModel = attribution.ModelLastClick
+	}
+	if !attribution.IsModel(set.Shopify.AttributionModel) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": attribution model")
+	}
+	if set.Shopify.AttributionWindowDays < 1 {
+		set.Shopify.AttributionWindowDays = 7
+	}
+	if set.Shopify.SyncLists == nil {
+		set.Shopify.SyncLists = []int{}
+	}
+	if set.Shopify.APIVersion == "" {
+		set.Shopify.APIVersion = shopify.DefaultAPIVersion
+	}
+	if set.Shopify.SyncCustomers && len(set.Shopify.SyncLists) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": shopify sync lists")
+	}
+	set.Shopify.ReportingCurrency = strings.ToUpper(strings.TrimSpace(set.Shopify.ReportingCurrency))
+	if set.Shopify.ReportingCurrency == "" {
+		set.Shopify.ReportingCurrency = "USD"
+	}
+	if !currency.IsCode(set.Shopify.ReportingCurrency) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": shopify reporting currency")
+	}
+	set.Shopify.ExchangeRatesSource = strings.TrimSpace(set.Shopify.ExchangeRatesSource)
+	set.Shopify.ShopURL = strings.TrimRight(strings.TrimSpace(set.Shopify.ShopURL), "/")
+	if set.Shopify.ShopURL != "" {
+		if u, err := url.ParseRequestURI(set.Shopify.ShopURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": shopify shop URL")
+		}
+	}
+
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
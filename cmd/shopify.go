@@ -2,22 +2,47 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/attribution"
 	"github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
-// ShopifyWebhook handles incoming Shopify order webhooks for purchase attribution.
+// shopifyEventTypes maps the supported Shopify webhook topics to the event
+// types they are logged with.
+var shopifyEventTypes = map[string]string{
+	webhooks.ShopifyTopicOrderCreate:    "order",
+	webhooks.ShopifyTopicOrderUpdated:   "order_updated",
+	webhooks.ShopifyTopicOrderCancelled: "order_cancelled",
+	webhooks.ShopifyTopicRefundCreate:   "refund",
+	webhooks.ShopifyTopicCustomerCreate: "customer",
+	webhooks.ShopifyTopicCustomerUpdate: "customer_updated",
+	webhooks.ShopifyTopicCustomerDelete: "customer_deleted",
+	webhooks.ShopifyTopicCheckoutCreate: "checkout",
+	webhooks.ShopifyTopicCheckoutUpdate: "checkout_updated",
+}
+
+// ShopifyWebhook handles incoming Shopify order, refund and cancellation webhooks
+// for purchase attribution, customer webhooks for subscriber sync and checkout webhooks
+// for abandoned checkout flows. The topic is read from the X-Shopify-Topic header and
+// defaults to orders/create.
 func (app *App) ShopifyWebhook(c echo.Context) error {
 	var (
 		service      = "shopify"
-		eventType    = "order"
+		topic        = c.Request().Header.Get("X-Shopify-Topic")
 		rawReq       []byte
 		err          error
 		processed    = false
@@ -25,54 +50,156 @@ func (app *App) ShopifyWebhook(c echo.Context) error {
 		responseCode = http.StatusOK
 	)
 
+	if topic == "" {
+		topic = webhooks.ShopifyTopicOrderCreate
+	}
+	eventType, ok := shopifyEventTypes[topic]
+	if !ok {
+		eventType = topic
+	}
+
 	// Read the raw request body for HMAC verification and logging
 	rawReq, err = io.ReadAll(c.Request().Body)
 	if err != nil {
 		errorMsg = fmt.Sprintf("error reading request body: %v", err)
 		responseCode = http.StatusBadRequest
-		app.logWebhook(service, eventType, c.Request().Header, rawReq, responseCode, "", processed, errorMsg)
+		app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
 		return echo.NewHTTPError(responseCode, errorMsg)
 	}
 
 	// Initialize Shopify webhook handler
 	shopifyHandler := webhooks.NewShopify(app.cfg.ShopifyWebhookSecret)
 
-	// Verify HMAC signature
+	// Verify HMAC signature. Stored webhooks (replays and inbox entries) were verified on receipt.
 	hmacHeader := c.Request().Header.Get("X-Shopify-Hmac-Sha256")
-	if err := shopifyHandler.VerifyWebhook(hmacHeader, rawReq); err != nil {
-		errorMsg = fmt.Sprintf("HMAC verification failed: %v", err)
-		responseCode = http.StatusUnauthorized
-		app.logWebhook(service, eventType, c.Request().Header, rawReq, responseCode, "", processed, errorMsg)
+	if !isWebhookVerified(c) {
+		if err := shopifyHandler.VerifyWebhook(hmacHeader, rawReq); err != nil {
+			errorMsg = fmt.Sprintf("HMAC verification failed: %v", err)
+			responseCode = http.StatusUnauthorized
+			app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
+			return echo.NewHTTPError(responseCode, errorMsg)
+		}
+	}
+
+	// Acknowledge the webhook once it's persisted to the inbox. The inbox workers
+	// process it by running it through this handler again.
+	if app.inbox != nil && !isWebhookVerified(c) {
+		if err := app.pushWebhookInbox(c, service, rawReq); err != nil {
+			responseCode = http.StatusInternalServerError
+			app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, err.Error())
+			return echo.NewHTTPError(responseCode, app.i18n.T("globals.messages.internalError"))
+		}
+
+		app.logWebhook(c, service, eventType, rawReq, responseCode, webhookQueued, processed, "")
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	if !ok {
+		errorMsg = fmt.Sprintf("unsupported topic: %s", topic)
+		responseCode = http.StatusBadRequest
+		app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
 		return echo.NewHTTPError(responseCode, errorMsg)
 	}
 
-	// Parse the order data
-	order, err := shopifyHandler.ProcessOrder(rawReq)
+	// Parse the payload of the topic.
+	var process func() error
+	switch topic {
+	case webhooks.ShopifyTopicRefundCreate:
+		var r *webhooks.ShopifyRefund
+		if r, err = shopifyHandler.ProcessRefund(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{MessageID: strconv.FormatInt(r.OrderID, 10)})
+			process = func() error { return app.refundPurchase(r) }
+		}
+
+	case webhooks.ShopifyTopicOrderUpdated, webhooks.ShopifyTopicOrderCancelled:
+		var order *webhooks.ShopifyOrder
+		if order, err = shopifyHandler.ProcessOrderUpdate(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{Email: order.Email, MessageID: strconv.FormatInt(order.ID, 10)})
+			process = func() error { return app.updateShopifyPurchase(order, topic == webhooks.ShopifyTopicOrderCancelled) }
+		}
+
+	case webhooks.ShopifyTopicCustomerCreate, webhooks.ShopifyTopicCustomerUpdate:
+		var cust *webhooks.ShopifyCustomer
+		if cust, err = shopifyHandler.ProcessCustomer(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{Email: cust.Email, MessageID: strconv.FormatInt(cust.ID, 10)})
+			process = func() error {
+				_, err := app.syncShopifyCustomer(*cust)
+				return err
+			}
+		}
+
+	case webhooks.ShopifyTopicCustomerDelete:
+		var cust *webhooks.ShopifyCustomer
+		if cust, err = shopifyHandler.ProcessCustomer(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{MessageID: strconv.FormatInt(cust.ID, 10)})
+			process = func() error { return app.deleteShopifyCustomer(cust.ID) }
+		}
+
+	case webhooks.ShopifyTopicCheckoutCreate, webhooks.ShopifyTopicCheckoutUpdate:
+		var co *webhooks.ShopifyCheckout
+		if co, err = shopifyHandler.ProcessCheckout(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{Email: co.Email, MessageID: co.Token})
+			process = func() error { return app.scheduleCheckoutFlows(co) }
+		}
+
+	default:
+		var order *webhooks.ShopifyOrder
+		if order, err = shopifyHandler.ProcessOrder(rawReq); err == nil {
+			setWebhookLogIndex(c, webhookLogIndex{Email: order.Email, MessageID: strconv.FormatInt(order.ID, 10)})
+			process = func() error { return app.attributePurchase(order.Order()) }
+		}
+	}
 	if err != nil {
-		errorMsg = fmt.Sprintf("error processing order: %v", err)
+		errorMsg = fmt.Sprintf("error processing %s: %v", eventType, err)
 		responseCode = http.StatusBadRequest
-		app.logWebhook(service, eventType, c.Request().Header, rawReq, responseCode, "", processed, errorMsg)
+		app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
 		return echo.NewHTTPError(responseCode, errorMsg)
 	}
 
-	// Attempt to attribute the purchase to a campaign
-	if err := app.attributePurchase(order); err != nil {
-		// Log the error but don't fail the webhook
-		// This allows Shopify to consider the webhook successfully received
-		app.log.Printf("error attributing purchase (order %d): %v", order.ID, err)
-		errorMsg = fmt.Sprintf("attribution error: %v", err)
-	} else {
-		processed = true
+	// Shopify retries deliveries that weren't acknowledged in time.
+	if !app.claimWebhookKey(c, service, c.Request().Header.Get("X-Shopify-Webhook-Id")) {
+		app.logWebhook(c, service, eventType, rawReq, responseCode, webhookDuplicate, true, "")
+		return c.JSON(http.StatusOK, okResp{true})
 	}
 
+	// Attribute the purchase, adjust an attributed purchase, sync the customer or
+	// schedule the checkout's flows.
+	// A failed request is retried by Shopify, or by the inbox worker, which dead-letters
+	// it after its attempts run out. Its idempotency key is released for the retry.
+	if err := process(); err != nil {
+		app.log.Printf("error processing shopify %s: %v", topic, err)
+		errorMsg = fmt.Sprintf("%s error: %v", eventType, err)
+		responseCode = http.StatusInternalServerError
+		app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
+		app.releaseWebhookKeys(c)
+		return echo.NewHTTPError(responseCode, app.i18n.T("globals.messages.internalError"))
+	}
+	processed = true
+
 	// Log the webhook
-	app.logWebhook(service, eventType, c.Request().Header, rawReq, responseCode, "", processed, errorMsg)
+	app.logWebhook(c, service, eventType, rawReq, responseCode, "", processed, errorMsg)
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// attributePurchase attempts to attribute a purchase to the most recent campaign sent to the subscriber.
-func (app *App) attributePurchase(order *webhooks.ShopifyOrder) error {
+// attributePurchase attributes a purchase to the campaigns the customer interacted with
+// in the attribution window before the purchase. The share of each campaign is recorded
+// for every attribution model and the purchase is attributed to the campaign with the
+// largest share in the configured model. Orders from all sources are attributed alike
+// and an order that's already attributed is ignored. The commerce flows of new orders
+// are started whether or not the purchase can be attributed.
+func (app *App) attributePurchase(order *models.Order) error {
+	if ok, err := app.hasPurchase(order.Source, order.OrderID); err != nil {
+		return err
+	} else if ok {
+		app.log.Printf("purchase of %s order %s is already recorded", order.Source, order.OrderID)
+		return nil
+	}
+
+	if err := app.startOrderFlows(order); err != nil {
+		app.log.Printf("error starting flows of %s order %s: %v", order.Source, order.OrderID, err)
+	}
+
 	// Find subscriber by email
 	var sub models.Subscriber
 	var subscriberID interface{}
@@ -91,155 +218,309 @@ func (app *App) attributePurchase(order *webhooks.ShopifyOrder) error {
 		subscriberID = sub.ID
 	}
 
-	var campaignID interface{}
-	attributedVia := "is_subscriber"
-	confidence := "high"
+	orderedAt := time.Now()
+	if order.CreatedAt.Valid {
+		orderedAt = order.CreatedAt.Time
+	}
 
-	// If no subscriber, check UTM parameters
-	if !hasSubscriber {
-		// Check if landing_site contains utm_source=listmonk
-		if order.LandingSite != "" && containsUTMSource(order.LandingSite, "listmonk") {
-			app.log.Printf("found utm_source=listmonk in landing_site, attributing to running campaign")
+	var (
+		campaignID    interface{}
+		attributedVia = null.StringFrom(app.cfg.ShopifyAttributionModel)
+		confidence    = null.StringFrom("high")
 
-			// Find most recent running campaign
-			var runningCampaign struct {
-				CampaignID int `db:"campaign_id"`
-			}
-			err = app.db.Get(&runningCampaign, `
-				SELECT id as campaign_id
-				FROM campaigns
-				WHERE status = 'running'
-				ORDER BY started_at DESC
-				LIMIT 1
-			`)
-
-			if err != nil {
-				if err == sql.ErrNoRows {
-					app.log.Printf("no running campaigns found for UTM attribution")
-					campaignID = nil
-				} else {
-					return fmt.Errorf("error finding running campaign for UTM: %v", err)
-				}
-			} else {
-				campaignID = runningCampaign.CampaignID
-				attributedVia = "utm_listmonk"
-				confidence = "medium"
-				app.log.Printf("attributed non-subscriber purchase to running campaign %d via UTM", campaignID)
+		// Campaign weights by attribution model.
+		credits = map[string]map[int]float64{}
+	)
+
+	if hasSubscriber {
+		var touches []attribution.Touch
+		if err := app.queries.GetAttributionTouches.Select(&touches, sub.ID, orderedAt, app.cfg.ShopifyAttributionWindowDays); err != nil {
+			return fmt.Errorf("error fetching campaign touches: %v", err)
+		}
+
+		for _, m := range attribution.Models {
+			if w := attribution.Weights(m, touches, orderedAt, attribution.DefaultHalfLife); len(w) > 0 {
+				credits[m] = w
 			}
-		} else {
-			return fmt.Errorf("no subscriber found with email: %s and no listmonk UTM parameters", order.Email)
 		}
-	} else {
-		// Subscriber exists - use original three-tier logic
-		var recentCampaign struct {
-			CampaignID int `db:"campaign_id"`
+
+		if id, ok := topCampaign(credits[app.cfg.ShopifyAttributionModel]); ok {
+			campaignID = id
 		}
+	}
 
-		err = app.db.Get(&recentCampaign, `
-			SELECT campaign_id
-			FROM azure_delivery_events
-			WHERE subscriber_id = $1
-			  AND status = 'Delivered'
-			ORDER BY event_timestamp DESC
-			LIMIT 1
-		`, sub.ID)
+	// Without any touches in the attribution window, attribute the purchase to the campaign
+	// in the utm_campaign parameter of the landing page and credit it fully under every model.
+	if campaignID == nil {
+		id, err := app.getUTMCampaign(order.LandingSite)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error finding utm_campaign campaign: %v", err)
+		}
 
-		if err != nil {
-			if err == sql.ErrNoRows {
-				// No campaigns delivered to this subscriber, try to find most recent running campaign for their lists
-				app.log.Printf("no delivered campaigns found for subscriber %d, looking for most recent running campaign", sub.ID)
-
-				err = app.db.Get(&recentCampaign, `
-					SELECT c.id as campaign_id
-					FROM campaigns c
-					JOIN campaign_lists cl ON cl.campaign_id = c.id
-					JOIN subscriber_lists sl ON sl.list_id = cl.list_id
-					WHERE sl.subscriber_id = $1
-					  AND c.status = 'running'
-					ORDER BY c.started_at DESC
-					LIMIT 1
-				`, sub.ID)
-
-				if err != nil {
-					if err == sql.ErrNoRows {
-						app.log.Printf("no running campaigns found for subscriber %d's lists, attributing without campaign", sub.ID)
-						campaignID = nil
-					} else {
-						return fmt.Errorf("error finding running campaigns: %v", err)
-					}
-				} else {
-					campaignID = recentCampaign.CampaignID
-					app.log.Printf("no delivered campaigns, attributed to most recent running campaign %d for subscriber %d", campaignID, sub.ID)
-				}
-			} else {
-				return fmt.Errorf("error finding recent campaigns: %v", err)
+		if err == nil {
+			campaignID = id
+			attributedVia = null.StringFrom("utm_campaign")
+			for _, m := range attribution.Models {
+				credits[m] = map[int]float64{id: 1}
 			}
-		} else {
-			campaignID = recentCampaign.CampaignID
 		}
 	}
 
-	// Parse total price
-	totalPrice, err := strconv.ParseFloat(order.TotalPrice, 64)
+	// Purchases of customers who aren't subscribers are only recorded when they can be
+	// attributed to a campaign. Subscribers' purchases without one are recorded unattributed.
+	if campaignID == nil {
+		if !hasSubscriber {
+			app.log.Printf("not attributing %s order %s: no subscriber with the e-mail and no utm_campaign", order.Source, order.OrderID)
+			return nil
+		}
+		attributedVia = null.String{}
+		confidence = null.String{}
+	}
+
+	totalPrice := order.TotalPrice
+
+	data := order.Data
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+
+	// The purchase, its credits and its line items are recorded together so that a purchase
+	// whose credits or line items fail is retried instead of being skipped as already recorded.
+	tx, err := app.db.Beginx()
 	if err != nil {
-		app.log.Printf("error parsing total price '%s': %v", order.TotalPrice, err)
-		totalPrice = 0
+		return fmt.Errorf("error starting transaction: %v", err)
 	}
+	defer tx.Rollback()
 
 	// Create purchase attribution record
-	var attribution models.PurchaseAttribution
-	err = app.queries.InsertPurchaseAttribution.Get(&attribution,
+	var purchase models.PurchaseAttribution
+	err = tx.Stmtx(app.queries.InsertPurchaseAttribution).Get(&purchase,
 		campaignID,
 		subscriberID,
-		fmt.Sprintf("%d", order.ID),
-		order.OrderNumber,
+		order.OrderID,
+		null.NewString(order.OrderNumber, order.OrderNumber != ""),
 		order.Email,
 		totalPrice,
 		order.Currency,
 		attributedVia,
 		confidence,
-		order.RawJSON,
+		data,
+		app.cfg.ShopifyReportingCurrency,
+		order.Source,
+		orderedAt,
 	)
 
 	if err != nil {
 		return fmt.Errorf("error inserting purchase attribution: %v", err)
 	}
 
+	// Record the revenue share of each campaign by model.
+	var (
+		campIDs    []int
+		modelNames []string
+		weights    []float64
+	)
+	for m, w := range credits {
+		for id, v := range w {
+			campIDs = append(campIDs, id)
+			modelNames = append(modelNames, m)
+			weights = append(weights, v)
+		}
+	}
+	if len(campIDs) > 0 {
+		if _, err := tx.Stmtx(app.queries.InsertPurchaseAttributionCredits).Exec(purchase.ID, totalPrice,
+			pq.Array(campIDs), pq.Array(modelNames), pq.Array(weights)); err != nil {
+			return fmt.Errorf("error inserting purchase attribution credits: %v", err)
+		}
+	}
+
+	if err := app.savePurchaseLineItems(tx.Stmtx(app.queries.UpsertPurchaseLineItems), purchase.ID, order.LineItems); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing purchase attribution: %v", err)
+	}
+
 	// Log attribution
 	if subscriberID != nil {
 		if campaignID != nil {
-			app.log.Printf("attributed purchase (order %d, $%.2f %s) to campaign %v for subscriber %v via %s",
-				order.ID, totalPrice, order.Currency, campaignID, subscriberID, attributedVia)
+			app.log.Printf("attributed purchase (%s order %s, %.2f %s) to campaign %v for subscriber %v via %s",
+				order.Source, order.OrderID, totalPrice, order.Currency, campaignID, subscriberID, attributedVia.String)
 		} else {
-			app.log.Printf("attributed purchase (order %d, $%.2f %s) to subscriber %v (no recent campaign)",
-				order.ID, totalPrice, order.Currency, subscriberID)
+			app.log.Printf("attributed purchase (%s order %s, %.2f %s) to subscriber %v (no recent campaign)",
+				order.Source, order.OrderID, totalPrice, order.Currency, subscriberID)
 		}
 	} else {
-		app.log.Printf("attributed non-subscriber purchase (order %d, $%.2f %s) to campaign %v via %s",
-			order.ID, totalPrice, order.Currency, campaignID, attributedVia)
+		app.log.Printf("attributed non-subscriber purchase (%s order %s, %.2f %s) to campaign %v via %s",
+			order.Source, order.OrderID, totalPrice, order.Currency, campaignID, attributedVia.String)
 	}
 
 	return nil
 }
 
-// containsUTMSource checks if a URL or query string contains a specific utm_source parameter.
-func containsUTMSource(landingSite, expectedSource string) bool {
-	// Simple check for utm_source parameter
-	// landingSite format: "/?utm_source=listmonk&utm_medium=email&utm_campaign=50OFF1"
-	searchStr := "utm_source=" + expectedSource
-	return len(landingSite) > 0 && (
-		// Check for utm_source at various positions
-		landingSite == "/?"+searchStr ||
-		strings.Contains(landingSite, "?"+searchStr+"&") ||
-		strings.Contains(landingSite, "&"+searchStr+"&") ||
-		strings.HasSuffix(landingSite, "&"+searchStr) ||
-		strings.HasSuffix(landingSite, "?"+searchStr))
+// refundPurchase records a refund of an attributed purchase, which reduces its net revenue.
+// Refunds are recorded once, so that a refund that's also listed in a later order update
+// isn't deducted twice.
+func (app *App) refundPurchase(r *webhooks.ShopifyRefund) error {
+	amount, currency := r.Amount()
+
+	var ids []int64
+	if err := app.queries.InsertPurchaseRefund.Select(&ids, strconv.FormatInt(r.OrderID, 10),
+		strconv.FormatInt(r.ID, 10), amount, currency, models.OrderSourceShopify); err != nil {
+		return fmt.Errorf("error recording refund: %v", err)
+	}
+
+	if len(ids) == 0 {
+		ok, err := app.hasPurchase(models.OrderSourceShopify, strconv.FormatInt(r.OrderID, 10))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no attributed purchase for order %d", r.OrderID)
+		}
+
+		app.log.Printf("refund %d of order %d is already recorded", r.ID, r.OrderID)
+		return nil
+	}
+
+	app.log.Printf("recorded refund %d (%.2f %s) of order %d", r.ID, amount, currency, r.OrderID)
+	return nil
+}
+
+// updateShopifyPurchase applies a Shopify order edit or cancellation to an attributed
+// purchase and records the refunds listed in the order.
+func (app *App) updateShopifyPurchase(o *webhooks.ShopifyOrder, cancelled bool) error {
+	order := o.Order()
+	if cancelled && !order.CancelledAt.Valid {
+		order.CancelledAt = null.TimeFrom(time.Now())
+	}
+
+	if err := app.updatePurchase(order); err != nil {
+		return err
+	}
+
+	for _, r := range o.Refunds {
+		r.OrderID = o.ID
+		if err := app.refundPurchase(&r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updatePurchase applies an order edit or cancellation to an attributed purchase. The
+// gross price is updated and cancelled orders are voided (their net revenue is 0).
+func (app *App) updatePurchase(order *models.Order) error {
+	var ids []int64
+	if err := app.queries.UpdatePurchaseAttributionOrder.Select(&ids, order.OrderID, order.TotalPrice,
+		order.Currency, order.CancelledAt, order.Data, order.Source); err != nil {
+		return fmt.Errorf("error updating purchase: %v", err)
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no attributed purchase for %s order %s", order.Source, order.OrderID)
+	}
+
+	// Order edits may add or remove products.
+	if len(order.LineItems) > 0 {
+		for _, id := range ids {
+			if err := app.savePurchaseLineItems(app.queries.UpsertPurchaseLineItems, id, order.LineItems); err != nil {
+				return err
+			}
+		}
+	}
+
+	if order.CancelledAt.Valid {
+		app.log.Printf("voided purchase of cancelled %s order %s", order.Source, order.OrderID)
+	} else {
+		app.log.Printf("updated purchase of %s order %s", order.Source, order.OrderID)
+	}
+
+	return nil
+}
+
+// savePurchaseLineItems replaces the line items of a purchase with the upsert statement,
+// which may be bound to a transaction.
+func (app *App) savePurchaseLineItems(stmt *sqlx.Stmt, purchaseID int64, items []models.OrderLineItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	if _, err := stmt.Exec(purchaseID, string(b)); err != nil {
+		return fmt.Errorf("error saving purchase line items: %v", err)
+	}
+
+	return nil
+}
+
+// hasPurchase checks if there's an attributed purchase for an order of a source.
+func (app *App) hasPurchase(source, orderID string) (bool, error) {
+	var ok bool
+	if err := app.db.Get(&ok, `SELECT EXISTS(SELECT 1 FROM purchase_attributions WHERE source = $1 AND order_id = $2)`,
+		source, orderID); err != nil {
+		return false, fmt.Errorf("error finding purchase: %v", err)
+	}
+
+	return ok, nil
+}
+
+// topCampaign returns the campaign with the largest weight. Ties go to the lowest campaign ID.
+func topCampaign(weights map[int]float64) (int, bool) {
+	var (
+		top  = 0
+		best = 0.0
+	)
+	for id, w := range weights {
+		if w > best || (w == best && id < top) {
+			top, best = id, w
+		}
+	}
+
+	return top, top > 0
+}
+
+// getUTMCampaign returns the ID of the campaign identified by the utm_campaign parameter
+// of an order's landing page, which is either the campaign's UUID or its name. It returns
+// sql.ErrNoRows if there's no such parameter or campaign.
+func (app *App) getUTMCampaign(landingSite string) (int, error) {
+	u, err := url.Parse(landingSite)
+	if err != nil {
+		return 0, sql.ErrNoRows
+	}
+
+	camp := strings.TrimSpace(u.Query().Get("utm_campaign"))
+	if camp == "" {
+		return 0, sql.ErrNoRows
+	}
+
+	var id int
+	if uu, err := uuid.FromString(camp); err == nil {
+		err = app.db.Get(&id, `SELECT id FROM campaigns WHERE uuid = $1`, uu)
+		return id, err
+	}
+
+	// Campaign names aren't unique. Pick the latest campaign with the name.
+	err = app.db.Get(&id, `
+		SELECT id FROM campaigns
+		WHERE LOWER(name) = LOWER($1)
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, camp)
+
+	return id, err
 }
 
 // GetCampaignPurchaseStats returns purchase statistics for a campaign.
 func (app *App) GetCampaignPurchaseStats(c echo.Context) error {
 	var (
 		campaignID, _ = strconv.Atoi(c.Param("id"))
+		model         = c.QueryParam("model")
 		stats         models.CampaignPurchaseStats
 	)
 
@@ -247,24 +528,108 @@ func (app *App) GetCampaignPurchaseStats(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.T("globals.messages.invalidID"))
 	}
 
-	// Get purchase stats for the campaign
-	err := app.queries.GetCampaignPurchaseStats.Get(&stats, campaignID)
+	// Report by the configured attribution model unless one is requested.
+	if model == "" {
+		model = app.cfg.ShopifyAttributionModel
+	}
+	if !attribution.IsModel(model) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "model"))
+	}
+
+	// Get purchase stats for the campaign in the reporting currency.
+	err := app.queries.GetCampaignPurchaseStats.Get(&stats, campaignID, model, app.cfg.ShopifyReportingCurrency)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No purchases found, return zero stats
-			stats = models.CampaignPurchaseStats{
-				TotalPurchases: 0,
-				TotalRevenue:   0,
-				AvgOrderValue:  0,
-				Currency:       "USD",
-			}
+		app.log.Printf("error fetching purchase stats for campaign %d: %v", campaignID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+	}
+	stats.Model = model
+
+	// Breakdown by the original currencies.
+	stats.Currencies = []models.CurrencyRevenue{}
+	if err := app.queries.GetCampaignPurchaseCurrencyStats.Select(&stats.Currencies, campaignID, model); err != nil {
+		app.log.Printf("error fetching purchase currency stats for campaign %d: %v", campaignID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{stats})
+}
+
+// GetCampaignProductStats returns the products sold in the purchases attributed to a
+// campaign and the products featured in its clicked links.
+func (app *App) GetCampaignProductStats(c echo.Context) error {
+	var (
+		campaignID, _ = strconv.Atoi(c.Param("id"))
+		model         = c.QueryParam("model")
+		limit, _      = strconv.Atoi(c.QueryParam("limit"))
+	)
+
+	if model == "" {
+		model = app.cfg.ShopifyAttributionModel
+	}
+	if !attribution.IsModel(model) {
+		return echo.NewHTTPError(http.StatusBadRequest, app.i18n.Ts("globals.messages.invalidFields", "name", "model"))
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	out := []models.CampaignProductStats{}
+	if err := app.queries.GetCampaignProductStats.Select(&out, campaignID, model, app.cfg.ShopifyReportingCurrency, limit); err != nil {
+		app.log.Printf("error fetching product stats for campaign %d: %v", campaignID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignHoldoutStats compares the purchases of the recipients of a campaign with
+// those of its holdout (control) group in the attribution window, which is the revenue
+// caused by the campaign. The window can be overridden with the window_days query param.
+func (app *App) GetCampaignHoldoutStats(c echo.Context) error {
+	var (
+		campaignID, _ = strconv.Atoi(c.Param("id"))
+		days, _       = strconv.Atoi(c.QueryParam("window_days"))
+	)
+
+	if days < 1 {
+		days = app.cfg.ShopifyAttributionWindowDays
+	}
+
+	camp, err := app.core.GetCampaign(campaignID, "", "")
+	if err != nil {
+		return err
+	}
+
+	var groups []attribution.Group
+	if err := app.queries.GetCampaignHoldoutStats.Select(&groups, campaignID, days, app.cfg.ShopifyReportingCurrency); err != nil {
+		app.log.Printf("error fetching holdout stats for campaign %d: %v", campaignID, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+	}
+
+	var recipients, holdout attribution.Group
+	for _, g := range groups {
+		if g.Holdout {
+			holdout = g
 		} else {
-			app.log.Printf("error fetching purchase stats for campaign %d: %v", campaignID, err)
-			return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+			recipients = g
 		}
 	}
 
-	return c.JSON(http.StatusOK, okResp{stats})
+	out := struct {
+		attribution.Incrementality
+		HoldoutPercent float64 `json:"holdout_percent"`
+		WindowDays     int     `json:"window_days"`
+		Confidence     float64 `json:"confidence"`
+		Currency       string  `json:"currency"`
+	}{
+		Incrementality: attribution.Compare(recipients, holdout, attribution.Z95),
+		HoldoutPercent: camp.HoldoutPercent,
+		WindowDays:     days,
+		Confidence:     0.95,
+		Currency:       app.cfg.ShopifyReportingCurrency,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // GetCampaignsPerformanceSummary returns aggregate performance metrics for all campaigns in the last 30 days.
@@ -272,7 +637,7 @@ func (app *App) GetCampaignsPerformanceSummary(c echo.Context) error {
 	var summary models.CampaignsPerformanceSummary
 
 	// Get aggregate performance stats
-	err := app.queries.GetCampaignsPerformanceSummary.Get(&summary)
+	err := app.queries.GetCampaignsPerformanceSummary.Get(&summary, app.cfg.ShopifyReportingCurrency)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// No campaigns found, return zero stats
@@ -282,6 +647,7 @@ func (app *App) GetCampaignsPerformanceSummary(c echo.Context) error {
 				TotalSent:           0,
 				TotalOrders:         0,
 				TotalRevenue:        0,
+				Currency:            app.cfg.ShopifyReportingCurrency,
 				OrderRate:           0,
 				RevenuePerRecipient: 0,
 			}
@@ -291,5 +657,12 @@ func (app *App) GetCampaignsPerformanceSummary(c echo.Context) error {
 		}
 	}
 
+	// Breakdown by the original currencies.
+	summary.Currencies = []models.CurrencyRevenue{}
+	if err := app.queries.GetPurchasesCurrencySummary.Select(&summary.Currencies); err != nil {
+		app.log.Printf("error fetching purchases currency summary: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, app.i18n.T("globals.messages.errorFetching"))
+	}
+
 	return c.JSON(http.StatusOK, okResp{summary})
 }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/shopify"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// shopifyCustomerSync tracks the progress of the Shopify customer backfill.
+type shopifyCustomerSync struct {
+	mu     sync.Mutex
+	status shopifySyncStatus
+}
+
+// shopifySyncStatus is the status of a Shopify customer backfill.
+type shopifySyncStatus struct {
+	Running    bool      `json:"running"`
+	StartedAt  null.Time `json:"started_at"`
+	FinishedAt null.Time `json:"finished_at"`
+	Synced     int       `json:"synced"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error"`
+}
+
+var errShopifySyncDisabled = errors.New("shopify customer sync is disabled")
+
+// GetShopifyCustomerSync returns the status of the Shopify customer backfill.
+func (a *App) GetShopifyCustomerSync(c echo.Context) error {
+	a.shopifySync.mu.Lock()
+	out := a.shopifySync.status
+	a.shopifySync.mu.Unlock()
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// SyncShopifyCustomers starts a backfill of all Shopify customers from the Admin API
+// into subscribers in the background.
+func (a *App) SyncShopifyCustomers(c echo.Context) error {
+	if !a.cfg.ShopifySyncCustomers {
+		return echo.NewHTTPError(http.StatusBadRequest, errShopifySyncDisabled.Error())
+	}
+
+	client, err := shopify.New(shopify.Opt{
+		ShopURL:     a.cfg.ShopifyShopURL,
+		AccessToken: a.cfg.ShopifyAccessToken,
+		APIVersion:  a.cfg.ShopifyAPIVersion,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
+	a.shopifySync.mu.Lock()
+	if a.shopifySync.status.Running {
+		a.shopifySync.mu.Unlock()
+		return echo.NewHTTPError(http.StatusConflict, "a shopify customer sync is already running")
+	}
+	a.shopifySync.status = shopifySyncStatus{Running: true, StartedAt: null.TimeFrom(time.Now())}
+	a.shopifySync.mu.Unlock()
+
+	go a.backfillShopifyCustomers(client)
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// backfillShopifyCustomers syncs all customers page by page.
+func (a *App) backfillShopifyCustomers(client *shopify.Client) {
+	a.log.Printf("starting shopify customer sync")
+
+	err := client.Customers(func(custs []webhooks.ShopifyCustomer) error {
+		for _, cust := range custs {
+			ok, err := a.syncShopifyCustomer(cust)
+
+			a.shopifySync.mu.Lock()
+			switch {
+			case err != nil:
+				a.shopifySync.status.Failed++
+			case ok:
+				a.shopifySync.status.Synced++
+			default:
+				a.shopifySync.status.Skipped++
+			}
+			a.shopifySync.mu.Unlock()
+
+			if err != nil {
+				a.log.Printf("error syncing shopify customer %d: %v", cust.ID, err)
+			}
+		}
+		return nil
+	})
+
+	a.shopifySync.mu.Lock()
+	defer a.shopifySync.mu.Unlock()
+
+	a.shopifySync.status.Running = false
+	a.shopifySync.status.FinishedAt = null.TimeFrom(time.Now())
+	if err != nil {
+		a.shopifySync.status.Error = err.Error()
+		a.log.Printf("error syncing shopify customers: %v", err)
+		return
+	}
+
+	a.log.Printf("shopify customer sync finished: %d synced, %d skipped, %d failed",
+		a.shopifySync.status.Synced, a.shopifySync.status.Skipped, a.shopifySync.status.Failed)
+}
+
+// syncShopifyCustomer creates or updates the subscriber of a Shopify customer. The
+// customer's e-mail marketing consent is mapped to the status of the subscriptions to
+// the sync lists and the customer's tags, total spent and order count are stored in
+// the subscriber's attributes under "shopify". Consent adds missing subscriptions and
+// confirms unconfirmed ones, but never re-subscribes a subscriber who unsubscribed from
+// a list. Customers who haven't consented and aren't subscribers already, and blocklisted
+// subscribers are skipped, in which case it returns false.
+func (a *App) syncShopifyCustomer(cust webhooks.ShopifyCustomer) (bool, error) {
+	if !a.cfg.ShopifySyncCustomers {
+		return false, errShopifySyncDisabled
+	}
+
+	email := strings.ToLower(strings.TrimSpace(cust.Email))
+	if email == "" {
+		return false, nil
+	}
+
+	var (
+		consent = cust.Consent()
+		listIDs = a.cfg.ShopifySyncListIDs
+	)
+
+	totalSpent, _ := strconv.ParseFloat(cust.TotalSpent, 64)
+	attribs := map[string]interface{}{
+		"customer_id":  strconv.FormatInt(cust.ID, 10),
+		"tags":         cust.TagList(),
+		"total_spent":  totalSpent,
+		"orders_count": cust.OrdersCount,
+		"currency":     cust.Currency,
+		"consent":      consent,
+		"updated_at":   cust.UpdatedAt,
+	}
+
+	var sub models.Subscriber
+	err := a.queries.GetSubscriberByEmail.Get(&sub, email)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("error finding subscriber: %v", err)
+	}
+
+	// New subscriber.
+	if err == sql.ErrNoRows {
+		if consent == webhooks.ShopifyConsentUnsubscribed {
+			return false, nil
+		}
+
+		name := strings.TrimSpace(cust.FirstName + " " + cust.LastName)
+		if name == "" {
+			name = strings.Split(email, "@")[0]
+		}
+
+		if _, _, err := a.core.InsertSubscriber(models.Subscriber{
+			Email:   email,
+			Name:    name,
+			Attribs: models.JSON{"shopify": attribs},
+		}, listIDs, nil, consent == webhooks.ShopifyConsentSubscribed, false); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	// Blocklisted subscribers are never updated or subscribed.
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return false, nil
+	}
+
+	// Existing subscriber. Merge the Shopify attributes with the existing ones.
+	if sub.Attribs == nil {
+		sub.Attribs = models.JSON{}
+	}
+	sub.Attribs["shopify"] = attribs
+	sub.Status = ""
+
+	subLists := listIDs
+	if consent == webhooks.ShopifyConsentUnsubscribed {
+		subLists = []int{}
+	}
+
+	// The update adds the missing subscriptions (confirmed on consent) and retains the status
+	// of the existing ones, except for unconfirmed subscriptions, which consent confirms.
+	if _, _, err := a.core.UpdateSubscriberWithLists(sub.ID, sub, subLists, []string{},
+		consent == webhooks.ShopifyConsentSubscribed, false, false); err != nil {
+		return false, err
+	}
+
+	// Withdrawn consent unsubscribes from the sync lists.
+	if consent == webhooks.ShopifyConsentUnsubscribed && len(listIDs) > 0 {
+		if err := a.core.UnsubscribeLists([]int{sub.ID}, listIDs, nil); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// deleteShopifyCustomer unsubscribes the subscriber of a deleted Shopify customer
+// from the sync lists and removes the Shopify attributes. The subscriber itself is
+// retained as they may have subscribed independently of Shopify.
+func (a *App) deleteShopifyCustomer(customerID int64) error {
+	if !a.cfg.ShopifySyncCustomers {
+		return errShopifySyncDisabled
+	}
+
+	var ids []int
+	if err := a.db.Select(&ids, `
+		UPDATE subscribers SET attribs = attribs - 'shopify', updated_at = NOW()
+		WHERE attribs->'shopify'->>'customer_id' = $1
+		RETURNING id
+	`, strconv.FormatInt(customerID, 10)); err != nil {
+		return fmt.Errorf("error removing shopify customer attributes: %v", err)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if len(a.cfg.ShopifySyncListIDs) > 0 {
+		if _, err := a.db.Exec(`
+			UPDATE subscriber_lists SET status = 'unsubscribed', updated_at = NOW()
+			WHERE subscriber_id = ANY($1) AND list_id = ANY($2)
+		`, pq.Array(ids), pq.Array(a.cfg.ShopifySyncListIDs)); err != nil {
+			return fmt.Errorf("error unsubscribing deleted shopify customer: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -130,7 +131,7 @@ func (a *App) CreateTemplate(c echo.Context) error {
 	}
 
 	// Create the template the in the DB.
-	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource, auth.GetUser(c))
 	if err != nil {
 		return err
 	}
@@ -150,10 +151,22 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 	if err := c.Bind(&o); err != nil {
 		return err
 	}
-	if err := a.validateTemplate(o); err != nil {
+
+	out, err := a.updateTemplate(getID(c), o, auth.GetUser(c))
+	if err != nil {
 		return err
 	}
 
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// updateTemplate validates, compiles and updates a template, recording it as a new version
+// by the author.
+func (a *App) updateTemplate(id int, o models.Template, author auth.User) (models.Template, error) {
+	if err := a.validateTemplate(o); err != nil {
+		return models.Template{}, err
+	}
+
 	// Subject is only relevant for fixed tx templates. For campaigns,
 	// the subject changes per campaign and is on models.Campaign.
 	var funcs template.FuncMap
@@ -166,14 +179,13 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 
 	// Compile the template and validate.
 	if err := o.Compile(funcs); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return models.Template{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	// Update the template in the DB.
-	id := getID(c)
-	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource, author)
 	if err != nil {
-		return err
+		return models.Template{}, err
 	}
 
 	// If it's a transactional template, cache it.
@@ -181,8 +193,7 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 		a.manager.CacheTpl(out.ID, &o)
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
-
+	return out, nil
 }
 
 // TemplateSetDefault handles template modification.
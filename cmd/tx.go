@@ -116,33 +116,7 @@ func (a *App) SendTxMessage(c echo.Context) error {
 				a.i18n.Ts("globals.messages.errorFetching", "name"))
 		}
 
-		// Prepare the final message.
-		msg := models.Message{}
-		msg.Subscriber = sub
-		msg.To = []string{sub.Email}
-		msg.From = m.FromEmail
-		msg.Subject = m.Subject
-		msg.ContentType = m.ContentType
-		msg.Messenger = m.Messenger
-		msg.Body = m.Body
-		for _, a := range m.Attachments {
-			msg.Attachments = append(msg.Attachments, models.Attachment{
-				Name:    a.Name,
-				Header:  a.Header,
-				Content: a.Content,
-			})
-		}
-
-		// Optional headers.
-		if len(m.Headers) != 0 {
-			msg.Headers = make(textproto.MIMEHeader, len(m.Headers))
-			for _, set := range m.Headers {
-				for hdr, val := range set {
-					msg.Headers.Add(hdr, val)
-				}
-			}
-		}
-
+		msg := makeTxMessage(m, sub)
 		if err := a.manager.PushMessage(msg); err != nil {
 			a.log.Printf("error sending message (%s): %v", msg.Subject, err)
 			return err
@@ -156,6 +130,95 @@ func (a *App) SendTxMessage(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// makeTxMessage prepares the outgoing message of a rendered tx message to a subscriber.
+func makeTxMessage(m models.TxMessage, sub models.Subscriber) models.Message {
+	msg := models.Message{}
+	msg.Subscriber = sub
+	msg.To = []string{sub.Email}
+	msg.From = m.FromEmail
+	msg.Subject = m.Subject
+	msg.ContentType = m.ContentType
+	msg.Messenger = m.Messenger
+	msg.Body = m.Body
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, models.Attachment{
+			Name:    a.Name,
+			Header:  a.Header,
+			Content: a.Content,
+		})
+	}
+
+	// Optional headers.
+	if len(m.Headers) != 0 {
+		msg.Headers = make(textproto.MIMEHeader, len(m.Headers))
+		for _, set := range m.Headers {
+			for hdr, val := range set {
+				msg.Headers.Add(hdr, val)
+			}
+		}
+	}
+
+	return msg
+}
+
+// queueTxTemplate queues a tx template with data to a subscriber in the e-mail queue.
+// Automations use it to send tx messages with the queue's rate limits and per-server
+// daily limits. The message is rendered when the queue processor sends it.
+func (a *App) queueTxTemplate(sub models.Subscriber, tplID int, subject string, data map[string]any) error {
+	m := models.TxMessage{
+		SubscriberIDs: []int{sub.ID},
+		TemplateID:    tplID,
+		Subject:       subject,
+		ContentType:   models.CampaignContentTypeHTML,
+		Data:          data,
+	}
+
+	m, err := a.validateTxMessage(m)
+	if err != nil {
+		return err
+	}
+	if _, err := a.manager.GetTpl(m.TemplateID); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := a.queries.QueueTxEmail.Exec(sub.ID, string(b)); err != nil {
+		return fmt.Errorf("error queueing tx message: %v", err)
+	}
+
+	return nil
+}
+
+// sendQueuedTx renders a tx message queued by queueTxTemplate to a subscriber and sends
+// it through the SMTP server picked by the queue processor.
+func (a *App) sendQueuedTx(subID int, b []byte, serverUUID string) error {
+	var m models.TxMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("error parsing tx message: %v", err)
+	}
+
+	sub, err := a.core.GetSubscriber(subID, "", "")
+	if err != nil {
+		return err
+	}
+	if sub.Status == models.SubscriberStatusBlockListed {
+		return fmt.Errorf("subscriber %d is blocklisted", sub.ID)
+	}
+
+	tpl, err := a.manager.GetTpl(m.TemplateID)
+	if err != nil {
+		return err
+	}
+	if err := m.Render(sub, tpl); err != nil {
+		return fmt.Errorf("error rendering template %d: %v", m.TemplateID, err)
+	}
+
+	return a.manager.PushMessageByServer(makeTxMessage(m, sub), serverUUID)
+}
+
 // validateTxMessage validates the tx message fields.
 func (a *App) validateTxMessage(m models.TxMessage) (models.TxMessage, error) {
 	if len(m.SubscriberEmails) > 0 && m.SubscriberEmail != "" {
@@ -50,6 +50,33 @@ var migList = []migFunc{
 	{"v7.0.0", migrations.V7_0_0},
 	{"v7.1.0", migrations.V7_1_0},
 	{"v7.2.0", migrations.V7_2_0},
+	{"v7.3.0", migrations.V7_3_0},
+	{"v7.4.0", migrations.V7_4_0},
+	{"v7.5.0", migrations.V7_5_0},
+	{"v7.6.0", migrations.V7_6_0},
+	{"v7.7.0", migrations.V7_7_0},
+	{"v7.8.0", migrations.V7_8_0},
+	{"v7.9.0", migrations.V7_9_0},
+	{"v7.10.0", migrations.V7_10_0},
+	{"v7.11.0", migrations.V7_11_0},
+	{"v7.12.0", migrations.V7_12_0},
+	{"v7.13.0", migrations.V7_13_0},
+	{"v7.14.0", migrations.V7_14_0},
+	{"v7.15.0", migrations.V7_15_0},
+	{"v7.16.0", migrations.V7_16_0},
+	{"v7.17.0", migrations.V7_17_0},
+	{"v7.18.0", migrations.V7_18_0},
+	{"v7.19.0", migrations.V7_19_0},
+	{"v7.20.0", migrations.V7_20_0},
+	{"v7.21.0", migrations.V7_21_0},
+	{"v7.22.0", migrations.V7_22_0},
+	{"v7.23.0", migrations.V7_23_0},
+	{"v7.24.0", migrations.V7_24_0},
+	{"v7.25.0", migrations.V7_25_0},
+	{"v7.26.0", migrations.V7_26_0},
+	{"v7.27.0", migrations.V7_27_0},
+	{"v7.28.0", migrations.V7_28_0},
+	{"v7.29.0", migrations.V7_29_0},
 }
 
 // upgrade upgrades the database to the current version by running SQL migration files
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/diff"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// versionDiff is the line by line diff of the fields of two versions of a campaign or a template.
+type versionDiff struct {
+	From   int         `json:"from"`
+	To     int         `json:"to"`
+	Fields []fieldDiff `json:"fields"`
+}
+
+type fieldDiff struct {
+	Name    string      `json:"name"`
+	Changed bool        `json:"changed"`
+	Lines   []diff.Line `json:"lines"`
+}
+
+// GetCampaignVersions returns the saved versions of a campaign's content, latest first.
+func (a *App) GetCampaignVersions(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaignVersions(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignVersion returns a version of a campaign's content.
+func (a *App) GetCampaignVersion(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	version, err := a.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.GetCampaignVersion(id, version)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DiffCampaignVersions returns the diff between two versions of a campaign's content.
+// `to` is the latest version if it's not given, and `from` is the version before `to`.
+func (a *App) DiffCampaignVersions(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	fromVer, toVer := a.getDiffParams(c)
+	to, err := a.core.GetCampaignVersion(id, toVer)
+	if err != nil {
+		return err
+	}
+	if fromVer == 0 {
+		fromVer = max(to.Version-1, 1)
+	}
+	from, err := a.core.GetCampaignVersion(id, fromVer)
+	if err != nil {
+		return err
+	}
+
+	out := versionDiff{
+		From: from.Version,
+		To:   to.Version,
+		Fields: []fieldDiff{
+			makeFieldDiff("subject", from.Subject, to.Subject),
+			makeFieldDiff("content_type", from.ContentType, to.ContentType),
+			makeFieldDiff("template_id", nullIntStr(from.TemplateID), nullIntStr(to.TemplateID)),
+			makeFieldDiff("headers", headersStr(from.Headers), headersStr(to.Headers)),
+			makeFieldDiff("body", from.Body, to.Body),
+			makeFieldDiff("body_source", from.BodySource.String, to.BodySource.String),
+			makeFieldDiff("altbody", from.AltBody.String, to.AltBody.String),
+		},
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RestoreCampaignVersion restores the content of a campaign to a saved version. The
+// restored content is saved as a new version.
+func (a *App) RestoreCampaignVersion(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	version, err := a.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	cm, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+
+	v, err := a.core.GetCampaignVersion(id, version)
+	if err != nil {
+		return err
+	}
+
+	listIDs, mediaIDs, err := campaignListMediaIDs(cm)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	o := cm
+	o.Subject = v.Subject
+	o.Body = v.Body
+	o.BodySource = v.BodySource
+	o.AltBody = v.AltBody
+	o.ContentType = v.ContentType
+	o.TemplateID = v.TemplateID
+	o.Headers = v.Headers
+
+	// Restoring the reviewed content of a campaign needs another review.
+	reviewed, err := a.isCampaignReviewEdited(cm, o, listIDs)
+	if err != nil {
+		return err
+	}
+
+	user := auth.GetUser(c)
+	out, err := a.core.UpdateCampaign(id, o, listIDs, mediaIDs, user)
+	if err != nil {
+		return err
+	}
+
+	if reviewed {
+		if err := a.resetCampaignReview(id, user); err != nil {
+			return err
+		}
+
+		if out, err = a.core.GetCampaign(id, "", ""); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetTemplateVersions returns the saved versions of a template, latest first.
+func (a *App) GetTemplateVersions(c echo.Context) error {
+	out, err := a.core.GetTemplateVersions(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetTemplateVersion returns a version of a template.
+func (a *App) GetTemplateVersion(c echo.Context) error {
+	version, err := a.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.core.GetTemplateVersion(getID(c), version)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DiffTemplateVersions returns the diff between two versions of a template.
+// `to` is the latest version if it's not given, and `from` is the version before `to`.
+func (a *App) DiffTemplateVersions(c echo.Context) error {
+	id := getID(c)
+
+	fromVer, toVer := a.getDiffParams(c)
+	to, err := a.core.GetTemplateVersion(id, toVer)
+	if err != nil {
+		return err
+	}
+	if fromVer == 0 {
+		fromVer = max(to.Version-1, 1)
+	}
+	from, err := a.core.GetTemplateVersion(id, fromVer)
+	if err != nil {
+		return err
+	}
+
+	out := versionDiff{
+		From: from.Version,
+		To:   to.Version,
+		Fields: []fieldDiff{
+			makeFieldDiff("name", from.Name, to.Name),
+			makeFieldDiff("subject", from.Subject, to.Subject),
+			makeFieldDiff("body", from.Body, to.Body),
+			makeFieldDiff("body_source", from.BodySource.String, to.BodySource.String),
+		},
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RestoreTemplateVersion restores a template to a saved version. The restored template is
+// saved as a new version.
+func (a *App) RestoreTemplateVersion(c echo.Context) error {
+	id := getID(c)
+
+	version, err := a.getVersionParam(c)
+	if err != nil {
+		return err
+	}
+
+	tpl, err := a.core.GetTemplate(id, false)
+	if err != nil {
+		return err
+	}
+
+	v, err := a.core.GetTemplateVersion(id, version)
+	if err != nil {
+		return err
+	}
+
+	o := models.Template{
+		Name:       v.Name,
+		Type:       tpl.Type,
+		Subject:    v.Subject,
+		Body:       v.Body,
+		BodySource: v.BodySource,
+	}
+	out, err := a.updateTemplate(id, o, auth.GetUser(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// getVersionParam returns the version number in the URI.
+func (a *App) getVersionParam(c echo.Context) (int, error) {
+	version, _ := strconv.Atoi(c.Param("version"))
+	if version < 1 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+	}
+
+	return version, nil
+}
+
+// getDiffParams returns the optional from and to versions in the query params.
+func (a *App) getDiffParams(c echo.Context) (int, int) {
+	from, _ := strconv.Atoi(c.QueryParam("from"))
+	to, _ := strconv.Atoi(c.QueryParam("to"))
+
+	return max(from, 0), max(to, 0)
+}
+
+func makeFieldDiff(name, from, to string) fieldDiff {
+	lines := diff.Lines(from, to)
+	return fieldDiff{Name: name, Changed: diff.Changed(lines), Lines: lines}
+}
+
+func nullIntStr(n null.Int) string {
+	if !n.Valid {
+		return ""
+	}
+
+	return strconv.Itoa(n.Int)
+}
+
+func headersStr(h models.Headers) string {
+	if len(h) == 0 {
+		return ""
+	}
+
+	b, _ := json.MarshalIndent(h, "", "  ")
+	return string(b)
+}
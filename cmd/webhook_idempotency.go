@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	// ctxWebhookKeys is the echo context key that holds the idempotency keys
+	// claimed while processing a webhook request.
+	ctxWebhookKeys = "webhook_keys"
+
+	// webhookDuplicate is logged as the response body of webhook deliveries
+	// that were skipped as duplicates.
+	webhookDuplicate = "duplicate"
+)
+
+// claimWebhookKey claims the idempotency key of a webhook event for the configured TTL.
+// It returns false if the key was already claimed, ie: the event is a retried delivery
+// that has already been processed. Replays of stored webhooks are never duplicates.
+func (a *App) claimWebhookKey(c echo.Context, webhookType, key string) bool {
+	if key == "" || isWebhookReplay(c) {
+		return true
+	}
+
+	var (
+		k   = webhookType + ":" + key
+		out string
+	)
+	if err := a.queries.ClaimWebhookKey.Get(&out, k, webhookType, a.cfg.WebhookIdempotencyTTL.Seconds()); err != nil {
+		if err == sql.ErrNoRows {
+			a.log.Printf("skipping duplicate %s webhook: %s", webhookType, key)
+			return false
+		}
+
+		// Rather process a possible duplicate than drop the event.
+		a.log.Printf("error claiming webhook idempotency key %s: %v", k, err)
+		return true
+	}
+
+	keys, _ := c.Get(ctxWebhookKeys).([]string)
+	c.Set(ctxWebhookKeys, append(keys, k))
+
+	return true
+}
+
+// releaseWebhookKeys releases the idempotency keys claimed by a webhook request that
+// failed so that the provider's retry of it is processed.
+func (a *App) releaseWebhookKeys(c echo.Context) {
+	keys, _ := c.Get(ctxWebhookKeys).([]string)
+	if len(keys) == 0 {
+		return
+	}
+
+	if _, err := a.queries.ReleaseWebhookKeys.Exec(pq.Array(keys)); err != nil {
+		a.log.Printf("error releasing webhook idempotency keys: %v", err)
+	}
+	c.Set(ctxWebhookKeys, nil)
+}
+
+// snsMessageID returns the ID of an SNS message from the request header SNS sets
+// or from the message body.
+func snsMessageID(c echo.Context, body []byte) string {
+	if id := c.Request().Header.Get("X-Amz-Sns-Message-Id"); id != "" {
+		return id
+	}
+
+	var m struct {
+		MessageID string `json:"MessageId"`
+	}
+	_ = json.Unmarshal(body, &m)
+
+	return m.MessageID
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/knadh/listmonk/internal/inbox"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ctxWebhookInbox is the echo context key that holds the ID of the inbox
+	// entry a webhook request was persisted to or is being processed from.
+	ctxWebhookInbox = "webhook_inbox"
+
+	// webhookQueued is logged as the response body of webhooks that were
+	// persisted to the inbox for processing.
+	webhookQueued = "queued"
+)
+
+// GetWebhookInboxStats returns the depth and lag of the webhook inbox.
+func (a *App) GetWebhookInboxStats(c echo.Context) error {
+	if a.inbox == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "webhook inbox is disabled")
+	}
+
+	out, err := a.inbox.GetStats()
+	if err != nil {
+		a.log.Printf("error getting webhook inbox stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetWebhookInbox returns webhook inbox entries, optionally filtered by status.
+func (a *App) GetWebhookInbox(c echo.Context) error {
+	if a.inbox == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "webhook inbox is disabled")
+	}
+
+	var (
+		status = c.QueryParam("status")
+		pg     = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	res, err := a.inbox.GetEntries(status, pg.Offset, pg.Limit)
+	if err != nil {
+		a.log.Printf("error getting webhook inbox entries: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	if len(res) == 0 {
+		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []inbox.Entry{}}})
+	}
+
+	out := models.PageResults{
+		Results: res,
+		Total:   res[0].Total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RetryWebhookInboxEntry queues a dead-lettered webhook inbox entry to be processed again.
+func (a *App) RetryWebhookInboxEntry(c echo.Context) error {
+	if a.inbox == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "webhook inbox is disabled")
+	}
+
+	if err := a.inbox.Retry(int64(getID(c))); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("globals.messages.notFound", "name", "webhook inbox entry"))
+		}
+
+		a.log.Printf("error retrying webhook inbox entry: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// pushWebhookInbox persists a verified webhook request to the inbox. The request's
+// log entry is linked to the inbox entry and is updated once it's processed.
+func (a *App) pushWebhookInbox(c echo.Context, webhookType string, body []byte) error {
+	id, err := a.inbox.Push(webhookType, webhookHeaders(c), string(body))
+	if err != nil {
+		a.log.Printf("error pushing %s webhook to inbox: %v", webhookType, err)
+		return err
+	}
+
+	c.Set(ctxWebhookInbox, id)
+	return nil
+}
+
+// processInboxEntry processes a webhook inbox entry by running it through the
+// handler of its webhook type. Entries rejected by the handler (4xx) are never
+// going to succeed and are dead-lettered right away.
+func (a *App) processInboxEntry(e inbox.Entry) error {
+	status, msg := a.runWebhook(e.WebhookType, e.RequestHeaders, e.RequestBody, ctxWebhookInbox, e.ID)
+	if status < http.StatusBadRequest {
+		return nil
+	}
+
+	err := fmt.Errorf("%d: %s", status, msg)
+	if status < http.StatusInternalServerError {
+		return inbox.Permanent(err)
+	}
+
+	return err
+}
+
+// isWebhookInbox checks if the request belongs to a webhook inbox entry.
+func isWebhookInbox(c echo.Context) bool {
+	_, ok := c.Get(ctxWebhookInbox).(int64)
+	return ok
+}
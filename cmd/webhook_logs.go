@@ -1,32 +1,91 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// ctxWebhookReplay is the echo context key that holds the ID of the stored
+	// webhook log that is being replayed.
+	ctxWebhookReplay = "webhook_replay"
+
+	// ctxWebhookIndex is the echo context key that holds the webhookLogIndex fields
+	// a webhook handler extracted from the payload.
+	ctxWebhookIndex = "webhook_index"
+
+	// maxWebhookReplay is the maximum number of logs replayed in one bulk request.
+	maxWebhookReplay = 1000
+)
+
+// webhookLogIndex holds the fields extracted from a webhook payload that are
+// stored in indexed columns alongside the webhook log for filtering.
+type webhookLogIndex struct {
+	Email        string
+	CampaignID   int
+	CampaignUUID string
+	MessageID    string
+}
+
+// webhookReplayResult is the outcome of replaying a single stored webhook.
+type webhookReplayResult struct {
+	LogID     int64  `json:"log_id"`
+	ReplayID  int64  `json:"replay_id"`
+	Status    int    `json:"status"`
+	Processed bool   `json:"processed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// webhookReplaySummary is the outcome of a bulk replay.
+type webhookReplaySummary struct {
+	Total     int                   `json:"total"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Results   []webhookReplayResult `json:"results"`
+}
+
 // GetWebhookLogs retrieves webhook logs with pagination and filtering.
 func (a *App) GetWebhookLogs(c echo.Context) error {
 	var (
 		webhookType = c.QueryParam("webhook_type")
 		eventType   = c.QueryParam("event_type")
+		email       = strings.TrimSpace(c.QueryParam("email"))
+		messageID   = strings.TrimSpace(c.QueryParam("message_id"))
+		search      = strings.TrimSpace(c.QueryParam("search"))
+		jsonPath    = strings.TrimSpace(c.QueryParam("json_path"))
 		pg          = a.pg.NewFromURL(c.Request().URL.Query())
 	)
 
+	campID, _ := strconv.Atoi(c.QueryParam("campaign_id"))
+
+	// Validate the JSON path expression so that a typo doesn't surface as a query error.
+	if jsonPath != "" {
+		if _, err := a.db.Exec(`SELECT $1::JSONPATH`, jsonPath); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": json_path: "+err.Error())
+		}
+	}
+
 	// Get total count
 	var total int
-	if err := a.queries.GetWebhookLogsCount.Get(&total, webhookType, eventType); err != nil {
+	if err := a.queries.GetWebhookLogsCount.Get(&total, webhookType, eventType, email, campID, messageID, search, jsonPath); err != nil {
 		a.log.Printf("error getting webhook logs count: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
 	}
 
 	// Get logs
 	var logs []models.WebhookLog
-	if err := a.queries.GetWebhookLogs.Select(&logs, webhookType, eventType, pg.Offset, pg.Limit); err != nil {
+	if err := a.queries.GetWebhookLogs.Select(&logs, webhookType, eventType, email, campID, messageID, search, jsonPath, pg.Offset, pg.Limit); err != nil {
 		a.log.Printf("error getting webhook logs: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
 	}
@@ -45,20 +104,200 @@ func (a *App) GetWebhookLogs(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// ExportWebhookLogs exports all webhook logs as a JSON file.
-func (a *App) ExportWebhookLogs(c echo.Context) error {
-	// Get all logs without pagination
+// ReplayWebhookLog re-runs a single stored webhook through its handler.
+func (a *App) ReplayWebhookLog(c echo.Context) error {
+	var l models.WebhookLog
+	if err := a.queries.GetWebhookLog.Get(&l, getID(c)); err != nil {
+		if err == sql.ErrNoRows {
+			return echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("globals.messages.notFound", "name", "webhook log"))
+		}
+
+		a.log.Printf("error getting webhook log: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	if l.ReplayOf.Valid {
+		return echo.NewHTTPError(http.StatusBadRequest, "replay entries cannot be replayed")
+	}
+
+	return c.JSON(http.StatusOK, okResp{a.replayWebhook(l)})
+}
+
+// ReplayWebhookLogs re-runs all stored webhooks matching the given filters through their handlers.
+func (a *App) ReplayWebhookLogs(c echo.Context) error {
+	var req struct {
+		WebhookType string    `json:"webhook_type"`
+		From        null.Time `json:"from"`
+		To          null.Time `json:"to"`
+		Processed   null.Bool `json:"processed"`
+		Limit       int       `json:"limit"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": "+err.Error())
+	}
+
+	if req.Limit < 1 || req.Limit > maxWebhookReplay {
+		req.Limit = maxWebhookReplay
+	}
+
 	var logs []models.WebhookLog
-	if err := a.queries.GetAllWebhookLogs.Select(&logs); err != nil {
+	if err := a.queries.GetWebhookLogsForReplay.Select(&logs, req.WebhookType, req.From, req.To, req.Processed, req.Limit); err != nil {
+		a.log.Printf("error getting webhook logs for replay: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	out := webhookReplaySummary{Results: make([]webhookReplayResult, 0, len(logs))}
+	for _, l := range logs {
+		res := a.replayWebhook(l)
+		if res.Processed {
+			out.Succeeded++
+		} else {
+			out.Failed++
+		}
+		out.Results = append(out.Results, res)
+	}
+	out.Total = len(logs)
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// replayWebhook runs a stored webhook request through the same handler that received
+// it originally, with signature verification skipped, and returns the outcome.
+func (a *App) replayWebhook(l models.WebhookLog) webhookReplayResult {
+	res := webhookReplayResult{LogID: l.ID}
+
+	res.Status, res.Error = a.runWebhook(l.WebhookType, l.RequestHeaders, l.RequestBody, ctxWebhookReplay, l.ID)
+
+	// The handler logs the replay as a new webhook log linked to the original one.
+	var rl models.WebhookLog
+	if err := a.queries.GetWebhookLogReplay.Get(&rl, l.ID); err == nil {
+		res.ReplayID = rl.ID
+		res.Processed = rl.Processed && res.Status < http.StatusBadRequest
+		if res.Error == "" && rl.ErrorMessage.Valid {
+			res.Error = rl.ErrorMessage.String
+		}
+	}
+
+	if res.Processed {
+		if _, err := a.queries.MarkWebhookLogProcessed.Exec(l.ID); err != nil {
+			a.log.Printf("error marking webhook log %d as processed: %v", l.ID, err)
+		}
+	}
+
+	return res
+}
+
+// runWebhook re-creates a webhook request from its stored headers and body and runs it
+// through the handler of the webhook type. ctxKey and ctxVal are set on the request context
+// to mark the request as an internal (already verified) one. It returns the HTTP status
+// of the handler's response and the error message, if any.
+func (a *App) runWebhook(webhookType string, headers json.RawMessage, body, ctxKey string, ctxVal interface{}) (int, string) {
+	var (
+		handler echo.HandlerFunc
+		service string
+	)
+	switch webhookType {
+	case "shopify":
+		handler = a.ShopifyWebhook
+	case "woocommerce":
+		handler = a.WooCommerceWebhook
+	case "stripe":
+		handler = a.StripeWebhook
+	case "native":
+		handler = a.BounceWebhook
+	case "ses", "sendgrid", "postmark", "forwardemail", "azure":
+		handler = a.BounceWebhook
+		service = webhookType
+	default:
+		return http.StatusBadRequest, "unknown webhook type: " + webhookType
+	}
+
+	// Re-create the original request from the stored headers and body.
+	var hdr map[string]string
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &hdr); err != nil {
+			return http.StatusBadRequest, "error reading stored headers: " + err.Error()
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/"+webhookType, strings.NewReader(body))
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+
+	var (
+		rec = httptest.NewRecorder()
+		c   = echo.New().NewContext(req, rec)
+	)
+	c.SetParamNames("service")
+	c.SetParamValues(service)
+	c.Set(ctxKey, ctxVal)
+
+	if err := handler(c); err != nil {
+		if e, ok := err.(*echo.HTTPError); ok {
+			return e.Code, fmt.Sprintf("%v", e.Message)
+		}
+		return http.StatusInternalServerError, err.Error()
+	}
+
+	return rec.Code, ""
+}
+
+// isWebhookReplay checks if the request is the replay of a stored webhook. Such requests
+// are only created internally by replayWebhook() and never from incoming HTTP requests.
+func isWebhookReplay(c echo.Context) bool {
+	_, ok := c.Get(ctxWebhookReplay).(int64)
+	return ok
+}
+
+// isWebhookVerified checks if the request is an internal one for a webhook that was
+// verified when it was received, ie: a replay or an inbox entry being processed.
+func isWebhookVerified(c echo.Context) bool {
+	return isWebhookReplay(c) || isWebhookInbox(c)
+}
+
+// ExportWebhookLogs streams all webhook logs as newline delimited JSON (NDJSON).
+func (a *App) ExportWebhookLogs(c echo.Context) error {
+	rows, err := a.queries.GetAllWebhookLogs.Queryx()
+	if err != nil {
 		a.log.Printf("error getting all webhook logs: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
 	}
+	defer rows.Close()
 
 	// Set headers for file download
-	c.Response().Header().Set("Content-Type", "application/json")
-	c.Response().Header().Set("Content-Disposition", "attachment; filename=webhook-logs.json")
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentType, "application/x-ndjson")
+	h.Set(echo.HeaderContentDisposition, "attachment; filename=webhook-logs.ndjson")
+	c.Response().WriteHeader(http.StatusOK)
 
-	return c.JSON(http.StatusOK, logs)
+	// Once the headers are out, errors can only be logged and the stream cut short.
+	var (
+		enc = json.NewEncoder(c.Response())
+		n   = 0
+	)
+	for rows.Next() {
+		var l models.WebhookLog
+		if err := rows.StructScan(&l); err != nil {
+			a.log.Printf("error scanning webhook log for export: %v", err)
+			return nil
+		}
+
+		if err := enc.Encode(l); err != nil {
+			a.log.Printf("error writing webhook log export: %v", err)
+			return nil
+		}
+
+		// Flush periodically so that the client receives rows as they are read.
+		if n++; n%100 == 0 {
+			c.Response().Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		a.log.Printf("error reading webhook logs for export: %v", err)
+	}
+
+	return nil
 }
 
 // DeleteWebhookLogs handles deletion of webhook logs.
@@ -90,24 +329,16 @@ func (a *App) DeleteWebhookLogs(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// logWebhook logs a webhook request to the database.
-func (a *App) logWebhook(webhookType, eventType string, headers http.Header, body []byte, status int, responseBody string, processed bool, errorMsg string) {
-	// Convert headers to JSONB
-	headersMap := make(map[string]string)
-	for key, values := range headers {
-		if len(values) > 0 {
-			headersMap[key] = values[0]
-		}
-	}
-
-	headersJSON, err := json.Marshal(headersMap)
-	if err != nil {
-		a.log.Printf("error marshalling webhook headers: %v", err)
-		headersJSON = []byte("{}")
-	}
+// logWebhook logs a webhook request to the database. If the request is a replay
+// of a stored webhook, the log entry is linked to the original one. If it belongs to
+// an inbox entry, the entry's log is updated with the outcome of processing it, which
+// the log of the request that queued the entry never overwrites.
+func (a *App) logWebhook(c echo.Context, webhookType, eventType string, body []byte, status int, responseBody string, processed bool, errorMsg string) {
+	headersJSON := webhookHeaders(c)
 
 	// Convert eventType and responseBody to null.String
-	var eventTypeNull, responseBodyNull, errorMsgNull interface{}
+	var eventTypeNull, responseBodyNull, errorMsgNull, replayOfNull, inboxIDNull interface{}
+	idx, _ := c.Get(ctxWebhookIndex).(webhookLogIndex)
 	if eventType != "" {
 		eventTypeNull = eventType
 	}
@@ -117,9 +348,17 @@ func (a *App) logWebhook(webhookType, eventType string, headers http.Header, bod
 	if errorMsg != "" {
 		errorMsgNull = errorMsg
 	}
+	if id, ok := c.Get(ctxWebhookReplay).(int64); ok {
+		replayOfNull = id
+	}
+	queued := false
+	if id, ok := c.Get(ctxWebhookInbox).(int64); ok {
+		inboxIDNull = id
+		queued = responseBody == webhookQueued
+	}
 
 	// Insert webhook log
-	_, err = a.queries.CreateWebhookLog.Exec(
+	_, err := a.queries.CreateWebhookLog.Exec(
 		webhookType,
 		eventTypeNull,
 		headersJSON,
@@ -128,9 +367,83 @@ func (a *App) logWebhook(webhookType, eventType string, headers http.Header, bod
 		responseBodyNull,
 		processed,
 		errorMsgNull,
+		replayOfNull,
+		idx.Email,
+		idx.CampaignID,
+		idx.CampaignUUID,
+		idx.MessageID,
+		inboxIDNull,
+		queued,
 	)
 
 	if err != nil {
 		a.log.Printf("error creating webhook log: %v", err)
 	}
 }
+
+// webhookHeaders returns the headers of a webhook request as a JSON map of the first
+// value of each header.
+func webhookHeaders(c echo.Context) json.RawMessage {
+	out := make(map[string]string)
+	for key, values := range c.Request().Header {
+		if len(values) > 0 {
+			out[key] = values[0]
+		}
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+
+	return b
+}
+
+// setWebhookLogIndex records the fields extracted from the webhook payload on the request
+// context for logWebhook() to store in the indexed columns of the log entry.
+func setWebhookLogIndex(c echo.Context, idx webhookLogIndex) {
+	c.Set(ctxWebhookIndex, idx)
+}
+
+// pruneWebhookLogs enforces the webhook log retention policies and deletes expired
+// webhook idempotency keys every $interval.
+func (a *App) pruneWebhookLogs(interval time.Duration) {
+	// Types that have their own policy are excluded from the default (empty type) policy.
+	types := make([]string, 0, len(a.cfg.WebhookLogRetention))
+	for _, r := range a.cfg.WebhookLogRetention {
+		if r.WebhookType != "" {
+			types = append(types, r.WebhookType)
+		}
+	}
+
+	fnPrune := func() {
+		for _, r := range a.cfg.WebhookLogRetention {
+			if r.Days == 0 && r.MaxRows == 0 {
+				continue
+			}
+
+			res, err := a.queries.PruneWebhookLogs.Exec(r.WebhookType, r.Days, r.MaxRows, pq.Array(types))
+			if err != nil {
+				a.log.Printf("error pruning webhook logs (%s): %v", r.WebhookType, err)
+				continue
+			}
+
+			if n, _ := res.RowsAffected(); n > 0 {
+				a.log.Printf("pruned %d webhook logs (%s)", n, r.WebhookType)
+			}
+		}
+
+		if _, err := a.queries.DeleteExpiredWebhookKeys.Exec(); err != nil {
+			a.log.Printf("error deleting expired webhook idempotency keys: %v", err)
+		}
+	}
+
+	fnPrune()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fnPrune()
+	}
+}
@@ -360,8 +360,102 @@ export const getCampaignAzureEngagementEvents = async (id, params) => http.get(
 );
 
 // Get Shopify purchase attribution stats for a campaign
-export const getCampaignPurchaseStats = async (id) => http.get(
+export const getCampaignPurchaseStats = async (id, model) => http.get(
   `/api/campaigns/${id}/purchases/stats`,
+  { params: model ? { model } : {}, loading: models.campaigns, camelCase: false },
+);
+
+// Shopify customer sync.
+export const getShopifyCustomerSync = async () => http.get('/api/shopify/customers/sync');
+
+export const syncShopifyCustomers = async () => http.post('/api/shopify/customers/sync');
+
+// Exchange rates for normalizing purchase revenue.
+export const getExchangeRates = async () => http.get('/api/exchange-rates');
+
+export const refreshExchangeRates = async () => http.post('/api/exchange-rates/refresh');
+
+// Get the products sold in the purchases attributed to a campaign
+export const getCampaignProductStats = async (id, params) => http.get(
+  `/api/campaigns/${id}/purchases/products`,
+  { params, loading: models.campaigns, camelCase: false },
+);
+
+export const getCampaignHoldoutStats = async (id, params) => http.get(
+  `/api/campaigns/${id}/purchases/holdout`,
+  { params, loading: models.campaigns, camelCase: false },
+);
+
+// Get the stats of the variants of a campaign's A/B test.
+export const getCampaignVariantStats = async (id) => http.get(
+  `/api/campaigns/${id}/variants/stats`,
+  { loading: models.campaigns, camelCase: false },
+);
+
+// Pick the winner of a campaign's A/B test and send it to the rest of the audience.
+export const sendCampaignWinner = async (id, variantID) => http.post(
+  `/api/campaigns/${id}/variants/winner`,
+  { variant_id: variantID },
+  { loading: models.campaigns },
+);
+
+// Resend a finished campaign to its recipients who haven't opened it.
+export const resendCampaign = async (id, data) => http.post(
+  `/api/campaigns/${id}/resend`,
+  data,
+  { loading: models.campaigns },
+);
+
+// Get the size of a campaign's audience with its exclusion lists and segment query.
+export const getCampaignAudience = async (id) => http.get(
+  `/api/campaigns/${id}/audience`,
+  { loading: models.campaigns, camelCase: false },
+);
+
+// Get the rolled up stats of a campaign and its resends to non-openers.
+export const getCampaignResendStats = async (id) => http.get(
+  `/api/campaigns/${id}/resends`,
+  { loading: models.campaigns, camelCase: false },
+);
+
+// Get the submissions, approvals, rejections and comments in a campaign's review.
+export const getCampaignReviews = async (id) => http.get(
+  `/api/campaigns/${id}/reviews`,
+  { camelCase: false },
+);
+
+// Comment on a campaign's review.
+export const commentCampaignReview = async (id, comment) => http.post(
+  `/api/campaigns/${id}/reviews`,
+  { comment },
+  { loading: models.campaigns },
+);
+
+// Run the pre-send checks on a campaign.
+export const checkCampaign = async (id, subscriberID) => http.post(
+  `/api/campaigns/${id}/check`,
+  { subscriber_id: subscriberID || 0 },
+  { camelCase: false },
+);
+
+// Submit a draft campaign for review.
+export const submitCampaignReview = async (id, comment) => http.post(
+  `/api/campaigns/${id}/submit`,
+  { comment },
+  { loading: models.campaigns },
+);
+
+// Approve a campaign in review.
+export const approveCampaign = async (id, comment) => http.post(
+  `/api/campaigns/${id}/approve`,
+  { comment },
+  { loading: models.campaigns },
+);
+
+// Reject a campaign in review, returning it to draft.
+export const rejectCampaign = async (id, comment) => http.post(
+  `/api/campaigns/${id}/reject`,
+  { comment },
   { loading: models.campaigns },
 );
 
@@ -520,6 +614,85 @@ export const deleteTemplate = async (id) => http.delete(
   { loading: models.templates },
 );
 
+// Versions of campaigns and templates. model is campaigns or templates.
+export const getVersions = async (model, id) => http.get(
+  `/api/${model}/${id}/versions`,
+  { camelCase: false },
+);
+
+export const diffVersions = async (model, id, from, to) => http.get(
+  `/api/${model}/${id}/versions/diff`,
+  { params: { from, to }, camelCase: false },
+);
+
+export const restoreVersion = async (model, id, version) => http.post(
+  `/api/${model}/${id}/versions/${version}/restore`,
+  {},
+  { loading: models[model] },
+);
+
+// Commerce flows.
+export const getCommerceFlows = async () => http.get('/api/commerce/flows');
+
+export const createCommerceFlow = async (data) => http.post('/api/commerce/flows', data);
+
+export const updateCommerceFlow = async (data) => http.put(`/api/commerce/flows/${data.id}`, data);
+
+export const deleteCommerceFlow = async (id) => http.delete(`/api/commerce/flows/${id}`);
+
+// Sequences.
+export const getSequences = async () => http.get(
+  '/api/sequences',
+  { camelCase: (keyPath) => !keyPath.startsWith('.*.steps.*.attribs.') },
+);
+
+export const createSequence = async (data) => http.post(
+  '/api/sequences',
+  data,
+  { camelCase: (keyPath) => !keyPath.startsWith('.steps.*.attribs.') },
+);
+
+export const updateSequence = async (data) => http.put(
+  `/api/sequences/${data.id}`,
+  data,
+  { camelCase: (keyPath) => !keyPath.startsWith('.steps.*.attribs.') },
+);
+
+export const deleteSequence = async (id) => http.delete(`/api/sequences/${id}`);
+
+// Event triggers.
+export const getEventTriggers = async () => http.get(
+  '/api/events/triggers',
+  { camelCase: (keyPath) => !keyPath.startsWith('.*.filters.') },
+);
+
+export const createEventTrigger = async (data) => http.post(
+  '/api/events/triggers',
+  data,
+  { camelCase: (keyPath) => !keyPath.startsWith('.filters.') },
+);
+
+export const updateEventTrigger = async (data) => http.put(
+  `/api/events/triggers/${data.id}`,
+  data,
+  { camelCase: (keyPath) => !keyPath.startsWith('.filters.') },
+);
+
+export const deleteEventTrigger = async (id) => http.delete(`/api/events/triggers/${id}`);
+
+// Recurring campaigns.
+export const getCampaignRecurrences = async () => http.get('/api/campaigns/recurring');
+
+export const getCampaignOccurrences = async (id) => http.get(`/api/campaigns/recurring/${id}/occurrences`);
+
+export const createCampaignRecurrence = async (data) => http.post('/api/campaigns/recurring', data);
+
+export const updateCampaignRecurrence = async (data) => http.put(`/api/campaigns/recurring/${data.id}`, data);
+
+export const deleteCampaignRecurrence = async (id) => http.delete(`/api/campaigns/recurring/${id}`);
+
+export const previewCampaignFeed = async (url) => http.post('/api/campaigns/recurring/feed', { url });
+
 // Settings.
 export const getServerConfig = async () => http.get(
   '/api/config',
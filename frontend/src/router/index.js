@@ -89,6 +89,30 @@ const routes = [
     meta: { title: 'analytics.title', group: 'campaigns' },
     component: () => import('../views/CampaignAnalytics.vue'),
   },
+  {
+    path: '/campaigns/flows',
+    name: 'flows',
+    meta: { title: 'flows.title', group: 'campaigns' },
+    component: () => import('../views/Flows.vue'),
+  },
+  {
+    path: '/campaigns/sequences',
+    name: 'sequences',
+    meta: { title: 'sequences.title', group: 'campaigns' },
+    component: () => import('../views/Sequences.vue'),
+  },
+  {
+    path: '/campaigns/triggers',
+    name: 'triggers',
+    meta: { title: 'triggers.title', group: 'campaigns' },
+    component: () => import('../views/Triggers.vue'),
+  },
+  {
+    path: '/campaigns/recurring',
+    name: 'recurringCampaigns',
+    meta: { title: 'recurring.title', group: 'campaigns' },
+    component: () => import('../views/RecurringCampaigns.vue'),
+  },
   {
     path: '/campaigns/queue',
     name: 'queue',
@@ -0,0 +1,146 @@
+// Package attribution implements multi-touch attribution models that distribute
+// the credit for a purchase across the campaigns a customer interacted with.
+package attribution
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Attribution models.
+const (
+	ModelLastClick  = "last_click"
+	ModelLastOpen   = "last_open"
+	ModelFirstTouch = "first_touch"
+	ModelLinear     = "linear"
+	ModelTimeDecay  = "time_decay"
+)
+
+// Touch types.
+const (
+	TouchClick    = "click"
+	TouchOpen     = "open"
+	TouchDelivery = "delivery"
+)
+
+// Models is the list of all attribution models.
+var Models = []string{ModelLastClick, ModelLastOpen, ModelFirstTouch, ModelLinear, ModelTimeDecay}
+
+// DefaultHalfLife is the half-life of the credit of a touch in the time-decay model.
+const DefaultHalfLife = time.Hour * 24 * 7
+
+// Touch is an interaction of a customer with a campaign before a purchase.
+type Touch struct {
+	CampaignID int       `db:"campaign_id"`
+	Type       string    `db:"type"`
+	At         time.Time `db:"created_at"`
+}
+
+// IsModel checks if the given name is a known attribution model.
+func IsModel(m string) bool {
+	for _, v := range Models {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// Weights returns the share of credit (0-1, summing up to 1) of each campaign for a
+// purchase made at the given time with the given model. Single-touch models fall back
+// to weaker signals in the order click, open, delivery when there are no touches of the
+// preferred type and multi-touch models consider engagements (clicks and opens) before
+// deliveries. Touches after the purchase are ignored. An empty map is returned if there
+// are no touches.
+func Weights(model string, touches []Touch, at time.Time, halfLife time.Duration) map[int]float64 {
+	var (
+		clicks     = filter(touches, at, TouchClick)
+		opens      = filter(touches, at, TouchOpen)
+		deliveries = filter(touches, at, TouchDelivery)
+		out        = map[int]float64{}
+	)
+
+	switch model {
+	case ModelLastOpen:
+		if t, ok := last(opens, clicks, deliveries); ok {
+			out[t.CampaignID] = 1
+		}
+
+	case ModelFirstTouch:
+		all := append(append(append([]Touch{}, clicks...), opens...), deliveries...)
+		if len(all) > 0 {
+			sortTouches(all)
+			out[all[0].CampaignID] = 1
+		}
+
+	case ModelLinear:
+		set := engagements(clicks, opens, deliveries)
+		for _, t := range set {
+			out[t.CampaignID] = 1
+		}
+		for id := range out {
+			out[id] = 1 / float64(len(out))
+		}
+
+	case ModelTimeDecay:
+		if halfLife <= 0 {
+			halfLife = DefaultHalfLife
+		}
+
+		var total float64
+		for _, t := range engagements(clicks, opens, deliveries) {
+			w := math.Pow(2, -float64(at.Sub(t.At))/float64(halfLife))
+			out[t.CampaignID] += w
+			total += w
+		}
+		for id := range out {
+			out[id] /= total
+		}
+
+	// Last click is the default.
+	default:
+		if t, ok := last(clicks, opens, deliveries); ok {
+			out[t.CampaignID] = 1
+		}
+	}
+
+	return out
+}
+
+// filter returns the touches of the given type that happened before the given time.
+func filter(touches []Touch, at time.Time, typ string) []Touch {
+	out := []Touch{}
+	for _, t := range touches {
+		if t.Type == typ && t.CampaignID > 0 && !t.At.After(at) {
+			out = append(out, t)
+		}
+	}
+	sortTouches(out)
+
+	return out
+}
+
+// last returns the latest touch from the first non-empty set.
+func last(sets ...[]Touch) (Touch, bool) {
+	for _, s := range sets {
+		if len(s) > 0 {
+			return s[len(s)-1], true
+		}
+	}
+	return Touch{}, false
+}
+
+// engagements returns clicks and opens, or deliveries if there are no engagements.
+func engagements(clicks, opens, deliveries []Touch) []Touch {
+	if out := append(append([]Touch{}, clicks...), opens...); len(out) > 0 {
+		return out
+	}
+	return deliveries
+}
+
+func sortTouches(t []Touch) {
+	sort.SliceStable(t, func(i, j int) bool {
+		return t[i].At.Before(t[j].At)
+	})
+}
@@ -0,0 +1,100 @@
+package attribution
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWeights(t *testing.T) {
+	var (
+		at  = time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+		day = time.Hour * 24
+	)
+
+	// Campaign 1 was delivered and opened a week before the purchase, campaign 2 was
+	// clicked two days before, campaign 3 was opened a day before, and campaign 4 was
+	// clicked after the purchase.
+	touches := []Touch{
+		{CampaignID: 3, Type: TouchOpen, At: at.Add(-day)},
+		{CampaignID: 1, Type: TouchDelivery, At: at.Add(-day * 8)},
+		{CampaignID: 1, Type: TouchOpen, At: at.Add(-day * 7)},
+		{CampaignID: 2, Type: TouchDelivery, At: at.Add(-day * 3)},
+		{CampaignID: 2, Type: TouchClick, At: at.Add(-day * 2)},
+		{CampaignID: 4, Type: TouchClick, At: at.Add(time.Hour)},
+	}
+
+	var (
+		deliveries = []Touch{
+			{CampaignID: 1, Type: TouchDelivery, At: at.Add(-day * 2)},
+			{CampaignID: 2, Type: TouchDelivery, At: at.Add(-day)},
+		}
+		opens = []Touch{
+			{CampaignID: 1, Type: TouchOpen, At: at.Add(-day * 2)},
+			{CampaignID: 2, Type: TouchDelivery, At: at.Add(-day)},
+		}
+	)
+
+	// Time-decay weights of touches 7, 2 and 1 days before the purchase with a 7 day half-life.
+	var (
+		w1    = math.Pow(2, -1)
+		w2    = math.Pow(2, -2.0/7)
+		w3    = math.Pow(2, -1.0/7)
+		total = w1 + w2 + w3
+	)
+
+	cases := []struct {
+		name     string
+		model    string
+		touches  []Touch
+		halfLife time.Duration
+		want     map[int]float64
+	}{
+		{"last click", ModelLastClick, touches, 0, map[int]float64{2: 1}},
+		{"unknown model is last click", "unknown", touches, 0, map[int]float64{2: 1}},
+		{"last click falls back to opens", ModelLastClick, opens, 0, map[int]float64{1: 1}},
+		{"last click falls back to deliveries", ModelLastClick, deliveries, 0, map[int]float64{2: 1}},
+		{"last open", ModelLastOpen, touches, 0, map[int]float64{3: 1}},
+		{"first touch", ModelFirstTouch, touches, 0, map[int]float64{1: 1}},
+		{"linear", ModelLinear, touches, 0, map[int]float64{1: 1.0 / 3, 2: 1.0 / 3, 3: 1.0 / 3}},
+		{"linear falls back to deliveries", ModelLinear, deliveries, 0, map[int]float64{1: 0.5, 2: 0.5}},
+		{"time decay", ModelTimeDecay, touches, DefaultHalfLife, map[int]float64{1: w1 / total, 2: w2 / total, 3: w3 / total}},
+		{"time decay default half-life", ModelTimeDecay, touches, 0, map[int]float64{1: w1 / total, 2: w2 / total, 3: w3 / total}},
+		{"time decay with a day half-life", ModelTimeDecay, deliveries, day,
+			map[int]float64{1: 0.25 / 0.75, 2: 0.5 / 0.75}},
+		{"no touches", ModelLinear, nil, 0, map[int]float64{}},
+		{"touches after the purchase", ModelLastClick, []Touch{{CampaignID: 4, Type: TouchClick, At: at.Add(time.Hour)}}, 0, map[int]float64{}},
+		{"touches without a campaign", ModelFirstTouch, []Touch{{CampaignID: 0, Type: TouchClick, At: at.Add(-day)}}, 0, map[int]float64{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Weights(c.model, c.touches, at, c.halfLife)
+			if len(got) != len(c.want) {
+				t.Fatalf("got %v, want %v", got, c.want)
+			}
+
+			sum := 0.0
+			for id, w := range c.want {
+				if math.Abs(got[id]-w) > 1e-9 {
+					t.Errorf("campaign %d: got %v, want %v", id, got[id], w)
+				}
+				sum += got[id]
+			}
+			if len(got) > 0 && math.Abs(sum-1) > 1e-9 {
+				t.Errorf("weights sum up to %v, want 1", sum)
+			}
+		})
+	}
+}
+
+func TestIsModel(t *testing.T) {
+	for _, m := range Models {
+		if !IsModel(m) {
+			t.Errorf("%s: expected a model", m)
+		}
+	}
+	if IsModel("utm_campaign") {
+		t.Error("utm_campaign: unexpected model")
+	}
+}
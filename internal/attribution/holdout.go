@@ -0,0 +1,133 @@
+package attribution
+
+import (
+	"math"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Z95 is the z-score of a two-sided 95% confidence interval.
+const Z95 = 1.959964
+
+// Group is the purchase outcome of the recipients or the holdout (control) group of a
+// campaign. RevenueSq is the sum of the squares of the revenue per subscriber.
+type Group struct {
+	Holdout     bool    `db:"holdout" json:"-"`
+	Subscribers int     `db:"subscribers" json:"subscribers"`
+	Purchasers  int     `db:"purchasers" json:"purchasers"`
+	Orders      int     `db:"orders" json:"orders"`
+	Revenue     float64 `db:"revenue" json:"revenue"`
+	RevenueSq   float64 `db:"revenue_sq" json:"-"`
+
+	PurchaseRate         float64 `db:"-" json:"purchase_rate"`
+	RevenuePerSubscriber float64 `db:"-" json:"revenue_per_subscriber"`
+}
+
+// Interval is an estimate with its confidence interval.
+type Interval struct {
+	Estimate float64 `json:"estimate"`
+	Low      float64 `json:"low"`
+	High     float64 `json:"high"`
+}
+
+// Significant checks if the interval excludes 0.
+func (i Interval) Significant() bool {
+	return i.Low > 0 || i.High < 0
+}
+
+// Incrementality is the difference in the purchases of the recipients and the holdout
+// group of a campaign, that is, the purchases caused by the campaign.
+type Incrementality struct {
+	Recipients Group `json:"recipients"`
+	Holdout    Group `json:"holdout"`
+
+	// PurchaseRateLift is the difference in the purchase rates (recipients - holdout).
+	// RelativeLift is the lift relative to the purchase rate of the holdout, which is
+	// null if no one in the holdout purchased.
+	PurchaseRateLift Interval     `json:"purchase_rate_lift"`
+	RelativeLift     null.Float64 `json:"relative_lift"`
+
+	// RevenuePerRecipientLift is the difference in the revenue per subscriber, and
+	// IncrementalRevenue and IncrementalPurchasers are the lifts scaled to the recipients.
+	RevenuePerRecipientLift Interval `json:"revenue_per_recipient_lift"`
+	IncrementalRevenue      Interval `json:"incremental_revenue"`
+	IncrementalPurchasers   Interval `json:"incremental_purchasers"`
+
+	// Significant is true if the purchase rate or revenue lift is statistically
+	// significant at the confidence level.
+	Significant bool `json:"significant"`
+}
+
+// Compare compares the recipients (treatment) and the holdout (control) group of a campaign.
+// Confidence intervals use the normal approximation of the difference of two proportions
+// (purchase rate) and of two means (revenue) with the given z-score, eg: Z95.
+func Compare(recipients, holdout Group, z float64) Incrementality {
+	recipients.PurchaseRate, recipients.RevenuePerSubscriber = rate(recipients)
+	holdout.PurchaseRate, holdout.RevenuePerSubscriber = rate(holdout)
+
+	out := Incrementality{
+		Recipients: recipients,
+		Holdout:    holdout,
+	}
+	if recipients.Subscribers == 0 || holdout.Subscribers == 0 {
+		return out
+	}
+
+	var (
+		n1 = float64(recipients.Subscribers)
+		n0 = float64(holdout.Subscribers)
+		p1 = recipients.PurchaseRate
+		p0 = holdout.PurchaseRate
+	)
+
+	// Purchase rate.
+	se := math.Sqrt(p1*(1-p1)/n1 + p0*(1-p0)/n0)
+	out.PurchaseRateLift = interval(p1-p0, se, z)
+	if p0 > 0 {
+		out.RelativeLift = null.Float64From(round((p1 - p0) / p0))
+	}
+
+	// Revenue per subscriber.
+	se = math.Sqrt(variance(recipients)/n1 + variance(holdout)/n0)
+	out.RevenuePerRecipientLift = interval(recipients.RevenuePerSubscriber-holdout.RevenuePerSubscriber, se, z)
+
+	out.IncrementalRevenue = scale(out.RevenuePerRecipientLift, n1)
+	out.IncrementalPurchasers = scale(out.PurchaseRateLift, n1)
+	out.Significant = out.PurchaseRateLift.Significant() || out.RevenuePerRecipientLift.Significant()
+
+	return out
+}
+
+// rate returns the purchase rate and revenue per subscriber of a group.
+func rate(g Group) (float64, float64) {
+	if g.Subscribers == 0 {
+		return 0, 0
+	}
+
+	n := float64(g.Subscribers)
+	return float64(g.Purchasers) / n, g.Revenue / n
+}
+
+// variance returns the sample variance of the revenue per subscriber of a group.
+func variance(g Group) float64 {
+	if g.Subscribers < 2 {
+		return 0
+	}
+
+	n := float64(g.Subscribers)
+	mean := g.Revenue / n
+	return math.Max(0, (g.RevenueSq-n*mean*mean)/(n-1))
+}
+
+func interval(est, se, z float64) Interval {
+	return Interval{Estimate: round(est), Low: round(est - z*se), High: round(est + z*se)}
+}
+
+func scale(i Interval, n float64) Interval {
+	return Interval{Estimate: round(i.Estimate * n), Low: round(i.Low * n), High: round(i.High * n)}
+}
+
+// round rounds a value to 6 decimal places.
+func round(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
@@ -0,0 +1,90 @@
+package attribution
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		name        string
+		recipients  Group
+		holdout     Group
+		lift        Interval
+		relative    float64
+		revenueLift Interval
+		incRevenue  Interval
+		incBuyers   Interval
+		significant bool
+	}{
+		{
+			// 5% of the recipients and 2% of the holdout bought for 100 each.
+			name:        "significant lift",
+			recipients:  Group{Subscribers: 1000, Purchasers: 50, Orders: 50, Revenue: 5000, RevenueSq: 50 * 100 * 100},
+			holdout:     Group{Holdout: true, Subscribers: 1000, Purchasers: 20, Orders: 20, Revenue: 2000, RevenueSq: 20 * 100 * 100},
+			lift:        Interval{Estimate: 0.03, Low: 0.013945, High: 0.046055},
+			relative:    1.5,
+			revenueLift: Interval{Estimate: 3, Low: 1.3937, High: 4.6063},
+			incRevenue:  Interval{Estimate: 3000, Low: 1393.7, High: 4606.3},
+			incBuyers:   Interval{Estimate: 30, Low: 13.945, High: 46.055},
+			significant: true,
+		},
+		{
+			// The same purchase rate in both groups.
+			name:        "no lift",
+			recipients:  Group{Subscribers: 100, Purchasers: 10, Orders: 10, Revenue: 1000, RevenueSq: 10 * 100 * 100},
+			holdout:     Group{Holdout: true, Subscribers: 100, Purchasers: 10, Orders: 10, Revenue: 1000, RevenueSq: 10 * 100 * 100},
+			relative:    0,
+			significant: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			out := Compare(c.recipients, c.holdout, Z95)
+
+			if c.lift != (Interval{}) && out.PurchaseRateLift != c.lift {
+				t.Errorf("purchase rate lift: got %+v, want %+v", out.PurchaseRateLift, c.lift)
+			}
+			if out.PurchaseRateLift.Estimate != c.lift.Estimate {
+				t.Errorf("purchase rate lift estimate: got %v, want %v", out.PurchaseRateLift.Estimate, c.lift.Estimate)
+			}
+			if !out.RelativeLift.Valid || math.Abs(out.RelativeLift.Float64-c.relative) > 1e-9 {
+				t.Errorf("relative lift: got %+v, want %v", out.RelativeLift, c.relative)
+			}
+			if c.revenueLift != (Interval{}) && out.RevenuePerRecipientLift != c.revenueLift {
+				t.Errorf("revenue lift: got %+v, want %+v", out.RevenuePerRecipientLift, c.revenueLift)
+			}
+			if c.incRevenue != (Interval{}) && out.IncrementalRevenue != c.incRevenue {
+				t.Errorf("incremental revenue: got %+v, want %+v", out.IncrementalRevenue, c.incRevenue)
+			}
+			if c.incBuyers != (Interval{}) && out.IncrementalPurchasers != c.incBuyers {
+				t.Errorf("incremental purchasers: got %+v, want %+v", out.IncrementalPurchasers, c.incBuyers)
+			}
+			if out.Significant != c.significant {
+				t.Errorf("significant: got %v, want %v", out.Significant, c.significant)
+			}
+		})
+	}
+}
+
+func TestCompareEmptyGroups(t *testing.T) {
+	// Without a holdout group, only the rates of the recipients are reported.
+	out := Compare(Group{Subscribers: 200, Purchasers: 10, Revenue: 500}, Group{Holdout: true}, Z95)
+	if out.Recipients.PurchaseRate != 0.05 || out.Recipients.RevenuePerSubscriber != 2.5 {
+		t.Errorf("unexpected recipient rates: %+v", out.Recipients)
+	}
+	if out.PurchaseRateLift != (Interval{}) || out.RelativeLift.Valid || out.Significant {
+		t.Errorf("unexpected lift without a holdout: %+v", out)
+	}
+
+	// No one in the holdout purchased.
+	out = Compare(Group{Subscribers: 100, Purchasers: 5, Revenue: 50, RevenueSq: 5 * 10 * 10},
+		Group{Holdout: true, Subscribers: 100}, Z95)
+	if out.RelativeLift.Valid {
+		t.Errorf("relative lift: got %v, want null", out.RelativeLift.Float64)
+	}
+	if out.PurchaseRateLift.Estimate != 0.05 {
+		t.Errorf("purchase rate lift: got %v, want 0.05", out.PurchaseRateLift.Estimate)
+	}
+}
@@ -57,9 +57,18 @@ const (
 	PermCampaignsGetAnalytics = "campaigns:get_analytics"
 	PermCampaignsManage       = "campaigns:manage"
 	PermCampaignsManageAll    = "campaigns:manage_all"
+	PermCampaignsApprove      = "campaigns:approve"
 	PermBouncesGet            = "bounces:get"
 	PermBouncesManage         = "bounces:manage"
 	PermWebhooksPostBounce    = "webhooks:post_bounce"
+	PermOrdersPost            = "orders:post"
+	PermFlowsGet              = "flows:get"
+	PermFlowsManage           = "flows:manage"
+	PermSequencesGet          = "sequences:get"
+	PermSequencesManage       = "sequences:manage"
+	PermEventsTrack           = "events:track"
+	PermTriggersGet           = "triggers:get"
+	PermTriggersManage        = "triggers:manage"
 	PermMediaGet              = "media:get"
 	PermMediaManage           = "media:manage"
 	PermTemplatesGet          = "templates:get"
@@ -63,6 +63,12 @@ func (p *Forwardemail) ProcessBounce(sigHex string, body []byte) ([]models.Bounc
 		return nil, errors.New("invalid signature")
 	}
 
+	return p.ParseBounce(body)
+}
+
+// ParseBounce parses a Forwardemail bounce notification without verifying the signature.
+// It is meant for re-processing stored payloads that were verified on receipt.
+func (p *Forwardemail) ParseBounce(body []byte) ([]models.Bounce, error) {
 	// Parse the JSON payload
 	var n forwardemailNotif
 	if err := json.Unmarshal(body, &n); err != nil {
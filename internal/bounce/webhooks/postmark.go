@@ -55,6 +55,12 @@ func (p *Postmark) ProcessBounce(b []byte, c echo.Context) ([]models.Bounce, err
 		return nil, err
 	}
 
+	return p.ParseBounce(b)
+}
+
+// ParseBounce parses a Postmark bounce notification without authenticating the request.
+// It is meant for re-processing stored payloads that were authenticated on receipt.
+func (p *Postmark) ParseBounce(b []byte) ([]models.Bounce, error) {
 	var n postmarkNotif
 	if err := json.Unmarshal(b, &n); err != nil {
 		return nil, fmt.Errorf("error unmarshalling postmark notification: %v", err)
@@ -55,6 +55,12 @@ func (s *Sendgrid) ProcessBounce(sig, timestamp string, b []byte) ([]models.Boun
 		return nil, err
 	}
 
+	return s.ParseBounce(b)
+}
+
+// ParseBounce parses Sendgrid bounce notifications without verifying the signature.
+// It is meant for re-processing stored payloads that were verified on receipt.
+func (s *Sendgrid) ParseBounce(b []byte) ([]models.Bounce, error) {
 	var notifs []sendgridNotif
 	if err := json.Unmarshal(b, &notifs); err != nil {
 		return nil, fmt.Errorf("error unmarshalling Sendgrid notification: %v", err)
@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/knadh/listmonk/models"
@@ -24,6 +25,18 @@ import (
 // https://sns.ap-southeast-1.amazonaws.com/SimpleNotificationService-010a507c1833636cd94bdb98bd93083a.pem
 var sesRegCertURL = regexp.MustCompile(`(?i)^https://sns\.[a-z0-9\-]+\.amazonaws\.com(\.cn)?/SimpleNotificationService\-[a-z0-9]+\.pem$`)
 
+// SES event types returned by ProcessNotification.
+const (
+	SESEventBounce           = "Bounce"
+	SESEventComplaint        = "Complaint"
+	SESEventDelivery         = "Delivery"
+	SESEventRenderingFailure = "Rendering Failure"
+)
+
+// sesFeedbackNotSpam is the complaint feedback type sent by mailbox providers
+// when a recipient explicitly marks a message as not being spam.
+const sesFeedbackNotSpam = "not-spam"
+
 // sesNotif is an individual notification wrapper posted by SNS.
 type sesNotif struct {
 	// Message may be a plaintext message or a stringified JSON payload based on the message type.
@@ -50,28 +63,68 @@ type sesMail struct {
 	NotifType string `json:"notificationType"`
 	Bounce    struct {
 		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
 		BouncedRecipients []struct {
-			Status string `json:"status"`
+			EmailAddress   string `json:"emailAddress"`
+			Status         string `json:"status"`
+			DiagnosticCode string `json:"diagnosticCode"`
 		} `json:"bouncedRecipients"`
 	} `json:"bounce"`
+	Complaint struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+		Timestamp sesTimestamp `json:"timestamp"`
+	} `json:"complaint"`
+	Delivery struct {
+		Recipients   []string     `json:"recipients"`
+		SMTPResponse string       `json:"smtpResponse"`
+		Timestamp    sesTimestamp `json:"timestamp"`
+	} `json:"delivery"`
+	Failure struct {
+		ErrorMessage string `json:"errorMessage"`
+		TemplateName string `json:"templateName"`
+	} `json:"failure"`
 	Mail struct {
 		Timestamp        sesTimestamp        `json:"timestamp"`
+		MessageID        string              `json:"messageId"`
 		HeadersTruncated bool                `json:"headersTruncated"`
 		Destination      []string            `json:"destination"`
 		Headers          []map[string]string `json:"headers"`
 	} `json:"mail"`
 }
 
+// SESEvent represents a single parsed SES notification. Bounce is set only
+// for events that have to be recorded as bounces (bounces and complaints).
+type SESEvent struct {
+	Type           string          `json:"type"`
+	MessageID      string          `json:"message_id"`
+	Email          string          `json:"email"`
+	CampaignUUID   string          `json:"campaign_uuid"`
+	SubscriberUUID string          `json:"subscriber_uuid"`
+	FeedbackType   string          `json:"feedback_type"`
+	Reason         string          `json:"reason"`
+	Meta           json.RawMessage `json:"meta"`
+	Timestamp      time.Time       `json:"timestamp"`
+
+	Bounce *models.Bounce `json:"-"`
+}
+
 // SES handles SES/SNS webhook notifications including confirming SNS topic subscription
 // requests and bounce notifications.
 type SES struct {
 	certs map[string]*x509.Certificate
+	mu    sync.RWMutex
+
+	client *http.Client
 }
 
 // NewSES returns a new SES instance.
 func NewSES() *SES {
 	return &SES{
-		certs: make(map[string]*x509.Certificate),
+		certs:  make(map[string]*x509.Certificate),
+		client: &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
@@ -92,10 +145,11 @@ func (s *SES) ProcessSubscription(b []byte) error {
 		u = n.UnsubscribeURL
 	}
 
-	resp, err := http.Get(u)
+	resp, err := s.client.Get(u)
 	if err != nil {
 		return fmt.Errorf("error requesting subscription URL: %v", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("non 200 response on subscription URL: %v", resp.StatusCode)
@@ -106,70 +160,155 @@ func (s *SES) ProcessSubscription(b []byte) error {
 
 // ProcessBounce processes an SES bounce notification and returns a Bounce object.
 func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
-	var (
-		bounce models.Bounce
-		n      sesNotif
-	)
+	ev, err := s.ProcessNotification(b)
+	if err != nil {
+		return models.Bounce{}, err
+	}
+	if ev.Bounce == nil {
+		return models.Bounce{}, errors.New("notification type is not bounce")
+	}
+
+	return *ev.Bounce, nil
+}
+
+// ProcessNotification processes an SES notification (bounce, complaint, delivery
+// or rendering failure) posted either as a classic SES notification or as an
+// SES event publishing event and returns the parsed event.
+func (s *SES) ProcessNotification(b []byte) (SESEvent, error) {
+	return s.processNotification(b, true)
+}
+
+// ParseNotification parses an SES notification without verifying its signature.
+// It is meant for re-processing stored payloads that were verified on receipt.
+func (s *SES) ParseNotification(b []byte) (SESEvent, error) {
+	return s.processNotification(b, false)
+}
+
+func (s *SES) processNotification(b []byte, verify bool) (SESEvent, error) {
+	var n sesNotif
 	if err := json.Unmarshal(b, &n); err != nil {
-		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
+		return SESEvent{}, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
-	if err := s.verifyNotif(n); err != nil {
-		return bounce, err
+	if verify {
+		if err := s.verifyNotif(n); err != nil {
+			return SESEvent{}, err
+		}
 	}
 
 	var m sesMail
 	if err := json.Unmarshal([]byte(n.Message), &m); err != nil {
-		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
-	}
-
-	if (m.EventType != "" && m.EventType != "Bounce") ||
-		(m.NotifType != "" && (m.NotifType != "Bounce" && m.NotifType != "Complaint")) {
-		return bounce, errors.New("notification type is not bounce")
+		return SESEvent{}, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
 
-	if len(m.Mail.Destination) == 0 {
-		return bounce, errors.New("no destination e-mails found in SES notification")
+	// Notifications have notificationType and event publishing events have eventType.
+	typ := m.NotifType
+	if m.EventType != "" {
+		typ = m.EventType
 	}
 
-	typ := models.BounceTypeSoft
-	if m.Bounce.BounceType == "Permanent" {
-		typ = models.BounceTypeHard
+	ev := SESEvent{
+		Type:      typ,
+		MessageID: m.Mail.MessageID,
+		Meta:      json.RawMessage(n.Message),
+		Timestamp: time.Time(m.Mail.Timestamp),
 	}
-	if m.Bounce.BounceType == "Transient" && len(m.Bounce.BouncedRecipients) > 0 {
-		// "Invalid domain" bounce.
-		if m.Bounce.BouncedRecipients[0].Status == "5.4.4" {
-			typ = models.BounceTypeHard
-		}
-	}
-	if m.NotifType == "Complaint" {
-		typ = models.BounceTypeComplaint
+	if len(m.Mail.Destination) > 0 {
+		ev.Email = strings.ToLower(m.Mail.Destination[0])
 	}
 
-	// Look for the campaign ID in headers.
-	campUUID := ""
+	// Look for the campaign and subscriber UUIDs in headers.
 	if !m.Mail.HeadersTruncated {
 		for _, h := range m.Mail.Headers {
-			key, ok := h["name"]
-			if !ok || key != models.EmailHeaderCampaignUUID {
+			val, ok := h["value"]
+			if !ok {
 				continue
 			}
 
-			campUUID, ok = h["value"]
-			if !ok {
-				continue
+			switch h["name"] {
+			case models.EmailHeaderCampaignUUID:
+				ev.CampaignUUID = val
+			case models.EmailHeaderSubscriberUUID:
+				ev.SubscriberUUID = val
 			}
-			break
 		}
 	}
 
-	return models.Bounce{
-		Email:        strings.ToLower(m.Mail.Destination[0]),
-		CampaignUUID: campUUID,
+	switch typ {
+	case SESEventBounce:
+		bType := models.BounceTypeSoft
+		if m.Bounce.BounceType == "Permanent" {
+			bType = models.BounceTypeHard
+		}
+		if len(m.Bounce.BouncedRecipients) > 0 {
+			r := m.Bounce.BouncedRecipients[0]
+			if r.EmailAddress != "" {
+				ev.Email = strings.ToLower(r.EmailAddress)
+			}
+			ev.Reason = r.DiagnosticCode
+
+			// "Invalid domain" bounce.
+			if m.Bounce.BounceType == "Transient" && r.Status == "5.4.4" {
+				bType = models.BounceTypeHard
+			}
+		}
+		if ev.Reason == "" {
+			ev.Reason = strings.TrimSpace(m.Bounce.BounceType + " " + m.Bounce.BounceSubType)
+		}
+
+		ev.Bounce = s.makeBounce(ev, bType)
+
+	case SESEventComplaint:
+		if len(m.Complaint.ComplainedRecipients) > 0 && m.Complaint.ComplainedRecipients[0].EmailAddress != "" {
+			ev.Email = strings.ToLower(m.Complaint.ComplainedRecipients[0].EmailAddress)
+		}
+		if !time.Time(m.Complaint.Timestamp).IsZero() {
+			ev.Timestamp = time.Time(m.Complaint.Timestamp)
+		}
+		ev.FeedbackType = m.Complaint.ComplaintFeedbackType
+		ev.Reason = m.Complaint.ComplaintFeedbackType
+
+		// A "not-spam" feedback report is the opposite of a complaint and is only
+		// recorded as an event.
+		if ev.FeedbackType != sesFeedbackNotSpam {
+			ev.Bounce = s.makeBounce(ev, models.BounceTypeComplaint)
+		}
+
+	case SESEventDelivery:
+		if len(m.Delivery.Recipients) > 0 {
+			ev.Email = strings.ToLower(m.Delivery.Recipients[0])
+		}
+		if !time.Time(m.Delivery.Timestamp).IsZero() {
+			ev.Timestamp = time.Time(m.Delivery.Timestamp)
+		}
+		ev.Reason = m.Delivery.SMTPResponse
+
+	case SESEventRenderingFailure:
+		ev.Reason = m.Failure.ErrorMessage
+		if m.Failure.TemplateName != "" {
+			ev.Reason = m.Failure.TemplateName + ": " + m.Failure.ErrorMessage
+		}
+
+	default:
+		return ev, fmt.Errorf("unsupported SES notification type: %s", typ)
+	}
+
+	if ev.Email == "" && typ != SESEventRenderingFailure {
+		return ev, errors.New("no destination e-mails found in SES notification")
+	}
+
+	return ev, nil
+}
+
+// makeBounce returns a bounce record of the given type for an SES event.
+func (s *SES) makeBounce(ev SESEvent, typ string) *models.Bounce {
+	return &models.Bounce{
+		Email:        ev.Email,
+		CampaignUUID: ev.CampaignUUID,
 		Type:         typ,
 		Source:       "ses",
-		Meta:         json.RawMessage(n.Message),
-		CreatedAt:    time.Time(m.Mail.Timestamp),
-	}, nil
+		Meta:         ev.Meta,
+		CreatedAt:    ev.Timestamp,
+	}
 }
 
 func (s *SES) buildSignature(n sesNotif) []byte {
@@ -208,28 +347,37 @@ func (s *SES) verifyNotif(n sesNotif) error {
 		return err
 	}
 
-	return cert.CheckSignature(x509.SHA1WithRSA, s.buildSignature(n), sign)
+	// SignatureVersion 2 uses SHA256 and 1 (default) uses SHA1.
+	algo := x509.SHA1WithRSA
+	if n.SignatureVersion == "2" {
+		algo = x509.SHA256WithRSA
+	}
+
+	return cert.CheckSignature(algo, s.buildSignature(n), sign)
 }
 
 // getCert takes the SNS certificate URL and fetches it and caches it for the first time,
-// and returns the cached cert for subsequent calls.
+// and returns the cached cert for subsequent calls. Expired certs are re-fetched.
 func (s *SES) getCert(certURL string) (*x509.Certificate, error) {
 	// Ensure that the cert URL is Amazon's.
 	u, err := url.Parse(certURL)
 	if err != nil {
 		return nil, err
 	}
-	if !sesRegCertURL.MatchString(certURL) {
+	if u.Scheme != "https" || !sesRegCertURL.MatchString(certURL) {
 		return nil, fmt.Errorf("invalid SNS certificate URL: %v", u.Host)
 	}
 
 	// Return if it's cached.
-	if c, ok := s.certs[u.Path]; ok {
+	s.mu.RLock()
+	c, ok := s.certs[certURL]
+	s.mu.RUnlock()
+	if ok && time.Now().Before(c.NotAfter) {
 		return c, nil
 	}
 
 	// Fetch the certificate.
-	resp, err := http.Get(certURL)
+	resp, err := s.client.Get(certURL)
 	if err != nil {
 		return nil, err
 	}
@@ -239,7 +387,7 @@ func (s *SES) getCert(certURL string) (*x509.Certificate, error) {
 		return nil, fmt.Errorf("invalid SNS certificate URL: %v", u.Host)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
 	if err != nil {
 		return nil, err
 	}
@@ -250,11 +398,21 @@ func (s *SES) getCert(certURL string) (*x509.Certificate, error) {
 	}
 
 	cert, err := x509.ParseCertificate(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, errors.New("SNS certificate is not valid at this time")
+	}
 
 	// Cache the cert in-memory.
-	s.certs[u.Path] = cert
+	s.mu.Lock()
+	s.certs[certURL] = cert
+	s.mu.Unlock()
 
-	return cert, err
+	return cert, nil
 }
 
 func (st *sesTimestamp) UnmarshalJSON(b []byte) error {
@@ -0,0 +1,338 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const testCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-0000000000000000000000000000000a.pem"
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// testSigner signs SNS notifications with a self-signed certificate that's
+// served at testCertURL by the SES client's transport.
+type testSigner struct {
+	key     *rsa.PrivateKey
+	certPEM []byte
+	fetches atomic.Int32
+}
+
+func newTestSigner(t *testing.T, notAfter time.Time) *testSigner {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSigner{
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// newSES returns an SES instance whose HTTP client serves the signer's certificate.
+func (ts *testSigner) newSES() *SES {
+	s := NewSES()
+	s.client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() != testCertURL {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		}
+
+		ts.fetches.Add(1)
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(ts.certPEM))}, nil
+	})}
+
+	return s
+}
+
+// notif wraps an SES message in a signed SNS notification.
+func (ts *testSigner) notif(t *testing.T, msg []byte) []byte {
+	t.Helper()
+
+	n := sesNotif{
+		Type:             "Notification",
+		MessageId:        "6a2e1b0c-3d4f-5a6b-7c8d-9e0f1a2b3c4d",
+		TopicArn:         "arn:aws:sns:us-east-1:123456789012:listmonk",
+		Message:          string(msg),
+		Timestamp:        "2024-03-12T09:15:05.000Z",
+		SignatureVersion: "2",
+		SigningCertURL:   testCertURL,
+	}
+
+	h := sha256.Sum256((&SES{}).buildSignature(n))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Signature = base64.StdEncoding.EncodeToString(sig)
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", "ses", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestSESProcessNotification(t *testing.T) {
+	const (
+		campUUID  = "5f2c3a7e-5d3b-4b8e-9c1a-2f6e8d4b1a90"
+		subUUID   = "9b1d4e2a-7c6f-4a3b-8e5d-1f0a2b3c4d5e"
+		messageID = "0100018e316e4f7b-8d2a6c1e-7b8f-4a77-a1a3-0c1c9a6b3e52-000000"
+	)
+
+	cases := []struct {
+		fixture    string
+		typ        string
+		email      string
+		campUUID   string
+		subUUID    string
+		feedback   string
+		reason     string
+		timestamp  string
+		bounceType string
+	}{
+		{
+			fixture:    "bounce.json",
+			typ:        SESEventBounce,
+			email:      "recipient@example.com",
+			campUUID:   campUUID,
+			reason:     "smtp; 550 5.1.1 user unknown",
+			timestamp:  "2024-03-12T09:10:00.123Z",
+			bounceType: models.BounceTypeHard,
+		},
+		{
+			fixture:    "complaint.json",
+			typ:        SESEventComplaint,
+			email:      "recipient@example.com",
+			campUUID:   campUUID,
+			subUUID:    subUUID,
+			feedback:   "abuse",
+			reason:     "abuse",
+			timestamp:  "2024-03-12T09:15:04.000Z",
+			bounceType: models.BounceTypeComplaint,
+		},
+		{
+			// not-spam feedback is recorded as an event and not as a complaint bounce.
+			fixture:   "complaint_not_spam.json",
+			typ:       SESEventComplaint,
+			email:     "recipient@example.com",
+			campUUID:  campUUID,
+			feedback:  "not-spam",
+			reason:    "not-spam",
+			timestamp: "2024-03-12T11:02:41.000Z",
+		},
+		{
+			// Truncated headers aren't looked up for UUIDs.
+			fixture:   "delivery.json",
+			typ:       SESEventDelivery,
+			email:     "recipient@example.com",
+			reason:    "250 2.6.0 Message received",
+			timestamp: "2024-03-12T09:10:01.456Z",
+		},
+		{
+			fixture:   "rendering_failure.json",
+			typ:       SESEventRenderingFailure,
+			email:     "recipient@example.com",
+			reason:    "welcome: Attribute 'name' is not present in the rendering data.",
+			timestamp: "2024-03-12T09:10:00.123Z",
+		},
+	}
+
+	ts := newTestSigner(t, time.Now().Add(time.Hour))
+	s := ts.newSES()
+
+	for _, c := range cases {
+		t.Run(c.fixture, func(t *testing.T) {
+			ev, err := s.ProcessNotification(ts.notif(t, readFixture(t, c.fixture)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if ev.Type != c.typ {
+				t.Errorf("type: got %q, want %q", ev.Type, c.typ)
+			}
+			if ev.MessageID != messageID {
+				t.Errorf("message ID: got %q, want %q", ev.MessageID, messageID)
+			}
+			if ev.Email != c.email {
+				t.Errorf("email: got %q, want %q", ev.Email, c.email)
+			}
+			if ev.CampaignUUID != c.campUUID {
+				t.Errorf("campaign UUID: got %q, want %q", ev.CampaignUUID, c.campUUID)
+			}
+			if ev.SubscriberUUID != c.subUUID {
+				t.Errorf("subscriber UUID: got %q, want %q", ev.SubscriberUUID, c.subUUID)
+			}
+			if ev.FeedbackType != c.feedback {
+				t.Errorf("feedback type: got %q, want %q", ev.FeedbackType, c.feedback)
+			}
+			if ev.Reason != c.reason {
+				t.Errorf("reason: got %q, want %q", ev.Reason, c.reason)
+			}
+			if ts := ev.Timestamp.Format("2006-01-02T15:04:05.000Z"); ts != c.timestamp {
+				t.Errorf("timestamp: got %q, want %q", ts, c.timestamp)
+			}
+
+			if c.bounceType == "" {
+				if ev.Bounce != nil {
+					t.Errorf("unexpected bounce: %+v", ev.Bounce)
+				}
+				return
+			}
+			if ev.Bounce == nil {
+				t.Fatal("expected a bounce")
+			}
+			if ev.Bounce.Type != c.bounceType || ev.Bounce.Email != c.email ||
+				ev.Bounce.CampaignUUID != c.campUUID || ev.Bounce.Source != "ses" {
+				t.Errorf("unexpected bounce: %+v", ev.Bounce)
+			}
+		})
+	}
+
+	// ProcessBounce only accepts notifications that are recorded as bounces.
+	if _, err := s.ProcessBounce(ts.notif(t, readFixture(t, "complaint.json"))); err != nil {
+		t.Errorf("complaint: unexpected error: %v", err)
+	}
+	for _, f := range []string{"complaint_not_spam.json", "delivery.json", "rendering_failure.json"} {
+		if _, err := s.ProcessBounce(ts.notif(t, readFixture(t, f))); err == nil {
+			t.Errorf("%s: expected an error", f)
+		}
+	}
+}
+
+func TestSESVerifyNotif(t *testing.T) {
+	ts := newTestSigner(t, time.Now().Add(time.Hour))
+	s := ts.newSES()
+
+	b := ts.notif(t, readFixture(t, "delivery.json"))
+
+	// Tampered message.
+	var n sesNotif
+	if err := json.Unmarshal(b, &n); err != nil {
+		t.Fatal(err)
+	}
+	n.Message = string(readFixture(t, "complaint.json"))
+	tampered, _ := json.Marshal(n)
+	if _, err := s.ProcessNotification(tampered); err == nil {
+		t.Error("tampered message: expected a signature error")
+	}
+
+	// Certificates that aren't on Amazon's SNS hosts aren't fetched.
+	fetches := ts.fetches.Load()
+	n.SigningCertURL = "https://example.com/SimpleNotificationService-0a.pem"
+	foreign, _ := json.Marshal(n)
+	if _, err := s.ProcessNotification(foreign); err == nil {
+		t.Error("foreign cert URL: expected an error")
+	}
+	if n := ts.fetches.Load(); n != fetches {
+		t.Errorf("foreign cert URL: got %d cert fetches, want %d", n, fetches)
+	}
+
+	// Stored payloads are re-parsed without verification.
+	if _, err := s.ParseNotification(tampered); err != nil {
+		t.Errorf("parse: unexpected error: %v", err)
+	}
+}
+
+func TestSESCertCache(t *testing.T) {
+	ts := newTestSigner(t, time.Now().Add(time.Hour))
+	s := ts.newSES()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.ProcessNotification(ts.notif(t, readFixture(t, "delivery.json"))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := ts.fetches.Load(); n != 1 {
+		t.Errorf("got %d cert fetches, want 1", n)
+	}
+
+	// An expired cert in the cache is re-fetched.
+	s.mu.Lock()
+	expired := *s.certs[testCertURL]
+	expired.NotAfter = time.Now().Add(-time.Minute)
+	s.certs[testCertURL] = &expired
+	s.mu.Unlock()
+
+	if _, err := s.ProcessNotification(ts.notif(t, readFixture(t, "delivery.json"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := ts.fetches.Load(); n != 2 {
+		t.Errorf("got %d cert fetches, want 2", n)
+	}
+
+	s.mu.RLock()
+	c := s.certs[testCertURL]
+	s.mu.RUnlock()
+	if !time.Now().Before(c.NotAfter) {
+		t.Error("expired cert was not replaced in the cache")
+	}
+}
+
+func TestSESCertExpired(t *testing.T) {
+	// A fetched cert that has expired is rejected and not cached.
+	ts := newTestSigner(t, time.Now().Add(-time.Minute))
+	s := ts.newSES()
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.ProcessNotification(ts.notif(t, readFixture(t, "delivery.json"))); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if n := ts.fetches.Load(); n != 2 {
+		t.Errorf("got %d cert fetches, want 2", n)
+	}
+
+	s.mu.RLock()
+	_, ok := s.certs[testCertURL]
+	s.mu.RUnlock()
+	if ok {
+		t.Error("expired cert was cached")
+	}
+}
@@ -7,6 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 // Shopify handles Shopify webhook verification and parsing.
@@ -14,16 +20,218 @@ type Shopify struct {
 	webhookSecret string
 }
 
+// Shopify webhook topics (X-Shopify-Topic header).
+const (
+	ShopifyTopicOrderCreate    = "orders/create"
+	ShopifyTopicOrderUpdated   = "orders/updated"
+	ShopifyTopicOrderCancelled = "orders/cancelled"
+	ShopifyTopicRefundCreate   = "refunds/create"
+	ShopifyTopicCustomerCreate = "customers/create"
+	ShopifyTopicCustomerUpdate = "customers/update"
+	ShopifyTopicCustomerDelete = "customers/delete"
+	ShopifyTopicCheckoutCreate = "checkouts/create"
+	ShopifyTopicCheckoutUpdate = "checkouts/update"
+)
+
 // ShopifyOrder represents the relevant fields from a Shopify order webhook.
 type ShopifyOrder struct {
-	ID          int64   `json:"id"`
-	OrderNumber int     `json:"order_number"`
-	Email       string  `json:"email"`
-	TotalPrice  string  `json:"total_price"`
-	Currency    string  `json:"currency"`
-	CreatedAt   string  `json:"created_at"`
-	LandingSite string  `json:"landing_site"`
-	RawJSON     []byte  `json:"-"`
+	ID          int64           `json:"id"`
+	OrderNumber int             `json:"order_number"`
+	Email       string          `json:"email"`
+	TotalPrice  string          `json:"total_price"`
+	Currency    string          `json:"currency"`
+	CreatedAt   string          `json:"created_at"`
+	CancelledAt string          `json:"cancelled_at"`
+	LandingSite string          `json:"landing_site"`
+	Refunds     []ShopifyRefund `json:"refunds"`
+
+	CheckoutToken string `json:"checkout_token"`
+
+	LineItems []struct {
+		ID        int64  `json:"id"`
+		ProductID *int64 `json:"product_id"`
+		VariantID *int64 `json:"variant_id"`
+		SKU       string `json:"sku"`
+		Title     string `json:"title"`
+		Quantity  int    `json:"quantity"`
+		Price     string `json:"price"`
+	} `json:"line_items"`
+	RawJSON []byte `json:"-"`
+}
+
+// Order converts the Shopify order to a provider-neutral order.
+func (o ShopifyOrder) Order() *models.Order {
+	out := &models.Order{
+		Source:      models.OrderSourceShopify,
+		OrderID:     strconv.FormatInt(o.ID, 10),
+		Email:       o.Email,
+		Currency:    o.Currency,
+		LandingSite: o.LandingSite,
+		Data:        o.RawJSON,
+
+		CheckoutToken: o.CheckoutToken,
+	}
+	if o.OrderNumber > 0 {
+		out.OrderNumber = strconv.Itoa(o.OrderNumber)
+	}
+
+	out.TotalPrice, _ = strconv.ParseFloat(o.TotalPrice, 64)
+	if t, err := time.Parse(time.RFC3339, o.CreatedAt); err == nil {
+		out.CreatedAt = null.TimeFrom(t)
+	}
+	if t, err := time.Parse(time.RFC3339, o.CancelledAt); err == nil {
+		out.CancelledAt = null.TimeFrom(t)
+	}
+
+	// Custom line items have no product or variant.
+	for _, li := range o.LineItems {
+		item := models.OrderLineItem{
+			LineID:   strconv.FormatInt(li.ID, 10),
+			SKU:      li.SKU,
+			Title:    li.Title,
+			Quantity: li.Quantity,
+		}
+		if li.ProductID != nil {
+			item.ProductID = strconv.FormatInt(*li.ProductID, 10)
+		}
+		if li.VariantID != nil {
+			item.VariantID = strconv.FormatInt(*li.VariantID, 10)
+		}
+		item.Price, _ = strconv.ParseFloat(li.Price, 64)
+
+		out.LineItems = append(out.LineItems, item)
+	}
+
+	return out
+}
+
+// ShopifyCheckout represents the relevant fields from a Shopify checkout webhook.
+// A checkout that's completed has an order.
+type ShopifyCheckout struct {
+	ID                   int64  `json:"id"`
+	Token                string `json:"token"`
+	Email                string `json:"email"`
+	TotalPrice           string `json:"total_price"`
+	Currency             string `json:"currency"`
+	AbandonedCheckoutURL string `json:"abandoned_checkout_url"`
+	CreatedAt            string `json:"created_at"`
+	UpdatedAt            string `json:"updated_at"`
+	CompletedAt          string `json:"completed_at"`
+	RawJSON              []byte `json:"-"`
+}
+
+// IsCompleted checks if the checkout was completed with an order.
+func (c ShopifyCheckout) IsCompleted() bool {
+	return c.CompletedAt != ""
+}
+
+// LastActivity returns the time the checkout was last updated.
+func (c ShopifyCheckout) LastActivity() time.Time {
+	if t, err := time.Parse(time.RFC3339, c.UpdatedAt); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.RFC3339, c.CreatedAt); err == nil {
+		return t
+	}
+
+	return time.Now()
+}
+
+// ShopifyCustomer represents the relevant fields from a Shopify customer webhook
+// or an Admin API customer.
+type ShopifyCustomer struct {
+	ID        int64  `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Tags      string `json:"tags"`
+
+	// AcceptsMarketing is the legacy consent field, which is superseded
+	// by EmailMarketingConsent in newer API versions.
+	AcceptsMarketing      bool `json:"accepts_marketing"`
+	EmailMarketingConsent *struct {
+		State string `json:"state"`
+	} `json:"email_marketing_consent"`
+
+	TotalSpent  string `json:"total_spent"`
+	OrdersCount int    `json:"orders_count"`
+	Currency    string `json:"currency"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// Shopify e-mail marketing consent states.
+const (
+	ShopifyConsentSubscribed   = "subscribed"
+	ShopifyConsentPending      = "pending"
+	ShopifyConsentUnsubscribed = "unsubscribed"
+)
+
+// Consent returns the customer's e-mail marketing consent as one of subscribed,
+// pending or unsubscribed. Customers who never consented are unsubscribed.
+func (c ShopifyCustomer) Consent() string {
+	if c.EmailMarketingConsent != nil {
+		switch c.EmailMarketingConsent.State {
+		case ShopifyConsentSubscribed:
+			return ShopifyConsentSubscribed
+		case ShopifyConsentPending:
+			return ShopifyConsentPending
+		default:
+			return ShopifyConsentUnsubscribed
+		}
+	}
+
+	if c.AcceptsMarketing {
+		return ShopifyConsentSubscribed
+	}
+	return ShopifyConsentUnsubscribed
+}
+
+// TagList returns the customer's comma separated tags as a list.
+func (c ShopifyCustomer) TagList() []string {
+	out := []string{}
+	for _, t := range strings.Split(c.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			out = append(out, t)
+		}
+	}
+
+	return out
+}
+
+// ShopifyRefund represents the relevant fields from a Shopify refund webhook
+// or a refund in an order.
+type ShopifyRefund struct {
+	ID           int64  `json:"id"`
+	OrderID      int64  `json:"order_id"`
+	CreatedAt    string `json:"created_at"`
+	Transactions []struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+		Kind     string `json:"kind"`
+		Status   string `json:"status"`
+	} `json:"transactions"`
+}
+
+// Amount returns the total amount of the successful refund transactions of the refund.
+func (r ShopifyRefund) Amount() (float64, string) {
+	var (
+		total    float64
+		currency string
+	)
+	for _, t := range r.Transactions {
+		if t.Kind != "refund" || t.Status != "success" {
+			continue
+		}
+
+		amt, err := strconv.ParseFloat(t.Amount, 64)
+		if err != nil {
+			continue
+		}
+		total += amt
+		currency = t.Currency
+	}
+
+	return total, currency
 }
 
 // NewShopify creates a new Shopify webhook handler.
@@ -83,3 +291,73 @@ func (s *Shopify) ProcessOrder(body []byte) (*ShopifyOrder, error) {
 
 	return &order, nil
 }
+
+// ProcessRefund parses a Shopify refund webhook payload.
+func (s *Shopify) ProcessRefund(body []byte) (*ShopifyRefund, error) {
+	var r ShopifyRefund
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("error parsing refund JSON: %v", err)
+	}
+
+	if r.ID == 0 {
+		return nil, errors.New("refund missing ID")
+	}
+
+	if r.OrderID == 0 {
+		return nil, errors.New("refund missing order ID")
+	}
+
+	return &r, nil
+}
+
+// ProcessOrderUpdate parses a Shopify order updated or cancelled webhook payload.
+// Unlike new orders, updates don't require an e-mail as they only refer to an
+// existing order.
+func (s *Shopify) ProcessOrderUpdate(body []byte) (*ShopifyOrder, error) {
+	var order ShopifyOrder
+	if err := json.Unmarshal(body, &order); err != nil {
+		return nil, fmt.Errorf("error parsing order JSON: %v", err)
+	}
+	order.RawJSON = body
+
+	if order.ID == 0 {
+		return nil, errors.New("order missing ID")
+	}
+
+	return &order, nil
+}
+
+// ProcessCustomer parses a Shopify customer webhook payload. Deleted customers
+// only have an ID.
+func (s *Shopify) ProcessCustomer(body []byte) (*ShopifyCustomer, error) {
+	var cust ShopifyCustomer
+	if err := json.Unmarshal(body, &cust); err != nil {
+		return nil, fmt.Errorf("error parsing customer JSON: %v", err)
+	}
+
+	if cust.ID == 0 {
+		return nil, errors.New("customer missing ID")
+	}
+
+	return &cust, nil
+}
+
+// ProcessCheckout parses a Shopify checkout webhook payload. Checkouts without an
+// e-mail (the customer hasn't entered it yet) can't be recovered and are rejected.
+func (s *Shopify) ProcessCheckout(body []byte) (*ShopifyCheckout, error) {
+	var co ShopifyCheckout
+	if err := json.Unmarshal(body, &co); err != nil {
+		return nil, fmt.Errorf("error parsing checkout JSON: %v", err)
+	}
+	co.RawJSON = body
+
+	if co.Token == "" {
+		return nil, errors.New("checkout missing token")
+	}
+
+	if co.Email == "" {
+		return nil, errors.New("checkout missing email address")
+	}
+
+	return &co, nil
+}
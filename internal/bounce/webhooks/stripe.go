@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Stripe handles Stripe webhook verification and parsing.
+type Stripe struct {
+	webhookSecret string
+}
+
+// Stripe event types.
+const (
+	StripeEventCheckoutCompleted    = "checkout.session.completed"
+	StripeEventCheckoutAsyncSuccess = "checkout.session.async_payment_succeeded"
+)
+
+// stripeTolerance is the maximum age of a signed Stripe webhook.
+const stripeTolerance = time.Minute * 5
+
+// Currencies whose Stripe amounts aren't in hundredths of the unit.
+var (
+	stripeZeroDecimal = map[string]bool{
+		"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true,
+		"KRW": true, "MGA": true, "PYG": true, "RWF": true, "UGX": true, "VND": true,
+		"VUV": true, "XAF": true, "XOF": true, "XPF": true,
+	}
+	stripeThreeDecimal = map[string]bool{
+		"BHD": true, "JOD": true, "KWD": true, "OMR": true, "TND": true,
+	}
+)
+
+// StripeEvent represents a Stripe webhook event.
+type StripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// StripeCheckoutSession represents the relevant fields of a Stripe Checkout session.
+type StripeCheckoutSession struct {
+	ID                string `json:"id"`
+	ClientReferenceID string `json:"client_reference_id"`
+	AmountTotal       int64  `json:"amount_total"`
+	Currency          string `json:"currency"`
+	Created           int64  `json:"created"`
+	PaymentStatus     string `json:"payment_status"`
+	CustomerEmail     string `json:"customer_email"`
+	CustomerDetails   *struct {
+		Email string `json:"email"`
+	} `json:"customer_details"`
+
+	// Metadata set when creating the session. landing_site and utm_* keys
+	// are used for attribution.
+	Metadata map[string]string `json:"metadata"`
+
+	RawJSON []byte `json:"-"`
+}
+
+// IsPaid checks if the session's payment is complete.
+func (s StripeCheckoutSession) IsPaid() bool {
+	return s.PaymentStatus == "paid" || s.PaymentStatus == "no_payment_required"
+}
+
+// Order converts the Checkout session to a provider-neutral order.
+func (s StripeCheckoutSession) Order() *models.Order {
+	cur := strings.ToUpper(s.Currency)
+	out := &models.Order{
+		Source:      models.OrderSourceStripe,
+		OrderID:     s.ID,
+		OrderNumber: s.ClientReferenceID,
+		Email:       s.CustomerEmail,
+		Currency:    cur,
+		LandingSite: s.Metadata["landing_site"],
+		Data:        s.RawJSON,
+	}
+	if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
+		out.Email = s.CustomerDetails.Email
+	}
+
+	// Amounts are in the smallest unit of the currency.
+	switch {
+	case stripeZeroDecimal[cur]:
+		out.TotalPrice = float64(s.AmountTotal)
+	case stripeThreeDecimal[cur]:
+		out.TotalPrice = float64(s.AmountTotal) / 1000
+	default:
+		out.TotalPrice = float64(s.AmountTotal) / 100
+	}
+
+	if s.Created > 0 {
+		out.CreatedAt = null.TimeFrom(time.Unix(s.Created, 0))
+	}
+
+	if out.LandingSite == "" {
+		q := url.Values{}
+		for _, k := range []string{"utm_source", "utm_medium", "utm_campaign", "utm_content"} {
+			if v := s.Metadata[k]; v != "" {
+				q.Set(k, v)
+			}
+		}
+		if len(q) > 0 {
+			out.LandingSite = "/?" + q.Encode()
+		}
+	}
+
+	return out
+}
+
+// NewStripe creates a new Stripe webhook handler.
+func NewStripe(secret string) *Stripe {
+	return &Stripe{webhookSecret: secret}
+}
+
+// VerifyWebhook verifies the Stripe-Signature header of a webhook, which is of the form
+// t=timestamp,v1=signature. The signature is a hex-encoded HMAC-SHA256 hash of the
+// timestamp and the raw request body joined by a dot. Webhooks signed more than
+// five minutes before now are rejected.
+func (s *Stripe) VerifyWebhook(header string, body []byte, now time.Time) error {
+	if s.webhookSecret == "" {
+		return errors.New("webhook secret not configured")
+	}
+
+	if header == "" {
+		return errors.New("missing signature header")
+	}
+
+	var (
+		ts   string
+		sigs [][]byte
+	)
+	for _, p := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			if b, err := hex.DecodeString(v); err == nil {
+				sigs = append(sigs, b)
+			}
+		}
+	}
+
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if math.Abs(now.Sub(time.Unix(t, 0)).Seconds()) > stripeTolerance.Seconds() {
+		return errors.New("signature timestamp outside the tolerance")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, sig := range sigs {
+		if hmac.Equal(expected, sig) {
+			return nil
+		}
+	}
+
+	return errors.New("signature verification failed")
+}
+
+// ProcessEvent parses a Stripe webhook event.
+func (s *Stripe) ProcessEvent(body []byte) (*StripeEvent, error) {
+	var e StripeEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("error parsing event JSON: %v", err)
+	}
+
+	if e.ID == "" || e.Type == "" {
+		return nil, errors.New("event missing ID or type")
+	}
+
+	return &e, nil
+}
+
+// ProcessCheckoutSession parses the Checkout session of a checkout event.
+func (s *Stripe) ProcessCheckoutSession(e *StripeEvent) (*StripeCheckoutSession, error) {
+	var sess StripeCheckoutSession
+	if err := json.Unmarshal(e.Data.Object, &sess); err != nil {
+		return nil, fmt.Errorf("error parsing checkout session JSON: %v", err)
+	}
+	sess.RawJSON = e.Data.Object
+
+	if sess.ID == "" {
+		return nil, errors.New("checkout session missing ID")
+	}
+
+	return &sess, nil
+}
@@ -0,0 +1,177 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// WooCommerce handles WooCommerce webhook verification and parsing.
+type WooCommerce struct {
+	webhookSecret string
+}
+
+// WooCommerce webhook topics (X-WC-Webhook-Topic header).
+const (
+	WooCommerceTopicOrderCreated = "order.created"
+	WooCommerceTopicOrderUpdated = "order.updated"
+)
+
+// WooCommerceOrder represents the relevant fields from a WooCommerce order webhook.
+type WooCommerceOrder struct {
+	ID       int64  `json:"id"`
+	Number   string `json:"number"`
+	Status   string `json:"status"`
+	Currency string `json:"currency"`
+	Total    string `json:"total"`
+
+	// Dates are in UTC without a zone, eg: 2024-01-02T15:04:05.
+	DateCreatedGMT string `json:"date_created_gmt"`
+
+	Billing struct {
+		Email string `json:"email"`
+	} `json:"billing"`
+
+	MetaData []struct {
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	} `json:"meta_data"`
+
+	LineItems []struct {
+		ID          int64   `json:"id"`
+		ProductID   int64   `json:"product_id"`
+		VariationID int64   `json:"variation_id"`
+		SKU         string  `json:"sku"`
+		Name        string  `json:"name"`
+		Quantity    int     `json:"quantity"`
+		Price       float64 `json:"price"`
+	} `json:"line_items"`
+
+	RawJSON []byte `json:"-"`
+}
+
+// IsPaid checks if the order is paid.
+func (o WooCommerceOrder) IsPaid() bool {
+	return o.Status == "processing" || o.Status == "completed"
+}
+
+// IsVoid checks if the order is cancelled, failed or fully refunded.
+func (o WooCommerceOrder) IsVoid() bool {
+	return o.Status == "cancelled" || o.Status == "failed" || o.Status == "refunded"
+}
+
+// Order converts the WooCommerce order to a provider-neutral order. The landing page
+// is taken from WooCommerce's order attribution metadata.
+func (o WooCommerceOrder) Order() *models.Order {
+	out := &models.Order{
+		Source:      models.OrderSourceWooCommerce,
+		OrderID:     strconv.FormatInt(o.ID, 10),
+		OrderNumber: o.Number,
+		Email:       o.Billing.Email,
+		Currency:    o.Currency,
+		Data:        o.RawJSON,
+	}
+
+	out.TotalPrice, _ = strconv.ParseFloat(o.Total, 64)
+	if t, err := time.Parse("2006-01-02T15:04:05", o.DateCreatedGMT); err == nil {
+		out.CreatedAt = null.TimeFrom(t)
+	}
+	if o.IsVoid() {
+		out.CancelledAt = null.TimeFrom(time.Now())
+	}
+
+	for _, li := range o.LineItems {
+		item := models.OrderLineItem{
+			LineID:    strconv.FormatInt(li.ID, 10),
+			ProductID: strconv.FormatInt(li.ProductID, 10),
+			SKU:       li.SKU,
+			Title:     li.Name,
+			Quantity:  li.Quantity,
+			Price:     li.Price,
+		}
+		if li.VariationID > 0 {
+			item.VariantID = strconv.FormatInt(li.VariationID, 10)
+		}
+
+		out.LineItems = append(out.LineItems, item)
+	}
+
+	// The session entry is the landing page URL. Without it, the UTM parameters are
+	// recorded individually.
+	meta := map[string]string{}
+	for _, m := range o.MetaData {
+		if v, ok := m.Value.(string); ok {
+			meta[m.Key] = v
+		}
+	}
+	if v := meta["_wc_order_attribution_session_entry"]; v != "" {
+		out.LandingSite = v
+	} else {
+		q := url.Values{}
+		for _, k := range []string{"source", "medium", "campaign", "content"} {
+			if v := meta["_wc_order_attribution_utm_"+k]; v != "" {
+				q.Set("utm_"+k, v)
+			}
+		}
+		if len(q) > 0 {
+			out.LandingSite = "/?" + q.Encode()
+		}
+	}
+
+	return out
+}
+
+// NewWooCommerce creates a new WooCommerce webhook handler.
+func NewWooCommerce(secret string) *WooCommerce {
+	return &WooCommerce{webhookSecret: secret}
+}
+
+// VerifyWebhook verifies the signature of a WooCommerce webhook. The signature is in
+// the X-WC-Webhook-Signature header and is a base64-encoded HMAC-SHA256 hash of the
+// raw request body.
+func (w *WooCommerce) VerifyWebhook(sig string, body []byte) error {
+	if w.webhookSecret == "" {
+		return errors.New("webhook secret not configured")
+	}
+
+	if sig == "" {
+		return errors.New("missing signature header")
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(w.webhookSecret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature verification failed")
+	}
+
+	return nil
+}
+
+// ProcessOrder parses a WooCommerce order webhook payload.
+func (w *WooCommerce) ProcessOrder(body []byte) (*WooCommerceOrder, error) {
+	var o WooCommerceOrder
+	if err := json.Unmarshal(body, &o); err != nil {
+		return nil, fmt.Errorf("error parsing order JSON: %v", err)
+	}
+	o.RawJSON = body
+
+	if o.ID == 0 {
+		return nil, errors.New("order missing ID")
+	}
+
+	return &o, nil
+}
@@ -1,16 +1,20 @@
 package core
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/queue"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -183,8 +187,8 @@ func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, e
 	return out, total, nil
 }
 
-// CreateCampaign creates a new campaign.
-func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
+// CreateCampaign creates a new campaign. Its content is the first version by the author.
+func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int, author auth.User) (models.Campaign, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -192,9 +196,22 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
 	}
 
+	if o.ExcludeListIDs == nil {
+		o.ExcludeListIDs = pq.Int64Array{}
+	}
+
+	// The campaign and its first version are saved together.
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error creating campaign: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
 	// Insert and read ID.
 	var newID int
-	if err := c.q.CreateCampaign.Get(&newID,
+	if err := tx.Stmtx(c.q.CreateCampaign).Get(&newID,
 		uu,
 		o.Type,
 		o.Name,
@@ -215,6 +232,15 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
 		o.BodySource,
+		o.UTM,
+		o.HoldoutPercent,
+		o.ABTest,
+		o.Variants,
+		o.Resend,
+		o.FeedItems,
+		o.ExcludeListIDs,
+		o.SegmentQuery,
+		o.AudienceSnapshot,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
@@ -225,6 +251,15 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	if err := c.recordCampaignVersion(tx, newID, author); err != nil {
+		return models.Campaign{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error creating campaign: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
 	out, err := c.GetCampaign(newID, "", "")
 	if err != nil {
 		return models.Campaign{}, err
@@ -233,9 +268,40 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 	return out, nil
 }
 
-// UpdateCampaign updates a campaign.
-func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
-	_, err := c.q.UpdateCampaign.Exec(id,
+// UpdateCampaign updates a campaign. If its content has changed, it's recorded as a new version by the author.
+func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int, author auth.User) (models.Campaign, error) {
+	if o.ExcludeListIDs == nil {
+		o.ExcludeListIDs = pq.Int64Array{}
+	}
+
+	// The campaign is locked while it's updated so that the versions of concurrent saves
+	// are recorded in order.
+	tx, err := c.db.Beginx()
+	if err != nil {
+		c.log.Printf("error updating campaign: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var lockID int
+	if err := tx.Stmtx(c.q.LockCampaign).Get(&lockID, id); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error updating campaign: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	// Content that was saved without a version (eg: by the installer) is kept before it's overwritten.
+	if err := c.recordCampaignVersion(tx, id, auth.User{}); err != nil {
+		return models.Campaign{}, err
+	}
+
+	_, err = tx.Stmtx(c.q.UpdateCampaign).Exec(id,
 		o.Name,
 		o.Subject,
 		o.FromEmail,
@@ -253,13 +319,30 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
-		o.BodySource)
+		o.BodySource,
+		o.UTM,
+		o.HoldoutPercent,
+		o.ABTest,
+		o.Variants,
+		o.Resend,
+		o.ExcludeListIDs,
+		o.SegmentQuery,
+		o.AudienceSnapshot)
 	if err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	if err := c.recordCampaignVersion(tx, id, author); err != nil {
+		return models.Campaign{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error updating campaign: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
 	out, err := c.GetCampaign(id, "", "")
 	if err != nil {
 		return models.Campaign{}, err
@@ -282,7 +365,8 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 			errMsg = c.i18n.T("campaigns.onlyScheduledAsDraft")
 		}
 	case models.CampaignStatusScheduled:
-		if cm.Status != models.CampaignStatusDraft && cm.Status != models.CampaignStatusPaused {
+		if cm.Status != models.CampaignStatusDraft && cm.Status != models.CampaignStatusPaused &&
+			cm.Status != models.CampaignStatusApproved {
 			errMsg = c.i18n.T("campaigns.onlyDraftAsScheduled")
 		}
 		if !cm.SendAt.Valid {
@@ -290,7 +374,8 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 		}
 
 	case models.CampaignStatusRunning:
-		if cm.Status != models.CampaignStatusPaused && cm.Status != models.CampaignStatusDraft {
+		if cm.Status != models.CampaignStatusPaused && cm.Status != models.CampaignStatusDraft &&
+			cm.Status != models.CampaignStatusApproved {
 			errMsg = c.i18n.T("campaigns.onlyPausedDraft")
 		}
 	case models.CampaignStatusPaused:
@@ -307,6 +392,13 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, errMsg)
 	}
 
+	// Campaigns that are sent to a snapshot of their audience take it before they start.
+	if status == models.CampaignStatusRunning && cm.NeedsAudienceSnapshot() && !cm.AudienceSnapshotAt.Valid {
+		if _, err := c.SnapshotCampaignAudience(cm.ID); err != nil {
+			return models.Campaign{}, err
+		}
+	}
+
 	// CRITICAL: If campaign is being set to running and messenger is "automatic",
 	// handle queue-based campaigns differently based on whether it's a new start or resume.
 	if status == models.CampaignStatusRunning && cm.Messenger == "automatic" {
@@ -320,6 +412,12 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 			}
 			c.log.Printf("re-queued %d cancelled emails for campaign %d (%s)", count, cm.ID, cm.Name)
 		} else {
+			// The version of the content that's sent. Campaigns on other messengers record it
+			// when the manager picks them up.
+			if _, err := c.q.SetCampaignSentVersion.Exec(cm.ID); err != nil {
+				c.log.Printf("error recording sent version of campaign %d: %v", cm.ID, err)
+			}
+
 			// New campaign start - queue emails ASYNCHRONOUSLY to prevent HTTP timeout
 			// on large campaigns (200K+ subscribers).
 			go func(campaignID int, campaignName string) {
@@ -498,6 +596,22 @@ func (c *Core) GetCampaignAzureDeliveryCounts(campIDs []int, fromDate, toDate st
 	return out, nil
 }
 
+// GetCampaignSESEventCounts returns SES event counts grouped by event type.
+func (c *Core) GetCampaignSESEventCounts(campIDs []int, fromDate, toDate string) ([]models.CampaignSESEventCount, error) {
+	if !strHasLen(fromDate, 10, 30) || !strHasLen(toDate, 10, 30) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("analytics.invalidDates"))
+	}
+
+	out := []models.CampaignSESEventCount{}
+	if err := c.q.GetCampaignSESEventCounts.Select(&out, pq.Array(campIDs), fromDate, toDate); err != nil {
+		c.log.Printf("error fetching campaign SES event counts: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // GetCampaignUnsubscribers returns the list of subscribers who unsubscribed after receiving the campaign.
 func (c *Core) GetCampaignUnsubscribers(campID int) ([]models.CampaignUnsubscriber, error) {
 	out := []models.CampaignUnsubscriber{}
@@ -525,9 +639,13 @@ func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
 }
 
 // RegisterCampaignLinkClick registers a subscriber's link click on a campaign.
-func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (string, error) {
-	var url string
-	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
+func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string, pos int) (string, error) {
+	var out struct {
+		URL          string             `db:"url"`
+		CampaignName null.String        `db:"campaign_name"`
+		UTM          models.CampaignUTM `db:"utm"`
+	}
+	if err := c.q.RegisterLinkClick.Get(&out, linkUUID, campUUID, subUUID); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
 			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
 		}
@@ -536,7 +654,7 @@ func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (st
 		return "", echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
 	}
 
-	return url, nil
+	return out.UTM.Apply(out.URL, campUUID, out.CampaignName.String, pos), nil
 }
 
 // DeleteCampaignViews deletes campaign views older than a given date.
@@ -560,14 +678,34 @@ func (c *Core) DeleteCampaignLinkClicks(before time.Time) error {
 }
 
 // QueueCampaignEmails queues all emails for a campaign to be sent via the queue system
+// If the campaign has an A/B test, only its test slice is queued, split across its variants.
 func (c *Core) QueueCampaignEmails(campID int) (int, error) {
+	camp, err := c.GetCampaign(campID, "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	testPercent := 0.0
+	if camp.ABTest.Enabled && len(camp.Variants) > 1 {
+		testPercent = camp.ABTest.TestPercent
+	}
+
 	// Queue all campaign emails (initially with same scheduled_at time)
-	if _, err := c.q.QueueCampaignEmails.Exec(campID); err != nil {
+	if _, err := c.q.QueueCampaignEmails.Exec(campID, testPercent); err != nil {
 		c.log.Printf("error queuing campaign emails: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
 	}
 
+	// Start the clock on the A/B test. The winner is queued to the rest of the audience when it ends.
+	if testPercent > 0 {
+		if _, err := c.q.StartCampaignABTest.Exec(campID); err != nil {
+			c.log.Printf("error starting campaign A/B test: %v", err)
+			return 0, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+		}
+	}
+
 	// Get the count of queued emails
 	var count int
 	if err := c.q.GetQueuedEmailCount.Get(&count, campID); err != nil {
@@ -602,6 +740,138 @@ func (c *Core) QueueCampaignEmails(campID int) (int, error) {
 	return count, nil
 }
 
+// ValidateSegmentQuery validates an arbitrary SQL expression on subscribers that a campaign's
+// audience is filtered by. Like subscriber queries, it may only read the allowed tables.
+func (c *Core) ValidateSegmentQuery(exp string) error {
+	stmt := strings.ReplaceAll(c.q.QuerySubscribers, "%query%", sanitizeSQLExp(exp))
+	stmt = strings.ReplaceAll(stmt, "%order%", "subscribers.id")
+
+	return validateQueryTables(c.db, stmt, allowedSubQueryTables)
+}
+
+// CountCampaignAudience returns the number of subscribers in the audience of a campaign as
+// it'd be snapshotted now, with its exclusion lists and segment query.
+func (c *Core) CountCampaignAudience(id int) (int, error) {
+	cm, err := c.GetCampaign(id, "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	return c.countCampaignAudience(id, cm.SegmentQuery)
+}
+
+// SnapshotCampaignAudience records the subscribers in the audience of a campaign, with its
+// exclusion lists and segment query, that it's then only sent to. The snapshot is only taken
+// once. It returns the number of subscribers in it.
+func (c *Core) SnapshotCampaignAudience(id int) (int, error) {
+	cm, err := c.GetCampaign(id, "", "")
+	if err != nil {
+		return 0, err
+	}
+
+	// Do a read-only dry run of the segment query before it's run in a writable transaction.
+	if _, err := c.countCampaignAudience(id, cm.SegmentQuery); err != nil {
+		return 0, err
+	}
+
+	var out int
+	if err := c.db.Get(&out, c.makeCampaignAudienceQuery(c.q.SnapshotCampaignAudience, cm.SegmentQuery), id); err != nil {
+		if err == sql.ErrNoRows {
+			return cm.AudienceSize, nil
+		}
+
+		c.log.Printf("error taking campaign audience snapshot: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// SnapshotDueCampaignAudiences takes the audience snapshots of the campaigns that are due to
+// start and are only sent to one. Campaigns whose snapshot fails are paused.
+func (c *Core) SnapshotDueCampaignAudiences() error {
+	var ids []int
+	if err := c.q.GetDueCampaignAudiences.Select(&ids); err != nil {
+		c.log.Printf("error fetching due campaign audiences: %v", err)
+		return err
+	}
+
+	for _, id := range ids {
+		n, err := c.SnapshotCampaignAudience(id)
+		if err != nil {
+			c.log.Printf("error taking audience snapshot of campaign %d, pausing: %v", id, err)
+			if _, err := c.q.UpdateCampaignStatus.Exec(id, models.CampaignStatusPaused); err != nil {
+				c.log.Printf("error pausing campaign %d: %v", id, err)
+			}
+			continue
+		}
+
+		c.log.Printf("took audience snapshot of %d subscribers for campaign %d", n, id)
+	}
+
+	return nil
+}
+
+func (c *Core) countCampaignAudience(id int, segment string) (int, error) {
+	// The count is run in a read-only transaction to ensure that the segment query is indeed readonly.
+	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		c.log.Printf("error preparing campaign audience query: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var out int
+	if err := tx.Get(&out, c.makeCampaignAudienceQuery(c.q.CountCampaignAudience, segment), id); err != nil {
+		c.log.Printf("error counting campaign audience: %v", err)
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// makeCampaignAudienceQuery injects the audience of a campaign, filtered by a segment query,
+// into a raw audience query.
+func (c *Core) makeCampaignAudienceQuery(baseQuery, segment string) string {
+	cond := "TRUE"
+	if q := sanitizeSQLExp(segment); q != "" {
+		cond = q
+	}
+
+	return strings.ReplaceAll(baseQuery, "%audience%", strings.ReplaceAll(c.q.CampaignAudienceTpl, "%query%", cond))
+}
+
+// SendCampaignWinner sets the winning variant of a campaign's A/B test and queues it to the
+// rest of the campaign's audience. It returns the number of e-mails queued, which is 0 if
+// the winner had already been picked.
+func (c *Core) SendCampaignWinner(campID, variantID int) (int, error) {
+	var count int
+	if err := c.q.QueueCampaignWinnerEmails.Get(&count, campID, variantID); err != nil {
+		c.log.Printf("error queuing campaign winner emails: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	settings, err := c.GetSettings()
+	if err != nil {
+		c.log.Printf("error getting settings for scheduling: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "settings", "error", pqErrMsg(err)))
+	}
+
+	// Schedule the winner's e-mails with staggered times and SMTP server assignments.
+	if err := c.NewScheduler(settings).ScheduleCampaign(campID, settings); err != nil {
+		c.log.Printf("error scheduling campaign winner emails: %v", err)
+	}
+
+	return count, nil
+}
+
 // CancelCampaignQueue cancels all queued emails for a campaign
 func (c *Core) CancelCampaignQueue(campID int) error {
 	if _, err := c.q.CancelCampaignQueue.Exec(campID); err != nil {
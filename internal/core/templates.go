@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	null "gopkg.in/volatiletech/null.v6"
@@ -36,10 +37,26 @@ func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 	return out[0], nil
 }
 
-// CreateTemplate creates a new template.
-func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource null.String) (models.Template, error) {
+// CreateTemplate creates a new template. It's the first version by the author.
+func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource null.String, author auth.User) (models.Template, error) {
+	// The template and its first version are saved together.
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
 	var newID int
-	if err := c.q.CreateTemplate.Get(&newID, name, typ, subject, body, bodySource); err != nil {
+	if err := tx.Stmtx(c.q.CreateTemplate).Get(&newID, name, typ, subject, body, bodySource); err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	if err := c.recordTemplateVersion(tx, newID, author); err != nil {
+		return models.Template{}, err
+	}
+	if err := tx.Commit(); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
@@ -47,9 +64,33 @@ func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource
 	return c.GetTemplate(newID, false)
 }
 
-// UpdateTemplate updates a given template.
-func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource null.String) (models.Template, error) {
-	res, err := c.q.UpdateTemplate.Exec(id, name, subject, body, bodySource)
+// UpdateTemplate updates a given template. If it has changed, it's recorded as a new version by the author.
+func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource null.String, author auth.User) (models.Template, error) {
+	// The template is locked while it's updated so that the versions of concurrent saves
+	// are recorded in order.
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var lockID int
+	if err := tx.Stmtx(c.q.LockTemplate).Get(&lockID, id); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Template{}, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.template}"))
+		}
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	// Templates that were saved without a version (eg: by the installer) are kept before they're overwritten.
+	if err := c.recordTemplateVersion(tx, id, auth.User{}); err != nil {
+		return models.Template{}, err
+	}
+
+	res, err := tx.Stmtx(c.q.UpdateTemplate).Exec(id, name, subject, body, bodySource)
 	if err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
@@ -60,6 +101,14 @@ func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySou
 			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.template}"))
 	}
 
+	if err := c.recordTemplateVersion(tx, id, author); err != nil {
+		return models.Template{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
 	return c.GetTemplate(id, false)
 }
 
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// GetCampaignVersions returns the versions of a campaign's content, latest first, without their bodies.
+func (c *Core) GetCampaignVersions(id int) ([]models.CampaignVersion, error) {
+	out := []models.CampaignVersion{}
+	if err := c.q.GetCampaignVersions.Select(&out, id); err != nil {
+		c.log.Printf("error fetching campaign versions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignVersion returns a version of a campaign's content, or the latest one if version is 0.
+func (c *Core) GetCampaignVersion(id, version int) (models.CampaignVersion, error) {
+	var out models.CampaignVersion
+	if err := c.q.GetCampaignVersion.Get(&out, id, version); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.version}"))
+		}
+
+		c.log.Printf("error fetching campaign version: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetTemplateVersions returns the versions of a template, latest first, without their bodies.
+func (c *Core) GetTemplateVersions(id int) ([]models.TemplateVersion, error) {
+	out := []models.TemplateVersion{}
+	if err := c.q.GetTemplateVersions.Select(&out, id); err != nil {
+		c.log.Printf("error fetching template versions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetTemplateVersion returns a version of a template, or the latest one if version is 0.
+func (c *Core) GetTemplateVersion(id, version int) (models.TemplateVersion, error) {
+	var out models.TemplateVersion
+	if err := c.q.GetTemplateVersion.Get(&out, id, version); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.version}"))
+		}
+
+		c.log.Printf("error fetching template version: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// recordCampaignVersion records the content of a campaign as a new version by the author
+// if it has changed. It's run in the transaction that saves the campaign, with the campaign
+// locked, so that the versions of concurrent saves aren't lost.
+func (c *Core) recordCampaignVersion(tx *sqlx.Tx, id int, author auth.User) error {
+	if _, err := tx.Stmtx(c.q.InsertCampaignVersion).Exec(id, authorID(author), author.Name); err != nil {
+		c.log.Printf("error recording version of campaign %d: %v", id, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// recordTemplateVersion records a template as a new version by the author if it has changed.
+// Like campaign versions, it's run in the transaction that saves the template.
+func (c *Core) recordTemplateVersion(tx *sqlx.Tx, id int, author auth.User) error {
+	if _, err := tx.Stmtx(c.q.InsertTemplateVersion).Exec(id, authorID(author), author.Name); err != nil {
+		c.log.Printf("error recording version of template %d: %v", id, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.version}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// authorID returns the user ID of the author of a version, which is null for
+// versions saved by the system, eg: campaigns created by a recurrence.
+func authorID(u auth.User) null.Int {
+	return null.NewInt(u.ID, u.ID > 0)
+}
@@ -0,0 +1,111 @@
+// Package currency loads exchange rates for normalizing amounts in different
+// currencies into a single reporting currency.
+package currency
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxBodySize is the maximum size of a rates file or API response.
+const maxBodySize = 1 << 20
+
+var reCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Rates is a set of exchange rates as units of each currency per one unit of
+// the base currency.
+type Rates struct {
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// IsCode checks if the given string is a three letter ISO 4217 currency code.
+func IsCode(c string) bool {
+	return reCode.MatchString(c)
+}
+
+// Load loads rates from an HTTP(s) URL or a file path. The rates are JSON in the
+// form {"base": "USD", "rates": {"EUR": 0.92, ...}}, which is the format of most
+// exchange rate APIs. "base_code" is accepted in place of "base".
+func Load(src string, timeout time.Duration) (Rates, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		b, err = fetch(src, timeout)
+	} else {
+		b, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return Rates{}, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates JSON rates.
+func Parse(b []byte) (Rates, error) {
+	var r struct {
+		Rates
+		BaseCode string `json:"base_code"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Rates{}, fmt.Errorf("error parsing rates: %v", err)
+	}
+	if r.Base == "" {
+		r.Base = r.BaseCode
+	}
+
+	return r.Rates, r.Rates.Validate()
+}
+
+// Validate validates and normalizes the currency codes to uppercase. The rate of
+// the base currency is always 1.
+func (r *Rates) Validate() error {
+	r.Base = strings.ToUpper(strings.TrimSpace(r.Base))
+	if !IsCode(r.Base) {
+		return fmt.Errorf("invalid base currency: %s", r.Base)
+	}
+	if len(r.Rates) == 0 {
+		return errors.New("no rates")
+	}
+
+	out := make(map[string]float64, len(r.Rates)+1)
+	for c, v := range r.Rates {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if !IsCode(c) {
+			return fmt.Errorf("invalid currency: %s", c)
+		}
+		if v <= 0 {
+			return fmt.Errorf("invalid rate for %s: %v", c, v)
+		}
+		out[c] = v
+	}
+	out[r.Base] = 1
+	r.Rates = out
+
+	return nil
+}
+
+func fetch(u string, timeout time.Duration) ([]byte, error) {
+	c := &http.Client{Timeout: timeout}
+	resp, err := c.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching rates: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+}
@@ -0,0 +1,160 @@
+// Package diff computes line by line differences between two texts, for
+// comparing versions of campaigns and templates.
+package diff
+
+import "strings"
+
+const (
+	OpEqual  = "="
+	OpInsert = "+"
+	OpDelete = "-"
+)
+
+// maxEdits is the largest number of inserted and deleted lines that are diffed.
+// Texts that differ by more are shown as entirely replaced.
+const maxEdits = 1000
+
+// Line is a line in a diff that's unchanged, inserted or deleted.
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines returns the lines of the diff from a to b.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	// Lines common to the start and the end are trimmed before diffing.
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+
+	out := make([]Line, 0, len(x)+len(y)-pre-suf)
+	for _, l := range x[:pre] {
+		out = append(out, Line{Op: OpEqual, Text: l})
+	}
+	out = append(out, myers(x[pre:len(x)-suf], y[pre:len(y)-suf])...)
+	for _, l := range x[len(x)-suf:] {
+		out = append(out, Line{Op: OpEqual, Text: l})
+	}
+
+	return out
+}
+
+// Changed returns true if a diff has any inserted or deleted lines.
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != OpEqual {
+			return true
+		}
+	}
+
+	return false
+}
+
+// myers returns the shortest diff from x to y with Myers' algorithm.
+// For every number of edits d, the furthest reaching x on each diagonal
+// k = x - y is recorded to backtrack the path from.
+func myers(x, y []string) []Line {
+	n, m := len(x), len(y)
+	if n+m == 0 {
+		return nil
+	}
+
+	var (
+		max   = n + m
+		off   = max + 1
+		v     = make([]int, 2*max+3)
+		trace [][]int
+	)
+	for d := 0; d <= max && d <= maxEdits; d++ {
+		// Diagonals -d-1 to d+1 of the previous round are kept for backtracking.
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				i = v[off+k+1]
+			} else {
+				i = v[off+k-1] + 1
+			}
+
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			v[off+k] = i
+
+			if i >= n && j >= m {
+				return backtrack(trace, x, y)
+			}
+		}
+	}
+
+	// Too many edits.
+	out := make([]Line, 0, n+m)
+	for _, l := range x {
+		out = append(out, Line{Op: OpDelete, Text: l})
+	}
+	for _, l := range y {
+		out = append(out, Line{Op: OpInsert, Text: l})
+	}
+
+	return out
+}
+
+func backtrack(trace [][]int, x, y []string) []Line {
+	var (
+		out  []Line
+		i, j = len(x), len(y)
+	)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		get := func(k int) int {
+			return v[k+d+1]
+		}
+
+		k := i - j
+		prevK := k - 1
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		}
+		prevI := get(prevK)
+		prevJ := prevI - prevK
+
+		for i > prevI && j > prevJ {
+			out = append(out, Line{Op: OpEqual, Text: x[i-1]})
+			i--
+			j--
+		}
+
+		if d > 0 {
+			if i == prevI {
+				out = append(out, Line{Op: OpInsert, Text: y[j-1]})
+			} else {
+				out = append(out, Line{Op: OpDelete, Text: x[i-1]})
+			}
+		}
+		i, j = prevI, prevJ
+	}
+
+	for a, b := 0, len(out)-1; a < b; a, b = a+1, b-1 {
+		out[a], out[b] = out[b], out[a]
+	}
+
+	return out
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,283 @@
+// Package feed fetches and parses RSS and Atom feeds into items for
+// digest campaigns.
+package feed
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// maxBodySize is the maximum size of a feed.
+const maxBodySize = 5 << 20
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	time.RFC3339Nano,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+type rssFeed struct {
+	// RSS 2.0 items are in <channel> and RSS 1.0 (RDF) items are at the root.
+	Items    []rssItem `xml:"channel>item"`
+	RDFItems []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Enclosure   struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Thumbnail struct {
+		URL string `xml:"url,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type atomFeed struct {
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID    string `xml:"id"`
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	Summary   atomText `xml:"summary"`
+	Content   atomText `xml:"content"`
+	Author    string   `xml:"author>name"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+}
+
+// atomText is an Atom text construct. XHTML is markup in the element and
+// HTML is escaped text.
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (t atomText) String() string {
+	if t.Type == "xhtml" {
+		return t.Inner
+	}
+	return t.Text
+}
+
+// Fetch fetches an RSS or Atom feed from an HTTP(s) URL and parses it.
+func Fetch(u string, timeout time.Duration) (models.FeedItems, error) {
+	b, err := fetch(u, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses an RSS (2.0 or 1.0) or Atom feed into items in the order of the feed.
+// Items without a GUID (or Atom ID) are identified by their link or title. Items
+// without a date are dated now.
+func Parse(b []byte) (models.FeedItems, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := newDecoder(b).Decode(&root); err != nil {
+		return nil, fmt.Errorf("error parsing feed: %v", err)
+	}
+
+	var (
+		now = time.Now()
+		out models.FeedItems
+	)
+	switch strings.ToLower(root.XMLName.Local) {
+	case "rss", "rdf":
+		var f rssFeed
+		if err := newDecoder(b).Decode(&f); err != nil {
+			return nil, fmt.Errorf("error parsing RSS feed: %v", err)
+		}
+
+		items := f.Items
+		if len(items) == 0 {
+			items = f.RDFItems
+		}
+		out = make(models.FeedItems, 0, len(items))
+		for _, i := range items {
+			it := models.FeedItem{
+				GUID:        strings.TrimSpace(i.GUID),
+				Title:       strings.TrimSpace(i.Title),
+				URL:         strings.TrimSpace(i.Link),
+				Description: strings.TrimSpace(i.Description),
+				Content:     strings.TrimSpace(i.Content),
+				Author:      strings.TrimSpace(firstOf(i.Creator, i.Author)),
+				PublishedAt: parseDate(firstOf(i.PubDate, i.Date), now),
+			}
+			if strings.HasPrefix(i.Enclosure.Type, "image/") {
+				it.ImageURL = i.Enclosure.URL
+			} else if i.Thumbnail.URL != "" {
+				it.ImageURL = i.Thumbnail.URL
+			}
+			out = append(out, it)
+		}
+
+	case "feed":
+		var f atomFeed
+		if err := newDecoder(b).Decode(&f); err != nil {
+			return nil, fmt.Errorf("error parsing Atom feed: %v", err)
+		}
+
+		out = make(models.FeedItems, 0, len(f.Entries))
+		for _, e := range f.Entries {
+			it := models.FeedItem{
+				GUID:        strings.TrimSpace(e.ID),
+				Title:       strings.TrimSpace(e.Title),
+				Description: strings.TrimSpace(e.Summary.String()),
+				Content:     strings.TrimSpace(e.Content.String()),
+				Author:      strings.TrimSpace(e.Author),
+				PublishedAt: parseDate(firstOf(e.Published, e.Updated), now),
+			}
+			for _, l := range e.Links {
+				switch {
+				case (l.Rel == "" || l.Rel == "alternate") && it.URL == "":
+					it.URL = strings.TrimSpace(l.Href)
+				case l.Rel == "enclosure" && strings.HasPrefix(l.Type, "image/"):
+					it.ImageURL = strings.TrimSpace(l.Href)
+				}
+			}
+			out = append(out, it)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown feed format: %s", root.XMLName.Local)
+	}
+
+	for n, i := range out {
+		if i.GUID != "" {
+			continue
+		}
+
+		id := firstOf(i.URL, i.Title)
+		if id == "" {
+			id = i.Description
+		}
+		h := sha1.Sum([]byte(id))
+		out[n].GUID = hex.EncodeToString(h[:])
+	}
+
+	return out, nil
+}
+
+// Digest returns the items of a digest of the pending (not yet sent) items of a feed,
+// latest first, and the IDs of the items that are sent in it. There's no digest unless
+// there are at least minItems pending items. A digest has the latest maxItems items and
+// the older pending items beyond them are sent in it without being included.
+func Digest(pending []models.CampaignFeedItem, minItems, maxItems int) (models.FeedItems, []int) {
+	if len(pending) == 0 || len(pending) < minItems {
+		return nil, nil
+	}
+
+	var (
+		items models.FeedItems
+		ids   = make([]int, 0, len(pending))
+	)
+	for n, p := range pending {
+		if n < maxItems {
+			items = append(items, p.Item)
+		}
+		ids = append(ids, p.ID)
+	}
+
+	return items, ids
+}
+
+func newDecoder(b []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(charset string, in io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "us-ascii", "ascii":
+			return in, nil
+		case "iso-8859-1", "latin1", "latin-1":
+			return charmap.ISO8859_1.NewDecoder().Reader(in), nil
+		case "windows-1252", "cp1252":
+			return charmap.Windows1252.NewDecoder().Reader(in), nil
+		}
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+
+	return d
+}
+
+func parseDate(s string, def time.Time) time.Time {
+	s = strings.TrimSpace(s)
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t
+		}
+	}
+
+	return def
+}
+
+func firstOf(s ...string) string {
+	for _, v := range s {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func fetch(u string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed: %v", err)
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8")
+	req.Header.Set("User-Agent", "listmonk")
+
+	c := &http.Client{Timeout: timeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching feed: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+}
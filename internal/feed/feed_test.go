@@ -0,0 +1,226 @@
+package feed
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// newServer serves the feed fixtures in testdata.
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := os.ReadFile(filepath.Join("testdata", filepath.Base(r.URL.Path)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func sha1Hex(s string) string {
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func date(t *testing.T, layout, s string) time.Time {
+	t.Helper()
+
+	d, err := time.Parse(layout, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestFetch(t *testing.T) {
+	srv := newServer(t)
+
+	cases := []struct {
+		fixture string
+		want    models.FeedItems
+	}{
+		{
+			fixture: "rss2.xml",
+			want: models.FeedItems{
+				{
+					GUID:        "tag:listmonk.app,2025:v5",
+					Title:       "v5.0 released",
+					URL:         "https://listmonk.app/blog/v5",
+					Description: "<p>Multi-user support &amp; more.</p>",
+					Content:     "<h1>v5.0</h1><p>Full release notes.</p>",
+					Author:      "Kailash",
+					ImageURL:    "https://listmonk.app/static/v5.png",
+					PublishedAt: date(t, time.RFC1123Z, "Tue, 04 Mar 2025 10:30:00 +0530"),
+				},
+				{
+					// Without a GUID, the item is identified by its link. Non-image
+					// enclosures are ignored for the thumbnail.
+					GUID:        sha1Hex("https://listmonk.app/blog/podcast-1"),
+					Title:       "Podcast \u2014 episode 1",
+					URL:         "https://listmonk.app/blog/podcast-1",
+					Author:      "team@listmonk.app (Team)",
+					ImageURL:    "https://listmonk.app/static/ep1.jpg",
+					PublishedAt: date(t, "Mon, 2 Jan 2006 15:04 MST", "Mon, 3 Mar 2025 08:00 GMT"),
+				},
+				{
+					// Without a GUID or link, the item is identified by its title.
+					GUID:        sha1Hex("An item without a link"),
+					Title:       "An item without a link",
+					Description: "No GUID or link.",
+				},
+			},
+		},
+		{
+			fixture: "rdf.xml",
+			want: models.FeedItems{
+				{
+					GUID:        sha1Hex("https://example.com/caf%C3%A9"),
+					Title:       "Café crème",
+					URL:         "https://example.com/caf%C3%A9",
+					Description: "Latin-1 encoded.",
+					Author:      "José",
+					PublishedAt: date(t, time.RFC3339, "2025-03-02T09:15:00Z"),
+				},
+				{
+					GUID:        sha1Hex("https://example.com/second"),
+					Title:       "Second",
+					URL:         "https://example.com/second",
+					PublishedAt: date(t, "2006-01-02", "2025-03-01"),
+				},
+			},
+		},
+		{
+			fixture: "atom.xml",
+			want: models.FeedItems{
+				{
+					GUID:        "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a",
+					Title:       "XHTML entry",
+					URL:         "https://example.org/2025/03/05/xhtml",
+					Description: "<p>Escaped &amp; HTML</p>",
+					Content:     `<div xmlns="http://www.w3.org/1999/xhtml"><p>XHTML content</p></div>`,
+					Author:      "Jane Doe",
+					ImageURL:    "https://example.org/cover.jpg",
+					PublishedAt: date(t, time.RFC3339, "2025-03-05T18:30:02+01:00"),
+				},
+				{
+					// Without an ID, the entry is identified by its link, and without
+					// a published date, it's dated when it was updated.
+					GUID:        sha1Hex("https://example.org/2025/03/04/updated"),
+					Title:       "Updated only",
+					URL:         "https://example.org/2025/03/04/updated",
+					Description: "Plain text summary",
+					PublishedAt: date(t, time.RFC3339, "2025-03-04T12:00:00Z"),
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.fixture, func(t *testing.T) {
+			start := time.Now()
+			items, err := Fetch(srv.URL+"/"+c.fixture, time.Second*5)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(items) != len(c.want) {
+				t.Fatalf("got %d items, want %d: %+v", len(items), len(c.want), items)
+			}
+
+			for n, want := range c.want {
+				got := items[n]
+
+				// Items without a date are dated when they're parsed.
+				if want.PublishedAt.IsZero() {
+					if got.PublishedAt.Before(start) || got.PublishedAt.After(time.Now()) {
+						t.Errorf("item %d: got date %v, want the time of parsing", n, got.PublishedAt)
+					}
+					got.PublishedAt = time.Time{}
+				} else if got.PublishedAt.Equal(want.PublishedAt) {
+					got.PublishedAt = want.PublishedAt
+				}
+
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("item %d:\ngot  %+v\nwant %+v", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv := newServer(t)
+
+	if _, err := Fetch(srv.URL+"/missing.xml", time.Second*5); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing feed: got error %v, want a 404 error", err)
+	}
+
+	for _, b := range []string{
+		`not xml`,
+		`<?xml version="1.0"?><html><body>Not a feed</body></html>`,
+		`<?xml version="1.0" encoding="koi8-r"?><rss><channel></channel></rss>`,
+	} {
+		if _, err := Parse([]byte(b)); err == nil {
+			t.Errorf("%q: expected an error", b)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// Pending items, latest first.
+	pending := make([]models.CampaignFeedItem, 5)
+	for n := range pending {
+		pending[n] = models.CampaignFeedItem{ID: 10 - n, Item: models.FeedItem{Title: string(rune('a' + n))}}
+	}
+
+	titles := func(items models.FeedItems) []string {
+		out := []string{}
+		for _, i := range items {
+			out = append(out, i.Title)
+		}
+		return out
+	}
+
+	cases := []struct {
+		name     string
+		pending  []models.CampaignFeedItem
+		min, max int
+		titles   []string
+		ids      []int
+	}{
+		{"below the minimum", pending, 6, 20, []string{}, nil},
+		{"at the minimum", pending, 5, 20, []string{"a", "b", "c", "d", "e"}, []int{10, 9, 8, 7, 6}},
+		{"no minimum", pending, 0, 20, []string{"a", "b", "c", "d", "e"}, []int{10, 9, 8, 7, 6}},
+		{"truncated to the maximum", pending, 1, 2, []string{"a", "b"}, []int{10, 9, 8, 7, 6}},
+		{"no pending items", nil, 0, 20, []string{}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			items, ids := Digest(c.pending, c.min, c.max)
+			if got := titles(items); !reflect.DeepEqual(got, c.titles) {
+				t.Errorf("got items %v, want %v", got, c.titles)
+			}
+			if !reflect.DeepEqual(ids, c.ids) {
+				t.Errorf("got IDs %v, want %v", ids, c.ids)
+			}
+		})
+	}
+}
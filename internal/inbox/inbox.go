@@ -0,0 +1,290 @@
+package inbox
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Inbox is a durable, DB backed inbox for incoming webhooks. Webhooks are acknowledged
+// once they are pushed to the inbox and are processed by a pool of workers. Entries
+// that fail are retried with an exponential backoff and are dead-lettered after
+// MaxAttempts failures.
+type Inbox struct {
+	db  *sqlx.DB
+	cfg Config
+	log *log.Logger
+
+	process func(Entry) error
+
+	// Control channels
+	notify   chan struct{}
+	stopChan chan struct{}
+	doneChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Config holds the inbox configuration.
+type Config struct {
+	// Workers is the number of concurrent workers processing entries.
+	Workers int
+
+	// MaxAttempts is the number of times an entry is tried before it's dead-lettered.
+	MaxAttempts int
+
+	// PollInterval is how often idle workers check for entries that are due.
+	PollInterval time.Duration
+
+	// RetryBackoff is the delay before the first retry. It doubles on every attempt.
+	RetryBackoff time.Duration
+
+	// MaxRetryBackoff caps the delay between retries.
+	MaxRetryBackoff time.Duration
+
+	// StaleTimeout is how long an entry can be in processing before it's considered
+	// abandoned (eg: the app was restarted mid-way) and is picked up again.
+	StaleTimeout time.Duration
+
+	// Retention is how long processed entries are kept.
+	Retention time.Duration
+}
+
+// New creates a new inbox.
+func New(db *sqlx.DB, cfg Config, log *log.Logger) *Inbox {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+
+	return &Inbox{
+		db:       db,
+		cfg:      cfg,
+		log:      log,
+		notify:   make(chan struct{}, cfg.Workers),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// SetProcessCallback sets the callback that processes an entry. An entry whose
+// callback returns an error is retried unless the error is a PermanentError.
+func (in *Inbox) SetProcessCallback(fn func(Entry) error) {
+	in.process = fn
+}
+
+// Push persists a webhook request to the inbox and returns the ID of the entry.
+func (in *Inbox) Push(webhookType string, headers json.RawMessage, body string) (int64, error) {
+	var id int64
+	if err := in.db.Get(&id, `
+		INSERT INTO webhook_inbox (webhook_type, request_headers, request_body)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, webhookType, headers, body); err != nil {
+		return 0, err
+	}
+
+	// Wake up an idle worker.
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+
+	return id, nil
+}
+
+// Start starts the workers and blocks until the inbox is stopped.
+func (in *Inbox) Start() {
+	in.log.Printf("starting webhook inbox with %d worker(s)", in.cfg.Workers)
+
+	for i := 0; i < in.cfg.Workers; i++ {
+		in.wg.Add(1)
+		go in.worker()
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	in.cleanup()
+	for {
+		select {
+		case <-ticker.C:
+			in.cleanup()
+		case <-in.stopChan:
+			in.wg.Wait()
+			in.log.Println("stopped webhook inbox")
+			close(in.doneChan)
+			return
+		}
+	}
+}
+
+// Stop stops the workers and waits for them to finish the entries they are processing.
+func (in *Inbox) Stop() {
+	close(in.stopChan)
+	<-in.doneChan
+}
+
+// GetStats returns the depth and lag of the inbox.
+func (in *Inbox) GetStats() (Stats, error) {
+	var s Stats
+	err := in.db.Get(&s, `
+		SELECT
+			COUNT(*) FILTER (WHERE status = $1) AS pending,
+			COUNT(*) FILTER (WHERE status = $2) AS processing,
+			COUNT(*) FILTER (WHERE status = $3) AS done,
+			COUNT(*) FILTER (WHERE status = $4) AS dead,
+			COUNT(*) FILTER (WHERE status IN ($1, $2)) AS depth,
+			MIN(created_at) FILTER (WHERE status IN ($1, $2)) AS oldest_pending,
+			COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at) FILTER (WHERE status IN ($1, $2))), 0) AS lag_seconds
+		FROM webhook_inbox
+	`, StatusPending, StatusProcessing, StatusDone, StatusDead)
+
+	return s, err
+}
+
+// GetEntries returns the entries of the given status (or all entries if it's empty).
+func (in *Inbox) GetEntries(status string, offset, limit int) ([]Entry, error) {
+	var out []Entry
+	err := in.db.Select(&out, `
+		SELECT COUNT(*) OVER () AS total, webhook_inbox.* FROM webhook_inbox
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id DESC
+		OFFSET $2 LIMIT $3
+	`, status, offset, limit)
+
+	return out, err
+}
+
+// Retry queues a dead-lettered entry to be processed again with a fresh set of attempts.
+// It returns sql.ErrNoRows if there's no such dead-lettered entry.
+func (in *Inbox) Retry(id int64) error {
+	res, err := in.db.Exec(`
+		UPDATE webhook_inbox SET status = $2, attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = $3
+	`, id, StatusPending, StatusDead)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// worker processes entries until the inbox is stopped. It waits for new entries or
+// for the poll interval when there are none that are due.
+func (in *Inbox) worker() {
+	defer in.wg.Done()
+
+	for {
+		select {
+		case <-in.stopChan:
+			return
+		default:
+		}
+
+		e, err := in.next()
+		if err != nil && err != sql.ErrNoRows {
+			in.log.Printf("error fetching webhook inbox entry: %v", err)
+		}
+
+		if err == nil {
+			in.handle(e)
+			continue
+		}
+
+		select {
+		case <-in.notify:
+		case <-time.After(in.cfg.PollInterval):
+		case <-in.stopChan:
+			return
+		}
+	}
+}
+
+// next claims the next entry that is due for processing.
+func (in *Inbox) next() (Entry, error) {
+	var e Entry
+	err := in.db.Get(&e, `
+		UPDATE webhook_inbox SET status = $1, attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM webhook_inbox
+			WHERE (status = $2 AND next_attempt_at <= NOW())
+			OR (status = $1 AND updated_at < NOW() - MAKE_INTERVAL(secs => $3))
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *
+	`, StatusProcessing, StatusPending, in.cfg.StaleTimeout.Seconds())
+
+	return e, err
+}
+
+// handle processes an entry and records the outcome.
+func (in *Inbox) handle(e Entry) {
+	err := in.process(e)
+	if err == nil {
+		if _, err := in.db.Exec(`
+			UPDATE webhook_inbox SET status = $2, last_error = NULL, processed_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+		`, e.ID, StatusDone); err != nil {
+			in.log.Printf("error marking webhook inbox entry %d as done: %v", e.ID, err)
+		}
+		return
+	}
+
+	// Dead-letter the entry or schedule a retry.
+	var perm *PermanentError
+	if errors.As(err, &perm) || e.Attempts >= in.cfg.MaxAttempts {
+		in.log.Printf("dead-lettering %s webhook inbox entry %d after %d attempt(s): %v", e.WebhookType, e.ID, e.Attempts, err)
+		if _, err := in.db.Exec(`
+			UPDATE webhook_inbox SET status = $2, last_error = $3, updated_at = NOW()
+			WHERE id = $1
+		`, e.ID, StatusDead, err.Error()); err != nil {
+			in.log.Printf("error dead-lettering webhook inbox entry %d: %v", e.ID, err)
+		}
+		return
+	}
+
+	backoff := in.cfg.RetryBackoff << (e.Attempts - 1)
+	if backoff > in.cfg.MaxRetryBackoff || backoff <= 0 {
+		backoff = in.cfg.MaxRetryBackoff
+	}
+
+	if _, err := in.db.Exec(`
+		UPDATE webhook_inbox SET status = $2, last_error = $3, next_attempt_at = NOW() + MAKE_INTERVAL(secs => $4), updated_at = NOW()
+		WHERE id = $1
+	`, e.ID, StatusPending, err.Error(), backoff.Seconds()); err != nil {
+		in.log.Printf("error scheduling retry of webhook inbox entry %d: %v", e.ID, err)
+	}
+}
+
+// cleanup deletes processed entries past the retention period.
+func (in *Inbox) cleanup() {
+	res, err := in.db.Exec(`
+		DELETE FROM webhook_inbox WHERE status = $1 AND processed_at < NOW() - MAKE_INTERVAL(secs => $2)
+	`, StatusDone, in.cfg.Retention.Seconds())
+	if err != nil {
+		in.log.Printf("error cleaning up webhook inbox: %v", err)
+		return
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		in.log.Printf("deleted %d processed webhook inbox entries", n)
+	}
+}
@@ -0,0 +1,64 @@
+package inbox
+
+import (
+	"encoding/json"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Inbox entry statuses.
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusDone       = "done"
+	StatusDead       = "dead"
+)
+
+// Entry represents a webhook request persisted to the inbox.
+type Entry struct {
+	ID             int64           `db:"id" json:"id"`
+	WebhookType    string          `db:"webhook_type" json:"webhook_type"`
+	RequestHeaders json.RawMessage `db:"request_headers" json:"request_headers"`
+	RequestBody    string          `db:"request_body" json:"request_body"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	LastError      null.String     `db:"last_error" json:"last_error"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	ProcessedAt    null.Time       `db:"processed_at" json:"processed_at"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time       `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total count in queries.
+	Total int `db:"total" json:"-"`
+}
+
+// Stats provides the depth and lag of the inbox.
+type Stats struct {
+	Pending    int `db:"pending" json:"pending"`
+	Processing int `db:"processing" json:"processing"`
+	Done       int `db:"done" json:"done"`
+	Dead       int `db:"dead" json:"dead"`
+
+	// Depth is the number of entries waiting to be processed (pending + processing).
+	Depth int `db:"depth" json:"depth"`
+
+	// Lag is the age in seconds of the oldest entry waiting to be processed.
+	OldestPending null.Time `db:"oldest_pending" json:"oldest_pending"`
+	LagSeconds    float64   `db:"lag_seconds" json:"lag_seconds"`
+}
+
+// PermanentError is returned by the process callback for entries that will never
+// succeed. They are dead-lettered without being retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent wraps an error as a PermanentError.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net/mail"
 	"net/textproto"
 	"strings"
 	"sync"
@@ -36,6 +37,7 @@ type Store interface {
 	NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error)
 	NextSubscribers(campID, limit int) ([]models.Subscriber, error)
 	GetCampaign(campID int) (*models.Campaign, error)
+	GetCampaignVariant(campID, variantID int) (models.CampaignVariant, error)
 	GetSubscriber(id int, uuid, email string) (models.Subscriber, error)
 	GetSettings() (models.Settings, error)
 	GetAttachment(mediaID int) (models.Attachment, error)
@@ -127,6 +129,9 @@ type CampaignMessage struct {
 	altBody  []byte
 	unsubURL string
 
+	// Number of tracked links rendered in the message so far.
+	links int
+
 	pipe *pipe
 }
 
@@ -230,13 +235,25 @@ func (m *Manager) PushMessage(msg models.Message) error {
 // PushCampaignMessageByID creates and sends a campaign message for a specific subscriber
 // This is used by the queue processor to send individual emails via a specific SMTP server
 // It sends directly through the messenger without using the campaign manager's queue
-func (m *Manager) PushCampaignMessageByID(campaignID int, subscriberID int, serverUUID string) error {
+// If variantID is set, the campaign's A/B test variant overrides the campaign's content.
+func (m *Manager) PushCampaignMessageByID(campaignID int, subscriberID int, variantID int, serverUUID string) error {
 	// Get the campaign
 	camp, err := m.store.GetCampaign(campaignID)
 	if err != nil {
 		return fmt.Errorf("error fetching campaign %d: %w", campaignID, err)
 	}
 
+	// Apply the A/B test variant the subscriber was assigned to.
+	var variant models.CampaignVariant
+	if variantID > 0 {
+		v, err := m.store.GetCampaignVariant(campaignID, variantID)
+		if err != nil {
+			return fmt.Errorf("error fetching variant %d of campaign %d: %w", variantID, campaignID, err)
+		}
+		camp.ApplyVariant(v)
+		variant = v
+	}
+
 	// Compile the campaign template
 	if err := camp.CompileTemplate(m.TemplateFuncs(camp)); err != nil {
 		return fmt.Errorf("error compiling template for campaign %d: %w", campaignID, err)
@@ -283,6 +300,11 @@ func (m *Manager) PushCampaignMessageByID(campaignID int, subscriberID int, serv
 
 	// Convert CampaignMessage to models.Message for sending
 	// Use the SMTP server's from_email instead of the campaign's from_email
+	// A variant's from address can only change the sender's display name.
+	if variant.FromEmail != "" {
+		serverFromEmail = withFromName(serverFromEmail, variant.FromEmail)
+	}
+
 	out := models.Message{
 		From:        serverFromEmail,
 		To:          []string{msg.to},
@@ -331,6 +353,28 @@ func (m *Manager) PushCampaignMessageByID(campaignID int, subscriberID int, serv
 	return nil
 }
 
+// PushMessageByServer sends a message, eg: a queued tx message, directly through the
+// messenger of a specific SMTP server. As with campaign messages, the server's from_email
+// is the sender, with the display name of the message's from address.
+func (m *Manager) PushMessageByServer(msg models.Message, serverUUID string) error {
+	serverName, _, serverFromEmail, err := m.getServerInfoByUUID(serverUUID)
+	if err != nil {
+		return fmt.Errorf("error getting server info for server %s: %w", serverUUID, err)
+	}
+
+	messenger, exists := m.messengers[serverName]
+	if !exists {
+		return fmt.Errorf("messenger '%s' not found for server %s", serverName, serverUUID)
+	}
+
+	msg.From = withFromName(serverFromEmail, msg.From)
+	if err := messenger.Push(msg); err != nil {
+		return fmt.Errorf("error sending message '%s' to %v: %w", msg.Subject, msg.To, err)
+	}
+
+	return nil
+}
+
 // getServerInfoByUUID looks up an SMTP server by UUID and returns its name, username, and configured from_email
 // The name is used to select the correct messenger, from_email is used as the message sender,
 // and username is logged to verify correct SMTP authentication
@@ -359,6 +403,23 @@ func (m *Manager) getServerInfoByUUID(serverUUID string) (name string, username
 	return "", "", "", fmt.Errorf("SMTP server with UUID %s not found", serverUUID)
 }
 
+// withFromName returns the address of a server's from e-mail with the display name
+// of another from e-mail, eg: a campaign variant's. If the latter has no display name,
+// the server's from e-mail is returned as is.
+func withFromName(serverFrom, from string) string {
+	addr, err := mail.ParseAddress(serverFrom)
+	if err != nil {
+		return serverFrom
+	}
+
+	f, err := mail.ParseAddress(from)
+	if err != nil || f.Name == "" {
+		return serverFrom
+	}
+
+	return (&mail.Address{Name: f.Name, Address: addr.Address}).String()
+}
+
 // PushCampaignMessage pushes a campaign messages into a queue to be sent out by the workers.
 // It times out if the queue is busy.
 func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
@@ -493,7 +554,18 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 				subUUID = dummyUUID
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			// Tag the tracking link with its position in the message for utm_content.
+			msg.links++
+			out, ok := m.trackLink(url, msg.Campaign.UUID, subUUID)
+			if ok && msg.Campaign.UTM.Enabled && msg.Campaign.UTM.Content {
+				sep := "?"
+				if strings.Contains(out, "?") {
+					sep = "&"
+				}
+				out += fmt.Sprintf("%spos=%d", sep, msg.links)
+			}
+
+			return out
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
 			subUUID := msg.Subscriber.UUID
@@ -711,14 +783,15 @@ func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
 }
 
 // trackLink register a URL and return its UUID to be used in message templates
-// for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+// for tracking links. If the URL can't be registered, the URL itself is returned
+// and ok is false.
+func (m *Manager) trackLink(url, campUUID, subUUID string) (string, bool) {
 	url = strings.ReplaceAll(url, "&amp;", "&")
 
 	m.linksMut.RLock()
 	if uu, ok := m.links[url]; ok {
 		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID), true
 	}
 	m.linksMut.RUnlock()
 
@@ -728,14 +801,14 @@ func (m *Manager) trackLink(url, campUUID, subUUID string) string {
 		m.log.Printf("error registering tracking for link '%s': %v", url, err)
 
 		// If the registration fails, fail over to the original URL.
-		return url
+		return url, false
 	}
 
 	m.linksMut.Lock()
 	m.links[url] = uu
 	m.linksMut.Unlock()
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID), true
 }
 
 // sendNotif sends a notification to registered admin e-mails.
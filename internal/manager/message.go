@@ -32,6 +32,7 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 // and applies the resultant bytes to Message.body to be used in messages.
 func (m *CampaignMessage) render() error {
 	out := bytes.Buffer{}
+	m.links = 0
 
 	// Render the subject if it's a template.
 	if m.Campaign.SubjectTpl != nil {
@@ -82,3 +83,9 @@ func (m *CampaignMessage) AltBody() []byte {
 	copy(out, m.altBody)
 	return out
 }
+
+// Items returns the feed items of the campaign, which are available in the
+// templates of the digest campaigns of feeds as .Items.
+func (m *CampaignMessage) Items() models.FeedItems {
+	return m.Campaign.FeedItems
+}
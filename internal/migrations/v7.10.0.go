@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_10_0 adds Shopify refunds and cancellations to purchase attributions so that
+// gross and net revenue can be reported separately.
+func V7_10_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.10.0: purchase refunds and cancellations")
+
+	// Net price is the gross price less refunds, or 0 if the order was cancelled.
+	if _, err := db.Exec(`
+		ALTER TABLE purchase_attributions
+			ADD COLUMN IF NOT EXISTS refunded_amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP WITH TIME ZONE NULL,
+			ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+
+		ALTER TABLE purchase_attributions
+			ADD COLUMN IF NOT EXISTS net_price DECIMAL(10,2) GENERATED ALWAYS AS (
+				CASE WHEN cancelled_at IS NULL THEN GREATEST(COALESCE(total_price, 0) - refunded_amount, 0) ELSE 0 END
+			) STORED;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS purchase_refunds (
+			id                BIGSERIAL PRIMARY KEY,
+			purchase_id       BIGINT NOT NULL REFERENCES purchase_attributions(id) ON DELETE CASCADE,
+			refund_id         TEXT NOT NULL,
+			amount            DECIMAL(10,2) NOT NULL DEFAULT 0,
+			currency          TEXT,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(purchase_id, refund_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.10.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_11_0 adds the Shopify customer sync settings.
+func V7_11_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.11.0: shopify customer sync")
+
+	// Existing values take precedence over the defaults.
+	if _, err := db.Exec(`
+		UPDATE settings SET value = '{"sync_customers": false, "sync_lists": [], "shop_url": "", "access_token": "", "api_version": "2024-10"}'::JSONB || value
+			WHERE key = 'shopify';
+	`); err != nil {
+		return err
+	}
+
+	// Synced subscribers are looked up by their Shopify customer ID.
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_subs_shopify_customer_id ON subscribers ((attribs->'shopify'->>'customer_id'));
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.11.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_12_0 adds the exchange_rates table and the exchange rate of every purchase
+// to its reporting currency for normalizing revenue across currencies.
+func V7_12_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.12.0: multi-currency revenue normalization")
+
+	// Rates are units of the currency per unit of the base currency, which has the rate 1.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			currency          VARCHAR(3) NOT NULL PRIMARY KEY,
+			rate              NUMERIC(20,10) NOT NULL CHECK (rate > 0),
+			base              VARCHAR(3) NOT NULL,
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	// exchange_rate() returns the rate for converting an amount in a currency to another
+	// with the current rates, or NULL if either of the currencies has no rate.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION exchange_rate(from_cur TEXT, to_cur TEXT) RETURNS NUMERIC AS $$
+			SELECT CASE WHEN UPPER(from_cur) = UPPER(to_cur) THEN 1
+				ELSE (SELECT t.rate / f.rate FROM exchange_rates f, exchange_rates t
+					WHERE f.currency = UPPER(from_cur) AND t.currency = UPPER(to_cur))
+			END;
+		$$ LANGUAGE SQL STABLE;
+	`); err != nil {
+		return err
+	}
+
+	// The rate is stored at order time. Purchases without a rate aren't included in normalized totals.
+	if _, err := db.Exec(`
+		ALTER TABLE purchase_attributions ADD COLUMN IF NOT EXISTS reporting_currency VARCHAR(3) NULL;
+		ALTER TABLE purchase_attributions ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(20,10) NULL;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		UPDATE settings SET value = '{"reporting_currency": "USD", "exchange_rates_source": ""}'::JSONB || value
+			WHERE key = 'shopify';
+	`); err != nil {
+		return err
+	}
+
+	// Existing purchases in the reporting currency need no conversion. The rest are
+	// normalized once rates are loaded.
+	if _, err := db.Exec(`
+		UPDATE purchase_attributions SET reporting_currency = 'USD', exchange_rate = 1
+			WHERE UPPER(COALESCE(currency, 'USD')) = 'USD';
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.12.0 completed successfully")
+	return nil
+}
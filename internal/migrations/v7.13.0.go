@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_13_0 adds the source (e-commerce provider) of purchases and the settings of
+// the WooCommerce and Stripe order webhooks.
+func V7_13_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.13.0: generic e-commerce orders")
+
+	// All existing purchases are from Shopify.
+	if _, err := db.Exec(`
+		ALTER TABLE purchase_attributions ADD COLUMN IF NOT EXISTS source VARCHAR(50) NOT NULL DEFAULT 'shopify';
+		CREATE INDEX IF NOT EXISTS idx_purchases_source_order_id ON purchase_attributions(source, order_id);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+		('woocommerce', '{"enabled": false, "webhook_secret": ""}'),
+		('stripe', '{"enabled": false, "webhook_secret": ""}')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.13.0 completed successfully")
+	return nil
+}
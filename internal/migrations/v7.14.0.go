@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_14_0 adds the purchase_line_items table with the products of purchases and
+// backfills it from the stored Shopify and WooCommerce order payloads.
+func V7_14_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.14.0: purchase line items")
+
+	// handle is the product's URL slug (/products/{handle}), which is matched against
+	// the URLs of tracked links.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS purchase_line_items (
+			id                BIGSERIAL PRIMARY KEY,
+			purchase_id       BIGINT NOT NULL REFERENCES purchase_attributions(id) ON DELETE CASCADE,
+			line_id           TEXT NOT NULL DEFAULT '',
+			product_id        TEXT NOT NULL DEFAULT '',
+			variant_id        TEXT NOT NULL DEFAULT '',
+			sku               TEXT NOT NULL DEFAULT '',
+			title             TEXT NOT NULL DEFAULT '',
+			handle            TEXT NOT NULL DEFAULT '',
+			quantity          INTEGER NOT NULL DEFAULT 1,
+			price             DECIMAL(12,2) NOT NULL DEFAULT 0,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_purchase_line_items_purchase ON purchase_line_items(purchase_id);
+		CREATE INDEX IF NOT EXISTS idx_purchase_line_items_product ON purchase_line_items(product_id);
+		CREATE INDEX IF NOT EXISTS idx_purchase_line_items_handle ON purchase_line_items(handle);
+	`); err != nil {
+		return err
+	}
+
+	// Shopify and WooCommerce line items have no handle. It's derived from the title.
+	if _, err := db.Exec(`
+		INSERT INTO purchase_line_items (purchase_id, line_id, product_id, variant_id, sku, title, handle, quantity, price)
+			SELECT p.id, COALESCE(li->>'id', ''), COALESCE(li->>'product_id', ''),
+				COALESCE(li->>'variant_id', li->>'variation_id', ''), COALESCE(li->>'sku', ''),
+				COALESCE(li->>'title', li->>'name', ''),
+				TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(COALESCE(li->>'title', li->>'name', '')), '[^a-z0-9]+', '-', 'g')),
+				COALESCE((li->>'quantity')::INT, 1), COALESCE((li->>'price')::NUMERIC, 0)
+			FROM purchase_attributions p
+			CROSS JOIN JSONB_ARRAY_ELEMENTS(p.shopify_data->'line_items') AS li
+			WHERE p.source IN ('shopify', 'woocommerce') AND JSONB_TYPEOF(p.shopify_data->'line_items') = 'array'
+				AND NOT EXISTS (SELECT 1 FROM purchase_line_items WHERE purchase_id = p.id);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.14.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_15_0 adds commerce flows, which send transactional messages and change the
+// list subscriptions of subscribers on abandoned checkouts and purchases, and the
+// jobs table that schedules them.
+func V7_15_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.15.0: commerce flows")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS commerce_flows (
+			id                SERIAL PRIMARY KEY,
+			name              TEXT NOT NULL,
+			trigger           VARCHAR(50) NOT NULL CHECK (trigger IN ('checkout_abandoned', 'order_placed')),
+			delay_minutes     INTEGER NOT NULL DEFAULT 0 CHECK (delay_minutes >= 0),
+			template_id       INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			subject           TEXT NOT NULL DEFAULT '',
+			add_list_ids      INTEGER[] NOT NULL DEFAULT '{}',
+			remove_list_ids   INTEGER[] NOT NULL DEFAULT '{}',
+			enabled           BOOLEAN NOT NULL DEFAULT true,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+
+		-- ref is the checkout token of abandoned checkouts and source:order_id of orders.
+		CREATE TABLE IF NOT EXISTS commerce_flow_jobs (
+			id                BIGSERIAL PRIMARY KEY,
+			flow_id           INTEGER NOT NULL REFERENCES commerce_flows(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			ref               TEXT NOT NULL,
+			email             TEXT NOT NULL,
+			data              JSONB NOT NULL DEFAULT '{}',
+			status            VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'sent', 'cancelled', 'skipped', 'failed')),
+			error             TEXT NOT NULL DEFAULT '',
+			run_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE (flow_id, ref)
+		);
+		CREATE INDEX IF NOT EXISTS idx_commerce_flow_jobs_status_run_at ON commerce_flow_jobs(status, run_at);
+		CREATE INDEX IF NOT EXISTS idx_commerce_flow_jobs_email ON commerce_flow_jobs(LOWER(email));
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.15.0 completed successfully")
+	return nil
+}
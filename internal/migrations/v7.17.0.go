@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_17_0 adds campaign variants and A/B tests, where the variants of a campaign are
+// sent to a test slice of its audience through the e-mail queue and the winner is sent
+// to the rest.
+func V7_17_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.17.0: campaign variants and A/B tests")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_variants (
+			id                SERIAL PRIMARY KEY,
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			name              TEXT NOT NULL,
+
+			-- Empty or NULL values are inherited from the campaign.
+			subject           TEXT NOT NULL DEFAULT '',
+			from_email        TEXT NOT NULL DEFAULT '',
+			body              TEXT NULL,
+			template_id       INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE (campaign_id, name)
+		);
+
+		ALTER TABLE campaigns
+			ADD COLUMN IF NOT EXISTS ab_test JSONB NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS ab_test_ends_at TIMESTAMP WITH TIME ZONE NULL,
+			ADD COLUMN IF NOT EXISTS ab_winner_id INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL,
+			ADD COLUMN IF NOT EXISTS ab_winner_sent_at TIMESTAMP WITH TIME ZONE NULL;
+
+		ALTER TABLE email_queue ADD COLUMN IF NOT EXISTS variant_id INTEGER NULL REFERENCES campaign_variants(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_email_queue_variant_id ON email_queue(campaign_id, variant_id);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.17.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_18_0 adds sequences, automations of steps that are run on subscribers from when
+// they join a list, and the sequence_subscribers table that records the progress of each
+// subscriber in a sequence.
+func V7_18_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.18.0: sequences")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS sequences (
+			id                SERIAL PRIMARY KEY,
+			name              TEXT NOT NULL,
+			list_id           INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			steps             JSONB NOT NULL DEFAULT '[]',
+			enabled           BOOLEAN NOT NULL DEFAULT true,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sequences_list_id ON sequences(list_id);
+
+		-- step is the index of the next step to run at next_run_at. last_campaign_id and
+		-- last_sent_at are of the last send step, for the branches on opens and clicks.
+		CREATE TABLE IF NOT EXISTS sequence_subscribers (
+			sequence_id       INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			step              INTEGER NOT NULL DEFAULT 0,
+			status            VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'running', 'completed', 'exited', 'failed')),
+			next_run_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			last_campaign_id  INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL,
+			last_sent_at      TIMESTAMP WITH TIME ZONE NULL,
+			error             TEXT NOT NULL DEFAULT '',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			PRIMARY KEY (sequence_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_sequence_subscribers_status_run_at ON sequence_subscribers(status, next_run_at);
+	`); err != nil {
+		return err
+	}
+
+	// Subscribers enter the enabled sequences of a list when they join it, whether by the API,
+	// an import, an opt-in confirmation or another automation. Subscriptions to double opt-in
+	// lists are entered on confirmation. A subscriber enters a sequence only once.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION enter_sequences() RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP = 'UPDATE' AND OLD.status = NEW.status THEN
+				RETURN NULL;
+			END IF;
+
+			INSERT INTO sequence_subscribers (sequence_id, subscriber_id)
+				SELECT s.id, NEW.subscriber_id FROM sequences s
+				JOIN lists l ON (l.id = s.list_id)
+				WHERE s.list_id = NEW.list_id AND s.enabled = true AND NEW.status != 'unsubscribed'
+					AND (l.optin != 'double' OR NEW.status = 'confirmed')
+			ON CONFLICT (sequence_id, subscriber_id) DO NOTHING;
+
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS trg_enter_sequences ON subscriber_lists;
+		CREATE TRIGGER trg_enter_sequences AFTER INSERT OR UPDATE OF status ON subscriber_lists
+			FOR EACH ROW EXECUTE FUNCTION enter_sequences();
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.18.0 completed successfully")
+	return nil
+}
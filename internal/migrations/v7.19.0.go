@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_19_0 adds subscriber events that are tracked by the API, event triggers that send
+// tx templates or enter subscribers into sequences on matching events, and the jobs table
+// that schedules them.
+func V7_19_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.19.0: event triggers")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_events (
+			id                BIGSERIAL PRIMARY KEY,
+			subscriber_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			name              TEXT NOT NULL,
+			properties        JSONB NOT NULL DEFAULT '{}',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_subscriber_events_sub ON subscriber_events(subscriber_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_subscriber_events_name ON subscriber_events(name, created_at);
+
+		-- filters is matched when the properties of an event contain it. A trigger is run at
+		-- most cap_count times for a subscriber in cap_minutes (ever, if 0). 0 cap_count is no cap.
+		CREATE TABLE IF NOT EXISTS event_triggers (
+			id                SERIAL PRIMARY KEY,
+			name              TEXT NOT NULL,
+			event             TEXT NOT NULL,
+			filters           JSONB NOT NULL DEFAULT '{}',
+			delay_minutes     INTEGER NOT NULL DEFAULT 0 CHECK (delay_minutes >= 0),
+			template_id       INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			subject           TEXT NOT NULL DEFAULT '',
+			sequence_id       INTEGER NULL REFERENCES sequences(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			cap_count         INTEGER NOT NULL DEFAULT 0 CHECK (cap_count >= 0),
+			cap_minutes       INTEGER NOT NULL DEFAULT 0 CHECK (cap_minutes >= 0),
+			enabled           BOOLEAN NOT NULL DEFAULT true,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_triggers_event ON event_triggers(event);
+
+		CREATE TABLE IF NOT EXISTS event_trigger_jobs (
+			id                BIGSERIAL PRIMARY KEY,
+			trigger_id        INTEGER NOT NULL REFERENCES event_triggers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			event_id          BIGINT NOT NULL REFERENCES subscriber_events(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			status            VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'sent', 'skipped', 'failed')),
+			error             TEXT NOT NULL DEFAULT '',
+			run_at            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_trigger_jobs_status_run_at ON event_trigger_jobs(status, run_at);
+		CREATE INDEX IF NOT EXISTS idx_event_trigger_jobs_trigger_sub ON event_trigger_jobs(trigger_id, subscriber_id, created_at);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.19.0 completed successfully")
+	return nil
+}
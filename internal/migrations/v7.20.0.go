@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_20_0 adds recurring campaigns, cron schedules on which a campaign is cloned into
+// a new campaign and started, and the campaign_occurrences table that records the
+// campaigns of each occurrence.
+func V7_20_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.20.0: recurring campaigns")
+
+	if _, err := db.Exec(`
+		-- campaign_id is the campaign that's cloned on every occurrence. next_run_at is the
+		-- next occurrence of the cron expression in the timezone.
+		CREATE TABLE IF NOT EXISTS campaign_recurrences (
+			id                SERIAL PRIMARY KEY,
+			name              TEXT NOT NULL,
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			cron              TEXT NOT NULL,
+			timezone          TEXT NOT NULL DEFAULT 'UTC',
+			name_format       TEXT NOT NULL DEFAULT '{name} {date}',
+			skip_previous     BOOLEAN NOT NULL DEFAULT false,
+			enabled           BOOLEAN NOT NULL DEFAULT true,
+			next_run_at       TIMESTAMP WITH TIME ZONE NULL,
+			last_run_at       TIMESTAMP WITH TIME ZONE NULL,
+			error             TEXT NOT NULL DEFAULT '',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_campaign_recurrences_next_run_at ON campaign_recurrences(next_run_at);
+
+		-- If skip_previous is set, the recipients of previous_campaign_id aren't sent campaign_id.
+		CREATE TABLE IF NOT EXISTS campaign_occurrences (
+			campaign_id          INTEGER NOT NULL PRIMARY KEY REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			recurrence_id        INTEGER NOT NULL REFERENCES campaign_recurrences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			previous_campaign_id INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			skip_previous        BOOLEAN NOT NULL DEFAULT false,
+			run_at               TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at           TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_campaign_occurrences_recurrence ON campaign_occurrences(recurrence_id, run_at);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.20.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_21_0 adds RSS and Atom feeds to recurring campaigns. The items of a feed that are
+// seen on polls are recorded in campaign_feed_items and the new items are sent in
+// digest campaigns that have them in campaigns.feed_items.
+func V7_21_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.21.0: feed digest campaigns")
+
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS feed_items JSONB NOT NULL DEFAULT '[]';
+
+		ALTER TABLE campaign_recurrences ADD COLUMN IF NOT EXISTS feed_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaign_recurrences ADD COLUMN IF NOT EXISTS feed_min_items INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE campaign_recurrences ADD COLUMN IF NOT EXISTS feed_max_items INTEGER NOT NULL DEFAULT 20;
+
+		-- sent_at is set when the item is sent in a digest campaign (campaign_id). Items
+		-- that haven't been sent are pending until there are enough of them for a digest.
+		CREATE TABLE IF NOT EXISTS campaign_feed_items (
+			id                SERIAL PRIMARY KEY,
+			recurrence_id     INTEGER NOT NULL REFERENCES campaign_recurrences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			guid              TEXT NOT NULL,
+			item              JSONB NOT NULL DEFAULT '{}',
+			published_at      TIMESTAMP WITH TIME ZONE NOT NULL,
+			campaign_id       INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			sent_at           TIMESTAMP WITH TIME ZONE NULL,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE (recurrence_id, guid)
+		);
+		CREATE INDEX IF NOT EXISTS idx_campaign_feed_items_pending ON campaign_feed_items(recurrence_id, published_at) WHERE sent_at IS NULL;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.21.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_22_0 adds resends of campaigns to their non-openers. A resend is a child campaign
+// that's only sent to the recipients of its parent who haven't opened it.
+func V7_22_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.22.0: campaign resends to non-openers")
+
+	if _, err := db.Exec(`
+		-- The rule for automatically resending a campaign to its non-openers when it finishes.
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS resend JSONB NOT NULL DEFAULT '{}';
+
+		-- campaign_id is the resend (child) campaign of parent_id. An automatic resend is recorded
+		-- before its campaign is created to claim it. If the parent is deleted, the resend
+		-- is sent to no one.
+		CREATE TABLE IF NOT EXISTS campaign_resends (
+			id                SERIAL PRIMARY KEY,
+			parent_id         INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			campaign_id       INTEGER NULL UNIQUE REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			after_hours       INTEGER NOT NULL DEFAULT 0,
+			auto              BOOLEAN NOT NULL DEFAULT false,
+			error             TEXT NOT NULL DEFAULT '',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_campaign_resends_parent ON campaign_resends(parent_id);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_campaign_resends_auto ON campaign_resends(parent_id) WHERE auto;
+	`); err != nil {
+		return err
+	}
+
+	// campaign_resend_excluded() is true if a campaign is a resend and the subscriber isn't a
+	// non-opener of its parent, that is, the subscriber wasn't sent the parent (e-mails of
+	// queue campaigns that were sent, or subscribers up to the last one the parent's pipe
+	// processed, outside its holdout) or has opened it (campaign views or Azure opens).
+	// For other campaigns, it's always false.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION campaign_resend_excluded(camp_id INTEGER, sub_id INTEGER) RETURNS BOOLEAN AS $$
+			SELECT EXISTS (
+				SELECT 1 FROM campaign_resends r
+				LEFT JOIN campaigns p ON (p.id = r.parent_id)
+				WHERE r.campaign_id = camp_id AND (
+					p.id IS NULL
+					OR NOT (
+						CASE WHEN COALESCE(p.use_queue, false) THEN
+							EXISTS (SELECT 1 FROM email_queue eq WHERE eq.campaign_id = p.id AND eq.subscriber_id = sub_id AND eq.status = 'sent')
+						ELSE
+							sub_id <= p.last_subscriber_id AND NOT EXISTS (
+								SELECT 1 FROM campaign_cohorts cc WHERE cc.campaign_id = p.id AND cc.subscriber_id = sub_id AND cc.holdout
+							)
+						END
+					)
+					OR EXISTS (SELECT 1 FROM campaign_views v WHERE v.campaign_id = p.id AND v.subscriber_id = sub_id)
+					OR EXISTS (
+						SELECT 1 FROM azure_engagement_events e
+						WHERE e.campaign_id = p.id AND e.subscriber_id = sub_id AND e.engagement_type = 'open'
+					)
+				)
+			);
+		$$ LANGUAGE SQL STABLE;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.22.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_23_0 adds exclusion lists and segment queries to campaigns, and audience snapshots,
+// the subscribers a campaign targeted, frozen when it starts.
+func V7_23_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.23.0: campaign exclusion lists and audience snapshots")
+
+	if _, err := db.Exec(`
+		-- Subscribers on exclude_list_ids aren't sent the campaign. segment_query is an arbitrary
+		-- SQL expression on subscribers that the audience is filtered by. Campaigns with a
+		-- segment_query or audience_snapshot are only sent to the snapshot of their audience that's
+		-- taken when they start (audience_snapshot_at).
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS exclude_list_ids INTEGER[] NOT NULL DEFAULT '{}';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS segment_query TEXT NOT NULL DEFAULT '';
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS audience_snapshot BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS audience_snapshot_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS audience_size INTEGER NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS campaign_audience (
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id     INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			PRIMARY KEY (campaign_id, subscriber_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	// campaign_audience_excluded() is true if a subscriber isn't in the audience of a campaign,
+	// that is, the campaign has an audience snapshot that the subscriber isn't in, or it doesn't
+	// have one and the subscriber is subscribed to one of its exclusion lists. The lists and
+	// statuses of the campaign's (included) lists are checked by the queries that send it.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION campaign_audience_excluded(camp_id INTEGER, sub_id INTEGER) RETURNS BOOLEAN AS $$
+			SELECT EXISTS (
+				SELECT 1 FROM campaigns c
+				WHERE c.id = camp_id AND (
+					CASE WHEN c.audience_snapshot_at IS NOT NULL THEN
+						NOT EXISTS (SELECT 1 FROM campaign_audience a WHERE a.campaign_id = c.id AND a.subscriber_id = sub_id)
+					ELSE
+						CARDINALITY(c.exclude_list_ids) > 0 AND EXISTS (
+							SELECT 1 FROM subscriber_lists sl
+							WHERE sl.subscriber_id = sub_id AND sl.list_id = ANY(c.exclude_list_ids) AND sl.status != 'unsubscribed'
+						)
+					END
+				)
+			);
+		$$ LANGUAGE SQL STABLE;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.23.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_24_0 adds the approval workflow of campaigns, the pending_review and approved campaign
+// statuses, and the campaign_reviews table that records submissions, approvals, rejections
+// and comments of reviewers.
+func V7_24_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.24.0: campaign approval workflow")
+
+	// Enum values can't be added in a transaction block on older versions of Postgres,
+	// so they're added one statement at a time.
+	for _, s := range []string{"pending_review", "approved"} {
+		if _, err := db.Exec(`ALTER TYPE campaign_status ADD VALUE IF NOT EXISTS '` + s + `'`); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec(`
+		-- review_content is the content of the campaign that was submitted for review, and is
+		-- approved once approvals_required reviewers have approved it (approved_at).
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS review_content JSONB NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approvals_required INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approved_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS submitted_by INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+		-- action is one of submitted, approved, rejected, commented or reset (edited after submission).
+		-- Approvals count towards the latest submission.
+		CREATE TABLE IF NOT EXISTS campaign_reviews (
+			id                SERIAL PRIMARY KEY,
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			user_id           INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			user_name         TEXT NOT NULL DEFAULT '',
+			action            TEXT NOT NULL,
+			comment           TEXT NOT NULL DEFAULT '',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_campaign_reviews_campaign ON campaign_reviews(campaign_id, id);
+
+		-- Approval is off by default. Campaigns whose audience is over the threshold (if it's
+		-- set) need approvals from two reviewers.
+		INSERT INTO settings (key, value) VALUES
+			('app.campaign_approval', 'false'),
+			('app.campaign_approval_threshold', '0')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.24.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_25_0 adds the version history of the content of campaigns and templates. Every save
+// records a version, and the version of a campaign that was sent is recorded when it starts.
+func V7_25_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.25.0: campaign and template versions")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_versions (
+			id                SERIAL PRIMARY KEY,
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			version           INTEGER NOT NULL,
+			user_id           INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			user_name         TEXT NOT NULL DEFAULT '',
+			subject           TEXT NOT NULL,
+			body              TEXT NOT NULL,
+			body_source       TEXT NULL,
+			altbody           TEXT NULL,
+			content_type      content_type NOT NULL DEFAULT 'richtext',
+			template_id       INTEGER NULL REFERENCES templates(id) ON DELETE SET NULL,
+			headers           JSONB NOT NULL DEFAULT '[]',
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(campaign_id, version)
+		);
+
+		-- The version of the campaign's content that was sent, recorded when it starts.
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS sent_version INTEGER NULL;
+
+		CREATE TABLE IF NOT EXISTS template_versions (
+			id                SERIAL PRIMARY KEY,
+			template_id       INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			version           INTEGER NOT NULL,
+			user_id           INTEGER NULL REFERENCES users(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			user_name         TEXT NOT NULL DEFAULT '',
+			name              TEXT NOT NULL,
+			subject           TEXT NOT NULL,
+			body              TEXT NOT NULL,
+			body_source       TEXT NULL,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(template_id, version)
+		);
+
+		-- The current content of existing campaigns and templates is their first version.
+		INSERT INTO campaign_versions (campaign_id, version, subject, body, body_source, altbody, content_type, template_id, headers, created_at)
+			SELECT id, 1, subject, body, body_source, altbody, content_type, template_id, headers, COALESCE(updated_at, NOW()) FROM campaigns
+			ON CONFLICT (campaign_id, version) DO NOTHING;
+		INSERT INTO template_versions (template_id, version, name, subject, body, body_source, created_at)
+			SELECT id, 1, name, subject, body, body_source, COALESCE(updated_at, NOW()) FROM templates
+			ON CONFLICT (template_id, version) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.25.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_26_0 adds the settings of the pre-send checks of campaigns: the hosts whose links
+// aren't checked, the DKIM selectors that are looked up, and whether campaigns with
+// failed checks can be started.
+func V7_26_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.26.0: campaign pre-send checks")
+
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+			('app.campaign_checks_block', 'false'),
+			('app.campaign_checks_link_allowlist', '[]'),
+			('app.campaign_checks_dkim_selectors', '["default", "dkim", "mail", "selector1", "selector2", "google", "k1", "s1", "s2"]')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.26.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_27_0 makes the webhook log of an inbox entry unique. The log written when a webhook is
+// queued and the one written by the worker that processes it could race and create two logs.
+func V7_27_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.27.0: unique webhook inbox logs")
+
+	// Of the duplicate logs of an entry, keep the latest outcome of processing it.
+	if _, err := db.Exec(`
+		DELETE FROM webhook_logs WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY inbox_id ORDER BY (response_body IS NOT DISTINCT FROM 'queued'), id DESC
+				) AS n
+				FROM webhook_logs WHERE inbox_id IS NOT NULL
+			) d WHERE n > 1
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		DROP INDEX IF EXISTS idx_webhook_logs_inbox_id;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_logs_inbox_id ON webhook_logs(inbox_id);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.27.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_28_0 allows tx messages, eg: those of commerce flows and event triggers, to be queued
+// in the e-mail queue so that they're sent with the queue's rate limits. A tx entry has no
+// campaign and holds its message, which is rendered when it's sent.
+func V7_28_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.28.0: tx messages in the e-mail queue")
+
+	if _, err := db.Exec(`
+		ALTER TABLE email_queue ALTER COLUMN campaign_id DROP NOT NULL;
+		ALTER TABLE email_queue ADD COLUMN IF NOT EXISTS tx_message JSONB NULL;
+
+		ALTER TABLE email_queue DROP CONSTRAINT IF EXISTS email_queue_message_check;
+		ALTER TABLE email_queue ADD CONSTRAINT email_queue_message_check CHECK (campaign_id IS NOT NULL OR tx_message IS NOT NULL);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.28.0 completed successfully")
+	return nil
+}
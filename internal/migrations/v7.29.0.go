@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_29_0 records when the order of a purchase was placed. Purchases can be recorded long
+// after their orders, eg: orders that are imported or whose webhooks are retried, so the
+// subscriber commerce attributes are computed from the order dates and not when the
+// purchases were recorded. Existing purchases get the time they were recorded.
+func V7_29_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.29.0: purchase order dates")
+
+	if _, err := db.Exec(`
+		ALTER TABLE purchase_attributions ADD COLUMN IF NOT EXISTS ordered_at TIMESTAMP WITH TIME ZONE NULL;
+		UPDATE purchase_attributions SET ordered_at = COALESCE(created_at, NOW()) WHERE ordered_at IS NULL;
+		ALTER TABLE purchase_attributions ALTER COLUMN ordered_at SET DEFAULT NOW();
+		ALTER TABLE purchase_attributions ALTER COLUMN ordered_at SET NOT NULL;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.29.0 completed successfully")
+	return nil
+}
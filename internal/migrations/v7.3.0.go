@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_3_0 adds the ses_events table for storing SES delivery, complaint and
+// rendering failure notifications.
+func V7_3_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.3.0: SES delivery and complaint events")
+
+	// SES message IDs are not UUIDs, so they can't go into azure_delivery_events.
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS ses_events (
+			id                BIGSERIAL PRIMARY KEY,
+			ses_message_id    TEXT NOT NULL,
+			campaign_id       INTEGER NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+			subscriber_id     INTEGER NULL REFERENCES subscribers(id) ON DELETE CASCADE,
+			event_type        VARCHAR(50) NOT NULL,
+			email             TEXT NOT NULL DEFAULT '',
+			feedback_type     VARCHAR(100) NULL,
+			status_reason     TEXT NULL,
+			meta              JSONB NOT NULL DEFAULT '{}',
+			event_timestamp   TIMESTAMP WITH TIME ZONE NOT NULL,
+			created_at        TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_ses_events_msg_id ON ses_events(ses_message_id);
+		CREATE INDEX IF NOT EXISTS idx_ses_events_campaign ON ses_events(campaign_id);
+		CREATE INDEX IF NOT EXISTS idx_ses_events_subscriber ON ses_events(subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_ses_events_type ON ses_events(event_type);
+		CREATE INDEX IF NOT EXISTS idx_ses_events_timestamp ON ses_events(event_timestamp);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.3.0 completed successfully")
+	return nil
+}
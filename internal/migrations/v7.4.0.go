@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_4_0 links replayed webhook logs to the original log entry.
+func V7_4_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.4.0: webhook log replays")
+
+	if _, err := db.Exec(`
+		ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS replay_of BIGINT NULL REFERENCES webhook_logs(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_replay_of ON webhook_logs(replay_of);
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.4.0 completed successfully")
+	return nil
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_5_0 adds indexed fields extracted from webhook payloads, full-text and
+// JSON search on webhook logs and the webhook log retention setting.
+func V7_5_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.5.0: webhook log retention and search")
+
+	if _, err := db.Exec(`
+		ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS recipient_email TEXT NULL;
+		ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS campaign_id INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL;
+		ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS external_message_id TEXT NULL;
+	`); err != nil {
+		return err
+	}
+
+	// Request bodies are stored as received and may not be valid JSON, so JSON path
+	// filters go through a cast that returns NULL instead of failing the whole query.
+	if _, err := db.Exec(`
+		CREATE OR REPLACE FUNCTION webhook_log_json(body TEXT) RETURNS JSONB AS $$
+		BEGIN
+			RETURN body::JSONB;
+		EXCEPTION WHEN OTHERS THEN
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql IMMUTABLE;
+	`); err != nil {
+		return err
+	}
+
+	// tsvectors are limited to 1MB, so only the head of large bodies is indexed.
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_recipient_email ON webhook_logs(recipient_email);
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_campaign_id ON webhook_logs(campaign_id);
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_external_message_id ON webhook_logs(external_message_id);
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_body_search ON webhook_logs
+			USING GIN(TO_TSVECTOR('simple', LEFT(request_body, 262144)));
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+		('webhook_logs.retention', '[]')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.5.0 completed successfully")
+	return nil
+}
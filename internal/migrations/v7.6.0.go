@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_6_0 adds the webhook_idempotency_keys table for de-duplicating webhook
+// deliveries that are retried by providers.
+func V7_6_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.6.0: webhook idempotency keys")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_idempotency_keys (
+			key               TEXT NOT NULL PRIMARY KEY,
+			webhook_type      VARCHAR(50) NOT NULL,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			expires_at        TIMESTAMP WITH TIME ZONE NOT NULL
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_webhook_idempotency_keys_expires_at ON webhook_idempotency_keys(expires_at);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+		('webhook_logs.idempotency_ttl', '"72h"')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.6.0 completed successfully")
+	return nil
+}
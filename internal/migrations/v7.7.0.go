@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_7_0 adds the webhook_inbox table where incoming webhooks are persisted
+// before they are processed asynchronously.
+func V7_7_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.7.0: webhook inbox")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_inbox (
+			id                BIGSERIAL PRIMARY KEY,
+			webhook_type      VARCHAR(50) NOT NULL,
+			request_headers   JSONB NOT NULL DEFAULT '{}',
+			request_body      TEXT NOT NULL,
+			status            VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts          INTEGER NOT NULL DEFAULT 0,
+			last_error        TEXT NULL,
+			next_attempt_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			processed_at      TIMESTAMP WITH TIME ZONE NULL,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_webhook_inbox_status ON webhook_inbox(status, next_attempt_at);
+		CREATE INDEX IF NOT EXISTS idx_webhook_inbox_created_at ON webhook_inbox(created_at);
+	`); err != nil {
+		return err
+	}
+
+	// The webhook log of an inbox entry is updated with the outcome of processing it.
+	if _, err := db.Exec(`
+		ALTER TABLE webhook_logs ADD COLUMN IF NOT EXISTS inbox_id BIGINT NULL REFERENCES webhook_inbox(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_webhook_logs_inbox_id ON webhook_logs(inbox_id);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO settings (key, value) VALUES
+		('webhook_inbox.enabled', 'true'),
+		('webhook_inbox.workers', '4'),
+		('webhook_inbox.max_attempts', '5')
+		ON CONFLICT (key) DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.7.0 completed successfully")
+	return nil
+}
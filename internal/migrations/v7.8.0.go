@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_8_0 adds the purchase_attribution_credits table that holds the share of
+// the revenue of a purchase credited to each campaign by each attribution model.
+func V7_8_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.8.0: multi-touch purchase attribution")
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS purchase_attribution_credits (
+			id                BIGSERIAL PRIMARY KEY,
+			purchase_id       BIGINT NOT NULL REFERENCES purchase_attributions(id) ON DELETE CASCADE,
+			campaign_id       INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+			model             VARCHAR(50) NOT NULL,
+			weight            NUMERIC(7,6) NOT NULL,
+			revenue           DECIMAL(12,2) NOT NULL DEFAULT 0,
+			created_at        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+			UNIQUE(purchase_id, model, campaign_id)
+		);
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_purchase_credits_campaign ON purchase_attribution_credits(campaign_id, model);
+	`); err != nil {
+		return err
+	}
+
+	// Existing purchases were attributed to a single campaign. Credit it fully under every model.
+	if _, err := db.Exec(`
+		INSERT INTO purchase_attribution_credits (purchase_id, campaign_id, model, weight, revenue, created_at)
+			SELECT p.id, p.campaign_id, m.model, 1, COALESCE(p.total_price, 0), p.created_at
+			FROM purchase_attributions p
+			CROSS JOIN UNNEST(ARRAY['last_click', 'last_open', 'first_touch', 'linear', 'time_decay']) AS m(model)
+			WHERE p.campaign_id IS NOT NULL
+		ON CONFLICT DO NOTHING;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+		UPDATE settings SET value = value || '{"attribution_model": "last_click"}'
+			WHERE key = 'shopify' AND NOT value ? 'attribution_model';
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.8.0 completed successfully")
+	return nil
+}
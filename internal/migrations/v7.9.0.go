@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+// V7_9_0 adds per-campaign UTM parameters that are appended to tracked links on redirect.
+func V7_9_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	lo.Println("running migration v7.9.0: campaign UTM parameters")
+
+	if _, err := db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS utm JSONB NOT NULL DEFAULT '{}';
+	`); err != nil {
+		return err
+	}
+
+	lo.Println("migration v7.9.0 completed successfully")
+	return nil
+}
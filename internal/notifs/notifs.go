@@ -21,6 +21,7 @@ const (
 	TplCampaignStatus  = "campaign-status"
 	TplSubscriberOptin = "subscriber-optin"
 	TplSubscriberData  = "subscriber-data"
+	TplCampaignReview  = "campaign-review"
 )
 
 type FuncPush func(msg models.Message) error
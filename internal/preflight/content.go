@@ -0,0 +1,220 @@
+package preflight
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knadh/listmonk/models"
+	"golang.org/x/net/html"
+)
+
+var (
+	// reURL matches URLs in plain text bodies.
+	reURL = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+	// reTplVar matches template tags that were left unrendered in a message, eg: tags in
+	// the body of a plain campaign or tags escaped by an editor.
+	reTplVar = regexp.MustCompile(`{{.*?}}`)
+
+	// reAttrib matches references to subscriber attributes in templates, as
+	// .Subscriber.Attribs.key or index .Subscriber.Attribs "key".
+	reAttrib = regexp.MustCompile(`\.Subscriber\.Attribs\.([\p{L}\p{N}_]+)|index\s+\.Subscriber\.Attribs\s+"([^"]+)"`)
+)
+
+// doc is the content of a message body that's checked.
+type doc struct {
+	links []string
+
+	// images are the src of the images in the body, and noAlt, the images without an alt attribute.
+	images []string
+	noAlt  []string
+
+	// textLen is the number of characters of visible text, with whitespace collapsed.
+	textLen int
+}
+
+// parse returns the links, images and text in a message body.
+func parse(body, contentType string) doc {
+	if contentType == models.CampaignContentTypePlain {
+		return doc{
+			links:   reURL.FindAllString(body, -1),
+			textLen: utf8.RuneCountInString(strings.Join(strings.Fields(body), " ")),
+		}
+	}
+
+	var (
+		out  doc
+		text strings.Builder
+
+		// Text in these tags isn't visible.
+		skip   = 0
+		hidden = map[string]bool{"head": true, "title": true, "script": true, "style": true}
+	)
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		t := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if hidden[t.Data] && tt == html.StartTagToken {
+				skip++
+			}
+
+			switch t.Data {
+			case "a":
+				if href, ok := attr(t, "href"); ok {
+					out.links = append(out.links, href)
+				}
+			case "img":
+				src, _ := attr(t, "src")
+				out.images = append(out.images, src)
+				if _, ok := attr(t, "alt"); !ok {
+					out.noAlt = append(out.noAlt, src)
+				}
+			}
+
+		case html.EndTagToken:
+			if hidden[t.Data] && skip > 0 {
+				skip--
+			}
+
+		case html.TextToken:
+			if skip == 0 {
+				text.WriteString(t.Data)
+				text.WriteString(" ")
+			}
+		}
+	}
+
+	out.textLen = utf8.RuneCountInString(strings.Join(strings.Fields(text.String()), " "))
+	return out
+}
+
+func attr(t html.Token, key string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val), true
+		}
+	}
+
+	return "", false
+}
+
+// checkUnsubscribe checks that the message has an unsubscribe link in the body
+// and a List-Unsubscribe header.
+func (c *Checker) checkUnsubscribe(m Message) Result {
+	hasLink := m.UnsubURL != "" && strings.Contains(m.Body, m.UnsubURL)
+	hasHeader := m.Headers.Get("List-Unsubscribe") != ""
+
+	switch {
+	case !hasLink && !hasHeader:
+		return result(CheckUnsubscribe, StatusError, c.i18n.T("campaigns.checks.unsubMissing"), nil)
+	case !hasLink:
+		return result(CheckUnsubscribe, StatusWarning, c.i18n.T("campaigns.checks.unsubNoLink"), nil)
+	case !hasHeader:
+		return result(CheckUnsubscribe, StatusWarning, c.i18n.T("campaigns.checks.unsubNoHeader"), nil)
+	}
+
+	return result(CheckUnsubscribe, StatusOK, c.i18n.T("campaigns.checks.unsubOK"), nil)
+}
+
+// checkSize checks the size of the HTML body against the size at which Gmail clips messages,
+// which hides the rest of the message including the unsubscribe link.
+func (c *Checker) checkSize(m Message) Result {
+	var (
+		size  = len(m.Body)
+		kb    = fmt.Sprintf("%.1f", float64(size)/1024)
+		maxKB = strconv.Itoa(c.cfg.MaxSize / 1024)
+	)
+
+	switch {
+	case m.ContentType == models.CampaignContentTypePlain:
+		return result(CheckSize, StatusOK, c.i18n.Ts("campaigns.checks.sizeOK", "size", kb), nil)
+	case size > c.cfg.MaxSize:
+		return result(CheckSize, StatusError, c.i18n.Ts("campaigns.checks.sizeClipped", "size", kb, "max", maxKB), nil)
+	case size > c.cfg.MaxSize*8/10:
+		return result(CheckSize, StatusWarning, c.i18n.Ts("campaigns.checks.sizeNear", "size", kb, "max", maxKB), nil)
+	}
+
+	return result(CheckSize, StatusOK, c.i18n.Ts("campaigns.checks.sizeOK", "size", kb), nil)
+}
+
+// checkImageRatio checks that there's enough text for the images in the message. Spam
+// filters are wary of messages that are mostly images.
+func (c *Checker) checkImageRatio(d doc) Result {
+	var (
+		images = strconv.Itoa(len(d.images))
+		chars  = strconv.Itoa(d.textLen)
+	)
+
+	switch {
+	case len(d.images) == 0:
+		return result(CheckImageRatio, StatusOK, c.i18n.T("campaigns.checks.imageRatioNoImages"), nil)
+	case d.textLen == 0:
+		return result(CheckImageRatio, StatusError, c.i18n.T("campaigns.checks.imageRatioOnlyImages"), nil)
+	case d.textLen < len(d.images)*c.cfg.MinTextPerImage:
+		return result(CheckImageRatio, StatusWarning, c.i18n.Ts("campaigns.checks.imageRatioLow",
+			"images", images, "chars", chars, "min", strconv.Itoa(c.cfg.MinTextPerImage)), nil)
+	}
+
+	return result(CheckImageRatio, StatusOK, c.i18n.Ts("campaigns.checks.imageRatioOK", "images", images, "chars", chars), nil)
+}
+
+// checkAltText checks that images have alt text. Decorative images can have an empty alt.
+func (c *Checker) checkAltText(d doc) Result {
+	if len(d.noAlt) > 0 {
+		return result(CheckAltText, StatusWarning,
+			c.i18n.Ts("campaigns.checks.altTextMissing", "num", strconv.Itoa(len(d.noAlt))), d.noAlt)
+	}
+
+	return result(CheckAltText, StatusOK, c.i18n.T("campaigns.checks.altTextOK"), nil)
+}
+
+// checkTemplateVars checks the rendered subject and body for template tags that weren't
+// rendered and for values that were missing, and the templates for subscriber attributes
+// that are missing on the sample subscriber.
+func (c *Checker) checkTemplateVars(m Message) Result {
+	var (
+		items = []string{}
+		seen  = map[string]bool{}
+	)
+	for _, s := range []string{m.Subject, m.Body} {
+		for _, v := range reTplVar.FindAllString(s, -1) {
+			if !seen[v] {
+				seen[v] = true
+				items = append(items, v)
+			}
+		}
+	}
+	if strings.Contains(m.Subject, "<no value>") || strings.Contains(m.Body, "<no value>") ||
+		strings.Contains(m.Body, "&lt;no value&gt;") {
+		items = append(items, "<no value>")
+	}
+	if len(items) > 0 {
+		return result(CheckTemplateVars, StatusError, c.i18n.T("campaigns.checks.templateVarsUnresolved"), items)
+	}
+
+	// Attributes that the sample subscriber doesn't have are rendered as empty values.
+	for _, match := range reAttrib.FindAllStringSubmatch(m.Source, -1) {
+		key := match[1]
+		if key == "" {
+			key = match[2]
+		}
+		if _, ok := m.Attribs[key]; !ok && !seen[key] {
+			seen[key] = true
+			items = append(items, key)
+		}
+	}
+	if len(items) > 0 {
+		return result(CheckTemplateVars, StatusWarning, c.i18n.T("campaigns.checks.templateVarsAttribs"), items)
+	}
+
+	return result(CheckTemplateVars, StatusOK, c.i18n.T("campaigns.checks.templateVarsOK"), nil)
+}
@@ -0,0 +1,187 @@
+package preflight
+
+import (
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const unsubURL = "https://lists.example.com/subscription/abc/def"
+
+func TestCheckUnsubscribe(t *testing.T) {
+	header := textproto.MIMEHeader{"List-Unsubscribe": {"<" + unsubURL + ">"}}
+
+	for _, c := range []struct {
+		name   string
+		m      Message
+		status string
+	}{
+		{"link and header", Message{Body: `<a href="` + unsubURL + `">Unsubscribe</a>`, UnsubURL: unsubURL, Headers: header}, StatusOK},
+		{"no link", Message{Body: "<p>Hello</p>", UnsubURL: unsubURL, Headers: header}, StatusWarning},
+		{"no unsubscribe URL", Message{Body: "<p>Hello</p>", Headers: header}, StatusWarning},
+		{"no header", Message{Body: `<a href="` + unsubURL + `">Unsubscribe</a>`, UnsubURL: unsubURL}, StatusWarning},
+		{"neither", Message{Body: "<p>Hello</p>", UnsubURL: unsubURL}, StatusError},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{}, nil, nil)
+			if r := ch.checkUnsubscribe(c.m); r.Check != CheckUnsubscribe || r.Status != c.status {
+				t.Errorf("got %s %q, want %q (%s)", r.Check, r.Status, c.status, r.Message)
+			}
+		})
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		size        int
+		contentType string
+		status      string
+	}{
+		{"small", 10 * 1024, models.CampaignContentTypeHTML, StatusOK},
+		{"at 80%", 102 * 1024 * 8 / 10, models.CampaignContentTypeHTML, StatusOK},
+		{"near the limit", 90 * 1024, models.CampaignContentTypeHTML, StatusWarning},
+		{"at the limit", 102 * 1024, models.CampaignContentTypeHTML, StatusWarning},
+		{"clipped", 102*1024 + 1, models.CampaignContentTypeHTML, StatusError},
+		{"plain text isn't clipped", 200 * 1024, models.CampaignContentTypePlain, StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{}, nil, nil)
+			m := Message{Body: strings.Repeat("a", c.size), ContentType: c.contentType}
+			if r := ch.checkSize(m); r.Check != CheckSize || r.Status != c.status {
+				t.Errorf("got %s %q, want %q (%s)", r.Check, r.Status, c.status, r.Message)
+			}
+		})
+	}
+}
+
+func TestCheckImageRatio(t *testing.T) {
+	var (
+		img  = `<img src="https://example.com/a.png" alt="">`
+		text = func(n int) string { return "<p>" + strings.Repeat("a", n) + "</p>" }
+	)
+
+	for _, c := range []struct {
+		name   string
+		body   string
+		status string
+	}{
+		{"no images", text(10), StatusOK},
+		{"only images", img + img, StatusError},
+		{"hidden text only", "<head><title>Title</title><style>p {}</style></head>" + img, StatusError},
+		{"too little text", img + img + text(799), StatusWarning},
+		{"enough text", img + img + text(800), StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{}, nil, nil)
+			d := parse(c.body, models.CampaignContentTypeHTML)
+			if r := ch.checkImageRatio(d); r.Check != CheckImageRatio || r.Status != c.status {
+				t.Errorf("got %s %q, want %q (%s)", r.Check, r.Status, c.status, r.Message)
+			}
+		})
+	}
+}
+
+func TestCheckAltText(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		body   string
+		status string
+		items  []string
+	}{
+		{"no images", "<p>Hello</p>", StatusOK, []string{}},
+		{"alt text", `<img src="a.png" alt="A"><img src="b.png" alt="">`, StatusOK, []string{}},
+		{"missing alt", `<img src="a.png" alt="A"><img src="b.png"><img src="c.png"/>`, StatusWarning, []string{"b.png", "c.png"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{}, nil, nil)
+			r := ch.checkAltText(parse(c.body, models.CampaignContentTypeHTML))
+			if r.Check != CheckAltText || r.Status != c.status {
+				t.Errorf("got %s %q, want %q (%s)", r.Check, r.Status, c.status, r.Message)
+			}
+			if !reflect.DeepEqual(r.Items, c.items) {
+				t.Errorf("got items %q, want %q", r.Items, c.items)
+			}
+		})
+	}
+}
+
+func TestCheckTemplateVars(t *testing.T) {
+	attribs := map[string]any{"city": "Berlin", "first name": "Ana"}
+
+	for _, c := range []struct {
+		name   string
+		m      Message
+		status string
+		items  []string
+	}{
+		{
+			name:   "rendered",
+			m:      Message{Subject: "Hello Ana", Body: "<p>Hello from Berlin</p>", Source: `Hello {{ index .Subscriber.Attribs "first name" }} {{ .Subscriber.Attribs.city }}`, Attribs: attribs},
+			status: StatusOK,
+			items:  []string{},
+		},
+		{
+			name:   "unrendered tags",
+			m:      Message{Subject: "Hello {{ .Name }}", Body: "<p>{{ .Name }} {{ TrackLink }}</p>"},
+			status: StatusError,
+			items:  []string{"{{ .Name }}", "{{ TrackLink }}"},
+		},
+		{
+			name:   "no value in the subject",
+			m:      Message{Subject: "Hello <no value>", Body: "<p>Hello</p>"},
+			status: StatusError,
+			items:  []string{"<no value>"},
+		},
+		{
+			name:   "escaped no value in the body",
+			m:      Message{Subject: "Hello", Body: "<p>Hello &lt;no value&gt;</p>"},
+			status: StatusError,
+			items:  []string{"<no value>"},
+		},
+		{
+			name:   "missing attributes",
+			m:      Message{Subject: "Hello", Body: "<p>Hello</p>", Source: `{{ .Subscriber.Attribs.city }} {{ .Subscriber.Attribs.plan }} {{ index .Subscriber.Attribs "last name" }} {{ .Subscriber.Attribs.plan }}`, Attribs: attribs},
+			status: StatusWarning,
+			items:  []string{"plan", "last name"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{}, nil, nil)
+			r := ch.checkTemplateVars(c.m)
+			if r.Check != CheckTemplateVars || r.Status != c.status {
+				t.Errorf("got %s %q, want %q (%s)", r.Check, r.Status, c.status, r.Message)
+			}
+			if !reflect.DeepEqual(r.Items, c.items) {
+				t.Errorf("got items %q, want %q", r.Items, c.items)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	d := parse(`<html><head><title>Title</title></head><body>
+		<a href=" https://example.com/a ">A</a> <a name="top">Top</a>
+		<img src="b.png">
+		<script>var x = 1;</script>
+		<p>Some   text</p></body></html>`, models.CampaignContentTypeHTML)
+
+	if want := []string{"https://example.com/a"}; !reflect.DeepEqual(d.links, want) {
+		t.Errorf("got links %q, want %q", d.links, want)
+	}
+	if want := []string{"b.png"}; !reflect.DeepEqual(d.images, want) || !reflect.DeepEqual(d.noAlt, want) {
+		t.Errorf("got images %q and without alt %q, want %q", d.images, d.noAlt, want)
+	}
+	if want := len("A Top Some text"); d.textLen != want {
+		t.Errorf("got text length %d, want %d", d.textLen, want)
+	}
+
+	// Plain text links are matched in the text.
+	d = parse("Visit https://example.com/a or <https://example.com/b>.", models.CampaignContentTypePlain)
+	if want := []string{"https://example.com/a", "https://example.com/b"}; !reflect.DeepEqual(d.links, want) {
+		t.Errorf("got links %q, want %q", d.links, want)
+	}
+}
@@ -0,0 +1,370 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+)
+
+// maxSPFLookups is the number of DNS lookups an SPF evaluation may do (RFC 7208, 4.6.4).
+const maxSPFLookups = 10
+
+var errSPFLookups = errors.New("too many DNS lookups")
+
+// checkDNS checks the SPF, DKIM and DMARC records of the from-domain, and that the
+// from addresses of the SMTP servers the campaign is sent through are on the same domain.
+func (c *Checker) checkDNS(ctx context.Context, from string, servers []Server) []Result {
+	domain := emailDomain(from)
+	if domain == "" {
+		msg := c.i18n.T("campaigns.checks.invalidFrom")
+		return []Result{
+			result(CheckSPF, StatusError, msg, nil),
+			result(CheckDKIM, StatusError, msg, nil),
+			result(CheckDMARC, StatusError, msg, nil),
+			result(CheckAlignment, StatusError, msg, nil),
+		}
+	}
+
+	return []Result{
+		c.checkSPF(ctx, domain, servers),
+		c.checkDKIM(ctx, domain),
+		c.checkDMARC(ctx, domain),
+		c.checkAlignment(domain, servers),
+	}
+}
+
+// checkSPF checks that the from-domain has an SPF record that authorizes the SMTP servers.
+// SMTP relays that send with their own envelope domain aren't authorized by the from-domain,
+// so an unauthorized server is only a warning.
+func (c *Checker) checkSPF(ctx context.Context, domain string, servers []Server) Result {
+	rec, err := c.lookupRecord(ctx, domain, "v=spf1")
+	if err != nil {
+		return result(CheckSPF, StatusError, c.i18n.Ts("campaigns.checks.dnsError", "domain", domain, "error", err.Error()), nil)
+	}
+	if rec == "" {
+		return result(CheckSPF, StatusError, c.i18n.Ts("campaigns.checks.spfMissing", "domain", domain), nil)
+	}
+	if len(servers) == 0 {
+		return result(CheckSPF, StatusOK, c.i18n.Ts("campaigns.checks.spfFound", "domain", domain), []string{rec})
+	}
+
+	items := []string{}
+	for _, s := range servers {
+		if err := c.spfPermits(ctx, domain, s.Host); err != nil {
+			items = append(items, s.Name+" ("+s.Host+"): "+err.Error())
+		}
+	}
+	if len(items) > 0 {
+		return result(CheckSPF, StatusWarning, c.i18n.Ts("campaigns.checks.spfNotPermitted", "domain", domain), items)
+	}
+
+	return result(CheckSPF, StatusOK, c.i18n.Ts("campaigns.checks.spfOK", "domain", domain), nil)
+}
+
+// checkDKIM looks up the DKIM keys of the configured selectors on the from-domain.
+func (c *Checker) checkDKIM(ctx context.Context, domain string) Result {
+	if len(c.cfg.DKIMSelectors) == 0 {
+		return result(CheckDKIM, StatusSkipped, c.i18n.T("campaigns.checks.dkimNoSelectors"), nil)
+	}
+
+	found := []string{}
+	for _, sel := range c.cfg.DKIMSelectors {
+		txt, err := c.lookupTXT(ctx, sel+"._domainkey."+domain)
+		if err != nil {
+			return result(CheckDKIM, StatusError, c.i18n.Ts("campaigns.checks.dnsError", "domain", domain, "error", err.Error()), nil)
+		}
+
+		for _, t := range txt {
+			if strings.Contains(strings.ReplaceAll(t, " ", ""), "p=") {
+				found = append(found, sel)
+				break
+			}
+		}
+	}
+	if len(found) == 0 {
+		return result(CheckDKIM, StatusWarning, c.i18n.Ts("campaigns.checks.dkimMissing",
+			"domain", domain, "selectors", strings.Join(c.cfg.DKIMSelectors, ", ")), nil)
+	}
+
+	return result(CheckDKIM, StatusOK, c.i18n.Ts("campaigns.checks.dkimOK", "domain", domain), found)
+}
+
+// checkDMARC checks that the from-domain, or its organizational domain, has a DMARC policy.
+func (c *Checker) checkDMARC(ctx context.Context, domain string) Result {
+	names := []string{domain}
+	if org := orgDomain(domain); org != domain {
+		names = append(names, org)
+	}
+
+	for _, d := range names {
+		rec, err := c.lookupRecord(ctx, "_dmarc."+d, "v=DMARC1")
+		if err != nil {
+			return result(CheckDMARC, StatusError, c.i18n.Ts("campaigns.checks.dnsError", "domain", d, "error", err.Error()), nil)
+		}
+		if rec != "" {
+			return result(CheckDMARC, StatusOK, c.i18n.Ts("campaigns.checks.dmarcOK", "domain", d), []string{rec})
+		}
+	}
+
+	return result(CheckDMARC, StatusError, c.i18n.Ts("campaigns.checks.dmarcMissing", "domain", domain), nil)
+}
+
+// checkAlignment checks that the from addresses of the SMTP servers are on the from-domain.
+// SMTP servers are usually set up to sign (DKIM) and send (SPF) for their own from-domain,
+// and messages from another domain fail DMARC alignment.
+func (c *Checker) checkAlignment(domain string, servers []Server) Result {
+	if len(servers) == 0 {
+		return result(CheckAlignment, StatusSkipped, c.i18n.T("campaigns.checks.alignmentNoServers"), nil)
+	}
+
+	items := []string{}
+	for _, s := range servers {
+		if d := emailDomain(s.FromEmail); d != "" && d != domain {
+			items = append(items, s.Name+" ("+s.FromEmail+")")
+		}
+	}
+	if len(items) > 0 {
+		return result(CheckAlignment, StatusError, c.i18n.Ts("campaigns.checks.alignmentMismatch", "domain", domain), items)
+	}
+
+	return result(CheckAlignment, StatusOK, c.i18n.Ts("campaigns.checks.alignmentOK", "domain", domain), nil)
+}
+
+// spfPermits evaluates the SPF record of a domain for the IPs of an SMTP host and returns
+// an error if any of them isn't permitted. Only the mechanisms that can be evaluated without
+// the sender (all, ip4, ip6, a, mx, include, redirect) are evaluated.
+func (c *Checker) spfPermits(ctx context.Context, domain, host string) error {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := c.dns.LookupIPAddr(ctx, host)
+		if err != nil {
+			return err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		lookups := 0
+		ok, err := c.evalSPF(ctx, domain, ip, &lookups)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New(ip.String() + " not permitted")
+		}
+	}
+
+	return nil
+}
+
+// evalSPF returns true if the SPF record of the domain passes the IP.
+func (c *Checker) evalSPF(ctx context.Context, domain string, ip net.IP, lookups *int) (bool, error) {
+	rec, err := c.lookupRecord(ctx, domain, "v=spf1")
+	if err != nil || rec == "" {
+		return false, err
+	}
+
+	redirect := ""
+	for _, term := range strings.Fields(rec)[1:] {
+		term = strings.ToLower(term)
+		if v, ok := strings.CutPrefix(term, "redirect="); ok {
+			redirect = v
+			continue
+		}
+		// Other modifiers, eg: exp=.
+		if strings.Contains(term, "=") && !strings.Contains(term, ":") {
+			continue
+		}
+
+		qual := byte('+')
+		if strings.IndexByte("+-~?", term[0]) >= 0 {
+			qual, term = term[0], term[1:]
+		}
+
+		// The mechanism, its optional domain or IP, and its optional CIDR lengths.
+		mech, arg, _ := strings.Cut(term, ":")
+		cidr := ""
+		if i := strings.IndexByte(mech, '/'); i >= 0 {
+			mech, cidr = mech[:i], mech[i:]
+		} else if i := strings.IndexByte(arg, '/'); i >= 0 && mech != "ip4" && mech != "ip6" {
+			arg, cidr = arg[:i], arg[i:]
+		}
+		if arg == "" {
+			arg = domain
+		}
+
+		match := false
+		switch mech {
+		case "all":
+			match = true
+
+		case "ip4", "ip6":
+			if !strings.Contains(arg, "/") {
+				if mech == "ip4" {
+					arg += "/32"
+				} else {
+					arg += "/128"
+				}
+			}
+			if _, n, err := net.ParseCIDR(arg); err == nil {
+				match = n.Contains(ip)
+			}
+
+		case "a", "mx":
+			if *lookups++; *lookups > maxSPFLookups {
+				return false, errSPFLookups
+			}
+
+			hosts := []string{arg}
+			if mech == "mx" {
+				mxs, err := c.dns.LookupMX(ctx, arg)
+				if err != nil && !isNotFound(err) {
+					return false, err
+				}
+				hosts = hosts[:0]
+				for _, mx := range mxs {
+					hosts = append(hosts, mx.Host)
+				}
+			}
+
+			for _, h := range hosts {
+				addrs, err := c.dns.LookupIPAddr(ctx, h)
+				if err != nil && !isNotFound(err) {
+					return false, err
+				}
+				for _, a := range addrs {
+					if inCIDR(ip, a.IP, cidr) {
+						match = true
+					}
+				}
+			}
+
+		case "include":
+			if *lookups++; *lookups > maxSPFLookups {
+				return false, errSPFLookups
+			}
+
+			ok, err := c.evalSPF(ctx, arg, ip, lookups)
+			if err != nil {
+				return false, err
+			}
+			match = ok
+
+		case "exists", "ptr":
+			// These need the sender and aren't evaluated, but count towards the lookups.
+			if *lookups++; *lookups > maxSPFLookups {
+				return false, errSPFLookups
+			}
+		}
+
+		if match {
+			return qual == '+', nil
+		}
+	}
+
+	if redirect != "" {
+		if *lookups++; *lookups > maxSPFLookups {
+			return false, errSPFLookups
+		}
+		return c.evalSPF(ctx, redirect, ip, lookups)
+	}
+
+	return false, nil
+}
+
+// lookupRecord returns the TXT record of a name that starts with the given version tag, eg: v=spf1.
+func (c *Checker) lookupRecord(ctx context.Context, name, tag string) (string, error) {
+	txt, err := c.lookupTXT(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, t := range txt {
+		if len(t) >= len(tag) && strings.EqualFold(t[:len(tag)], tag) &&
+			(len(t) == len(tag) || t[len(tag)] == ' ' || t[len(tag)] == ';') {
+			return t, nil
+		}
+	}
+
+	return "", nil
+}
+
+// lookupTXT returns the TXT records of a name. Names that don't exist have no records.
+func (c *Checker) lookupTXT(ctx context.Context, name string) ([]string, error) {
+	txt, err := c.dns.LookupTXT(ctx, name)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return txt, nil
+}
+
+// inCIDR returns true if ip is in the network of addr with the SPF dual CIDR lengths
+// (eg: /24 or /24//64), which default to the full address.
+func inCIDR(ip, addr net.IP, cidr string) bool {
+	v4, v6, _ := strings.Cut(strings.TrimPrefix(cidr, "/"), "//")
+
+	bits, length := 128, v6
+	if addr.To4() != nil {
+		bits, length = 32, v4
+		addr, ip = addr.To4(), ip.To4()
+		if ip == nil {
+			return false
+		}
+	} else if ip.To4() != nil {
+		return false
+	}
+
+	ones := bits
+	if length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 || n > bits {
+			return false
+		}
+		ones = n
+	}
+
+	mask := net.CIDRMask(ones, bits)
+	return addr.Mask(mask).Equal(ip.Mask(mask))
+}
+
+func isNotFound(err error) bool {
+	var dErr *net.DNSError
+	return errors.As(err, &dErr) && dErr.IsNotFound
+}
+
+// emailDomain returns the lowercase domain of an e-mail address, which may have a name.
+func emailDomain(s string) string {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return ""
+	}
+
+	_, domain, ok := strings.Cut(addr.Address, "@")
+	if !ok {
+		return ""
+	}
+
+	return strings.ToLower(domain)
+}
+
+// orgDomain returns the last two labels of a domain, which is an approximation
+// of its organizational domain for looking up DMARC policies.
+func orgDomain(domain string) string {
+	parts := strings.Split(domain, ".")
+	if len(parts) <= 2 {
+		return domain
+	}
+
+	return strings.Join(parts[len(parts)-2:], ".")
+}
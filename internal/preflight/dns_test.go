@@ -0,0 +1,250 @@
+package preflight
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeResolver resolves names from its records. Names without records aren't found,
+// and names in errs fail with a temporary error.
+type fakeResolver struct {
+	txt  map[string][]string
+	ips  map[string][]string
+	mx   map[string][]string
+	errs map[string]bool
+}
+
+func (r fakeResolver) lookup(name string) error {
+	if r.errs[name] {
+		return &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if t, ok := r.txt[name]; ok {
+		return t, nil
+	}
+	return nil, r.lookup(name)
+}
+
+func (r fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.ips[host]
+	if !ok {
+		return nil, r.lookup(host)
+	}
+
+	out := []net.IPAddr{}
+	for _, ip := range ips {
+		out = append(out, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return out, nil
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := r.mx[name]
+	if !ok {
+		return nil, r.lookup(name)
+	}
+
+	out := []*net.MX{}
+	for _, h := range hosts {
+		out = append(out, &net.MX{Host: h, Pref: 10})
+	}
+	return out, nil
+}
+
+// dnsRecords are the records of example.com, whose SPF record permits its own range,
+// its MX and A hosts, and the hosts of the included mailer.test.
+var dnsRecords = fakeResolver{
+	txt: map[string][]string{
+		"example.com": {
+			"google-site-verification=abc",
+			"v=spf1 ip4:192.0.2.0/24 mx a:relay.example.com/28 include:_spf.mailer.test ~all",
+		},
+		"_spf.mailer.test":           {"v=spf1 ip6:2001:db8::/32 redirect=_spf2.mailer.test"},
+		"_spf2.mailer.test":          {"v=spf1 ip4:203.0.113.7 -all"},
+		"s1._domainkey.example.com":  {"v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA"},
+		"s2._domainkey.example.com":  {"v=DKIM1; k=rsa; p=MIGfMA0GCSqGSIb3DQEBAQUAA4GNADCBiQKBgQC"},
+		"old._domainkey.example.com": {"v=DKIM1; n=revoked"},
+		"_dmarc.example.com":         {"v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com"},
+		"bare.test":                  {"v=spf1-not-a-record"},
+		"loop.test":                  {"v=spf1 include:loop.test -all"},
+		"err-dkim.test":              {"v=spf1 -all"},
+	},
+	ips: map[string][]string{
+		"mx1.example.com.":    {"198.51.100.25"},
+		"relay.example.com":   {"198.51.100.40"},
+		"smtp.example.com":    {"192.0.2.10"},
+		"smtp.mailer.test":    {"203.0.113.7", "2001:db8::25"},
+		"smtp.other.test":     {"198.18.0.1"},
+		"smtp.mx.example.com": {"198.51.100.25"},
+	},
+	mx: map[string][]string{
+		"example.com": {"mx1.example.com."},
+	},
+	errs: map[string]bool{
+		"err.test":                    true,
+		"_dmarc.err.test":             true,
+		"s1._domainkey.err.test":      true,
+		"s1._domainkey.err-dkim.test": true,
+		"_dmarc.err-dkim.test":        true,
+		"smtp.broken.test":            true,
+	},
+}
+
+func TestCheckDNS(t *testing.T) {
+	var (
+		sendgrid = Server{Name: "sendgrid", Host: "smtp.other.test", FromEmail: "news@other.test"}
+		own      = Server{Name: "own", Host: "smtp.example.com", FromEmail: "news@example.com"}
+		mailer   = Server{Name: "mailer", Host: "smtp.mailer.test", FromEmail: "News <news@example.com>"}
+		mx       = Server{Name: "mx", Host: "smtp.mx.example.com", FromEmail: "news@example.com"}
+		relay    = Server{Name: "relay", Host: "198.51.100.45", FromEmail: "news@example.com"}
+	)
+
+	type want struct {
+		status string
+		items  []string
+	}
+	cases := []struct {
+		name      string
+		from      string
+		servers   []Server
+		selectors []string
+		spf       want
+		dkim      want
+		dmarc     want
+		alignment want
+	}{
+		{
+			name:      "all pass",
+			from:      "Example <news@Example.com>",
+			servers:   []Server{own, mailer, mx, relay},
+			selectors: []string{"s1", "old", "s2"},
+			spf:       want{StatusOK, nil},
+			dkim:      want{StatusOK, []string{"s1", "s2"}},
+			dmarc:     want{StatusOK, []string{"v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com"}},
+			alignment: want{StatusOK, nil},
+		},
+		{
+			// The SPF record doesn't permit the server's IP and it sends from another domain.
+			name:      "mismatched server",
+			from:      "news@example.com",
+			servers:   []Server{own, sendgrid},
+			selectors: []string{"s1"},
+			spf:       want{StatusWarning, []string{"sendgrid (smtp.other.test): 198.18.0.1 not permitted"}},
+			dkim:      want{StatusOK, []string{"s1"}},
+			dmarc:     want{StatusOK, []string{"v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com"}},
+			alignment: want{StatusError, []string{"sendgrid (news@other.test)"}},
+		},
+		{
+			// The DMARC policy of the organizational domain applies to its subdomains.
+			name:      "subdomain",
+			from:      "news@mail.example.com",
+			spf:       want{StatusError, nil},
+			dkim:      want{StatusSkipped, nil},
+			dmarc:     want{StatusOK, []string{"v=DMARC1; p=quarantine; rua=mailto:dmarc@example.com"}},
+			alignment: want{StatusSkipped, nil},
+		},
+		{
+			name:      "missing records",
+			from:      "news@bare.test",
+			servers:   []Server{{Name: "bare", Host: "smtp.bare.test", FromEmail: "news@bare.test"}},
+			selectors: []string{"s1", "s2"},
+			spf:       want{StatusError, nil},
+			dkim:      want{StatusWarning, nil},
+			dmarc:     want{StatusError, nil},
+			alignment: want{StatusOK, nil},
+		},
+		{
+			name:      "SPF lookup limit",
+			from:      "news@loop.test",
+			servers:   []Server{{Name: "loop", Host: "192.0.2.1", FromEmail: "news@loop.test"}},
+			spf:       want{StatusWarning, []string{"loop (192.0.2.1): " + errSPFLookups.Error()}},
+			dkim:      want{StatusSkipped, nil},
+			dmarc:     want{StatusError, nil},
+			alignment: want{StatusOK, nil},
+		},
+		{
+			name:      "DNS errors",
+			from:      "news@err-dkim.test",
+			servers:   []Server{{Name: "broken", Host: "smtp.broken.test", FromEmail: "news@err-dkim.test"}},
+			selectors: []string{"s1"},
+			spf:       want{StatusWarning, []string{"broken (smtp.broken.test): lookup smtp.broken.test: server misbehaving"}},
+			dkim:      want{StatusError, nil},
+			dmarc:     want{StatusError, nil},
+			alignment: want{StatusOK, nil},
+		},
+		{
+			name:      "invalid from",
+			from:      "not an address",
+			servers:   []Server{own},
+			spf:       want{StatusError, nil},
+			dkim:      want{StatusError, nil},
+			dmarc:     want{StatusError, nil},
+			alignment: want{StatusError, nil},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ch := newChecker(t, Config{DKIMSelectors: c.selectors}, dnsRecords, nil)
+
+			res := ch.checkDNS(context.Background(), c.from, c.servers)
+			checks := []string{CheckSPF, CheckDKIM, CheckDMARC, CheckAlignment}
+			if len(res) != len(checks) {
+				t.Fatalf("got %d results, want %d", len(res), len(checks))
+			}
+
+			for n, w := range []want{c.spf, c.dkim, c.dmarc, c.alignment} {
+				r := res[n]
+				if r.Check != checks[n] {
+					t.Errorf("result %d: got check %q, want %q", n, r.Check, checks[n])
+				}
+				if r.Status != w.status {
+					t.Errorf("%s: got status %q, want %q (%s %v)", r.Check, r.Status, w.status, r.Message, r.Items)
+				}
+				if w.items == nil {
+					w.items = []string{}
+				}
+				if !reflect.DeepEqual(r.Items, w.items) {
+					t.Errorf("%s: got items %q, want %q", r.Check, r.Items, w.items)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDNSError(t *testing.T) {
+	ch := newChecker(t, Config{DKIMSelectors: []string{"s1"}}, dnsRecords, nil)
+
+	// A failed lookup is an error and not a missing record.
+	for _, r := range ch.checkDNS(context.Background(), "news@err.test", nil)[:3] {
+		if r.Status != StatusError || !strings.Contains(r.Message, "server misbehaving") {
+			t.Errorf("%s: unexpected result: %+v", r.Check, r)
+		}
+	}
+}
+
+func TestInCIDR(t *testing.T) {
+	for _, c := range []struct {
+		ip, addr, cidr string
+		want           bool
+	}{
+		{"192.0.2.10", "192.0.2.10", "", true},
+		{"192.0.2.11", "192.0.2.10", "", false},
+		{"192.0.2.11", "192.0.2.10", "/24", true},
+		{"192.0.3.11", "192.0.2.10", "/24", false},
+		{"2001:db8::2", "2001:db8::1", "/24//64", true},
+		{"2001:db9::2", "2001:db8::1", "/24//64", false},
+		{"192.0.2.10", "2001:db8::1", "//0", false},
+		{"192.0.2.10", "192.0.2.10", "/33", false},
+	} {
+		if got := inCIDR(net.ParseIP(c.ip), net.ParseIP(c.addr), c.cidr); got != c.want {
+			t.Errorf("%s in %s%s: got %v, want %v", c.ip, c.addr, c.cidr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cgnat is the shared address space of carrier-grade NATs (RFC 6598), which isn't
+// publicly routable.
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewClient returns an HTTP client for requesting the links in campaigns. Campaign
+// content is written by users who may only have access to view campaigns, so the client
+// refuses to connect to loopback, private, link-local and other non-public addresses
+// to keep the checks from probing the app's own network. The address is checked after
+// the host is resolved, so it also applies to redirects and to hosts that resolve to
+// internal addresses. Proxies aren't used as they'd connect on the client's behalf.
+func NewClient(timeout time.Duration) *http.Client {
+	d := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublic(ap.Addr()) {
+				return fmt.Errorf("refusing to connect to non-public address %s", ap.Addr())
+			}
+			return nil
+		},
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = nil
+	tr.DialContext = d.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: tr}
+}
+
+// checkLinks requests the links in a message and reports the ones that fail or respond
+// with a 4xx or 5xx status. Links to the app and to allowlisted hosts aren't requested.
+func (c *Checker) checkLinks(ctx context.Context, links []string) Result {
+	var (
+		urls = []string{}
+		seen = map[string]bool{}
+	)
+	for _, l := range links {
+		if seen[l] || len(urls) >= c.cfg.MaxLinks {
+			continue
+		}
+		seen[l] = true
+
+		u, err := url.Parse(l)
+		if err != nil {
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		if c.cfg.RootURL != "" && strings.HasPrefix(l, c.cfg.RootURL) {
+			continue
+		}
+		if c.isAllowed(u.Hostname()) {
+			continue
+		}
+
+		urls = append(urls, l)
+	}
+
+	if len(urls) == 0 {
+		return result(CheckLinks, StatusOK, c.i18n.T("campaigns.checks.linksNone"), nil)
+	}
+
+	var (
+		broken = []string{}
+		mut    sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, c.cfg.Concurrency)
+	)
+	for _, u := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(u string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if msg := c.checkLink(ctx, u); msg != "" {
+				mut.Lock()
+				broken = append(broken, u+" ("+msg+")")
+				mut.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	if len(broken) > 0 {
+		sort.Strings(broken)
+		return result(CheckLinks, StatusError,
+			c.i18n.Ts("campaigns.checks.linksBroken", "num", strconv.Itoa(len(broken))), broken)
+	}
+
+	return result(CheckLinks, StatusOK, c.i18n.Ts("campaigns.checks.linksOK", "num", strconv.Itoa(len(urls))), nil)
+}
+
+// checkLink requests a link and returns the error or the failed status, if any.
+func (c *Checker) checkLink(ctx context.Context, u string) string {
+	code, err := c.request(ctx, http.MethodHead, u)
+
+	// Not every server responds to HEAD requests.
+	if err != nil || code == http.StatusMethodNotAllowed || code == http.StatusForbidden ||
+		code == http.StatusNotFound || code == http.StatusNotImplemented {
+		code, err = c.request(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		var uErr *url.Error
+		if errors.As(err, &uErr) {
+			err = uErr.Err
+		}
+		return err.Error()
+	}
+
+	if code >= http.StatusBadRequest {
+		return strconv.Itoa(code) + " " + http.StatusText(code)
+	}
+
+	return ""
+}
+
+func (c *Checker) request(ctx context.Context, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "listmonk")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain a bit of the body so that the connection can be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	return resp.StatusCode, nil
+}
+
+// isAllowed returns true if a host or its parent domain is on the link allowlist.
+func (c *Checker) isAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range c.cfg.LinkAllowlist {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPublic returns true if an address is a publicly routable unicast address.
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap()
+
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !cgnat.Contains(ip)
+}
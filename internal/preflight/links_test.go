@@ -0,0 +1,252 @@
+package preflight
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/i18n"
+)
+
+// newChecker returns a Checker with the English language pack.
+func newChecker(t *testing.T, cfg Config, dns Resolver, client *http.Client) *Checker {
+	t.Helper()
+
+	b, err := os.ReadFile("../../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(cfg, dns, client, i)
+}
+
+// newLinkServer serves the links that are checked and records the requests it receives
+// as "METHOD /path".
+func newLinkServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	var (
+		mut  sync.Mutex
+		reqs = []string{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mut.Lock()
+		reqs = append(reqs, r.Method+" "+r.URL.Path)
+		mut.Unlock()
+
+		if r.Header.Get("User-Agent") != "listmonk" {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+
+		// Servers that don't respond to HEAD requests.
+		case "/no-head", "/head-forbidden", "/head-not-found":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(map[string]int{
+					"/no-head":        http.StatusMethodNotAllowed,
+					"/head-forbidden": http.StatusForbidden,
+					"/head-not-found": http.StatusNotFound,
+				}[r.URL.Path])
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, func() []string {
+		mut.Lock()
+		defer mut.Unlock()
+		return append([]string{}, reqs...)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	srv, reqs := newLinkServer(t)
+
+	c := newChecker(t, Config{
+		RootURL:       "https://lists.example.com",
+		LinkAllowlist: []string{"allowed.test"},
+	}, nil, srv.Client())
+
+	links := []string{
+		srv.URL + "/ok",
+		srv.URL + "/ok",
+		srv.URL + "/redirect",
+		srv.URL + "/no-head",
+		srv.URL + "/head-forbidden",
+		srv.URL + "/head-not-found",
+		srv.URL + "/missing",
+		srv.URL + "/gone",
+		srv.URL + "/error",
+		srv.URL + "/unavailable",
+
+		// These aren't requested. The allowlisted hosts don't resolve and would be
+		// reported as broken if they were.
+		"https://lists.example.com/subscription/form",
+		"https://allowed.test/page",
+		"https://cdn.allowed.test/page",
+		"mailto:hello@example.com",
+		"#top",
+	}
+
+	res := c.checkLinks(context.Background(), links)
+	if res.Status != StatusError {
+		t.Errorf("got status %q, want %q", res.Status, StatusError)
+	}
+
+	wantBroken := []string{
+		srv.URL + "/error (500 Internal Server Error)",
+		srv.URL + "/gone (410 Gone)",
+		srv.URL + "/missing (404 Not Found)",
+		srv.URL + "/unavailable (503 Service Unavailable)",
+	}
+	if !reflect.DeepEqual(res.Items, wantBroken) {
+		t.Errorf("got broken links:\n%v\nwant:\n%v", res.Items, wantBroken)
+	}
+
+	// Duplicate links are requested once, and links are requested again with GET only
+	// if the HEAD request is refused or not found.
+	got := map[string]int{}
+	for _, r := range reqs() {
+		got[r]++
+	}
+	want := map[string]int{
+		"HEAD /ok":             2,
+		"HEAD /redirect":       1,
+		"HEAD /no-head":        1,
+		"GET /no-head":         1,
+		"HEAD /head-forbidden": 1,
+		"GET /head-forbidden":  1,
+		"HEAD /head-not-found": 1,
+		"GET /head-not-found":  1,
+		"HEAD /missing":        1,
+		"GET /missing":         1,
+		"HEAD /gone":           1,
+		"HEAD /error":          1,
+		"HEAD /unavailable":    1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got requests:\n%v\nwant:\n%v", got, want)
+	}
+}
+
+func TestCheckLinksOK(t *testing.T) {
+	srv, _ := newLinkServer(t)
+	c := newChecker(t, Config{LinkAllowlist: []string{"allowed.test"}}, nil, srv.Client())
+
+	res := c.checkLinks(context.Background(), []string{srv.URL + "/ok", srv.URL + "/no-head"})
+	if res.Status != StatusOK || len(res.Items) != 0 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// Only allowlisted links.
+	res = c.checkLinks(context.Background(), []string{"https://allowed.test", "https://www.allowed.test/page"})
+	if res.Status != StatusOK || res.Message != c.i18n.T("campaigns.checks.linksNone") {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
+
+func TestCheckLinksMax(t *testing.T) {
+	srv, reqs := newLinkServer(t)
+	c := newChecker(t, Config{MaxLinks: 2}, nil, srv.Client())
+
+	c.checkLinks(context.Background(), []string{srv.URL + "/ok", srv.URL + "/ok", srv.URL + "/redirect", srv.URL + "/error"})
+	if r := reqs(); len(r) != 3 || strings.Contains(strings.Join(r, ","), "/error") {
+		t.Errorf("got requests %v, want the first 2 links", r)
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	c := newChecker(t, Config{LinkAllowlist: []string{"example.com", "cdn.test"}}, nil, nil)
+
+	for host, want := range map[string]bool{
+		"example.com":       true,
+		"EXAMPLE.com":       true,
+		"www.example.com":   true,
+		"a.b.example.com":   true,
+		"cdn.test":          true,
+		"badexample.com":    false,
+		"example.com.evil":  false,
+		"other.test":        false,
+		"img.cdn.test.evil": false,
+	} {
+		if got := c.isAllowed(host); got != want {
+			t.Errorf("%s: got %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv, reqs := newLinkServer(t)
+
+	// The client refuses to connect to the loopback address of the test server.
+	c := newChecker(t, Config{}, nil, NewClient(time.Second*5))
+	res := c.checkLinks(context.Background(), []string{srv.URL + "/ok"})
+	if res.Status != StatusError || len(res.Items) != 1 || !strings.Contains(res.Items[0], "non-public address") {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if r := reqs(); len(r) != 0 {
+		t.Errorf("got requests %v, want none", r)
+	}
+
+	// The address is refused before a connection is attempted.
+	for _, u := range []string{"http://[::1]:1/", "http://169.254.169.254/latest/meta-data/", "http://10.0.0.1:8080/"} {
+		if _, err := NewClient(time.Second * 5).Get(u); err == nil || !strings.Contains(err.Error(), "non-public address") {
+			t.Errorf("%s: got error %v, want a non-public address error", u, err)
+		}
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"100.64.0.1":           false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"::":                   false,
+		"224.0.0.1":            false,
+		"255.255.255.255":      false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:10.0.0.1":      false,
+		"::ffff:93.184.215.14": true,
+	} {
+		if got := isPublic(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: got %v, want %v", addr, got, want)
+		}
+	}
+}
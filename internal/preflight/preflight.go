@@ -0,0 +1,185 @@
+// Package preflight runs pre-send checks on a campaign message rendered for a sample
+// subscriber: its links, unsubscribe link and header, size, images and template
+// variables, and the SPF, DKIM and DMARC records of its from-domain.
+package preflight
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/knadh/listmonk/internal/i18n"
+)
+
+// Check names.
+const (
+	CheckLinks        = "links"
+	CheckUnsubscribe  = "unsubscribe"
+	CheckSize         = "size"
+	CheckImageRatio   = "image_ratio"
+	CheckAltText      = "alt_text"
+	CheckTemplateVars = "template_vars"
+	CheckSPF          = "spf"
+	CheckDKIM         = "dkim"
+	CheckDMARC        = "dmarc"
+	CheckAlignment    = "alignment"
+)
+
+// Check statuses.
+const (
+	StatusOK      = "ok"
+	StatusWarning = "warning"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+// Resolver looks up DNS records. net.Resolver satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// Config holds the checker configuration.
+type Config struct {
+	// RootURL is the root URL of the app. Its links (unsubscribe, archive etc.) aren't checked.
+	RootURL string
+
+	// LinkAllowlist is the list of hosts whose links aren't checked, eg: sites that
+	// block automated requests. Subdomains of the hosts are also allowed.
+	LinkAllowlist []string
+
+	// DKIMSelectors are the selectors whose DKIM keys are looked up on the from-domain.
+	DKIMSelectors []string
+
+	// MaxSize is the size of the HTML body in bytes over which Gmail clips messages.
+	MaxSize int
+
+	// MinTextPerImage is the number of characters of text per image under which
+	// a message is image heavy.
+	MinTextPerImage int
+
+	// MaxLinks is the maximum number of links in a message that are checked.
+	MaxLinks int
+
+	// Concurrency is the number of links that are checked at a time.
+	Concurrency int
+}
+
+// Server is an SMTP server that a campaign is sent through.
+type Server struct {
+	Name      string
+	Host      string
+	FromEmail string
+}
+
+// Message is a campaign message rendered for a sample subscriber.
+type Message struct {
+	Subject     string
+	Body        string
+	ContentType string
+	Headers     textproto.MIMEHeader
+
+	// UnsubURL is the unsubscribe URL of the sample subscriber.
+	UnsubURL string
+
+	// Source is the unrendered subject and body, which are checked for subscriber
+	// attributes that are missing on the sample subscriber's Attribs.
+	Source  string
+	Attribs map[string]any
+
+	FromEmail string
+	Servers   []Server
+}
+
+// Result is the result of a check. Items are the offending links, images,
+// variables or servers.
+type Result struct {
+	Check   string   `json:"check"`
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Items   []string `json:"items"`
+}
+
+// Report is the result of all checks on a message.
+type Report struct {
+	Errors   int      `json:"errors"`
+	Warnings int      `json:"warnings"`
+	Results  []Result `json:"results"`
+}
+
+// Checker runs the checks.
+type Checker struct {
+	cfg  Config
+	dns  Resolver
+	http *http.Client
+	i18n *i18n.I18n
+}
+
+// New returns a new Checker. Links are requested with the HTTP client, which
+// defaults to NewClient, and DNS records are looked up with the resolver.
+func New(cfg Config, dns Resolver, client *http.Client, i *i18n.I18n) *Checker {
+	if cfg.MaxSize < 1 {
+		cfg.MaxSize = 102 * 1024
+	}
+	if cfg.MinTextPerImage < 1 {
+		cfg.MinTextPerImage = 400
+	}
+	if cfg.MaxLinks < 1 {
+		cfg.MaxLinks = 200
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 8
+	}
+	if dns == nil {
+		dns = net.DefaultResolver
+	}
+	if client == nil {
+		client = NewClient(time.Second * 10)
+	}
+
+	return &Checker{
+		cfg:  cfg,
+		dns:  dns,
+		http: client,
+		i18n: i,
+	}
+}
+
+// Check runs all checks on a message.
+func (c *Checker) Check(ctx context.Context, m Message) Report {
+	doc := parse(m.Body, m.ContentType)
+
+	out := Report{
+		Results: []Result{
+			c.checkLinks(ctx, doc.links),
+			c.checkUnsubscribe(m),
+			c.checkSize(m),
+			c.checkImageRatio(doc),
+			c.checkAltText(doc),
+			c.checkTemplateVars(m),
+		},
+	}
+	out.Results = append(out.Results, c.checkDNS(ctx, m.FromEmail, m.Servers)...)
+
+	for _, r := range out.Results {
+		switch r.Status {
+		case StatusError:
+			out.Errors++
+		case StatusWarning:
+			out.Warnings++
+		}
+	}
+
+	return out
+}
+
+func result(check, status, msg string, items []string) Result {
+	if items == nil {
+		items = []string{}
+	}
+
+	return Result{Check: check, Status: status, Message: msg, Items: items}
+}
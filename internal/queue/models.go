@@ -10,6 +10,7 @@ type EmailQueueItem struct {
 	ID                    int64     `db:"id" json:"id"`
 	CampaignID            int       `db:"campaign_id" json:"campaign_id"`
 	SubscriberID          int       `db:"subscriber_id" json:"subscriber_id"`
+	VariantID             sql.NullInt64 `db:"variant_id" json:"variant_id"`
 	Status                string    `db:"status" json:"status"`
 	Priority              int       `db:"priority" json:"priority"`
 	ScheduledAt           time.Time `db:"scheduled_at" json:"scheduled_at"`
@@ -19,6 +20,9 @@ type EmailQueueItem struct {
 	LastError              sql.NullString  `db:"last_error" json:"last_error,omitempty"`
 	CreatedAt             time.Time `db:"created_at" json:"created_at"`
 	UpdatedAt             time.Time `db:"updated_at" json:"updated_at"`
+
+	// TxMessage is the tx message of an entry that isn't a campaign e-mail (CampaignID is 0).
+	TxMessage []byte `db:"tx_message" json:"-"`
 }
 
 // Queue item statuses
@@ -20,7 +20,8 @@ type Processor struct {
 
 	// Campaign and messenger access
 	getCampaign func(int) (*models.Campaign, error)
-	pushEmail   func(campaignID int, subID int, serverUUID string) error
+	pushEmail   func(campaignID int, subID int, variantID int, serverUUID string) error
+	pushTx      func(subID int, msg []byte, serverUUID string) error
 
 	// Control channels
 	stopChan chan struct{}
@@ -60,10 +61,15 @@ func New(db *sqlx.DB, cfg Config, log *log.Logger) *Processor {
 }
 
 // SetPushEmailCallback sets the callback function for actually sending emails
-func (p *Processor) SetPushEmailCallback(fn func(campaignID int, subID int, serverUUID string) error) {
+func (p *Processor) SetPushEmailCallback(fn func(campaignID int, subID int, variantID int, serverUUID string) error) {
 	p.pushEmail = fn
 }
 
+// SetPushTxCallback sets the callback function for sending the queued tx messages
+func (p *Processor) SetPushTxCallback(fn func(subID int, msg []byte, serverUUID string) error) {
+	p.pushTx = fn
+}
+
 // Start begins processing the queue
 func (p *Processor) Start() {
 	p.log.Println("starting queue processor")
@@ -533,8 +539,8 @@ func (p *Processor) processQueue() error {
 			p.log.Printf("✓ email %d (campaign %d, subscriber %d) delivered successfully via server %s",
 				em.ID, em.CampaignID, em.SubscriberID, srv)
 
-			// Update Smart Sending tracking after successful send
-			if settings.AppSmartSendingEnabled {
+			// Update Smart Sending tracking after successful send. Tx messages don't count.
+			if settings.AppSmartSendingEnabled && em.CampaignID != 0 {
 				if _, err := p.db.Exec(
 					`INSERT INTO subscriber_last_send (subscriber_id, last_campaign_send_at, updated_at)
 					 VALUES ($1, NOW(), NOW())
@@ -595,6 +601,7 @@ func (p *Processor) checkCompletedCampaigns() error {
 	// 1. Are currently running
 	// 2. Use the queue (use_queue=true)
 	// 3. Have no queued or sending emails left
+	// 4. Are not waiting for the winner of an A/B test to be picked
 	query := `
 		SELECT DISTINCT c.id, c.name
 		FROM campaigns c
@@ -611,6 +618,7 @@ func (p *Processor) checkCompletedCampaigns() error {
 		    FROM email_queue eq
 		    WHERE eq.campaign_id = c.id
 		  )
+		  AND (c.ab_test_ends_at IS NULL OR c.ab_winner_id IS NOT NULL)
 	`
 
 	type campaignInfo struct {
@@ -696,15 +704,17 @@ func (p *Processor) getNextBatch() ([]EmailQueueItem, error) {
 		// OPTIMIZED: Filter Smart Sending subscribers at SQL level
 		// This prevents fetching emails that will be skipped, avoiding rate limit waste
 		query = `
-			SELECT eq.id, eq.campaign_id, eq.subscriber_id, eq.status, eq.priority,
+			SELECT eq.id, COALESCE(eq.campaign_id, 0) AS campaign_id, eq.subscriber_id, eq.variant_id, eq.status, eq.priority,
 			       eq.scheduled_at, eq.sent_at, eq.assigned_smtp_server_uuid,
-			       eq.retry_count, eq.last_error, eq.created_at, eq.updated_at
+			       eq.retry_count, eq.last_error, eq.created_at, eq.updated_at, eq.tx_message
 			FROM email_queue eq
 			LEFT JOIN subscriber_last_send sls ON eq.subscriber_id = sls.subscriber_id
 			WHERE eq.status = $1
 			  AND eq.scheduled_at <= NOW()
 			  AND (
-			    sls.last_campaign_send_at IS NULL
+			    -- Tx messages aren't subject to Smart Sending.
+			    eq.campaign_id IS NULL
+			    OR sls.last_campaign_send_at IS NULL
 			    OR sls.last_campaign_send_at <= NOW() - INTERVAL '1 hour' * $2
 			  )
 			ORDER BY eq.priority DESC, eq.scheduled_at ASC
@@ -714,9 +724,9 @@ func (p *Processor) getNextBatch() ([]EmailQueueItem, error) {
 	} else {
 		// Smart Sending disabled, fetch all queued emails
 		query = `
-			SELECT id, campaign_id, subscriber_id, status, priority,
+			SELECT id, COALESCE(campaign_id, 0) AS campaign_id, subscriber_id, variant_id, status, priority,
 			       scheduled_at, sent_at, assigned_smtp_server_uuid,
-			       retry_count, last_error, created_at, updated_at
+			       retry_count, last_error, created_at, updated_at, tx_message
 			FROM email_queue
 			WHERE status = $1
 			  AND scheduled_at <= NOW()
@@ -966,10 +976,18 @@ func (p *Processor) getSettings() (models.Settings, error) {
 }
 
 func (p *Processor) sendEmail(email EmailQueueItem, serverUUID string) error {
+	// Tx messages (eg: those of commerce flows) have no campaign.
+	if email.CampaignID == 0 {
+		if p.pushTx == nil {
+			return fmt.Errorf("no push callback for tx message %d", email.ID)
+		}
+		return p.pushTx(email.SubscriberID, email.TxMessage, serverUUID)
+	}
+
 	// Check if this is a callback-based send (integrated with campaign manager)
 	if p.pushEmail != nil {
 		// Use the campaign manager's push logic
-		return p.pushEmail(email.CampaignID, email.SubscriberID, serverUUID)
+		return p.pushEmail(email.CampaignID, email.SubscriberID, int(email.VariantID.Int64), serverUUID)
 	}
 
 	// Fallback: In testing mode or when no pushEmail callback is set,
@@ -0,0 +1,120 @@
+// Package shopify is a minimal client for the Shopify Admin REST API.
+package shopify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+)
+
+// DefaultAPIVersion is the Admin API version used when none is configured.
+const DefaultAPIVersion = "2024-10"
+
+// pageSize is the number of records fetched per request (max allowed by Shopify).
+const pageSize = 250
+
+// reNextLink matches the next page URL in the Link response header, eg:
+// <https://shop.myshopify.com/admin/api/2024-10/customers.json?limit=250&page_info=xyz>; rel="next"
+var reNextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Opt represents the client options.
+type Opt struct {
+	// ShopURL is the base URL of the shop, eg: https://shop.myshopify.com.
+	// It can point to a mock of the Admin API for testing.
+	ShopURL     string
+	AccessToken string
+	APIVersion  string
+	Timeout     time.Duration
+}
+
+// Client is a Shopify Admin API client.
+type Client struct {
+	opt Opt
+	c   *http.Client
+}
+
+// New returns a new Admin API client.
+func New(o Opt) (*Client, error) {
+	if o.ShopURL == "" || o.AccessToken == "" {
+		return nil, errors.New("shop URL and access token are required")
+	}
+
+	if _, err := url.Parse(o.ShopURL); err != nil {
+		return nil, fmt.Errorf("invalid shop URL: %v", err)
+	}
+
+	o.ShopURL = strings.TrimRight(o.ShopURL, "/")
+	if o.APIVersion == "" {
+		o.APIVersion = DefaultAPIVersion
+	}
+	if o.Timeout == 0 {
+		o.Timeout = time.Second * 30
+	}
+
+	return &Client{opt: o, c: &http.Client{Timeout: o.Timeout}}, nil
+}
+
+// Customers fetches all customers page by page and calls fn with every page.
+// It stops on the first error returned by fn.
+func (c *Client) Customers(fn func([]webhooks.ShopifyCustomer) error) error {
+	u := fmt.Sprintf("%s/admin/api/%s/customers.json?limit=%d", c.opt.ShopURL, c.opt.APIVersion, pageSize)
+	for u != "" {
+		var out struct {
+			Customers []webhooks.ShopifyCustomer `json:"customers"`
+		}
+
+		next, err := c.get(u, &out)
+		if err != nil {
+			return err
+		}
+
+		if len(out.Customers) > 0 {
+			if err := fn(out.Customers); err != nil {
+				return err
+			}
+		}
+
+		u = next
+	}
+
+	return nil
+}
+
+// get fetches and decodes a URL and returns the URL of the next page, if any.
+func (c *Client) get(u string, out interface{}) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Shopify-Access-Token", c.opt.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("shopify API returned %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return "", fmt.Errorf("error decoding shopify API response: %v", err)
+	}
+
+	if m := reNextLink.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		return m[1], nil
+	}
+
+	return "", nil
+}
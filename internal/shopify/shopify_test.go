@@ -0,0 +1,196 @@
+package shopify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+)
+
+const testToken = "shpat_test"
+
+// customerPages are the Admin API customer pages served by the mock, keyed by page_info.
+var customerPages = map[string]string{
+	"": `{"customers": [
+		{"id": 1, "email": "subscribed@example.com", "first_name": "Ada", "last_name": "Lovelace",
+			"tags": "vip, wholesale ,", "email_marketing_consent": {"state": "subscribed", "opt_in_level": "single_opt_in"},
+			"total_spent": "199.50", "orders_count": 3, "currency": "EUR"},
+		{"id": 2, "email": "pending@example.com", "email_marketing_consent": {"state": "pending"}},
+		{"id": 3, "email": "unsubscribed@example.com", "email_marketing_consent": {"state": "unsubscribed"}}
+	]}`,
+	"page2": `{"customers": [
+		{"id": 4, "email": "not-subscribed@example.com", "email_marketing_consent": {"state": "not_subscribed"}},
+		{"id": 5, "email": "redacted@example.com", "email_marketing_consent": {"state": "redacted"}},
+		{"id": 6, "email": "legacy@example.com", "accepts_marketing": true},
+		{"id": 7, "email": "legacy-no@example.com", "accepts_marketing": false}
+	]}`,
+	"page3": `{"customers": []}`,
+}
+
+// newMock returns a mock of the Admin API customers endpoint that pages through
+// customerPages with Link headers, and the list of requests it received.
+func newMock(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var (
+		reqs = []string{}
+		next = map[string]string{"": "page2", "page2": "page3"}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqs = append(reqs, r.URL.RequestURI())
+
+		if r.Header.Get("X-Shopify-Access-Token") != testToken {
+			http.Error(w, `{"errors":"[API] Invalid API key or access token"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/admin/api/2024-10/customers.json" || r.URL.Query().Get("limit") != "250" {
+			http.NotFound(w, r)
+			return
+		}
+
+		page := r.URL.Query().Get("page_info")
+		body, ok := customerPages[page]
+		if !ok {
+			http.Error(w, `{"errors":"invalid page_info"}`, http.StatusBadRequest)
+			return
+		}
+
+		links := []string{}
+		if page != "" {
+			links = append(links, fmt.Sprintf(`<http://%s/admin/api/2024-10/customers.json?limit=250&page_info=prev>; rel="previous"`, r.Host))
+		}
+		if n, ok := next[page]; ok {
+			links = append(links, fmt.Sprintf(`<http://%s/admin/api/2024-10/customers.json?limit=250&page_info=%s>; rel="next"`, r.Host, n))
+		}
+		if len(links) > 0 {
+			w.Header().Set("Link", strings.Join(links, ", "))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &reqs
+}
+
+func TestCustomers(t *testing.T) {
+	srv, reqs := newMock(t)
+
+	c, err := New(Opt{ShopURL: srv.URL + "/", AccessToken: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		pages = 0
+		custs = []webhooks.ShopifyCustomer{}
+	)
+	if err := c.Customers(func(cs []webhooks.ShopifyCustomer) error {
+		pages++
+		custs = append(custs, cs...)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Empty pages aren't passed on.
+	if pages != 2 {
+		t.Errorf("got %d pages, want 2", pages)
+	}
+	wantReqs := []string{
+		"/admin/api/2024-10/customers.json?limit=250",
+		"/admin/api/2024-10/customers.json?limit=250&page_info=page2",
+		"/admin/api/2024-10/customers.json?limit=250&page_info=page3",
+	}
+	if !reflect.DeepEqual(*reqs, wantReqs) {
+		t.Errorf("got requests %v, want %v", *reqs, wantReqs)
+	}
+
+	// The consent of each customer that the sync maps to subscriptions.
+	wantConsent := map[int64]string{
+		1: webhooks.ShopifyConsentSubscribed,
+		2: webhooks.ShopifyConsentPending,
+		3: webhooks.ShopifyConsentUnsubscribed,
+		4: webhooks.ShopifyConsentUnsubscribed,
+		5: webhooks.ShopifyConsentUnsubscribed,
+		6: webhooks.ShopifyConsentSubscribed,
+		7: webhooks.ShopifyConsentUnsubscribed,
+	}
+	if len(custs) != len(wantConsent) {
+		t.Fatalf("got %d customers, want %d", len(custs), len(wantConsent))
+	}
+	for _, cu := range custs {
+		if got := cu.Consent(); got != wantConsent[cu.ID] {
+			t.Errorf("customer %d (%s): got consent %q, want %q", cu.ID, cu.Email, got, wantConsent[cu.ID])
+		}
+	}
+
+	cu := custs[0]
+	if cu.FirstName != "Ada" || cu.LastName != "Lovelace" || cu.TotalSpent != "199.50" ||
+		cu.OrdersCount != 3 || cu.Currency != "EUR" {
+		t.Errorf("unexpected customer: %+v", cu)
+	}
+	if tags := cu.TagList(); !reflect.DeepEqual(tags, []string{"vip", "wholesale"}) {
+		t.Errorf("got tags %v, want [vip wholesale]", tags)
+	}
+}
+
+func TestCustomersStopsOnError(t *testing.T) {
+	srv, reqs := newMock(t)
+
+	c, err := New(Opt{ShopURL: srv.URL, AccessToken: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An error from the callback stops the pagination.
+	errStop := fmt.Errorf("stop")
+	if err := c.Customers(func([]webhooks.ShopifyCustomer) error {
+		return errStop
+	}); err != errStop {
+		t.Errorf("got error %v, want %v", err, errStop)
+	}
+	if len(*reqs) != 1 {
+		t.Errorf("got %d requests, want 1", len(*reqs))
+	}
+}
+
+func TestCustomersAPIError(t *testing.T) {
+	srv, _ := newMock(t)
+
+	c, err := New(Opt{ShopURL: srv.URL, AccessToken: "invalid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Customers(func([]webhooks.ShopifyCustomer) error {
+		t.Error("unexpected page")
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v, want a 401 error", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Opt{ShopURL: "https://shop.myshopify.com"}); err == nil {
+		t.Error("expected an error without an access token")
+	}
+	if _, err := New(Opt{AccessToken: testToken}); err == nil {
+		t.Error("expected an error without a shop URL")
+	}
+
+	c, err := New(Opt{ShopURL: "https://shop.myshopify.com/", AccessToken: testToken, APIVersion: "2025-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.opt.ShopURL != "https://shop.myshopify.com" || c.opt.APIVersion != "2025-01" {
+		t.Errorf("unexpected options: %+v", c.opt)
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"maps"
 	"net/textproto"
+	"net/url"
 	"regexp"
+	"slices"
 	"strings"
 	txttpl "text/template"
 	"time"
@@ -42,6 +45,8 @@ const (
 	CampaignStatusPaused        = "paused"
 	CampaignStatusFinished      = "finished"
 	CampaignStatusCancelled     = "cancelled"
+	CampaignStatusPendingReview = "pending_review"
+	CampaignStatusApproved      = "approved"
 	CampaignTypeRegular         = "regular"
 	CampaignTypeOptin           = "optin"
 	CampaignContentTypeRichtext = "richtext"
@@ -50,6 +55,22 @@ const (
 	CampaignContentTypePlain    = "plain"
 	CampaignContentTypeVisual   = "visual"
 
+	// Values of utm_campaign appended to the tracked links of a campaign.
+	CampaignUTMCampaignUUID = "uuid"
+	CampaignUTMCampaignName = "name"
+
+	// Metrics by which the winner of a campaign's A/B test is picked.
+	ABTestMetricOpens   = "opens"
+	ABTestMetricClicks  = "clicks"
+	ABTestMetricRevenue = "revenue"
+
+	// Actions in the review of a campaign.
+	CampaignReviewSubmitted = "submitted"
+	CampaignReviewApproved  = "approved"
+	CampaignReviewRejected  = "rejected"
+	CampaignReviewCommented = "commented"
+	CampaignReviewReset     = "reset"
+
 	// List.
 	ListTypePrivate = "private"
 	ListTypePublic  = "public"
@@ -240,6 +261,49 @@ type Campaign struct {
 	ArchiveSlug       null.String     `db:"archive_slug" json:"archive_slug"`
 	ArchiveTemplateID null.Int        `db:"archive_template_id" json:"archive_template_id"`
 	ArchiveMeta       json.RawMessage `db:"archive_meta" json:"archive_meta"`
+	UTM               CampaignUTM     `db:"utm" json:"utm"`
+
+	// HoldoutPercent is the percentage of the campaign's audience that's held out
+	// as a control group and not sent the campaign.
+	HoldoutPercent float64 `db:"holdout_percent" json:"holdout_percent"`
+
+	// ABTest is the A/B test of the campaign's variants. ABTestEndsAt is set when the
+	// test starts, and ABWinnerID and ABWinnerSentAt when the winning variant is picked
+	// and queued to the rest of the audience. Variants are only loaded by the get-campaign query.
+	ABTest         CampaignABTest   `db:"ab_test" json:"ab_test"`
+	ABTestEndsAt   null.Time        `db:"ab_test_ends_at" json:"ab_test_ends_at"`
+	ABWinnerID     null.Int         `db:"ab_winner_id" json:"ab_winner_id"`
+	ABWinnerSentAt null.Time        `db:"ab_winner_sent_at" json:"ab_winner_sent_at"`
+	Variants       CampaignVariants `db:"variants" json:"variants"`
+
+	// Resend is the rule for automatically resending the campaign to its non-openers
+	// when it finishes.
+	Resend CampaignResend `db:"resend" json:"resend"`
+
+	// ExcludeListIDs are the lists whose subscribers aren't sent the campaign. SegmentQuery
+	// is an arbitrary SQL expression on subscribers that filters the audience. Campaigns with
+	// a SegmentQuery or AudienceSnapshot are only sent to the snapshot of their audience
+	// that's taken when they start (AudienceSnapshotAt), of AudienceSize subscribers.
+	ExcludeListIDs     pq.Int64Array `db:"exclude_list_ids" json:"exclude_list_ids"`
+	SegmentQuery       string        `db:"segment_query" json:"segment_query"`
+	AudienceSnapshot   bool          `db:"audience_snapshot" json:"audience_snapshot"`
+	AudienceSnapshotAt null.Time     `db:"audience_snapshot_at" json:"audience_snapshot_at"`
+	AudienceSize       int           `db:"audience_size" json:"audience_size"`
+
+	// ReviewContent is the content of the campaign that was submitted for review in the
+	// approval workflow. It's approved (ApprovedAt) once ApprovalsRequired reviewers have
+	// approved it, and is reset if the content is edited.
+	ReviewContent     CampaignContent `db:"review_content" json:"review_content"`
+	ApprovalsRequired int             `db:"approvals_required" json:"approvals_required"`
+	ApprovedAt        null.Time       `db:"approved_at" json:"approved_at"`
+	SubmittedBy       null.Int        `db:"submitted_by" json:"submitted_by"`
+
+	// SentVersion is the version of the content that was sent, recorded when the campaign starts.
+	SentVersion null.Int `db:"sent_version" json:"sent_version"`
+
+	// FeedItems are the new items of the RSS or Atom feed of a recurring campaign
+	// that are sent in a digest campaign, available in its template as .Items.
+	FeedItems FeedItems `db:"feed_items" json:"feed_items"`
 
 	// TemplateBody is joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
@@ -260,6 +324,180 @@ type Campaign struct {
 	Total int `db:"total" json:"-"`
 }
 
+// CampaignUTM holds the UTM parameters that are appended to the tracked links
+// of a campaign when they are clicked.
+type CampaignUTM struct {
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+	Medium  string `json:"medium"`
+
+	// Campaign is the campaign identity sent as utm_campaign, either
+	// the campaign's UUID (default) or its name.
+	Campaign string `json:"campaign"`
+
+	// Content sends the position of the link in the message as utm_content.
+	Content bool `json:"content"`
+}
+
+// CampaignABTest is the A/B test of a campaign. The campaign's variants are split
+// evenly across a test slice of its audience, and after the test duration, the
+// variant with the best metric is sent to the rest of the audience.
+type CampaignABTest struct {
+	Enabled bool `json:"enabled"`
+
+	// TestPercent is the percentage of the audience that the variants are tested on.
+	TestPercent float64 `json:"test_percent"`
+
+	// Metric is the metric the winner is picked by: opens, clicks or revenue.
+	Metric          string `json:"metric"`
+	DurationMinutes int    `json:"duration_minutes"`
+}
+
+// CampaignResend is the rule for resending a campaign to its recipients who haven't opened
+// it. The resend is a child campaign that's scheduled AfterHours after the campaign finishes,
+// with an optional different subject.
+type CampaignResend struct {
+	Enabled    bool   `json:"enabled"`
+	AfterHours int    `json:"after_hours"`
+	Subject    string `json:"subject"`
+}
+
+// CampaignResendStats is the engagement of a campaign or one of its resends to non-openers.
+// TotalOpeners and TotalClickers are the unique subscribers across the campaign and all
+// its resends.
+type CampaignResendStats struct {
+	CampaignID    int       `db:"campaign_id" json:"campaign_id"`
+	Name          string    `db:"name" json:"name"`
+	Subject       string    `db:"subject" json:"subject"`
+	Status        string    `db:"status" json:"status"`
+	SendAt        null.Time `db:"send_at" json:"send_at"`
+	StartedAt     null.Time `db:"started_at" json:"started_at"`
+	IsParent      bool      `db:"is_parent" json:"is_parent"`
+	AfterHours    null.Int  `db:"after_hours" json:"after_hours"`
+	Sent          int       `db:"sent" json:"sent"`
+	Openers       int       `db:"openers" json:"openers"`
+	Clickers      int       `db:"clickers" json:"clickers"`
+	TotalOpeners  int       `db:"total_openers" json:"-"`
+	TotalClickers int       `db:"total_clickers" json:"-"`
+}
+
+// CampaignContent is the content of a campaign that's reviewed and approved in the approval
+// workflow. Any change to it needs another review.
+type CampaignContent struct {
+	Subject        string           `json:"subject"`
+	FromEmail      string           `json:"from_email"`
+	Body           string           `json:"body"`
+	AltBody        null.String      `json:"altbody"`
+	ContentType    string           `json:"content_type"`
+	TemplateID     null.Int         `json:"template_id"`
+	Headers        Headers          `json:"headers"`
+	ListIDs        []int            `json:"lists"`
+	ExcludeListIDs pq.Int64Array    `json:"exclude_list_ids"`
+	SegmentQuery   string           `json:"segment_query"`
+	Variants       CampaignVariants `json:"variants"`
+}
+
+// Equal returns true if the content is the same as another. List IDs are compared in order.
+func (c CampaignContent) Equal(b CampaignContent) bool {
+	if c.Subject != b.Subject || c.FromEmail != b.FromEmail || c.Body != b.Body || c.AltBody != b.AltBody ||
+		c.ContentType != b.ContentType || c.TemplateID != b.TemplateID || c.SegmentQuery != b.SegmentQuery {
+		return false
+	}
+
+	if !slices.Equal(c.ListIDs, b.ListIDs) || !slices.Equal(c.ExcludeListIDs, b.ExcludeListIDs) ||
+		!c.Variants.Equal(b.Variants) || len(c.Headers) != len(b.Headers) {
+		return false
+	}
+	for i := range c.Headers {
+		if !maps.Equal(c.Headers[i], b.Headers[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CampaignVersion is a saved version of the content of a campaign. Sent is true for
+// the version that the campaign was sent with.
+type CampaignVersion struct {
+	ID          int         `db:"id" json:"id"`
+	CampaignID  int         `db:"campaign_id" json:"campaign_id"`
+	Version     int         `db:"version" json:"version"`
+	UserID      null.Int    `db:"user_id" json:"user_id"`
+	UserName    string      `db:"user_name" json:"user_name"`
+	Subject     string      `db:"subject" json:"subject"`
+	Body        string      `db:"body" json:"body,omitempty"`
+	BodySource  null.String `db:"body_source" json:"body_source,omitempty"`
+	AltBody     null.String `db:"altbody" json:"altbody,omitempty"`
+	ContentType string      `db:"content_type" json:"content_type"`
+	TemplateID  null.Int    `db:"template_id" json:"template_id"`
+	Headers     Headers     `db:"headers" json:"headers"`
+	Sent        bool        `db:"sent" json:"sent"`
+	CreatedAt   null.Time   `db:"created_at" json:"created_at"`
+}
+
+// CampaignReview is a submission, approval, rejection or comment in the review of a campaign.
+type CampaignReview struct {
+	ID         int       `db:"id" json:"id"`
+	CampaignID int       `db:"campaign_id" json:"campaign_id"`
+	UserID     null.Int  `db:"user_id" json:"user_id"`
+	UserName   string    `db:"user_name" json:"user_name"`
+	Action     string    `db:"action" json:"action"`
+	Comment    string    `db:"comment" json:"comment"`
+	CreatedAt  null.Time `db:"created_at" json:"created_at"`
+}
+
+// CampaignVariant is a variant of a campaign's subject, from address, body or
+// template. Empty fields are inherited from the campaign.
+type CampaignVariant struct {
+	ID         int         `db:"id" json:"id"`
+	CampaignID int         `db:"campaign_id" json:"-"`
+	Name       string      `db:"name" json:"name"`
+	Subject    string      `db:"subject" json:"subject"`
+	FromEmail  string      `db:"from_email" json:"from_email"`
+	Body       null.String `db:"body" json:"body"`
+	TemplateID null.Int    `db:"template_id" json:"template_id"`
+
+	// TemplateBody is joined in from templates by the get-campaign-variant query.
+	TemplateBody string `db:"template_body" json:"-"`
+}
+
+// CampaignVariants represents a slice of CampaignVariant.
+type CampaignVariants []CampaignVariant
+
+// Equal checks if two sets of variants have the same content, ignoring their IDs.
+func (v CampaignVariants) Equal(b CampaignVariants) bool {
+	if len(v) != len(b) {
+		return false
+	}
+
+	for i := range v {
+		x, y := v[i], b[i]
+		if x.Name != y.Name || x.Subject != y.Subject || x.FromEmail != y.FromEmail ||
+			x.Body != y.Body || x.TemplateID != y.TemplateID {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CampaignVariantStats contains the recipients and the engagement of a campaign's variant.
+// Views and Clicks are the number of recipients who opened the message or clicked a
+// link in it, and Revenue is the revenue credited to the campaign by their purchases.
+type CampaignVariantStats struct {
+	CampaignID int     `db:"campaign_id" json:"campaign_id"`
+	ID         int     `db:"id" json:"id"`
+	Name       string  `db:"name" json:"name"`
+	Winner     bool    `db:"winner" json:"winner"`
+	Sent       int     `db:"sent" json:"sent"`
+	Views      int     `db:"views" json:"views"`
+	Clicks     int     `db:"clicks" json:"clicks"`
+	Purchases  int     `db:"purchases" json:"purchases"`
+	Revenue    float64 `db:"revenue" json:"revenue"`
+	Currency   string  `db:"currency" json:"currency"`
+}
+
 // CampaignMeta contains fields tracking a campaign's progress.
 type CampaignMeta struct {
 	CampaignID int `db:"campaign_id" json:"-"`
@@ -280,10 +518,12 @@ type CampaignMeta struct {
 	Sent      int       `db:"sent" json:"sent"`
 	AzureSent int       `db:"azure_sent" json:"azure_sent"`
 
-	// Purchase attribution stats (from Shopify integration)
-	PurchaseOrders  int     `db:"purchase_orders" json:"purchase_orders"`
-	PurchaseRevenue float64 `db:"purchase_revenue" json:"purchase_revenue"`
-	PurchaseCurrency string `db:"purchase_currency" json:"purchase_currency"`
+	// Purchase attribution stats (from Shopify integration). PurchaseRevenue
+	// is net of refunds and cancellations.
+	PurchaseOrders       int     `db:"purchase_orders" json:"purchase_orders"`
+	PurchaseRevenue      float64 `db:"purchase_revenue" json:"purchase_revenue"`
+	PurchaseGrossRevenue float64 `db:"purchase_gross_revenue" json:"purchase_gross_revenue"`
+	PurchaseCurrency     string  `db:"purchase_currency" json:"purchase_currency"`
 }
 
 type CampaignStats struct {
@@ -312,6 +552,9 @@ type CampaignStats struct {
 	// Auto-pause tracking for time window functionality
 	AutoPaused   bool      `db:"auto_paused" json:"auto_paused"`
 	AutoPausedAt null.Time `db:"auto_paused_at" json:"auto_paused_at"`
+
+	// Stats of the variants of campaigns with an A/B test.
+	Variants []CampaignVariantStats `db:"-" json:"variants,omitempty"`
 }
 
 type CampaignAnalyticsCount struct {
@@ -332,6 +575,13 @@ type CampaignAzureDeliveryCount struct {
 	Count      int    `db:"count" json:"count"`
 }
 
+// CampaignSESEventCount represents SES event counts by event type.
+type CampaignSESEventCount struct {
+	CampaignID int    `db:"campaign_id" json:"campaign_id"`
+	EventType  string `db:"event_type" json:"event_type"`
+	Count      int    `db:"count" json:"count"`
+}
+
 // CampaignUnsubscriber represents a subscriber who unsubscribed after receiving a campaign
 type CampaignUnsubscriber struct {
 	ID             int       `db:"id" json:"id"`
@@ -361,6 +611,20 @@ type Template struct {
 	Tpl        *template.Template `json:"-"`
 }
 
+// TemplateVersion is a saved version of a template.
+type TemplateVersion struct {
+	ID         int         `db:"id" json:"id"`
+	TemplateID int         `db:"template_id" json:"template_id"`
+	Version    int         `db:"version" json:"version"`
+	UserID     null.Int    `db:"user_id" json:"user_id"`
+	UserName   string      `db:"user_name" json:"user_name"`
+	Name       string      `db:"name" json:"name"`
+	Subject    string      `db:"subject" json:"subject"`
+	Body       string      `db:"body" json:"body,omitempty"`
+	BodySource null.String `db:"body_source" json:"body_source,omitempty"`
+	CreatedAt  null.Time   `db:"created_at" json:"created_at"`
+}
+
 // Bounce represents a single bounce event.
 type Bounce struct {
 	ID        int             `db:"id" json:"id"`
@@ -394,15 +658,97 @@ type WebhookLog struct {
 	ResponseBody   null.String     `db:"response_body" json:"response_body"`
 	Processed      bool            `db:"processed" json:"processed"`
 	ErrorMessage   null.String     `db:"error_message" json:"error_message"`
+	ReplayOf       null.Int64      `db:"replay_of" json:"replay_of"`
 	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
 
+	// Fields extracted from the payload at ingest for filtering.
+	RecipientEmail    null.String `db:"recipient_email" json:"recipient_email"`
+	CampaignID        null.Int    `db:"campaign_id" json:"campaign_id"`
+	ExternalMessageID null.String `db:"external_message_id" json:"external_message_id"`
+	InboxID           null.Int64  `db:"inbox_id" json:"inbox_id"`
+
 	// Pseudofield for getting the total count in queries.
 	Total int `db:"total" json:"-"`
 }
 
+// WebhookLogRetention is the retention policy for the logs of a webhook type.
+// An empty type applies to all types that don't have a policy of their own.
+type WebhookLogRetention struct {
+	WebhookType string `json:"webhook_type"`
+	Days        int    `json:"days"`
+	MaxRows     int    `json:"max_rows"`
+}
+
+// Order sources.
+const (
+	OrderSourceShopify     = "shopify"
+	OrderSourceWooCommerce = "woocommerce"
+	OrderSourceStripe      = "stripe"
+	OrderSourceAPI         = "api"
+)
+
+// Order is a provider-neutral e-commerce order that's attributed to campaigns.
+// Shopify, WooCommerce and Stripe orders, and orders posted to the API are all
+// converted to it.
+type Order struct {
+	Source      string  `json:"source"`
+	OrderID     string  `json:"order_id"`
+	OrderNumber string  `json:"order_number"`
+	Email       string  `json:"email"`
+	TotalPrice  float64 `json:"total_price"`
+	Currency    string  `json:"currency"`
+
+	// LandingSite is the URL (or path and query) the customer landed on in the store.
+	// Its utm_* parameters are used for attribution.
+	LandingSite string    `json:"landing_site"`
+	CreatedAt   null.Time `json:"created_at"`
+	CancelledAt null.Time `json:"cancelled_at"`
+
+	// CheckoutToken is the token of the checkout the order was placed from, which
+	// recovers the checkout.
+	CheckoutToken string `json:"checkout_token"`
+
+	// Data is the raw order payload, which is stored with the purchase.
+	Data json.RawMessage `json:"data"`
+
+	LineItems []OrderLineItem `json:"line_items"`
+}
+
+// OrderLineItem is a product in an order. Handle is the product's URL slug
+// (/products/{handle}) and is derived from the title if it's empty.
+type OrderLineItem struct {
+	LineID    string  `json:"line_id"`
+	ProductID string  `json:"product_id"`
+	VariantID string  `json:"variant_id"`
+	SKU       string  `json:"sku"`
+	Title     string  `json:"title"`
+	Handle    string  `json:"handle"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+// CampaignProductStats represents the sales of a product attributed to a campaign
+// and the clicks on the product's links in the campaign.
+type CampaignProductStats struct {
+	ProductID string  `db:"product_id" json:"product_id"`
+	SKU       string  `db:"sku" json:"sku"`
+	Title     string  `db:"title" json:"title"`
+	Handle    string  `db:"handle" json:"handle"`
+	Orders    int     `db:"orders" json:"orders"`
+	Units     int     `db:"units" json:"units"`
+	Revenue   float64 `db:"revenue" json:"revenue"`
+	Currency  string  `db:"currency" json:"currency"`
+
+	// Featured is true if the product's link was clicked in the campaign.
+	Featured      bool `db:"featured" json:"featured"`
+	Clicks        int  `db:"clicks" json:"clicks"`
+	ClickedBuyers int  `db:"clicked_buyers" json:"clicked_buyers"`
+}
+
 // PurchaseAttribution represents a purchase attributed to a campaign.
 type PurchaseAttribution struct {
 	ID             int64           `db:"id" json:"id"`
+	Source         string          `db:"source" json:"source"`
 	CampaignID     null.Int        `db:"campaign_id" json:"campaign_id"`
 	SubscriberID   null.Int        `db:"subscriber_id" json:"subscriber_id"`
 	OrderID        string          `db:"order_id" json:"order_id"`
@@ -413,7 +759,17 @@ type PurchaseAttribution struct {
 	AttributedVia  null.String     `db:"attributed_via" json:"attributed_via"`
 	Confidence     null.String     `db:"confidence" json:"confidence"`
 	ShopifyData    json.RawMessage `db:"shopify_data" json:"shopify_data"`
+	RefundedAmount float64         `db:"refunded_amount" json:"refunded_amount"`
+	NetPrice       float64         `db:"net_price" json:"net_price"`
+	CancelledAt    null.Time       `db:"cancelled_at" json:"cancelled_at"`
+	OrderedAt      time.Time       `db:"ordered_at" json:"ordered_at"`
 	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt      null.Time       `db:"updated_at" json:"updated_at"`
+
+	// ExchangeRate converts the price to the reporting currency at order time.
+	// It's null if there was no rate for the currency.
+	ReportingCurrency null.String  `db:"reporting_currency" json:"reporting_currency"`
+	ExchangeRate      null.Float64 `db:"exchange_rate" json:"exchange_rate"`
 
 	// Pseudofield for getting the total count in queries.
 	Total int `db:"total" json:"-"`
@@ -421,10 +777,321 @@ type PurchaseAttribution struct {
 
 // CampaignPurchaseStats represents aggregate purchase statistics for a campaign.
 type CampaignPurchaseStats struct {
+	Model          string `db:"-" json:"model"`
+	TotalPurchases int    `db:"total_purchases" json:"total_purchases"`
+
+	// AttributedPurchases is the number of purchases credited to the campaign
+	// by the model. It's fractional for multi-touch models.
+	AttributedPurchases float64 `db:"attributed_purchases" json:"attributed_purchases"`
+
+	// TotalRevenue is net of refunds and cancellations. Revenue is in the
+	// reporting currency (Currency) and excludes unconverted purchases that
+	// have no exchange rate.
+	TotalRevenue         float64 `db:"total_revenue" json:"total_revenue"`
+	GrossRevenue         float64 `db:"gross_revenue" json:"gross_revenue"`
+	AvgOrderValue        float64 `db:"avg_order_value" json:"avg_order_value"`
+	UnconvertedPurchases int     `db:"unconverted_purchases" json:"unconverted_purchases"`
+	Currency             string  `db:"currency" json:"currency"`
+
+	// Currencies is the breakdown of the revenue in the original currencies.
+	Currencies []CurrencyRevenue `db:"-" json:"currencies"`
+}
+
+// CurrencyRevenue represents purchases and revenue in a single currency.
+type CurrencyRevenue struct {
+	Currency       string  `db:"currency" json:"currency"`
 	TotalPurchases int     `db:"total_purchases" json:"total_purchases"`
 	TotalRevenue   float64 `db:"total_revenue" json:"total_revenue"`
-	AvgOrderValue  float64 `db:"avg_order_value" json:"avg_order_value"`
-	Currency       string  `db:"currency" json:"currency"`
+	GrossRevenue   float64 `db:"gross_revenue" json:"gross_revenue"`
+}
+
+// ExchangeRate represents the rate of a currency per unit of the base currency.
+type ExchangeRate struct {
+	Currency  string    `db:"currency" json:"currency"`
+	Rate      float64   `db:"rate" json:"rate"`
+	Base      string    `db:"base" json:"base"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// Commerce flow triggers.
+const (
+	FlowTriggerCheckoutAbandoned = "checkout_abandoned"
+	FlowTriggerOrderPlaced       = "order_placed"
+)
+
+// Commerce flow job statuses.
+const (
+	FlowJobStatusPending   = "pending"
+	FlowJobStatusRunning   = "running"
+	FlowJobStatusSent      = "sent"
+	FlowJobStatusCancelled = "cancelled"
+	FlowJobStatusSkipped   = "skipped"
+	FlowJobStatusFailed    = "failed"
+)
+
+// CommerceFlow is an action that's run on subscribers a delay after a checkout is
+// abandoned or an order is placed. It sends a tx template and/or adds and removes
+// list subscriptions.
+type CommerceFlow struct {
+	ID            int           `db:"id" json:"id"`
+	Name          string        `db:"name" json:"name"`
+	Trigger       string        `db:"trigger" json:"trigger"`
+	DelayMinutes  int           `db:"delay_minutes" json:"delay_minutes"`
+	TemplateID    null.Int      `db:"template_id" json:"template_id"`
+	Subject       string        `db:"subject" json:"subject"`
+	AddListIDs    pq.Int64Array `db:"add_list_ids" json:"add_list_ids"`
+	RemoveListIDs pq.Int64Array `db:"remove_list_ids" json:"remove_list_ids"`
+	Enabled       bool          `db:"enabled" json:"enabled"`
+	CreatedAt     null.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt     null.Time     `db:"updated_at" json:"updated_at"`
+
+	// Job counts by status.
+	Jobs json.RawMessage `db:"jobs" json:"jobs"`
+}
+
+// CommerceFlowJob is a scheduled run of a flow for a checkout or an order.
+type CommerceFlowJob struct {
+	ID        int64           `db:"id" json:"id"`
+	FlowID    int             `db:"flow_id" json:"flow_id"`
+	Ref       string          `db:"ref" json:"ref"`
+	Email     string          `db:"email" json:"email"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	Status    string          `db:"status" json:"status"`
+	Error     string          `db:"error" json:"error"`
+	RunAt     time.Time       `db:"run_at" json:"run_at"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// Sequence step types.
+const (
+	SequenceStepWait          = "wait"
+	SequenceStepSend          = "send"
+	SequenceStepBranch        = "branch"
+	SequenceStepAddLists      = "add_lists"
+	SequenceStepRemoveLists   = "remove_lists"
+	SequenceStepUpdateAttribs = "update_attribs"
+)
+
+// Sequence branch conditions on the last send step.
+const (
+	SequenceConditionOpened  = "opened"
+	SequenceConditionClicked = "clicked"
+)
+
+// Sequence subscriber statuses.
+const (
+	SequenceSubStatusActive    = "active"
+	SequenceSubStatusRunning   = "running"
+	SequenceSubStatusCompleted = "completed"
+	SequenceSubStatusExited    = "exited"
+	SequenceSubStatusFailed    = "failed"
+)
+
+// Sequence is an automation of steps that are run on subscribers from when they
+// join a list, eg: a welcome series.
+type Sequence struct {
+	ID        int           `db:"id" json:"id"`
+	Name      string        `db:"name" json:"name"`
+	ListID    int           `db:"list_id" json:"list_id"`
+	Steps     SequenceSteps `db:"steps" json:"steps"`
+	Enabled   bool          `db:"enabled" json:"enabled"`
+	CreatedAt null.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt null.Time     `db:"updated_at" json:"updated_at"`
+
+	// Subscriber counts by status.
+	Subscribers json.RawMessage `db:"subscribers" json:"subscribers"`
+}
+
+// SequenceStep is a step of a sequence. The fields that apply depend on the type.
+type SequenceStep struct {
+	Type string `json:"type"`
+
+	// Minutes to wait before the next step (wait).
+	Minutes int `json:"minutes,omitempty"`
+
+	// Campaign whose content is sent (send).
+	CampaignID int `json:"campaign_id,omitempty"`
+
+	// Condition on the last send step, and the indexes of the steps to go to if it's
+	// met or not (branch). Steps past the last one end the sequence.
+	Condition string `json:"condition,omitempty"`
+	Then      int    `json:"then,omitempty"`
+	Else      int    `json:"else,omitempty"`
+
+	// Lists to add the subscriber to or remove from (add_lists, remove_lists).
+	ListIDs []int `json:"list_ids,omitempty"`
+
+	// Attributes merged into the subscriber's attributes (update_attribs).
+	Attribs JSON `json:"attribs,omitempty"`
+}
+
+// SequenceSteps represents a slice of SequenceStep.
+type SequenceSteps []SequenceStep
+
+// Scan unmarshals JSONB from the DB.
+func (s *SequenceSteps) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, s)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, s)
+}
+
+// Value returns the JSON marshalled steps.
+func (s SequenceSteps) Value() (driver.Value, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(s)
+}
+
+// SequenceSubscriber is the progress of a subscriber in a sequence.
+type SequenceSubscriber struct {
+	SequenceID     int       `db:"sequence_id" json:"sequence_id"`
+	SubscriberID   int       `db:"subscriber_id" json:"subscriber_id"`
+	Step           int       `db:"step" json:"step"`
+	Status         string    `db:"status" json:"status"`
+	NextRunAt      time.Time `db:"next_run_at" json:"next_run_at"`
+	LastCampaignID null.Int  `db:"last_campaign_id" json:"last_campaign_id"`
+	LastSentAt     null.Time `db:"last_sent_at" json:"last_sent_at"`
+	Error          string    `db:"error" json:"error"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updated_at"`
+
+	// The subscriber's status and the status of their subscription to the sequence's list.
+	SubscriberStatus   string `db:"subscriber_status" json:"-"`
+	SubscriptionStatus string `db:"subscription_status" json:"-"`
+}
+
+// Event trigger job statuses.
+const (
+	TriggerJobStatusPending = "pending"
+	TriggerJobStatusRunning = "running"
+	TriggerJobStatusSent    = "sent"
+	TriggerJobStatusSkipped = "skipped"
+	TriggerJobStatusFailed  = "failed"
+)
+
+// SubscriberEvent is an application event of a subscriber that's tracked by the API.
+type SubscriberEvent struct {
+	ID           int64           `db:"id" json:"id"`
+	SubscriberID int             `db:"subscriber_id" json:"subscriber_id"`
+	Name         string          `db:"name" json:"name"`
+	Properties   json.RawMessage `db:"properties" json:"properties"`
+	CreatedAt    time.Time       `db:"created_at" json:"created_at"`
+}
+
+// EventTrigger is an action that's run on subscribers a delay after they have an event
+// whose properties match the trigger's filters. It sends a tx template and/or enters the
+// subscriber into a sequence.
+type EventTrigger struct {
+	ID           int             `db:"id" json:"id"`
+	Name         string          `db:"name" json:"name"`
+	Event        string          `db:"event" json:"event"`
+	Filters      json.RawMessage `db:"filters" json:"filters"`
+	DelayMinutes int             `db:"delay_minutes" json:"delay_minutes"`
+	TemplateID   null.Int        `db:"template_id" json:"template_id"`
+	Subject      string          `db:"subject" json:"subject"`
+	SequenceID   null.Int        `db:"sequence_id" json:"sequence_id"`
+	CapCount     int             `db:"cap_count" json:"cap_count"`
+	CapMinutes   int             `db:"cap_minutes" json:"cap_minutes"`
+	Enabled      bool            `db:"enabled" json:"enabled"`
+	CreatedAt    null.Time       `db:"created_at" json:"created_at"`
+	UpdatedAt    null.Time       `db:"updated_at" json:"updated_at"`
+
+	// Job counts by status.
+	Jobs json.RawMessage `db:"jobs" json:"jobs"`
+}
+
+// EventTriggerJob is a scheduled run of a trigger for an event of a subscriber.
+type EventTriggerJob struct {
+	ID           int64     `db:"id" json:"id"`
+	TriggerID    int       `db:"trigger_id" json:"trigger_id"`
+	SubscriberID int       `db:"subscriber_id" json:"subscriber_id"`
+	EventID      int64     `db:"event_id" json:"event_id"`
+	Status       string    `db:"status" json:"status"`
+	Error        string    `db:"error" json:"error"`
+	RunAt        time.Time `db:"run_at" json:"run_at"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+
+	// The event of the job.
+	EventName       string          `db:"event_name" json:"-"`
+	EventProperties json.RawMessage `db:"event_properties" json:"-"`
+	EventCreatedAt  time.Time       `db:"event_created_at" json:"-"`
+}
+
+// CampaignRecurrence is a recurring campaign. On every occurrence of its cron expression
+// in its timezone, its campaign is cloned into a new campaign that's started.
+type CampaignRecurrence struct {
+	ID           int       `db:"id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	Cron         string    `db:"cron" json:"cron"`
+	Timezone     string    `db:"timezone" json:"timezone"`
+	NameFormat   string    `db:"name_format" json:"name_format"`
+	SkipPrevious bool      `db:"skip_previous" json:"skip_previous"`
+	Enabled      bool      `db:"enabled" json:"enabled"`
+	NextRunAt    null.Time `db:"next_run_at" json:"next_run_at"`
+	LastRunAt    null.Time `db:"last_run_at" json:"last_run_at"`
+	Error        string    `db:"error" json:"error"`
+	CreatedAt    null.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    null.Time `db:"updated_at" json:"updated_at"`
+
+	// FeedURL is the RSS or Atom feed that's polled on every occurrence. The new items
+	// of the feed are sent in a digest campaign if there are at least FeedMinItems of
+	// them, with at most FeedMaxItems of the latest items in the campaign.
+	FeedURL      string `db:"feed_url" json:"feed_url"`
+	FeedMinItems int    `db:"feed_min_items" json:"feed_min_items"`
+	FeedMaxItems int    `db:"feed_max_items" json:"feed_max_items"`
+
+	Occurrences    int      `db:"occurrences" json:"occurrences"`
+	LastCampaignID null.Int `db:"last_campaign_id" json:"last_campaign_id"`
+	PendingItems   int      `db:"pending_items" json:"pending_items"`
+}
+
+// FeedItem is an item of the RSS or Atom feed of a recurring campaign.
+type FeedItem struct {
+	GUID        string    `json:"guid"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Content     string    `json:"content"`
+	Author      string    `json:"author"`
+	ImageURL    string    `json:"image_url"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// FeedItems is a list of feed items.
+type FeedItems []FeedItem
+
+// CampaignFeedItem is an item of the feed of a recurring campaign that has been seen
+// on a poll. CampaignID and SentAt are set when the item is sent in a digest campaign.
+type CampaignFeedItem struct {
+	ID           int       `db:"id" json:"id"`
+	RecurrenceID int       `db:"recurrence_id" json:"recurrence_id"`
+	GUID         string    `db:"guid" json:"guid"`
+	Item         FeedItem  `db:"item" json:"item"`
+	PublishedAt  time.Time `db:"published_at" json:"published_at"`
+	CampaignID   null.Int  `db:"campaign_id" json:"campaign_id"`
+	SentAt       null.Time `db:"sent_at" json:"sent_at"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// CampaignOccurrence is the campaign of an occurrence of a recurring campaign.
+type CampaignOccurrence struct {
+	CampaignID         int       `db:"campaign_id" json:"campaign_id"`
+	RecurrenceID       int       `db:"recurrence_id" json:"recurrence_id"`
+	PreviousCampaignID null.Int  `db:"previous_campaign_id" json:"previous_campaign_id"`
+	SkipPrevious       bool      `db:"skip_previous" json:"skip_previous"`
+	RunAt              time.Time `db:"run_at" json:"run_at"`
+	CreatedAt          time.Time `db:"created_at" json:"created_at"`
+	CampaignName       string    `db:"campaign_name" json:"campaign_name"`
+	CampaignStatus     string    `db:"campaign_status" json:"campaign_status"`
 }
 
 // CampaignsPerformanceSummary represents aggregate performance metrics for all campaigns.
@@ -434,16 +1101,24 @@ type CampaignsPerformanceSummary struct {
 	TotalSent           int     `db:"total_sent" json:"total_sent"`
 	TotalOrders         int     `db:"total_orders" json:"total_orders"`
 	TotalRevenue        float64 `db:"total_revenue" json:"total_revenue"`
+	GrossRevenue        float64 `db:"gross_revenue" json:"gross_revenue"`
+	UnconvertedOrders   int     `db:"unconverted_orders" json:"unconverted_orders"`
+	Currency            string  `db:"currency" json:"currency"`
 	OrderRate           float64 `db:"order_rate" json:"order_rate"`
 	RevenuePerRecipient float64 `db:"revenue_per_recipient" json:"revenue_per_recipient"`
+
+	// Currencies is the breakdown of the revenue in the original currencies.
+	Currencies []CurrencyRevenue `db:"-" json:"currencies"`
 }
 
 // CampaignPurchaseStatsListItem represents purchase stats for a single campaign in a list.
 type CampaignPurchaseStatsListItem struct {
-	CampaignID   int     `db:"campaign_id" json:"campaign_id"`
-	TotalOrders  int     `db:"total_orders" json:"total_orders"`
-	TotalRevenue float64 `db:"total_revenue" json:"total_revenue"`
-	Currency     string  `db:"currency" json:"currency"`
+	CampaignID        int     `db:"campaign_id" json:"campaign_id"`
+	TotalOrders       int     `db:"total_orders" json:"total_orders"`
+	TotalRevenue      float64 `db:"total_revenue" json:"total_revenue"`
+	GrossRevenue      float64 `db:"gross_revenue" json:"gross_revenue"`
+	UnconvertedOrders int     `db:"unconverted_orders" json:"unconverted_orders"`
+	Currency          string  `db:"currency" json:"currency"`
 }
 
 // Message is the message pushed to a Messenger.
@@ -618,6 +1293,200 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 	return nil
 }
 
+// Apply appends the campaign's UTM parameters to a URL. pos is the 1-based position
+// of the link in the message (0 if it's unknown). Parameters that the URL already
+// has are left untouched.
+func (u CampaignUTM) Apply(link, campUUID, campName string, pos int) string {
+	if !u.Enabled {
+		return link
+	}
+
+	p, err := url.Parse(link)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") {
+		return link
+	}
+
+	var (
+		cur = p.Query()
+		q   = url.Values{}
+	)
+	set := func(k, v string) {
+		if v != "" && !cur.Has(k) {
+			q.Set(k, v)
+		}
+	}
+
+	set("utm_source", u.Source)
+	set("utm_medium", u.Medium)
+	if u.Campaign == CampaignUTMCampaignName {
+		set("utm_campaign", campName)
+	} else {
+		set("utm_campaign", campUUID)
+	}
+	if u.Content && pos > 0 {
+		set("utm_content", fmt.Sprintf("link-%d", pos))
+	}
+
+	if len(q) == 0 {
+		return link
+	}
+
+	if p.RawQuery != "" {
+		p.RawQuery += "&"
+	}
+	p.RawQuery += q.Encode()
+
+	return p.String()
+}
+
+// Scan unmarshals JSONB from the DB.
+func (u *CampaignUTM) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, u)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, u)
+}
+
+// Value returns the JSON marshalled UTM parameters.
+func (u CampaignUTM) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (t *CampaignABTest) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, t)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, t)
+}
+
+// Value returns the JSON marshalled A/B test.
+func (t CampaignABTest) Value() (driver.Value, error) {
+	return json.Marshal(t)
+}
+
+// Scan unmarshals JSON from the DB.
+func (v *CampaignVariants) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, v)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, v)
+}
+
+// Value returns the JSON marshalled variants.
+func (v CampaignVariants) Value() (driver.Value, error) {
+	if v == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(v)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (r *CampaignResend) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, r)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, r)
+}
+
+// Value returns the JSON marshalled resend rule.
+func (r CampaignResend) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (c *CampaignContent) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, c)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, c)
+}
+
+// Value returns the JSON marshalled content.
+func (c CampaignContent) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (f *FeedItems) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, f)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, f)
+}
+
+// Value returns the JSON marshalled feed items.
+func (f FeedItems) Value() (driver.Value, error) {
+	if f == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(f)
+}
+
+// Scan unmarshals JSONB from the DB.
+func (f *FeedItem) Scan(src any) error {
+	if src == nil {
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, f)
+	}
+	return fmt.Errorf("could not not decode type %T -> %T", src, f)
+}
+
+// Value returns the JSON marshalled feed item.
+func (f FeedItem) Value() (driver.Value, error) {
+	return json.Marshal(f)
+}
+
+// NeedsAudienceSnapshot returns true if a campaign is only sent to a snapshot of its audience.
+func (c Campaign) NeedsAudienceSnapshot() bool {
+	return c.AudienceSnapshot || c.SegmentQuery != ""
+}
+
+// ApplyVariant overrides the campaign's subject, from address, body and template
+// with the ones set on a variant. It should be called before CompileTemplate().
+func (c *Campaign) ApplyVariant(v CampaignVariant) {
+	if v.Subject != "" {
+		c.Subject = v.Subject
+	}
+	if v.FromEmail != "" {
+		c.FromEmail = v.FromEmail
+	}
+	if v.Body.Valid {
+		c.Body = v.Body.String
+	}
+	if v.TemplateID.Valid {
+		c.TemplateID = v.TemplateID
+		c.TemplateBody = v.TemplateBody
+	}
+}
+
 // CompileTemplate compiles a campaign body template into its base
 // template and sets the resultant template to Campaign.Tpl.
 func (c *Campaign) CompileTemplate(f template.FuncMap) error {
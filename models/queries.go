@@ -76,6 +76,7 @@ type Queries struct {
 	GetCampaignLinkCounts           *sqlx.Stmt `query:"get-campaign-link-counts"`
 	GetCampaignBounceCounts         *sqlx.Stmt `query:"get-campaign-bounce-counts"`
 	GetCampaignAzureDeliveryCounts  *sqlx.Stmt `query:"get-campaign-azure-delivery-counts"`
+	GetCampaignSESEventCounts       *sqlx.Stmt `query:"get-campaign-ses-event-counts"`
 	GetCampaignUnsubscribers        *sqlx.Stmt `query:"get-campaign-unsubscribers"`
 	DeleteCampaignViews        *sqlx.Stmt `query:"delete-campaign-views"`
 	DeleteCampaignLinkClicks   *sqlx.Stmt `query:"delete-campaign-link-clicks"`
@@ -132,12 +133,23 @@ type Queries struct {
 	DeleteBounces               *sqlx.Stmt `query:"delete-bounces"`
 	DeleteBouncesBySubscriber   *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
 
-	CreateWebhookLog       *sqlx.Stmt `query:"create-webhook-log"`
-	GetWebhookLogs         *sqlx.Stmt `query:"get-webhook-logs"`
-	GetWebhookLogsCount    *sqlx.Stmt `query:"get-webhook-logs-count"`
-	GetAllWebhookLogs      *sqlx.Stmt `query:"get-all-webhook-logs"`
-	DeleteWebhookLogs      *sqlx.Stmt `query:"delete-webhook-logs"`
-	DeleteAllWebhookLogs   *sqlx.Stmt `query:"delete-all-webhook-logs"`
+	CreateWebhookLog        *sqlx.Stmt `query:"create-webhook-log"`
+	GetWebhookLog           *sqlx.Stmt `query:"get-webhook-log"`
+	GetWebhookLogsForReplay *sqlx.Stmt `query:"get-webhook-logs-for-replay"`
+	GetWebhookLogReplay     *sqlx.Stmt `query:"get-webhook-log-replay"`
+	MarkWebhookLogProcessed *sqlx.Stmt `query:"mark-webhook-log-processed"`
+	GetWebhookLogs          *sqlx.Stmt `query:"get-webhook-logs"`
+	GetWebhookLogsCount     *sqlx.Stmt `query:"get-webhook-logs-count"`
+	GetAllWebhookLogs       *sqlx.Stmt `query:"get-all-webhook-logs"`
+	PruneWebhookLogs        *sqlx.Stmt `query:"prune-webhook-logs"`
+
+	ClaimWebhookKey          *sqlx.Stmt `query:"claim-webhook-key"`
+	ReleaseWebhookKeys       *sqlx.Stmt `query:"release-webhook-keys"`
+	DeleteExpiredWebhookKeys *sqlx.Stmt `query:"delete-expired-webhook-keys"`
+	DeleteWebhookLogs       *sqlx.Stmt `query:"delete-webhook-logs"`
+	DeleteAllWebhookLogs    *sqlx.Stmt `query:"delete-all-webhook-logs"`
+
+	InsertSESEvent *sqlx.Stmt `query:"insert-ses-event"`
 
 	GetDBInfo              string     `query:"get-db-info"`
 
@@ -160,13 +172,110 @@ type Queries struct {
 	DeleteListPermission  *sqlx.Stmt `query:"delete-list-permission"`
 
 	// Shopify Purchase Attribution queries
-	InsertPurchaseAttribution     *sqlx.Stmt `query:"insert-purchase-attribution"`
-	FindRecentLinkClick           *sqlx.Stmt `query:"find-recent-link-click"`
-	FindRecentEmailOpen           *sqlx.Stmt `query:"find-recent-email-open"`
-	GetCampaignPurchaseStats      *sqlx.Stmt `query:"get-campaign-purchase-stats"`
-	GetSubscriberByEmail          *sqlx.Stmt `query:"get-subscriber-by-email"`
-	GetCampaignsPerformanceSummary *sqlx.Stmt `query:"get-campaigns-performance-summary"`
-	GetCampaignsPurchaseStats     *sqlx.Stmt `query:"get-campaigns-purchase-stats"`
+	InsertPurchaseAttribution        *sqlx.Stmt `query:"insert-purchase-attribution"`
+	FindRecentLinkClick              *sqlx.Stmt `query:"find-recent-link-click"`
+	FindRecentEmailOpen              *sqlx.Stmt `query:"find-recent-email-open"`
+	GetAttributionTouches            *sqlx.Stmt `query:"get-attribution-touches"`
+	InsertPurchaseAttributionCredits *sqlx.Stmt `query:"insert-purchase-attribution-credits"`
+	InsertPurchaseRefund             *sqlx.Stmt `query:"insert-purchase-refund"`
+	UpdatePurchaseAttributionOrder   *sqlx.Stmt `query:"update-purchase-attribution-order"`
+	GetCampaignPurchaseStats         *sqlx.Stmt `query:"get-campaign-purchase-stats"`
+	GetCampaignPurchaseCurrencyStats *sqlx.Stmt `query:"get-campaign-purchase-currency-stats"`
+	UpsertPurchaseLineItems          *sqlx.Stmt `query:"upsert-purchase-line-items"`
+	GetCampaignProductStats          *sqlx.Stmt `query:"get-campaign-product-stats"`
+	GetSubscriberByEmail             *sqlx.Stmt `query:"get-subscriber-by-email"`
+	GetCampaignsPerformanceSummary   *sqlx.Stmt `query:"get-campaigns-performance-summary"`
+	GetPurchasesCurrencySummary      *sqlx.Stmt `query:"get-purchases-currency-summary"`
+	GetCampaignsPurchaseStats        *sqlx.Stmt `query:"get-campaigns-purchase-stats"`
+	GetExchangeRates                 *sqlx.Stmt `query:"get-exchange-rates"`
+	UpdateExchangeRates              *sqlx.Stmt `query:"update-exchange-rates"`
+	NormalizePurchases               *sqlx.Stmt `query:"normalize-purchases"`
+
+	GetCommerceFlows         *sqlx.Stmt `query:"get-commerce-flows"`
+	CreateCommerceFlow       *sqlx.Stmt `query:"create-commerce-flow"`
+	UpdateCommerceFlow       *sqlx.Stmt `query:"update-commerce-flow"`
+	DeleteCommerceFlow       *sqlx.Stmt `query:"delete-commerce-flow"`
+	ScheduleCommerceFlowJobs *sqlx.Stmt `query:"schedule-commerce-flow-jobs"`
+	CancelCheckoutFlowJobs   *sqlx.Stmt `query:"cancel-checkout-flow-jobs"`
+	NextCommerceFlowJobs     *sqlx.Stmt `query:"next-commerce-flow-jobs"`
+	UpdateCommerceFlowJob    *sqlx.Stmt `query:"update-commerce-flow-job"`
+
+	GetCampaignHoldoutStats *sqlx.Stmt `query:"get-campaign-holdout-stats"`
+	SyncCommerceAttribs     *sqlx.Stmt `query:"sync-commerce-attribs"`
+
+	GetCampaignVariant        *sqlx.Stmt `query:"get-campaign-variant"`
+	StartCampaignABTest       *sqlx.Stmt `query:"start-campaign-ab-test"`
+	GetDueABTests             *sqlx.Stmt `query:"get-due-ab-tests"`
+	GetCampaignVariantStats   *sqlx.Stmt `query:"get-campaign-variant-stats"`
+	QueueCampaignWinnerEmails *sqlx.Stmt `query:"queue-campaign-winner-emails"`
+
+	GetSequences             *sqlx.Stmt `query:"get-sequences"`
+	CreateSequence           *sqlx.Stmt `query:"create-sequence"`
+	UpdateSequence           *sqlx.Stmt `query:"update-sequence"`
+	DeleteSequence           *sqlx.Stmt `query:"delete-sequence"`
+	NextSequenceSubscribers  *sqlx.Stmt `query:"next-sequence-subscribers"`
+	UpdateSequenceSubscriber *sqlx.Stmt `query:"update-sequence-subscriber"`
+	QueueSequenceEmail       *sqlx.Stmt `query:"queue-sequence-email"`
+	QueueTxEmail             *sqlx.Stmt `query:"queue-tx-email"`
+	GetSequenceEngagement    *sqlx.Stmt `query:"get-sequence-engagement"`
+	MergeSubscriberAttribs   *sqlx.Stmt `query:"merge-subscriber-attribs"`
+	EnterSequence            *sqlx.Stmt `query:"enter-sequence"`
+
+	LockEventTriggerCaps  *sqlx.Stmt `query:"lock-event-trigger-caps"`
+	InsertSubscriberEvent *sqlx.Stmt `query:"insert-subscriber-event"`
+	GetSubscriberEvents   *sqlx.Stmt `query:"get-subscriber-events"`
+	GetEventTriggers      *sqlx.Stmt `query:"get-event-triggers"`
+	CreateEventTrigger    *sqlx.Stmt `query:"create-event-trigger"`
+	UpdateEventTrigger    *sqlx.Stmt `query:"update-event-trigger"`
+	DeleteEventTrigger    *sqlx.Stmt `query:"delete-event-trigger"`
+	NextEventTriggerJobs  *sqlx.Stmt `query:"next-event-trigger-jobs"`
+	UpdateEventTriggerJob *sqlx.Stmt `query:"update-event-trigger-job"`
+
+	GetCampaignRecurrences     *sqlx.Stmt `query:"get-campaign-recurrences"`
+	CreateCampaignRecurrence   *sqlx.Stmt `query:"create-campaign-recurrence"`
+	UpdateCampaignRecurrence   *sqlx.Stmt `query:"update-campaign-recurrence"`
+	DeleteCampaignRecurrence   *sqlx.Stmt `query:"delete-campaign-recurrence"`
+	GetDueCampaignRecurrences  *sqlx.Stmt `query:"get-due-campaign-recurrences"`
+	ClaimCampaignRecurrence    *sqlx.Stmt `query:"claim-campaign-recurrence"`
+	SetCampaignRecurrenceError *sqlx.Stmt `query:"set-campaign-recurrence-error"`
+	InsertCampaignOccurrence   *sqlx.Stmt `query:"insert-campaign-occurrence"`
+	GetCampaignOccurrences     *sqlx.Stmt `query:"get-campaign-occurrences"`
+
+	InsertCampaignFeedItems     *sqlx.Stmt `query:"insert-campaign-feed-items"`
+	GetPendingCampaignFeedItems *sqlx.Stmt `query:"get-pending-campaign-feed-items"`
+	GetCampaignFeedItems        *sqlx.Stmt `query:"get-campaign-feed-items"`
+	SetCampaignFeedItems        *sqlx.Stmt `query:"set-campaign-feed-items"`
+	MarkCampaignFeedItemsSent   *sqlx.Stmt `query:"mark-campaign-feed-items-sent"`
+
+	InsertCampaignResend    *sqlx.Stmt `query:"insert-campaign-resend"`
+	SetCampaignResend       *sqlx.Stmt `query:"set-campaign-resend"`
+	GetDueCampaignResends   *sqlx.Stmt `query:"get-due-campaign-resends"`
+	GetCampaignResendParent *sqlx.Stmt `query:"get-campaign-resend-parent"`
+	GetCampaignResendStats  *sqlx.Stmt `query:"get-campaign-resend-stats"`
+
+	CampaignAudienceTpl      string     `query:"campaign-audience-template"`
+	CountCampaignAudience    string     `query:"count-campaign-audience"`
+	SnapshotCampaignAudience string     `query:"snapshot-campaign-audience"`
+	GetDueCampaignAudiences  *sqlx.Stmt `query:"get-due-campaign-audiences"`
+
+	SubmitCampaignReview      *sqlx.Stmt `query:"submit-campaign-review"`
+	InsertCampaignReview      *sqlx.Stmt `query:"insert-campaign-review"`
+	GetCampaignApprovers      *sqlx.Stmt `query:"get-campaign-approvers"`
+	ApproveCampaign           *sqlx.Stmt `query:"approve-campaign"`
+	CopyCampaignApproval      *sqlx.Stmt `query:"copy-campaign-approval"`
+	ResetCampaignReview       *sqlx.Stmt `query:"reset-campaign-review"`
+	GetCampaignReviews        *sqlx.Stmt `query:"get-campaign-reviews"`
+	GetCampaignReviewerEmails *sqlx.Stmt `query:"get-campaign-reviewer-emails"`
+
+	LockCampaign           *sqlx.Stmt `query:"lock-campaign"`
+	InsertCampaignVersion  *sqlx.Stmt `query:"insert-campaign-version"`
+	GetCampaignVersions    *sqlx.Stmt `query:"get-campaign-versions"`
+	GetCampaignVersion     *sqlx.Stmt `query:"get-campaign-version"`
+	SetCampaignSentVersion *sqlx.Stmt `query:"set-campaign-sent-version"`
+	LockTemplate           *sqlx.Stmt `query:"lock-template"`
+	InsertTemplateVersion  *sqlx.Stmt `query:"insert-template-version"`
+	GetTemplateVersions    *sqlx.Stmt `query:"get-template-versions"`
+	GetTemplateVersion     *sqlx.Stmt `query:"get-template-version"`
 }
 
 // compileSubscriberQueryTpl takes an arbitrary WHERE expressions
@@ -41,6 +41,15 @@ type Settings struct {
 	AppSmartSendingEnabled     bool `json:"app.smart_sending_enabled"`
 	AppSmartSendingPeriodHours int  `json:"app.smart_sending_period_hours"`
 
+	// Campaign approval - campaigns need to be approved by reviewers before they're sent
+	AppCampaignApproval          bool `json:"app.campaign_approval"`
+	AppCampaignApprovalThreshold int  `json:"app.campaign_approval_threshold"`
+
+	// Pre-send checks of campaigns - links, unsubscribe, size, images and DNS records
+	AppCampaignChecksBlock         bool     `json:"app.campaign_checks_block"`
+	AppCampaignChecksLinkAllowlist []string `json:"app.campaign_checks_link_allowlist"`
+	AppCampaignChecksDKIMSelectors []string `json:"app.campaign_checks_dkim_selectors"`
+
 	PrivacyIndividualTracking bool     `json:"privacy.individual_tracking"`
 	PrivacyUnsubHeader        bool     `json:"privacy.unsubscribe_header"`
 	PrivacyAllowBlocklist     bool     `json:"privacy.allow_blocklist"`
@@ -167,8 +176,37 @@ type Settings struct {
 		Enabled               bool   `json:"enabled"`
 		WebhookSecret         string `json:"webhook_secret,omitempty"`
 		AttributionWindowDays int    `json:"attribution_window_days"`
+		AttributionModel      string `json:"attribution_model"`
+
+		// Customer sync.
+		SyncCustomers bool   `json:"sync_customers"`
+		SyncLists     []int  `json:"sync_lists"`
+		ShopURL       string `json:"shop_url"`
+		AccessToken   string `json:"access_token,omitempty"`
+		APIVersion    string `json:"api_version"`
+
+		// Revenue normalization.
+		ReportingCurrency   string `json:"reporting_currency"`
+		ExchangeRatesSource string `json:"exchange_rates_source"`
 	} `json:"shopify"`
 
+	WooCommerce struct {
+		Enabled       bool   `json:"enabled"`
+		WebhookSecret string `json:"webhook_secret,omitempty"`
+	} `json:"woocommerce"`
+
+	Stripe struct {
+		Enabled       bool   `json:"enabled"`
+		WebhookSecret string `json:"webhook_secret,omitempty"`
+	} `json:"stripe"`
+
+	WebhookLogRetention   []WebhookLogRetention `json:"webhook_logs.retention"`
+	WebhookIdempotencyTTL string                `json:"webhook_logs.idempotency_ttl"`
+
+	WebhookInboxEnabled     bool `json:"webhook_inbox.enabled"`
+	WebhookInboxWorkers     int  `json:"webhook_inbox.workers"`
+	WebhookInboxMaxAttempts int  `json:"webhook_inbox.max_attempts"`
+
 	AdminCustomCSS  string `json:"appearance.admin.custom_css"`
 	AdminCustomJS   string `json:"appearance.admin.custom_js"`
 	PublicCustomCSS string `json:"appearance.public.custom_css"`
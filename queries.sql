@@ -86,7 +86,7 @@ SELECT lists.*,
 -- name: insert-subscriber
 WITH sub AS (
     INSERT INTO subscribers (uuid, email, name, status, attribs)
-    VALUES($1, $2, $3, $4, $5)
+    VALUES($1, $2, $3, $4, $5::JSONB - 'commerce')
     RETURNING id, status
 ),
 listIDs AS (
@@ -114,13 +114,14 @@ SELECT id from sub;
 -- name: upsert-subscriber
 -- Upserts a subscriber where existing subscribers get their names and attributes overwritten.
 -- If $7 = true, update values, otherwise, skip.
+-- attribs.commerce is reserved for the computed commerce attributes and is retained.
 WITH sub AS (
     INSERT INTO subscribers as s (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'enabled')
+    VALUES($1, $2, $3, $4::JSONB - 'commerce', 'enabled')
     ON CONFLICT (email)
     DO UPDATE SET
         name=(CASE WHEN $7 THEN $3 ELSE s.name END),
-        attribs=(CASE WHEN $7 THEN $4 ELSE s.attribs END),
+        attribs=(CASE WHEN $7 THEN ($4::JSONB - 'commerce') || JSONB_STRIP_NULLS(JSONB_BUILD_OBJECT('commerce', s.attribs->'commerce')) ELSE s.attribs END),
         updated_at=NOW()
     RETURNING uuid, id, status
 ),
@@ -149,23 +150,25 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM sub);
 
 -- name: update-subscriber
+-- attribs.commerce is reserved for the computed commerce attributes and is retained.
 UPDATE subscribers SET
     email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
     name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
-    attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
+    attribs=(CASE WHEN $5 != '' THEN ($5::JSONB - 'commerce') || JSONB_STRIP_NULLS(JSONB_BUILD_OBJECT('commerce', attribs->'commerce')) ELSE attribs END),
     updated_at=NOW()
 WHERE id = $1;
 
 -- name: update-subscriber-with-lists
 -- Updates a subscriber's data, and given a list of list_ids, inserts subscriptions
 -- for them while deleting existing subscriptions not in the list.
+-- attribs.commerce is reserved for the computed commerce attributes and is retained.
 WITH s AS (
     UPDATE subscribers SET
         email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
         name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
         status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
-        attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
+        attribs=(CASE WHEN $5 != '' THEN ($5::JSONB - 'commerce') || JSONB_STRIP_NULLS(JSONB_BUILD_OBJECT('commerce', attribs->'commerce')) ELSE attribs END),
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
@@ -535,11 +538,17 @@ counts AS (
         (l.optin = 'double' AND sl.status = 'confirmed') OR
         (l.optin != 'double' AND sl.status != 'unsubscribed')
       )
+      -- Subscribers on the exclusion lists.
+      AND NOT EXISTS (
+        SELECT 1 FROM subscriber_lists ex
+        WHERE ex.subscriber_id = s.id AND ex.list_id = ANY($27::INT[]) AND ex.status != 'unsubscribed'
+      )
 ),
 camp AS (
     INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody,
         content_type, send_at, headers, tags, messenger, template_id, to_send,
-        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source)
+        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source, utm, holdout_percent, ab_test,
+        resend, feed_items, exclude_list_ids, segment_query, audience_snapshot)
         SELECT $1, $2, $3, $4, $5,
             -- body
             COALESCE(NULLIF($6, ''), (SELECT body FROM tpl), ''),
@@ -554,9 +563,22 @@ camp AS (
             $17,
             $18,
             -- body_source
-            COALESCE($20, (SELECT body_source FROM tpl))
+            COALESCE($20, (SELECT body_source FROM tpl)),
+            $21,
+            $22,
+            $23,
+            $25,
+            $26,
+            $27,
+            $28,
+            $29
         RETURNING id
 ),
+variants AS (
+    INSERT INTO campaign_variants (campaign_id, name, subject, from_email, body, template_id)
+        SELECT (SELECT id FROM camp), v.name, COALESCE(v.subject, ''), COALESCE(v.from_email, ''), v.body, v.template_id
+        FROM JSONB_TO_RECORDSET($24::JSONB) AS v(name TEXT, subject TEXT, from_email TEXT, body TEXT, template_id INT)
+),
 med AS (
     INSERT INTO campaign_media (campaign_id, media_id, filename)
         (SELECT (SELECT id FROM camp), id, filename FROM media WHERE id=ANY($19::INT[]))
@@ -598,7 +620,12 @@ ORDER BY %order% OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
 
 -- name: get-campaign
 SELECT campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    COALESCE((
+        SELECT JSON_AGG(JSON_BUILD_OBJECT('id', v.id, 'name', v.name, 'subject', v.subject,
+            'from_email', v.from_email, 'body', v.body, 'template_id', v.template_id) ORDER BY v.id)
+        FROM campaign_variants v WHERE v.campaign_id = campaigns.id
+    ), '[]') AS variants
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $4 = 'default' THEN templates.id = campaigns.template_id
@@ -787,6 +814,8 @@ WITH camps AS (
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
     AND NOT(campaigns.id = ANY($1::INT[]))
     AND (use_queue IS NULL OR use_queue = false)
+    -- Campaigns that are sent to a snapshot of their audience wait for it (snapshot-campaign-audience).
+    AND (campaigns.audience_snapshot_at IS NOT NULL OR (NOT campaigns.audience_snapshot AND campaigns.segment_query = ''))
 ),
 campLists AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
@@ -801,7 +830,12 @@ campMedia AS (
     GROUP BY campaign_id
 ),
 counts AS (
-    SELECT camps.id AS campaign_id, COUNT(DISTINCT sl.subscriber_id) AS to_send, COALESCE(MAX(sl.subscriber_id), 0) AS max_subscriber_id
+    -- Subscribers in the campaign's holdout are not sent the campaign and are not counted in to_send.
+    SELECT camps.id AS campaign_id,
+        COUNT(DISTINCT sl.subscriber_id) FILTER (WHERE (camps.type = 'optin' OR NOT campaign_holdout(camps.id, sl.subscriber_id, camps.holdout_percent))
+            AND NOT campaign_resend_excluded(camps.id, sl.subscriber_id)
+            AND NOT campaign_audience_excluded(camps.id, sl.subscriber_id)) AS to_send,
+        COALESCE(MAX(sl.subscriber_id), 0) AS max_subscriber_id
     FROM camps
     JOIN campLists cl ON cl.campaign_id = camps.id
     JOIN subscriber_lists sl ON sl.list_id = cl.list_id
@@ -827,7 +861,10 @@ u AS (
     SET to_send = co.to_send,
         status = (CASE WHEN status != 'running' THEN 'running' ELSE status END),
         max_subscriber_id = co.max_subscriber_id,
-        started_at=(CASE WHEN ca.started_at IS NULL THEN NOW() ELSE ca.started_at END)
+        started_at=(CASE WHEN ca.started_at IS NULL THEN NOW() ELSE ca.started_at END),
+        -- The version of the content that's sent is recorded when the campaign starts.
+        sent_version=(CASE WHEN ca.sent_version IS NULL
+            THEN (SELECT MAX(version) FROM campaign_versions WHERE campaign_id = ca.id) ELSE ca.sent_version END)
     FROM (SELECT * FROM counts) co
     WHERE ca.id = co.campaign_id
 )
@@ -885,6 +922,14 @@ SELECT campaign_id, status, COUNT(*) AS "count"
     GROUP BY campaign_id, status
     ORDER BY campaign_id, status;
 
+-- name: get-campaign-ses-event-counts
+-- Get counts of SES events (deliveries, bounces, complaints, rendering failures) by type for campaigns
+SELECT campaign_id, event_type, COUNT(*) AS "count"
+    FROM ses_events
+    WHERE campaign_id=ANY($1) AND event_timestamp >= $2 AND event_timestamp <= $3
+    GROUP BY campaign_id, event_type
+    ORDER BY campaign_id, event_type;
+
 -- name: get-campaign-unsubscribers
 -- Get the list of subscribers who unsubscribed from any list that the campaign was sent to
 -- This tracks subscribers who unsubscribed after receiving the campaign email
@@ -938,6 +983,10 @@ subs AS (
             AND s.id <= $4
              -- Subscriber should not be blacklisted.
             AND s.status != 'blocklisted'
+            -- A resend to non-openers is only sent to the non-openers of its parent.
+            AND NOT campaign_resend_excluded($1, s.id)
+            -- Subscribers on the exclusion lists or outside the audience snapshot.
+            AND NOT campaign_audience_excluded($1, s.id)
             AND (
                 -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
                 ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
@@ -955,12 +1004,23 @@ subs AS (
         ORDER BY s.id LIMIT $6
     ) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id
 ),
+cohort AS (
+    -- Subscribers in the campaign's holdout are recorded as its control group and not sent the campaign.
+    -- The checkpoint still moves past them. Opt-in campaigns have no holdout.
+    SELECT subs.id, ($2 != 'optin' AND campaign_holdout($1, subs.id, c.holdout_percent)) AS holdout, c.holdout_percent
+    FROM subs, campaigns c WHERE c.id = $1
+),
+insCohort AS (
+    INSERT INTO campaign_cohorts (campaign_id, subscriber_id, holdout)
+        SELECT $1, id, holdout FROM cohort WHERE holdout_percent > 0
+        ON CONFLICT (campaign_id, subscriber_id) DO NOTHING
+),
 u AS (
     UPDATE campaigns
     SET last_subscriber_id = (SELECT MAX(id) FROM subs), updated_at = NOW()
     WHERE (SELECT COUNT(id) FROM subs) > 0 AND id=$1
 )
-SELECT * FROM subs;
+SELECT * FROM subs WHERE id IN (SELECT id FROM cohort WHERE NOT holdout) ORDER BY id;
 
 -- name: delete-campaign-views
 DELETE FROM campaign_views WHERE created_at < $1;
@@ -1002,9 +1062,30 @@ WITH camp AS (
         archive_template_id=(CASE WHEN $7::content_type = 'visual' THEN NULL ELSE $16::INT END),
         archive_meta=$17,
         body_source=$19,
+        utm=$20,
+        holdout_percent=$21,
+        ab_test=$22,
+        resend=$24,
+        exclude_list_ids=$25,
+        segment_query=$26,
+        audience_snapshot=$27,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
+variants AS (
+    SELECT v.name, COALESCE(v.subject, '') AS subject, COALESCE(v.from_email, '') AS from_email, v.body, v.template_id
+    FROM JSONB_TO_RECORDSET($23::JSONB) AS v(name TEXT, subject TEXT, from_email TEXT, body TEXT, template_id INT)
+),
+delVariants AS (
+    DELETE FROM campaign_variants WHERE campaign_id = $1 AND name NOT IN (SELECT name FROM variants)
+),
+upVariants AS (
+    -- Variants are matched by name so that their IDs are retained.
+    INSERT INTO campaign_variants (campaign_id, name, subject, from_email, body, template_id)
+        SELECT $1, name, subject, from_email, body, template_id FROM variants
+        ON CONFLICT (campaign_id, name) DO UPDATE SET subject = EXCLUDED.subject, from_email = EXCLUDED.from_email,
+            body = EXCLUDED.body, template_id = EXCLUDED.template_id, updated_at = NOW()
+),
 clists AS (
     -- Reset list relationships
     DELETE FROM campaign_lists WHERE campaign_id = $1 AND NOT(list_id = ANY($13))
@@ -1132,14 +1213,18 @@ INSERT INTO links (uuid, url) VALUES($1, $2) ON CONFLICT (url) DO UPDATE SET url
 -- name: register-link-click
 WITH link AS(
     SELECT id, url FROM links WHERE uuid = $1
+),
+camp AS (
+    SELECT id, name, utm FROM campaigns WHERE uuid = $2
 )
 INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
-    (SELECT id FROM campaigns WHERE uuid = $2),
+    (SELECT id FROM camp),
     (SELECT id FROM subscribers WHERE
         (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
     ),
     (SELECT id FROM link)
-) RETURNING (SELECT url FROM link);
+) RETURNING (SELECT url FROM link) AS url, (SELECT name FROM camp) AS campaign_name,
+    COALESCE((SELECT utm FROM camp), '{}') AS utm;
 
 -- name: get-dashboard-charts
 SELECT data FROM mat_dashboard_charts;
@@ -1233,31 +1318,119 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
 
 -- webhook logs
 -- name: create-webhook-log
-INSERT INTO webhook_logs (webhook_type, event_type, request_headers, request_body, response_status, response_body, processed, error_message)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;
+-- campaign_id is resolved from either the campaign ID ($11) or UUID ($12), whichever is known to the webhook handler.
+-- If the webhook belongs to an inbox entry ($14), the entry's existing log is updated with the outcome instead.
+-- The log of a webhook being queued ($15) is only inserted and never overwrites the outcome logged by
+-- the inbox worker, which can process the entry before the request that queued it is logged.
+INSERT INTO webhook_logs (webhook_type, event_type, request_headers, request_body, response_status, response_body, processed, error_message,
+        replay_of, recipient_email, campaign_id, external_message_id, inbox_id)
+    VALUES($1::VARCHAR, $2::VARCHAR, $3::JSONB, $4::TEXT, $5::INT, $6::TEXT, $7::BOOLEAN, $8::TEXT, $9::BIGINT,
+        NULLIF(LOWER($10::TEXT), ''),
+        (SELECT id FROM campaigns WHERE id = $11::INT OR ($12::TEXT != '' AND uuid::TEXT = $12::TEXT) LIMIT 1),
+        NULLIF($13::TEXT, ''),
+        $14::BIGINT)
+    ON CONFLICT (inbox_id) DO UPDATE SET event_type = EXCLUDED.event_type, response_status = EXCLUDED.response_status,
+        response_body = EXCLUDED.response_body, processed = EXCLUDED.processed, error_message = EXCLUDED.error_message,
+        recipient_email = COALESCE(EXCLUDED.recipient_email, webhook_logs.recipient_email),
+        campaign_id = COALESCE(EXCLUDED.campaign_id, webhook_logs.campaign_id),
+        external_message_id = COALESCE(EXCLUDED.external_message_id, webhook_logs.external_message_id)
+    WHERE NOT $15::BOOLEAN;
+
+-- name: get-webhook-log
+SELECT * FROM webhook_logs WHERE id = $1;
+
+-- name: get-webhook-logs-for-replay
+-- Replays of earlier logs and webhooks that are still in the inbox are never picked up.
+SELECT * FROM webhook_logs
+    WHERE replay_of IS NULL
+    AND ($1 = '' OR webhook_type = $1)
+    AND ($2::TIMESTAMP WITH TIME ZONE IS NULL OR created_at >= $2)
+    AND ($3::TIMESTAMP WITH TIME ZONE IS NULL OR created_at <= $3)
+    AND ($4::BOOLEAN IS NULL OR processed = $4)
+    AND NOT EXISTS (SELECT 1 FROM webhook_inbox i WHERE i.id = webhook_logs.inbox_id AND i.status IN ('pending', 'processing'))
+    ORDER BY id
+    LIMIT $5;
+
+-- name: get-webhook-log-replay
+SELECT * FROM webhook_logs WHERE replay_of = $1 ORDER BY id DESC LIMIT 1;
+
+-- name: mark-webhook-log-processed
+UPDATE webhook_logs SET processed = true WHERE id = $1;
 
 -- name: get-webhook-logs
+-- $6 is a full-text query on the request body and $7 an SQL/JSON path that the JSON request body should match.
 SELECT * FROM webhook_logs
     WHERE ($1 = '' OR webhook_type = $1)
     AND ($2 = '' OR event_type = $2)
+    AND ($3 = '' OR recipient_email = LOWER($3))
+    AND ($4 = 0 OR campaign_id = $4)
+    AND ($5 = '' OR external_message_id = $5)
+    AND ($6 = '' OR TO_TSVECTOR('simple', LEFT(request_body, 262144)) @@ WEBSEARCH_TO_TSQUERY('simple', $6))
+    AND ($7 = '' OR JSONB_PATH_EXISTS(webhook_log_json(request_body), $7::JSONPATH))
     ORDER BY created_at DESC
-    OFFSET $3 LIMIT $4;
+    OFFSET $8 LIMIT $9;
 
 -- name: get-webhook-logs-count
 SELECT COUNT(*) AS total FROM webhook_logs
     WHERE ($1 = '' OR webhook_type = $1)
-    AND ($2 = '' OR event_type = $2);
+    AND ($2 = '' OR event_type = $2)
+    AND ($3 = '' OR recipient_email = LOWER($3))
+    AND ($4 = 0 OR campaign_id = $4)
+    AND ($5 = '' OR external_message_id = $5)
+    AND ($6 = '' OR TO_TSVECTOR('simple', LEFT(request_body, 262144)) @@ WEBSEARCH_TO_TSQUERY('simple', $6))
+    AND ($7 = '' OR JSONB_PATH_EXISTS(webhook_log_json(request_body), $7::JSONPATH));
 
 -- name: get-all-webhook-logs
 SELECT * FROM webhook_logs
     ORDER BY created_at DESC;
 
+-- name: prune-webhook-logs
+-- Deletes logs of the given type ($1) older than $2 days and all but the newest $3 logs.
+-- An empty type applies to all types except the ones in $4 that have their own policy.
+WITH logs AS (
+    SELECT id, created_at, ROW_NUMBER() OVER (ORDER BY id DESC) AS num FROM webhook_logs
+    WHERE CASE WHEN $1 != '' THEN webhook_type = $1 ELSE webhook_type != ALL($4::TEXT[]) END
+)
+DELETE FROM webhook_logs WHERE id IN (
+    SELECT id FROM logs
+    WHERE ($2 > 0 AND created_at < NOW() - MAKE_INTERVAL(days => $2))
+    OR ($3 > 0 AND num > $3)
+);
+
 -- name: delete-webhook-logs
 DELETE FROM webhook_logs WHERE id = ANY($1);
 
 -- name: delete-all-webhook-logs
 DELETE FROM webhook_logs;
 
+-- name: claim-webhook-key
+-- Claims an idempotency key for $3 seconds. Returns no rows if the key is already
+-- claimed and hasn't expired, ie: the webhook is a duplicate.
+INSERT INTO webhook_idempotency_keys (key, webhook_type, expires_at)
+    VALUES ($1, $2, NOW() + MAKE_INTERVAL(secs => $3))
+    ON CONFLICT (key) DO UPDATE SET webhook_type = $2, created_at = NOW(), expires_at = NOW() + MAKE_INTERVAL(secs => $3)
+    WHERE webhook_idempotency_keys.expires_at < NOW()
+    RETURNING key;
+
+-- name: release-webhook-keys
+DELETE FROM webhook_idempotency_keys WHERE key = ANY($1);
+
+-- name: delete-expired-webhook-keys
+DELETE FROM webhook_idempotency_keys WHERE expires_at < NOW();
+
+-- name: insert-ses-event
+-- Campaign and subscriber are resolved from the X-Listmonk-* headers, falling back to the recipient e-mail.
+INSERT INTO ses_events (ses_message_id, campaign_id, subscriber_id, event_type, email, feedback_type, status_reason, meta, event_timestamp)
+    VALUES (
+        $1,
+        (SELECT id FROM campaigns WHERE uuid = NULLIF($2, '')::UUID),
+        COALESCE(
+            (SELECT id FROM subscribers WHERE uuid = NULLIF($3, '')::UUID),
+            (SELECT id FROM subscribers WHERE email = LOWER($4))
+        ),
+        $5, LOWER($4), NULLIF($6, ''), NULLIF($7, ''), $8, $9
+    );
+
 -- name: get-db-info
 SELECT JSON_BUILD_OBJECT('version', (SELECT VERSION()),
                         'size_mb', (SELECT ROUND(pg_database_size((SELECT CURRENT_DATABASE()))/(1024^2)))) AS info;
@@ -1470,18 +1643,61 @@ DELETE FROM roles WHERE id=$1;
 -- name: queue-campaign-emails
 -- Queue all emails for a campaign to be sent via the queue system
 -- Emails are scheduled 2 minutes in the future to give the queue processor time to start
-INSERT INTO email_queue (campaign_id, subscriber_id, status, priority, scheduled_at, created_at, updated_at)
+-- Subscribers in the campaign's holdout are recorded as its control group and not queued.
+-- If the campaign has an A/B test, only the test slice ($2 percent) of the audience is queued, split
+-- evenly across its variants. The rest are queued with the winner by queue-campaign-winner-emails.
+-- An occurrence of a recurring campaign that skips previous recipients isn't queued to the
+-- subscribers who were sent the previous occurrence.
+-- A resend of a campaign to its non-openers is only queued to the recipients of the parent
+-- campaign who haven't opened it.
+-- Subscribers on the campaign's exclusion lists, or outside its audience snapshot if it has one,
+-- aren't queued.
+WITH subs AS (
+    SELECT DISTINCT sl.subscriber_id, campaign_holdout($1, sl.subscriber_id, c.holdout_percent) AS holdout, c.holdout_percent
+    FROM campaign_lists cl
+    INNER JOIN subscriber_lists sl ON (cl.list_id = sl.list_id AND sl.status = 'confirmed')
+    INNER JOIN campaigns c ON (c.id = cl.campaign_id)
+    WHERE cl.campaign_id = $1
+        -- Skip the recipients of the previous occurrence of a recurring campaign.
+        AND NOT EXISTS (
+            SELECT 1 FROM campaign_occurrences o
+            JOIN email_queue eq ON (eq.campaign_id = o.previous_campaign_id AND eq.subscriber_id = sl.subscriber_id AND eq.status = 'sent')
+            WHERE o.campaign_id = $1 AND o.skip_previous = true
+        )
+        -- A resend to non-openers is only queued to the non-openers of its parent.
+        AND NOT campaign_resend_excluded($1, sl.subscriber_id)
+        -- Subscribers on the exclusion lists or outside the audience snapshot.
+        AND NOT campaign_audience_excluded($1, sl.subscriber_id)
+),
+insCohort AS (
+    INSERT INTO campaign_cohorts (campaign_id, subscriber_id, holdout)
+        SELECT $1, subscriber_id, holdout FROM subs WHERE holdout_percent > 0
+        ON CONFLICT (campaign_id, subscriber_id) DO NOTHING
+),
+variants AS (
+    SELECT id, (ROW_NUMBER() OVER (ORDER BY id) - 1) AS idx, COUNT(*) OVER () AS num
+    FROM campaign_variants WHERE campaign_id = $1
+),
+recipients AS (
+    -- Number the recipients in a random but stable order for picking the test slice.
+    SELECT subscriber_id,
+        (ROW_NUMBER() OVER (ORDER BY MD5($1::TEXT || ':' || subscriber_id::TEXT)) - 1) AS n,
+        COUNT(*) OVER () AS total
+    FROM subs WHERE NOT holdout
+)
+INSERT INTO email_queue (campaign_id, subscriber_id, variant_id, status, priority, scheduled_at, created_at, updated_at)
 SELECT
     $1 as campaign_id,
-    sl.subscriber_id,
+    r.subscriber_id,
+    v.id as variant_id,
     'queued' as status,
     0 as priority,
     NOW() + INTERVAL '2 minutes' as scheduled_at,
     NOW() as created_at,
     NOW() as updated_at
-FROM campaign_lists cl
-INNER JOIN subscriber_lists sl ON (cl.list_id = sl.list_id AND sl.status = 'confirmed')
-WHERE cl.campaign_id = $1
+FROM recipients r
+LEFT JOIN variants v ON ($2::NUMERIC > 0 AND v.idx = r.n % v.num)
+WHERE $2::NUMERIC = 0 OR r.n < CEIL(r.total * $2::NUMERIC / 100)
 ON CONFLICT DO NOTHING;
 
 -- name: get-queued-email-count
@@ -1663,13 +1879,53 @@ WHERE c.id = ANY($1::INT[])
 -- Shopify Purchase Attribution Queries
 
 -- name: insert-purchase-attribution
--- Insert a new purchase attribution record
+-- Insert a new purchase attribution record of an order from a source ($12) placed at $13.
+-- The exchange rate to the reporting currency ($11) at order time is stored with it.
 INSERT INTO purchase_attributions (
     campaign_id, subscriber_id, order_id, order_number, customer_email,
-    total_price, currency, attributed_via, confidence, shopify_data
-) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    total_price, currency, attributed_via, confidence, shopify_data,
+    reporting_currency, exchange_rate, source, ordered_at
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, exchange_rate(COALESCE(NULLIF($7, ''), $11), $11), $12, $13)
 RETURNING *;
 
+-- name: insert-purchase-refund
+-- Record a refund ($2) of an order ($1) of a source ($5) and deduct it from the order's purchases.
+-- Returns nothing if the refund is already recorded or there's no purchase for the order.
+WITH p AS (
+    SELECT id FROM purchase_attributions WHERE source = $5 AND order_id = $1
+),
+ins AS (
+    INSERT INTO purchase_refunds (purchase_id, refund_id, amount, currency)
+        SELECT id, $2, $3, NULLIF($4, '') FROM p
+        ON CONFLICT (purchase_id, refund_id) DO NOTHING
+        RETURNING purchase_id
+)
+UPDATE purchase_attributions SET refunded_amount = refunded_amount + $3, updated_at = NOW()
+    WHERE id IN (SELECT purchase_id FROM ins)
+    RETURNING id;
+
+-- name: update-purchase-attribution-order
+-- Apply an order edit or cancellation ($4) to the purchases of an order ($1) of a source ($6). The
+-- gross revenue credited to campaigns is updated with the new total price ($2).
+WITH p AS (
+    UPDATE purchase_attributions SET
+        total_price = COALESCE($2, total_price),
+        currency = COALESCE(NULLIF($3, ''), currency),
+        -- Re-convert if the edit changed the currency.
+        exchange_rate = (CASE WHEN NULLIF($3, '') IS NOT NULL AND UPPER($3) IS DISTINCT FROM UPPER(currency)
+            THEN exchange_rate($3, reporting_currency) ELSE exchange_rate END),
+        cancelled_at = COALESCE(cancelled_at, $4),
+        shopify_data = COALESCE($5, shopify_data),
+        updated_at = NOW()
+    WHERE source = $6 AND order_id = $1
+    RETURNING id, total_price
+),
+c AS (
+    UPDATE purchase_attribution_credits SET revenue = ROUND(COALESCE(p.total_price, 0) * weight, 2)
+        FROM p WHERE purchase_id = p.id
+)
+SELECT id FROM p;
+
 -- name: find-recent-link-click
 -- Find the most recent link click for a subscriber within the attribution window
 SELECT lc.campaign_id, lc.link_id, lc.created_at
@@ -1689,16 +1945,127 @@ WHERE aee.subscriber_id = $1
 ORDER BY aee.event_timestamp DESC
 LIMIT 1;
 
+-- name: get-attribution-touches
+-- Get the campaign touches (clicks, opens and deliveries) of a subscriber in the attribution window ($3 days) before a purchase ($2).
+SELECT campaign_id, 'click' AS type, created_at FROM link_clicks
+    WHERE subscriber_id = $1 AND campaign_id IS NOT NULL
+    AND created_at BETWEEN $2::TIMESTAMPTZ - MAKE_INTERVAL(days => $3) AND $2::TIMESTAMPTZ
+UNION ALL
+SELECT campaign_id, 'open' AS type, created_at FROM campaign_views
+    WHERE subscriber_id = $1 AND campaign_id IS NOT NULL
+    AND created_at BETWEEN $2::TIMESTAMPTZ - MAKE_INTERVAL(days => $3) AND $2::TIMESTAMPTZ
+UNION ALL
+SELECT campaign_id, 'delivery' AS type, event_timestamp AS created_at FROM azure_delivery_events
+    WHERE subscriber_id = $1 AND campaign_id IS NOT NULL AND status = 'Delivered'
+    AND event_timestamp BETWEEN $2::TIMESTAMPTZ - MAKE_INTERVAL(days => $3) AND $2::TIMESTAMPTZ
+UNION ALL
+SELECT campaign_id, 'delivery' AS type, event_timestamp AS created_at FROM ses_events
+    WHERE subscriber_id = $1 AND campaign_id IS NOT NULL AND event_type = 'Delivery'
+    AND event_timestamp BETWEEN $2::TIMESTAMPTZ - MAKE_INTERVAL(days => $3) AND $2::TIMESTAMPTZ
+ORDER BY created_at;
+
+-- name: insert-purchase-attribution-credits
+-- Insert the share of a purchase ($1) of revenue ($2) credited to campaigns ($3) by models ($4) with weights ($5).
+INSERT INTO purchase_attribution_credits (purchase_id, campaign_id, model, weight, revenue)
+    SELECT $1, c.campaign_id, c.model, c.weight, ROUND($2::NUMERIC * c.weight, 2)
+    FROM UNNEST($3::INT[], $4::TEXT[], $5::NUMERIC[]) AS c(campaign_id, model, weight)
+    ON CONFLICT DO NOTHING;
+
 -- name: get-campaign-purchase-stats
--- Get aggregate purchase statistics for a campaign
+-- Get aggregate purchase statistics for a campaign ($1) by an attribution model ($2).
+-- Revenue is the share of the purchases credited to the campaign by the model,
+-- converted to the reporting currency ($3) with the rate stored at order time.
+-- Purchases without a rate to the reporting currency are counted as unconverted and
+-- not included in the revenue. total_revenue is net of refunds and cancellations,
+-- gross_revenue is not.
 SELECT
-    COUNT(*) as total_purchases,
-    COALESCE(SUM(total_price), 0) as total_revenue,
-    COALESCE(AVG(total_price), 0) as avg_order_value,
-    COALESCE(currency, 'USD') as currency
-FROM purchase_attributions
-WHERE campaign_id = $1
-GROUP BY currency;
+    COUNT(*) FILTER (WHERE p.cancelled_at IS NULL) AS total_purchases,
+    COALESCE(SUM(c.weight) FILTER (WHERE p.cancelled_at IS NULL), 0) AS attributed_purchases,
+    COALESCE(ROUND(SUM(c.weight * p.net_price * p.exchange_rate) FILTER (WHERE p.reporting_currency = $3), 2), 0) AS total_revenue,
+    COALESCE(ROUND(SUM(c.weight * p.total_price * p.exchange_rate) FILTER (WHERE p.reporting_currency = $3), 2), 0) AS gross_revenue,
+    COALESCE(ROUND(AVG(p.net_price * p.exchange_rate) FILTER (WHERE p.cancelled_at IS NULL AND p.reporting_currency = $3), 2), 0) AS avg_order_value,
+    COUNT(*) FILTER (WHERE p.cancelled_at IS NULL AND (p.exchange_rate IS NULL OR p.reporting_currency IS DISTINCT FROM $3)) AS unconverted_purchases,
+    $3::TEXT AS currency
+FROM purchase_attribution_credits c
+JOIN purchase_attributions p ON p.id = c.purchase_id
+WHERE c.campaign_id = $1 AND c.model = $2;
+
+-- name: get-campaign-purchase-currency-stats
+-- Get the purchase statistics of a campaign ($1) by an attribution model ($2) in the
+-- original currencies of the purchases.
+SELECT
+    COALESCE(p.currency, '') AS currency,
+    COUNT(*) FILTER (WHERE p.cancelled_at IS NULL) AS total_purchases,
+    COALESCE(ROUND(SUM(c.weight * p.net_price), 2), 0) AS total_revenue,
+    COALESCE(SUM(c.revenue), 0) AS gross_revenue
+FROM purchase_attribution_credits c
+JOIN purchase_attributions p ON p.id = c.purchase_id
+WHERE c.campaign_id = $1 AND c.model = $2
+GROUP BY p.currency
+ORDER BY total_revenue DESC;
+
+-- name: upsert-purchase-line-items
+-- Replace the line items of a purchase ($1) with a JSON array of line items ($2). The
+-- product handle is derived from the title if it's not set.
+WITH d AS (
+    DELETE FROM purchase_line_items WHERE purchase_id = $1
+)
+INSERT INTO purchase_line_items (purchase_id, line_id, product_id, variant_id, sku, title, handle, quantity, price)
+    SELECT $1, COALESCE(li.line_id, ''), COALESCE(li.product_id, ''), COALESCE(li.variant_id, ''),
+        COALESCE(li.sku, ''), COALESCE(li.title, ''),
+        COALESCE(NULLIF(LOWER(li.handle), ''), TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(COALESCE(li.title, '')), '[^a-z0-9]+', '-', 'g'))),
+        COALESCE(li.quantity, 1), COALESCE(li.price, 0)
+    FROM JSONB_TO_RECORDSET($2::JSONB) AS li(line_id TEXT, product_id TEXT, variant_id TEXT, sku TEXT,
+        title TEXT, handle TEXT, quantity INT, price NUMERIC);
+
+-- name: get-campaign-product-stats
+-- Get the products sold in the purchases credited to a campaign ($1) by an attribution
+-- model ($2), and the products featured in the campaign's clicked links (/products/{handle}).
+-- Revenue is the campaign's share of the line items in the reporting currency ($3).
+-- clicked_buyers is the number of buyers of a product who clicked its link in the campaign.
+WITH sold AS (
+    SELECT
+        COALESCE(NULLIF(li.product_id, ''), NULLIF(li.sku, ''), li.title) AS product_key,
+        MAX(li.product_id) AS product_id,
+        MAX(li.sku) AS sku,
+        MAX(li.title) AS title,
+        MAX(li.handle) AS handle,
+        COUNT(DISTINCT p.id) AS orders,
+        SUM(li.quantity) AS units,
+        ROUND(SUM(li.price * li.quantity * c.weight * p.exchange_rate) FILTER (WHERE p.reporting_currency = $3), 2) AS revenue,
+        ARRAY_AGG(DISTINCT p.subscriber_id) FILTER (WHERE p.subscriber_id IS NOT NULL) AS buyers
+    FROM purchase_attribution_credits c
+    JOIN purchase_attributions p ON p.id = c.purchase_id AND p.cancelled_at IS NULL
+    JOIN purchase_line_items li ON li.purchase_id = p.id
+    WHERE c.campaign_id = $1 AND c.model = $2
+    GROUP BY product_key
+),
+clicked AS (
+    SELECT
+        LOWER(SUBSTRING(l.url FROM '/products/([^/?#]+)')) AS handle,
+        COUNT(*) AS clicks,
+        ARRAY_AGG(DISTINCT lc.subscriber_id) FILTER (WHERE lc.subscriber_id IS NOT NULL) AS clickers
+    FROM link_clicks lc
+    JOIN links l ON l.id = lc.link_id
+    WHERE lc.campaign_id = $1 AND l.url ~ '/products/[^/?#]+'
+    GROUP BY 1
+)
+SELECT
+    COALESCE(s.product_id, '') AS product_id,
+    COALESCE(s.sku, '') AS sku,
+    COALESCE(s.title, '') AS title,
+    COALESCE(NULLIF(s.handle, ''), k.handle, '') AS handle,
+    COALESCE(s.orders, 0) AS orders,
+    COALESCE(s.units, 0) AS units,
+    COALESCE(s.revenue, 0) AS revenue,
+    COALESCE(k.clicks, 0) AS clicks,
+    (k.handle IS NOT NULL) AS featured,
+    CARDINALITY(ARRAY(SELECT UNNEST(s.buyers) INTERSECT SELECT UNNEST(k.clickers))) AS clicked_buyers,
+    $3::TEXT AS currency
+FROM sold s
+FULL OUTER JOIN clicked k ON k.handle = s.handle
+ORDER BY revenue DESC, units DESC, clicks DESC
+LIMIT $4;
 
 -- name: get-subscriber-by-email
 -- Get subscriber by email address (case-insensitive)
@@ -1739,9 +2106,13 @@ recent_campaigns AS (
     WHERE c.updated_at >= NOW() - INTERVAL '30 days'
 ),
 purchase_data AS (
+    -- Revenue is net of refunds and cancelled orders are not counted. Revenue is in the
+    -- reporting currency ($1) and excludes orders without a rate to it.
     SELECT
-        COUNT(*) AS total_orders,
-        COALESCE(SUM(total_price), 0) AS total_revenue
+        COUNT(*) FILTER (WHERE cancelled_at IS NULL) AS total_orders,
+        COALESCE(ROUND(SUM(net_price * exchange_rate) FILTER (WHERE reporting_currency = $1), 2), 0) AS total_revenue,
+        COALESCE(ROUND(SUM(total_price * exchange_rate) FILTER (WHERE reporting_currency = $1), 2), 0) AS gross_revenue,
+        COUNT(*) FILTER (WHERE cancelled_at IS NULL AND (exchange_rate IS NULL OR reporting_currency IS DISTINCT FROM $1)) AS unconverted_orders
     FROM purchase_attributions
     WHERE created_at >= NOW() - INTERVAL '30 days'
 )
@@ -1751,6 +2122,9 @@ SELECT
     COALESCE(SUM(sent), 0) AS total_sent,
     (SELECT COALESCE(MAX(total_orders), 0) FROM purchase_data) AS total_orders,
     (SELECT COALESCE(MAX(total_revenue), 0) FROM purchase_data) AS total_revenue,
+    (SELECT COALESCE(MAX(gross_revenue), 0) FROM purchase_data) AS gross_revenue,
+    (SELECT COALESCE(MAX(unconverted_orders), 0) FROM purchase_data) AS unconverted_orders,
+    $1::TEXT AS currency,
     CASE
         WHEN COALESCE(SUM(sent), 0) > 0 THEN ((SELECT COALESCE(MAX(total_orders), 0) FROM purchase_data)::FLOAT / SUM(sent)::FLOAT) * 100
         ELSE 0
@@ -1761,13 +2135,778 @@ SELECT
     END AS revenue_per_recipient
 FROM recent_campaigns;
 
+-- name: get-purchases-currency-summary
+-- Get the orders and revenue of the last 30 days in their original currencies.
+SELECT
+    COALESCE(currency, '') AS currency,
+    COUNT(*) FILTER (WHERE cancelled_at IS NULL) AS total_purchases,
+    COALESCE(SUM(net_price), 0) AS total_revenue,
+    COALESCE(SUM(total_price), 0) AS gross_revenue
+FROM purchase_attributions
+WHERE created_at >= NOW() - INTERVAL '30 days'
+GROUP BY currency
+ORDER BY total_revenue DESC;
+
 -- name: get-campaigns-purchase-stats
--- Get purchase stats for multiple campaigns (for campaigns list)
+-- Get purchase stats for multiple campaigns (for campaigns list) in the reporting currency ($2).
 SELECT
     campaign_id,
-    COUNT(*) AS total_orders,
-    COALESCE(SUM(total_price), 0) AS total_revenue,
-    COALESCE(currency, 'USD') AS currency
+    COUNT(*) FILTER (WHERE cancelled_at IS NULL) AS total_orders,
+    COALESCE(ROUND(SUM(net_price * exchange_rate) FILTER (WHERE reporting_currency = $2), 2), 0) AS total_revenue,
+    COALESCE(ROUND(SUM(total_price * exchange_rate) FILTER (WHERE reporting_currency = $2), 2), 0) AS gross_revenue,
+    COUNT(*) FILTER (WHERE cancelled_at IS NULL AND (exchange_rate IS NULL OR reporting_currency IS DISTINCT FROM $2)) AS unconverted_orders,
+    $2::TEXT AS currency
 FROM purchase_attributions
 WHERE campaign_id = ANY($1)
-GROUP BY campaign_id, currency;
+GROUP BY campaign_id;
+
+-- name: get-exchange-rates
+SELECT * FROM exchange_rates ORDER BY currency;
+
+-- name: update-exchange-rates
+-- Replace the exchange rates with rates ($3) of currencies ($2) per unit of a base currency ($1).
+WITH d AS (
+    DELETE FROM exchange_rates WHERE currency <> ALL($2::TEXT[])
+)
+INSERT INTO exchange_rates (currency, rate, base, updated_at)
+    SELECT r.currency, r.rate, $1, NOW() FROM UNNEST($2::TEXT[], $3::NUMERIC[]) AS r(currency, rate)
+    ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, base = EXCLUDED.base, updated_at = NOW();
+
+-- name: normalize-purchases
+-- Convert purchases that have no rate to the reporting currency ($1), or a rate to a
+-- previous reporting currency, with the current rates. Purchases in currencies that
+-- have no rate are left as they are.
+UPDATE purchase_attributions SET
+    reporting_currency = $1,
+    exchange_rate = exchange_rate(COALESCE(NULLIF(currency, ''), $1), $1)
+WHERE (exchange_rate IS NULL OR reporting_currency IS DISTINCT FROM $1)
+    AND exchange_rate(COALESCE(NULLIF(currency, ''), $1), $1) IS NOT NULL;
+
+-- commerce flows
+-- name: get-commerce-flows
+-- Get all commerce flows or one flow ($1) with the counts of their jobs by status.
+SELECT f.*, COALESCE(
+    (SELECT JSON_OBJECT_AGG(status, num) FROM
+        (SELECT status, COUNT(*) AS num FROM commerce_flow_jobs WHERE flow_id = f.id GROUP BY status) j),
+    '{}') AS jobs
+FROM commerce_flows f
+WHERE ($1 = 0 OR f.id = $1)
+ORDER BY f.id;
+
+-- name: create-commerce-flow
+INSERT INTO commerce_flows (name, trigger, delay_minutes, template_id, subject, add_list_ids, remove_list_ids, enabled)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;
+
+-- name: update-commerce-flow
+UPDATE commerce_flows SET name = $2, trigger = $3, delay_minutes = $4, template_id = $5, subject = $6,
+    add_list_ids = $7, remove_list_ids = $8, enabled = $9, updated_at = NOW()
+WHERE id = $1;
+
+-- name: delete-commerce-flow
+DELETE FROM commerce_flows WHERE id = $1;
+
+-- name: schedule-commerce-flow-jobs
+-- Schedule jobs of the enabled flows of a trigger ($1) for a checkout or an order ($2) of
+-- an e-mail ($3) with data ($4) at the flow's delay after the event ($5). A rescheduled
+-- job that hasn't run yet is delayed again with the latest data.
+INSERT INTO commerce_flow_jobs (flow_id, ref, email, data, run_at)
+    SELECT id, $2, LOWER($3), $4, $5::TIMESTAMP WITH TIME ZONE + MAKE_INTERVAL(mins => delay_minutes)
+    FROM commerce_flows WHERE enabled = true AND trigger = $1
+ON CONFLICT (flow_id, ref) DO UPDATE SET email = EXCLUDED.email, data = EXCLUDED.data,
+    run_at = EXCLUDED.run_at, updated_at = NOW()
+    WHERE commerce_flow_jobs.status = 'pending';
+
+-- name: cancel-checkout-flow-jobs
+-- Cancel the pending abandoned checkout jobs of a checkout ($1), or of an e-mail ($2)
+-- created before an order ($3).
+UPDATE commerce_flow_jobs SET status = 'cancelled', updated_at = NOW()
+WHERE status = 'pending'
+    AND flow_id IN (SELECT id FROM commerce_flows WHERE trigger = 'checkout_abandoned')
+    AND (ref = $1 OR (LOWER(email) = LOWER($2) AND created_at <= $3));
+
+-- name: next-commerce-flow-jobs
+-- Claim the next batch ($1) of due jobs. Jobs that were claimed but not completed in an
+-- hour (eg: the app stopped mid-run) are claimed again.
+UPDATE commerce_flow_jobs SET status = 'running', updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM commerce_flow_jobs
+    WHERE run_at <= NOW() AND
+        (status = 'pending' OR (status = 'running' AND updated_at < NOW() - INTERVAL '1 hour'))
+    ORDER BY run_at
+    LIMIT $1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: update-commerce-flow-job
+UPDATE commerce_flow_jobs SET status = $2, error = $3, updated_at = NOW() WHERE id = $1;
+
+-- name: get-campaign-holdout-stats
+-- Get the purchases of the recipients and the holdout (control) group of a campaign ($1)
+-- in the attribution window ($2 days) after they were selected for the campaign. All the
+-- purchases of a subscriber are counted, whether or not they're attributed to the campaign.
+-- Revenue is net of refunds in the reporting currency ($3). revenue_sq is the sum of the
+-- squares of the revenue per subscriber, for the variance.
+WITH subs AS (
+    SELECT c.subscriber_id, c.holdout,
+        COUNT(p.id) AS orders,
+        COALESCE(SUM(p.net_price * p.exchange_rate) FILTER (WHERE p.reporting_currency = $3), 0) AS revenue
+    FROM campaign_cohorts c
+    LEFT JOIN purchase_attributions p ON p.subscriber_id = c.subscriber_id
+        AND p.cancelled_at IS NULL
+        AND p.created_at >= c.created_at AND p.created_at < c.created_at + MAKE_INTERVAL(days => $2)
+    WHERE c.campaign_id = $1
+    GROUP BY c.subscriber_id, c.holdout
+)
+SELECT holdout,
+    COUNT(*) AS subscribers,
+    COUNT(*) FILTER (WHERE orders > 0) AS purchasers,
+    COALESCE(SUM(orders), 0) AS orders,
+    COALESCE(SUM(revenue), 0) AS revenue,
+    COALESCE(SUM(revenue * revenue), 0) AS revenue_sq
+FROM subs
+GROUP BY holdout;
+
+-- name: sync-commerce-attribs
+-- Compute the lifetime value and RFM (recency, frequency, monetary) attributes of subscribers
+-- from their purchases and store them in attribs.commerce for segmenting. Revenue is net of
+-- refunds in the reporting currency ($1). R, F and M are scored 1-5 by quintile among all
+-- purchasers. The dates are those of the orders. The churn bucket compares the days since the
+-- last order with the subscriber's average days between orders, or $2 days if they have one
+-- order. Subscribers that have no purchases left lose the attributes. Returns the number of
+-- subscribers updated.
+WITH purchases AS (
+    SELECT COALESCE(p.subscriber_id, s.id) AS subscriber_id, p.ordered_at,
+        (CASE WHEN p.reporting_currency = $1 THEN p.net_price * p.exchange_rate ELSE 0 END) AS revenue
+    FROM purchase_attributions p
+    LEFT JOIN subscribers s ON (p.subscriber_id IS NULL AND LOWER(s.email) = LOWER(p.customer_email))
+    WHERE p.cancelled_at IS NULL
+),
+totals AS (
+    SELECT subscriber_id,
+        COUNT(*) AS orders,
+        ROUND(SUM(revenue), 2) AS revenue,
+        MIN(ordered_at) AS first_order_at,
+        MAX(ordered_at) AS last_order_at,
+        DATE_PART('day', NOW() - MAX(ordered_at))::INT AS recency_days,
+        GREATEST(CASE WHEN COUNT(*) > 1
+            THEN DATE_PART('epoch', MAX(ordered_at) - MIN(ordered_at)) / 86400 / (COUNT(*) - 1)
+            ELSE $2::INT END, 1) AS interval_days
+    FROM purchases WHERE subscriber_id IS NOT NULL
+    GROUP BY subscriber_id
+),
+scores AS (
+    SELECT *,
+        NTILE(5) OVER (ORDER BY last_order_at) AS r_score,
+        NTILE(5) OVER (ORDER BY orders) AS f_score,
+        NTILE(5) OVER (ORDER BY revenue) AS m_score
+    FROM totals
+),
+commerce AS (
+    SELECT subscriber_id, JSONB_BUILD_OBJECT(
+        'orders', orders,
+        'lifetime_value', revenue,
+        'avg_order_value', ROUND(revenue / orders, 2),
+        'currency', $1::TEXT,
+        'first_order_at', first_order_at,
+        'last_order_at', last_order_at,
+        'recency_days', recency_days,
+        'r_score', r_score,
+        'f_score', f_score,
+        'm_score', m_score,
+        'rfm', CONCAT(r_score, f_score, m_score),
+        'churn', (CASE
+            WHEN recency_days <= interval_days * 1.5 THEN 'active'
+            WHEN recency_days <= interval_days * 3 THEN 'at_risk'
+            ELSE 'churned' END)
+    ) AS attribs
+    FROM scores
+),
+upd AS (
+    UPDATE subscribers s SET attribs = s.attribs || JSONB_BUILD_OBJECT('commerce', c.attribs)
+    FROM commerce c
+    WHERE s.id = c.subscriber_id AND (s.attribs->'commerce') IS DISTINCT FROM c.attribs
+    RETURNING s.id
+),
+del AS (
+    UPDATE subscribers SET attribs = attribs - 'commerce'
+    WHERE attribs->'commerce' IS NOT NULL AND id NOT IN (SELECT subscriber_id FROM commerce)
+    RETURNING id
+)
+SELECT (SELECT COUNT(*) FROM upd) + (SELECT COUNT(*) FROM del);
+
+-- name: get-campaign-variant
+-- Get a variant ($2) of a campaign ($1) with the body of its template.
+SELECT v.id, v.campaign_id, v.name, v.subject, v.from_email, v.body, v.template_id,
+    COALESCE(t.body, '') AS template_body
+FROM campaign_variants v
+LEFT JOIN templates t ON (t.id = v.template_id)
+WHERE v.campaign_id = $1 AND v.id = $2;
+
+-- name: start-campaign-ab-test
+-- Start the A/B test of a campaign ($1) that ends after the test duration.
+UPDATE campaigns SET
+    ab_test_ends_at = NOW() + MAKE_INTERVAL(mins => GREATEST(COALESCE((ab_test->>'duration_minutes')::INT, 0), 1)),
+    ab_winner_id = NULL,
+    ab_winner_sent_at = NULL,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: get-due-ab-tests
+-- Get the running campaigns whose A/B test has ended and whose winner hasn't been picked.
+SELECT id FROM campaigns
+WHERE status = 'running' AND ab_test_ends_at <= NOW() AND ab_winner_id IS NULL
+ORDER BY ab_test_ends_at;
+
+-- name: get-campaign-variant-stats
+-- Get the recipients and engagement of the variants of campaigns ($1) whose A/B test has started.
+-- Views and clicks are the number of recipients of a variant who viewed or clicked. Revenue is
+-- the share of their purchases credited to the campaign by an attribution model ($2) in the
+-- reporting currency ($3). Only the test slice is counted and not the rest of the audience
+-- that the winner is sent to, which is queued at ab_winner_sent_at.
+WITH recipients AS (
+    SELECT DISTINCT eq.campaign_id, eq.variant_id, eq.subscriber_id, eq.status FROM email_queue eq
+    JOIN campaigns c ON (c.id = eq.campaign_id)
+    WHERE eq.campaign_id = ANY($1::INT[]) AND eq.variant_id IS NOT NULL
+        AND (c.ab_winner_sent_at IS NULL OR eq.created_at < c.ab_winner_sent_at)
+),
+purchases AS (
+    SELECT r.variant_id, p.id, p.cancelled_at, pc.weight * p.net_price * p.exchange_rate AS revenue, p.reporting_currency
+    FROM recipients r
+    JOIN purchase_attributions p ON (p.subscriber_id = r.subscriber_id)
+    JOIN purchase_attribution_credits pc ON (pc.purchase_id = p.id AND pc.campaign_id = r.campaign_id AND pc.model = $2)
+)
+SELECT v.campaign_id, v.id, v.name,
+    (v.id = c.ab_winner_id) IS TRUE AS winner,
+    (SELECT COUNT(*) FROM recipients r WHERE r.variant_id = v.id AND r.status = 'sent') AS sent,
+    (SELECT COUNT(DISTINCT r.subscriber_id) FROM recipients r
+        JOIN campaign_views cv ON (cv.campaign_id = r.campaign_id AND cv.subscriber_id = r.subscriber_id)
+        WHERE r.variant_id = v.id) AS views,
+    (SELECT COUNT(DISTINCT r.subscriber_id) FROM recipients r
+        JOIN link_clicks lc ON (lc.campaign_id = r.campaign_id AND lc.subscriber_id = r.subscriber_id)
+        WHERE r.variant_id = v.id) AS clicks,
+    (SELECT COUNT(DISTINCT p.id) FROM purchases p WHERE p.variant_id = v.id AND p.cancelled_at IS NULL) AS purchases,
+    COALESCE((SELECT ROUND(SUM(p.revenue), 2) FROM purchases p WHERE p.variant_id = v.id AND p.reporting_currency = $3), 0) AS revenue,
+    $3::TEXT AS currency
+FROM campaign_variants v
+JOIN campaigns c ON (c.id = v.campaign_id)
+WHERE v.campaign_id = ANY($1::INT[]) AND c.ab_test_ends_at IS NOT NULL
+ORDER BY v.campaign_id, v.id;
+
+-- name: queue-campaign-winner-emails
+-- Set the winning variant ($2) of the A/B test of a campaign ($1) and queue it to the rest of the
+-- campaign's audience that wasn't in the test slice. Subscribers in the campaign's holdout are
+-- recorded as its control group and not queued. Returns the number of e-mails queued.
+WITH camp AS (
+    UPDATE campaigns SET ab_winner_id = $2, ab_winner_sent_at = NOW(), updated_at = NOW()
+    WHERE id = $1 AND ab_winner_id IS NULL
+    RETURNING id, holdout_percent
+),
+subs AS (
+    SELECT DISTINCT sl.subscriber_id, campaign_holdout($1, sl.subscriber_id, c.holdout_percent) AS holdout, c.holdout_percent
+    FROM campaign_lists cl
+    INNER JOIN subscriber_lists sl ON (cl.list_id = sl.list_id AND sl.status = 'confirmed')
+    INNER JOIN camp c ON (c.id = cl.campaign_id)
+    WHERE cl.campaign_id = $1
+        AND NOT EXISTS (SELECT 1 FROM email_queue eq WHERE eq.campaign_id = $1 AND eq.subscriber_id = sl.subscriber_id)
+        AND NOT EXISTS (
+            SELECT 1 FROM campaign_occurrences o
+            JOIN email_queue eq ON (eq.campaign_id = o.previous_campaign_id AND eq.subscriber_id = sl.subscriber_id AND eq.status = 'sent')
+            WHERE o.campaign_id = $1 AND o.skip_previous = true
+        )
+        -- A resend to non-openers is only queued to the non-openers of its parent.
+        AND NOT campaign_resend_excluded($1, sl.subscriber_id)
+        -- Subscribers on the exclusion lists or outside the audience snapshot.
+        AND NOT campaign_audience_excluded($1, sl.subscriber_id)
+),
+insCohort AS (
+    INSERT INTO campaign_cohorts (campaign_id, subscriber_id, holdout)
+        SELECT $1, subscriber_id, holdout FROM subs WHERE holdout_percent > 0
+        ON CONFLICT (campaign_id, subscriber_id) DO NOTHING
+),
+ins AS (
+    INSERT INTO email_queue (campaign_id, subscriber_id, variant_id, status, priority, scheduled_at, created_at, updated_at)
+    SELECT $1, subscriber_id, $2, 'queued', 0, NOW() + INTERVAL '2 minutes', NOW(), NOW()
+    FROM subs WHERE NOT holdout
+    RETURNING id
+)
+SELECT COUNT(*) FROM ins;
+
+-- name: get-sequences
+-- Get all sequences or one sequence ($1) with the counts of their subscribers by status.
+SELECT s.*, COALESCE(
+    (SELECT JSON_OBJECT_AGG(status, num) FROM
+        (SELECT status, COUNT(*) AS num FROM sequence_subscribers WHERE sequence_id = s.id GROUP BY status) ss),
+    '{}') AS subscribers
+FROM sequences s
+WHERE ($1 = 0 OR s.id = $1)
+ORDER BY s.id;
+
+-- name: create-sequence
+INSERT INTO sequences (name, list_id, steps, enabled) VALUES($1, $2, $3, $4) RETURNING id;
+
+-- name: update-sequence
+UPDATE sequences SET name = $2, list_id = $3, steps = $4, enabled = $5, updated_at = NOW() WHERE id = $1;
+
+-- name: delete-sequence
+DELETE FROM sequences WHERE id = $1;
+
+-- name: next-sequence-subscribers
+-- Claim the next batch ($1) of subscribers whose next step in an enabled sequence is due,
+-- with their status and the status of their subscription to the sequence's list. Subscribers
+-- that were claimed but not completed in an hour (eg: the app stopped mid-run) are claimed again.
+WITH claimed AS (
+    UPDATE sequence_subscribers SET status = 'running', updated_at = NOW()
+    WHERE (sequence_id, subscriber_id) IN (
+        SELECT ss.sequence_id, ss.subscriber_id FROM sequence_subscribers ss
+        JOIN sequences s ON (s.id = ss.sequence_id AND s.enabled = true)
+        WHERE ss.next_run_at <= NOW() AND
+            (ss.status = 'active' OR (ss.status = 'running' AND ss.updated_at < NOW() - INTERVAL '1 hour'))
+        ORDER BY ss.next_run_at
+        LIMIT $1
+        FOR UPDATE OF ss SKIP LOCKED
+    )
+    RETURNING *
+)
+SELECT c.*, sub.status AS subscriber_status, COALESCE(sl.status::TEXT, 'unsubscribed') AS subscription_status
+FROM claimed c
+JOIN subscribers sub ON (sub.id = c.subscriber_id)
+JOIN sequences s ON (s.id = c.sequence_id)
+LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = c.subscriber_id AND sl.list_id = s.list_id);
+
+-- name: update-sequence-subscriber
+UPDATE sequence_subscribers SET step = $3, status = $4, next_run_at = $5, last_campaign_id = $6,
+    last_sent_at = $7, error = $8, updated_at = NOW()
+WHERE sequence_id = $1 AND subscriber_id = $2;
+
+-- name: queue-sequence-email
+-- Queue the content of a campaign ($1) to a subscriber ($2) of a sequence. It's sent by the
+-- queue processor with the rate limits of the queue.
+INSERT INTO email_queue (campaign_id, subscriber_id, status, priority, scheduled_at, created_at, updated_at)
+    VALUES($1, $2, 'queued', 0, NOW(), NOW(), NOW());
+
+-- name: queue-tx-email
+-- Queue a tx message ($2) to a subscriber ($1). The message is rendered and sent by the
+-- queue processor with the rate limits of the queue.
+INSERT INTO email_queue (subscriber_id, tx_message, status, priority, scheduled_at, created_at, updated_at)
+    VALUES($1, $2, 'queued', 0, NOW(), NOW(), NOW());
+
+-- name: get-sequence-engagement
+-- Check if a subscriber ($2) opened or clicked a campaign ($1) since it was sent ($3).
+SELECT
+    EXISTS (SELECT 1 FROM campaign_views WHERE campaign_id = $1 AND subscriber_id = $2 AND created_at >= $3) AS opened,
+    EXISTS (SELECT 1 FROM link_clicks WHERE campaign_id = $1 AND subscriber_id = $2 AND created_at >= $3) AS clicked;
+
+-- name: merge-subscriber-attribs
+-- Merge attributes ($2) into a subscriber's ($1) attributes. The reserved commerce attributes are retained.
+UPDATE subscribers SET attribs = attribs || ($2::JSONB - 'commerce'), updated_at = NOW() WHERE id = $1;
+
+-- name: enter-sequence
+-- Enter a subscriber ($2) into an enabled sequence ($1) from its first step. A subscriber
+-- enters a sequence only once.
+INSERT INTO sequence_subscribers (sequence_id, subscriber_id)
+    SELECT id, $2 FROM sequences WHERE id = $1 AND enabled = true
+ON CONFLICT (sequence_id, subscriber_id) DO NOTHING;
+
+-- name: lock-event-trigger-caps
+-- Lock the frequency caps of the enabled, capped triggers of an event ($2) for a subscriber ($1)
+-- until the end of the transaction so that concurrent events of the subscriber are counted one
+-- after the other. The triggers are locked in order to avoid deadlocks.
+SELECT pg_advisory_xact_lock(t.id, $1) FROM (
+    SELECT id FROM event_triggers WHERE enabled = true AND event = $2 AND cap_count > 0 ORDER BY id
+) t;
+
+-- name: insert-subscriber-event
+-- Insert an event ($2) with properties ($3) of a subscriber ($1) and schedule the jobs of the
+-- enabled triggers of the event whose filters are contained in the properties at the trigger's
+-- delay, within the trigger's frequency cap for the subscriber. It's run after
+-- lock-event-trigger-caps in the same transaction.
+WITH e AS (
+    INSERT INTO subscriber_events (subscriber_id, name, properties) VALUES($1, $2, $3) RETURNING *
+),
+jobs AS (
+    INSERT INTO event_trigger_jobs (trigger_id, subscriber_id, event_id, run_at)
+        SELECT t.id, e.subscriber_id, e.id, e.created_at + MAKE_INTERVAL(mins => t.delay_minutes)
+        FROM event_triggers t, e
+        WHERE t.enabled = true AND t.event = e.name AND e.properties @> t.filters
+            AND (t.cap_count = 0 OR (
+                SELECT COUNT(*) FROM event_trigger_jobs j
+                WHERE j.trigger_id = t.id AND j.subscriber_id = e.subscriber_id
+                    AND j.status NOT IN ('skipped', 'failed')
+                    AND (t.cap_minutes = 0 OR j.created_at > NOW() - MAKE_INTERVAL(mins => t.cap_minutes))
+            ) < t.cap_count)
+    RETURNING id
+)
+SELECT e.id, (SELECT COUNT(*) FROM jobs) AS triggered FROM e;
+
+-- name: get-subscriber-events
+-- Get the latest events of a subscriber ($1), optionally by name ($2), up to a limit ($3).
+SELECT * FROM subscriber_events
+WHERE subscriber_id = $1 AND ($2 = '' OR name = $2)
+ORDER BY created_at DESC, id DESC
+LIMIT $3;
+
+-- name: get-event-triggers
+-- Get all event triggers or one trigger ($1) with the counts of their jobs by status.
+SELECT t.*, COALESCE(
+    (SELECT JSON_OBJECT_AGG(status, num) FROM
+        (SELECT status, COUNT(*) AS num FROM event_trigger_jobs WHERE trigger_id = t.id GROUP BY status) j),
+    '{}') AS jobs
+FROM event_triggers t
+WHERE ($1 = 0 OR t.id = $1)
+ORDER BY t.id;
+
+-- name: create-event-trigger
+INSERT INTO event_triggers (name, event, filters, delay_minutes, template_id, subject, sequence_id, cap_count, cap_minutes, enabled)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;
+
+-- name: update-event-trigger
+UPDATE event_triggers SET name = $2, event = $3, filters = $4, delay_minutes = $5, template_id = $6, subject = $7,
+    sequence_id = $8, cap_count = $9, cap_minutes = $10, enabled = $11, updated_at = NOW()
+WHERE id = $1;
+
+-- name: delete-event-trigger
+DELETE FROM event_triggers WHERE id = $1;
+
+-- name: next-event-trigger-jobs
+-- Claim the next batch ($1) of due jobs with their events. Jobs that were claimed but not
+-- completed in an hour (eg: the app stopped mid-run) are claimed again.
+WITH claimed AS (
+    UPDATE event_trigger_jobs SET status = 'running', updated_at = NOW()
+    WHERE id IN (
+        SELECT id FROM event_trigger_jobs
+        WHERE run_at <= NOW() AND
+            (status = 'pending' OR (status = 'running' AND updated_at < NOW() - INTERVAL '1 hour'))
+        ORDER BY run_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *
+)
+SELECT c.*, e.name AS event_name, e.properties AS event_properties, e.created_at AS event_created_at
+FROM claimed c
+JOIN subscriber_events e ON (e.id = c.event_id);
+
+-- name: update-event-trigger-job
+UPDATE event_trigger_jobs SET status = $2, error = $3, updated_at = NOW() WHERE id = $1;
+
+-- name: get-campaign-recurrences
+-- Get all recurring campaigns or one ($1) with the number of their occurrences, their latest
+-- campaign, and the number of feed items that are pending for a digest.
+SELECT r.*,
+    (SELECT COUNT(*) FROM campaign_occurrences WHERE recurrence_id = r.id) AS occurrences,
+    (SELECT campaign_id FROM campaign_occurrences WHERE recurrence_id = r.id
+        ORDER BY run_at DESC, created_at DESC LIMIT 1) AS last_campaign_id,
+    (SELECT COUNT(*) FROM campaign_feed_items WHERE recurrence_id = r.id AND sent_at IS NULL) AS pending_items
+FROM campaign_recurrences r
+WHERE ($1 = 0 OR r.id = $1)
+ORDER BY r.id;
+
+-- name: create-campaign-recurrence
+INSERT INTO campaign_recurrences (name, campaign_id, cron, timezone, name_format, skip_previous, enabled, next_run_at,
+    feed_url, feed_min_items, feed_max_items)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;
+
+-- name: update-campaign-recurrence
+UPDATE campaign_recurrences SET name = $2, campaign_id = $3, cron = $4, timezone = $5, name_format = $6,
+    skip_previous = $7, enabled = $8, next_run_at = $9, feed_url = $10, feed_min_items = $11, feed_max_items = $12,
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: delete-campaign-recurrence
+DELETE FROM campaign_recurrences WHERE id = $1;
+
+-- name: get-due-campaign-recurrences
+SELECT id FROM campaign_recurrences WHERE enabled = true AND next_run_at <= NOW() ORDER BY next_run_at;
+
+-- name: claim-campaign-recurrence
+-- Claim the occurrence of a recurring campaign ($1) that's due at $2 by moving it to its
+-- next occurrence ($3). Only one instance of the app can claim an occurrence.
+UPDATE campaign_recurrences SET next_run_at = $3, last_run_at = NOW(), error = '', updated_at = NOW()
+WHERE id = $1 AND enabled = true AND next_run_at = $2;
+
+-- name: set-campaign-recurrence-error
+UPDATE campaign_recurrences SET error = $2, updated_at = NOW() WHERE id = $1;
+
+-- name: insert-campaign-occurrence
+-- Record the campaign ($2) of an occurrence ($3) of a recurring campaign ($1), linked to the
+-- campaign of the previous occurrence.
+INSERT INTO campaign_occurrences (recurrence_id, campaign_id, previous_campaign_id, skip_previous, run_at)
+    VALUES($1, $2, (SELECT campaign_id FROM campaign_occurrences WHERE recurrence_id = $1
+        ORDER BY run_at DESC, created_at DESC LIMIT 1), $4, $3);
+
+-- name: get-campaign-occurrences
+SELECT o.*, c.name AS campaign_name, c.status AS campaign_status
+FROM campaign_occurrences o
+JOIN campaigns c ON (c.id = o.campaign_id)
+WHERE o.recurrence_id = $1
+ORDER BY o.run_at DESC, o.created_at DESC;
+
+-- name: insert-campaign-feed-items
+-- Record the items ($2, a JSON array) of a poll of the feed of a recurring campaign ($1).
+-- Items that have been seen before are ignored.
+INSERT INTO campaign_feed_items (recurrence_id, guid, item, published_at)
+    SELECT $1, i->>'guid', i, (i->>'published_at')::TIMESTAMP WITH TIME ZONE
+    FROM JSONB_ARRAY_ELEMENTS($2::JSONB) i
+    WHERE COALESCE(i->>'guid', '') != ''
+ON CONFLICT (recurrence_id, guid) DO NOTHING;
+
+-- name: get-pending-campaign-feed-items
+-- Get the feed items of a recurring campaign ($1) that haven't been sent in a digest, latest first.
+SELECT * FROM campaign_feed_items WHERE recurrence_id = $1 AND sent_at IS NULL
+ORDER BY published_at DESC, id DESC;
+
+-- name: get-campaign-feed-items
+-- Get the latest $2 feed items of a recurring campaign ($1).
+SELECT * FROM campaign_feed_items WHERE recurrence_id = $1
+ORDER BY published_at DESC, id DESC LIMIT $2;
+
+-- name: set-campaign-feed-items
+-- Set the feed items ($2) of the digest campaign ($1) of a recurring campaign.
+UPDATE campaigns SET feed_items = $2 WHERE id = $1;
+
+-- name: mark-campaign-feed-items-sent
+-- Mark the pending feed items ($3) of a recurring campaign ($1) as sent in its digest campaign ($2)
+-- once the campaign has started.
+UPDATE campaign_feed_items SET campaign_id = $2, sent_at = NOW()
+WHERE recurrence_id = $1 AND id = ANY($3::INT[]) AND sent_at IS NULL;
+
+-- name: insert-campaign-resend
+-- Record a resend of a campaign ($1) to its non-openers, $2 hours after it. An automatic ($3)
+-- resend is only recorded once for a campaign, which claims it.
+INSERT INTO campaign_resends (parent_id, after_hours, auto) VALUES($1, $2, $3)
+    ON CONFLICT (parent_id) WHERE auto DO NOTHING
+    RETURNING id;
+
+-- name: set-campaign-resend
+-- Set the campaign ($2) or the error ($3) of a resend ($1).
+UPDATE campaign_resends SET campaign_id = $2, error = $3 WHERE id = $1;
+
+-- name: get-due-campaign-resends
+-- Get the finished campaigns that automatically resend to their non-openers and haven't yet.
+SELECT id FROM campaigns c
+WHERE status = 'finished' AND COALESCE((resend->>'enabled')::BOOLEAN, false) = true
+    AND NOT EXISTS (SELECT 1 FROM campaign_resends r WHERE r.parent_id = c.id AND r.auto)
+ORDER BY id;
+
+-- name: get-campaign-resend-parent
+-- Get the parent of a campaign ($1) if it's a resend to non-openers, or the campaign itself.
+SELECT COALESCE((SELECT parent_id FROM campaign_resends WHERE campaign_id = $1), $1);
+
+-- name: get-campaign-resend-stats
+-- Get the recipients, unique openers and unique clickers of a campaign ($1) and each of its resends
+-- to non-openers, with the unique openers and clickers across all of them.
+WITH camps AS (
+    SELECT $1::INT AS id, true AS is_parent
+    UNION ALL
+    SELECT campaign_id, false FROM campaign_resends WHERE parent_id = $1 AND campaign_id IS NOT NULL
+),
+opens AS (
+    SELECT campaign_id, subscriber_id FROM campaign_views
+    WHERE campaign_id IN (SELECT id FROM camps) AND subscriber_id IS NOT NULL
+    UNION
+    SELECT campaign_id, subscriber_id FROM azure_engagement_events
+    WHERE campaign_id IN (SELECT id FROM camps) AND engagement_type = 'open' AND subscriber_id IS NOT NULL
+),
+clicks AS (
+    SELECT DISTINCT campaign_id, subscriber_id FROM link_clicks
+    WHERE campaign_id IN (SELECT id FROM camps) AND subscriber_id IS NOT NULL
+)
+SELECT c.id AS campaign_id, c.name, c.subject, c.status, c.send_at, c.started_at, camps.is_parent,
+    r.after_hours, c.sent,
+    (SELECT COUNT(*) FROM opens WHERE opens.campaign_id = c.id) AS openers,
+    (SELECT COUNT(*) FROM clicks WHERE clicks.campaign_id = c.id) AS clickers,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM opens) AS total_openers,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM clicks) AS total_clickers
+FROM camps
+JOIN campaigns c ON (c.id = camps.id)
+LEFT JOIN campaign_resends r ON (r.campaign_id = c.id)
+ORDER BY camps.is_parent DESC, c.id;
+
+-- name: campaign-audience-template
+-- raw: true
+-- The audience of a campaign ($1): the subscribers on its lists (unconfirmed on double opt-in
+-- lists for opt-in campaigns, confirmed on double opt-in lists, or not unsubscribed on others)
+-- that aren't blocklisted, on its exclusion lists, or excluded from a resend to non-openers, and
+-- that match its arbitrary segment expression (%query%). It's injected into other raw queries as
+-- %audience% and is not terminated with a semicolon.
+SELECT DISTINCT subscriber_lists.subscriber_id AS id FROM campaigns camp
+JOIN campaign_lists ON (campaign_lists.campaign_id = camp.id)
+JOIN lists ON (lists.id = campaign_lists.list_id)
+JOIN subscriber_lists ON (subscriber_lists.list_id = lists.id)
+JOIN subscribers s ON (s.id = subscriber_lists.subscriber_id AND s.status != 'blocklisted')
+WHERE camp.id = $1
+    AND (
+        CASE
+            WHEN camp.type = 'optin' THEN subscriber_lists.status = 'unconfirmed' AND lists.optin = 'double'
+            WHEN lists.optin = 'double' THEN subscriber_lists.status = 'confirmed'
+            ELSE subscriber_lists.status != 'unsubscribed'
+        END
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM subscriber_lists ex
+        WHERE ex.subscriber_id = s.id AND ex.list_id = ANY(camp.exclude_list_ids) AND ex.status != 'unsubscribed'
+    )
+    AND NOT campaign_resend_excluded(camp.id, s.id)
+    -- The segment expression is evaluated on subscribers alone.
+    AND s.id IN (SELECT subscribers.id FROM subscribers WHERE %query%)
+
+-- name: count-campaign-audience
+-- raw: true
+-- Count the audience of a campaign ($1) as it'd be snapshotted now.
+WITH subs AS (%audience%)
+SELECT COUNT(*) FROM subs;
+
+-- name: snapshot-campaign-audience
+-- raw: true
+-- Take the snapshot of the audience of a campaign ($1) that it's only sent to. The snapshot is only
+-- taken once. Returns the number of subscribers in it.
+WITH subs AS (%audience%),
+camp AS (
+    SELECT id FROM campaigns WHERE id = $1 AND audience_snapshot_at IS NULL
+),
+ins AS (
+    INSERT INTO campaign_audience (campaign_id, subscriber_id)
+        SELECT (SELECT id FROM camp), id FROM subs WHERE EXISTS (SELECT 1 FROM camp)
+        ON CONFLICT DO NOTHING
+        RETURNING subscriber_id
+)
+UPDATE campaigns SET audience_snapshot_at = NOW(), audience_size = (SELECT COUNT(*) FROM ins)
+    WHERE id = (SELECT id FROM camp)
+    RETURNING audience_size;
+
+-- name: get-due-campaign-audiences
+-- Get the campaigns that are sent to a snapshot of their audience (or have a segment query) and are
+-- due to start without one.
+SELECT id FROM campaigns
+WHERE audience_snapshot_at IS NULL AND (audience_snapshot = true OR segment_query != '')
+    AND (status = 'running' OR (status = 'scheduled' AND NOW() >= send_at))
+    AND (use_queue IS NULL OR use_queue = false)
+ORDER BY id;
+
+-- campaign reviews
+-- name: submit-campaign-review
+-- Submit a draft campaign for review with the snapshot of its content ($3). Automated
+-- submissions have no user ($2 = 0).
+WITH camp AS (
+    UPDATE campaigns SET status='pending_review', review_content=$3, approvals_required=$4,
+        approved_at=NULL, submitted_by=NULLIF($2, 0), updated_at=NOW()
+    WHERE id=$1 AND status='draft'
+    RETURNING id
+)
+INSERT INTO campaign_reviews (campaign_id, user_id, user_name, action, comment)
+    SELECT id, NULLIF($2, 0), $5, 'submitted', $6 FROM camp
+    RETURNING id;
+
+-- name: insert-campaign-review
+INSERT INTO campaign_reviews (campaign_id, user_id, user_name, action, comment)
+    VALUES($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: get-campaign-approvers
+-- Get the users who have approved the latest submission of a campaign.
+SELECT DISTINCT user_id FROM campaign_reviews
+    WHERE campaign_id=$1 AND action='approved' AND user_id IS NOT NULL
+    AND id > COALESCE((SELECT MAX(id) FROM campaign_reviews
+        WHERE campaign_id=$1 AND action IN ('submitted', 'rejected', 'reset')), 0);
+
+-- name: approve-campaign
+-- Approve a campaign in review if the latest submission has the approvals it requires. The
+-- approvals are counted when the campaign is updated so that concurrent approvals aren't missed.
+UPDATE campaigns SET status='approved', approved_at=NOW(), updated_at=NOW()
+    WHERE id=$1 AND status='pending_review' AND approvals_required <= (
+        SELECT COUNT(DISTINCT user_id) FROM campaign_reviews
+        WHERE campaign_id=$1 AND action='approved' AND user_id IS NOT NULL
+        AND id > COALESCE((SELECT MAX(id) FROM campaign_reviews
+            WHERE campaign_id=$1 AND action IN ('submitted', 'rejected', 'reset')), 0)
+    );
+
+-- name: copy-campaign-approval
+-- Record the approval of a campaign ($2) on a campaign that's cloned from it for an automated send.
+UPDATE campaigns SET approved_at=(SELECT approved_at FROM campaigns WHERE id=$2), updated_at=NOW()
+    WHERE id=$1;
+
+-- name: reset-campaign-review
+-- Reset the review of a campaign (on rejection or edits after submission) to the given status.
+UPDATE campaigns SET status=$2, review_content=NULL, approvals_required=0, approved_at=NULL, updated_at=NOW()
+    WHERE id=$1;
+
+-- name: get-campaign-reviews
+SELECT * FROM campaign_reviews WHERE campaign_id=$1 ORDER BY id;
+
+-- name: get-campaign-reviewer-emails
+-- Get the e-mails of the enabled users who can approve campaigns.
+SELECT u.email FROM users u
+    JOIN roles r ON r.id = u.user_role_id
+    WHERE u.status='enabled' AND (r.id = 1 OR 'campaigns:approve' = ANY(r.permissions))
+    ORDER BY u.id;
+
+-- campaign and template versions
+-- name: lock-campaign
+-- Lock a campaign that's being updated so that the versions of concurrent saves are recorded in order.
+SELECT id FROM campaigns WHERE id=$1 FOR UPDATE;
+
+-- name: insert-campaign-version
+-- Record the content of a campaign as a new version if it has changed since the last one.
+-- It's run with the campaign locked (lock-campaign).
+WITH last AS (
+    SELECT * FROM campaign_versions WHERE campaign_id=$1 ORDER BY version DESC LIMIT 1
+)
+INSERT INTO campaign_versions (campaign_id, version, user_id, user_name, subject, body, body_source, altbody, content_type, template_id, headers)
+    SELECT c.id, COALESCE((SELECT version FROM last), 0) + 1, $2, $3,
+        c.subject, c.body, c.body_source, c.altbody, c.content_type, c.template_id, c.headers
+    FROM campaigns c
+    WHERE c.id=$1 AND NOT EXISTS (
+        SELECT 1 FROM last WHERE last.subject=c.subject AND last.body=c.body
+            AND last.body_source IS NOT DISTINCT FROM c.body_source AND last.altbody IS NOT DISTINCT FROM c.altbody
+            AND last.content_type=c.content_type AND last.template_id IS NOT DISTINCT FROM c.template_id
+            AND last.headers=c.headers
+    );
+
+-- name: get-campaign-versions
+-- Get the versions of a campaign without their bodies, latest first.
+SELECT v.id, v.campaign_id, v.version, v.user_id, v.user_name, v.subject, v.content_type, v.template_id,
+    v.headers, v.created_at, COALESCE(c.sent_version = v.version, false) AS sent
+    FROM campaign_versions v
+    JOIN campaigns c ON (c.id = v.campaign_id)
+    WHERE v.campaign_id=$1 ORDER BY v.version DESC;
+
+-- name: get-campaign-version
+-- Get a version of a campaign, or the latest one if $2 = 0.
+SELECT v.*, COALESCE(c.sent_version = v.version, false) AS sent
+    FROM campaign_versions v
+    JOIN campaigns c ON (c.id = v.campaign_id)
+    WHERE v.campaign_id=$1 AND ($2 = 0 OR v.version=$2)
+    ORDER BY v.version DESC LIMIT 1;
+
+-- name: set-campaign-sent-version
+-- Record the latest version of a campaign as the one that's sent when it starts.
+UPDATE campaigns SET sent_version=(SELECT MAX(version) FROM campaign_versions WHERE campaign_id=$1)
+    WHERE id=$1 AND sent_version IS NULL;
+
+-- name: lock-template
+-- Lock a template that's being updated so that the versions of concurrent saves are recorded in order.
+SELECT id FROM templates WHERE id=$1 FOR UPDATE;
+
+-- name: insert-template-version
+-- Record a template as a new version if it has changed since the last one.
+-- It's run with the template locked (lock-template).
+WITH last AS (
+    SELECT * FROM template_versions WHERE template_id=$1 ORDER BY version DESC LIMIT 1
+)
+INSERT INTO template_versions (template_id, version, user_id, user_name, name, subject, body, body_source)
+    SELECT t.id, COALESCE((SELECT version FROM last), 0) + 1, $2, $3, t.name, t.subject, t.body, t.body_source
+    FROM templates t
+    WHERE t.id=$1 AND NOT EXISTS (
+        SELECT 1 FROM last WHERE last.name=t.name AND last.subject=t.subject AND last.body=t.body
+            AND last.body_source IS NOT DISTINCT FROM t.body_source
+    );
+
+-- name: get-template-versions
+-- Get the versions of a template without their bodies, latest first.
+SELECT id, template_id, version, user_id, user_name, name, subject, created_at
+    FROM template_versions WHERE template_id=$1 ORDER BY version DESC;
+
+-- name: get-template-version
+-- Get a version of a template, or the latest one if $2 = 0.
+SELECT * FROM template_versions WHERE template_id=$1 AND ($2 = 0 OR version=$2)
+    ORDER BY version DESC LIMIT 1;